@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"slices"
 
 	lua "github.com/yuin/gopher-lua"
 	"go.uber.org/zap"
@@ -10,17 +11,38 @@ import (
 	"github.com/sapslaj/zonepop/config/luahttp"
 	"github.com/sapslaj/zonepop/config/luazap"
 	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/enricher"
+	"github.com/sapslaj/zonepop/enricher/hostname"
+	"github.com/sapslaj/zonepop/enricher/macvendor"
 	"github.com/sapslaj/zonepop/pkg/gluamapper"
 	"github.com/sapslaj/zonepop/pkg/log"
 	"github.com/sapslaj/zonepop/provider"
+	adguardhome "github.com/sapslaj/zonepop/provider/adguard_home"
 	"github.com/sapslaj/zonepop/provider/aws"
+	"github.com/sapslaj/zonepop/provider/azure"
+	"github.com/sapslaj/zonepop/provider/cloudflare"
+	"github.com/sapslaj/zonepop/provider/coredns"
 	custom_provider "github.com/sapslaj/zonepop/provider/custom"
+	dnsserver "github.com/sapslaj/zonepop/provider/dns_server"
+	"github.com/sapslaj/zonepop/provider/dnsmasq"
 	"github.com/sapslaj/zonepop/provider/file"
+	"github.com/sapslaj/zonepop/provider/gcp"
 	hostsfile "github.com/sapslaj/zonepop/provider/hosts_file"
 	http_provider "github.com/sapslaj/zonepop/provider/http"
+	"github.com/sapslaj/zonepop/provider/pihole"
+	"github.com/sapslaj/zonepop/provider/powerdns"
 	prometheusmetrics "github.com/sapslaj/zonepop/provider/prometheus_metrics"
+	"github.com/sapslaj/zonepop/provider/unbound"
 	"github.com/sapslaj/zonepop/source"
+	"github.com/sapslaj/zonepop/source/axfr"
+	"github.com/sapslaj/zonepop/source/consul"
 	custom_source "github.com/sapslaj/zonepop/source/custom"
+	"github.com/sapslaj/zonepop/source/httpjson"
+	"github.com/sapslaj/zonepop/source/mdns"
+	"github.com/sapslaj/zonepop/source/netbox"
+	prometheussd "github.com/sapslaj/zonepop/source/prometheus_sd"
+	"github.com/sapslaj/zonepop/source/snmp"
+	"github.com/sapslaj/zonepop/source/tailscale"
 	"github.com/sapslaj/zonepop/source/vyos"
 )
 
@@ -29,6 +51,7 @@ import (
 type Config interface {
 	Parse() error
 	Sources() ([]source.NamedSource, error)
+	Enrichers() ([]enricher.NamedEnricher, error)
 	Providers() ([]provider.NamedProvider, error)
 }
 
@@ -37,6 +60,7 @@ type luaConfig struct {
 	configFileName       string
 	state                *lua.LState
 	sourceDeclarations   map[string]*lua.LTable
+	enricherDeclarations []*lua.LTable
 	providerDeclarations map[string]*lua.LTable
 }
 
@@ -79,6 +103,7 @@ func (c *luaConfig) Parse() error {
 		return err
 	}
 	sourceDeclarations := make(map[string]*lua.LTable)
+	enricherDeclarations := make([]*lua.LTable, 0)
 	providerDeclarations := make(map[string]*lua.LTable)
 	t.ForEach(func(key, value lua.LValue) {
 		if key.String() == "sources" {
@@ -94,6 +119,22 @@ func (c *luaConfig) Parse() error {
 				c.logger.Sugar().Panicf("config: could not convert %#v to LTable", value)
 			}
 		}
+		if key.String() == "enrichers" {
+			et, ok := value.(*lua.LTable)
+			if !ok {
+				c.logger.Sugar().Panicf("config: could not convert %#v to LTable", value)
+			}
+			// enrichers are a list rather than a table of names since they
+			// run in order
+			for i := 1; i <= et.MaxN(); i++ {
+				enricherDeclaration := et.RawGetInt(i)
+				ed, ok := enricherDeclaration.(*lua.LTable)
+				if !ok {
+					c.logger.Sugar().Panicf("config: could not convert %#v to LTable", enricherDeclaration)
+				}
+				enricherDeclarations = append(enricherDeclarations, ed)
+			}
+		}
 		if key.String() == "providers" {
 			pt, ok := value.(*lua.LTable)
 			pt.ForEach(func(providerName, providerDeclaration lua.LValue) {
@@ -109,6 +150,7 @@ func (c *luaConfig) Parse() error {
 		}
 	})
 	c.sourceDeclarations = sourceDeclarations
+	c.enricherDeclarations = enricherDeclarations
 	c.providerDeclarations = providerDeclarations
 	return nil
 }
@@ -136,11 +178,75 @@ func (c *luaConfig) Sources() ([]source.NamedSource, error) {
 		sourceLogger = sourceLogger.With("kind", kind)
 		sourceLogger.Infof("config: source %s is kind %s", sourceName, kind)
 		switch kind {
+		case "axfr":
+			var axfrConfig axfr.AXFRSourceConfig
+			err = gluamapper.Map(sourceConfig, &axfrConfig)
+			if err != nil {
+				sourceLogger.Errorw("error configuring source", "err", err)
+				return sources, err
+			}
+			sourceInstance, err = axfr.NewAXFRSource(axfrConfig)
+		case "consul":
+			var consulConfig consul.ConsulSourceConfig
+			err = gluamapper.Map(sourceConfig, &consulConfig)
+			if err != nil {
+				sourceLogger.Errorw("error configuring source", "err", err)
+				return sources, err
+			}
+			sourceInstance, err = consul.NewConsulSource(consulConfig)
 		case "custom":
 			endpointFunc, ok := sourceConfig.RawGetString("endpoints").(*lua.LFunction)
 			if ok {
 				sourceInstance, err = custom_source.NewCustomLuaSource(c.state, endpointFunc)
 			}
+		case "http_json":
+			var httpJSONConfig httpjson.HTTPJSONSourceConfig
+			err = gluamapper.Map(sourceConfig, &httpJSONConfig)
+			if err != nil {
+				sourceLogger.Errorw("error configuring source", "err", err)
+				return sources, err
+			}
+			sourceInstance, err = httpjson.NewHTTPJSONSource(httpJSONConfig)
+		case "mdns":
+			var mdnsConfig mdns.MDNSSourceConfig
+			err = gluamapper.Map(sourceConfig, &mdnsConfig)
+			if err != nil {
+				sourceLogger.Errorw("error configuring source", "err", err)
+				return sources, err
+			}
+			sourceInstance, err = mdns.NewMDNSSource(mdnsConfig)
+		case "netbox":
+			var netboxConfig netbox.NetBoxSourceConfig
+			err = gluamapper.Map(sourceConfig, &netboxConfig)
+			if err != nil {
+				sourceLogger.Errorw("error configuring source", "err", err)
+				return sources, err
+			}
+			sourceInstance, err = netbox.NewNetBoxSource(netboxConfig)
+		case "prometheus_sd":
+			var prometheusSDConfig prometheussd.PrometheusSDSourceConfig
+			err = gluamapper.Map(sourceConfig, &prometheusSDConfig)
+			if err != nil {
+				sourceLogger.Errorw("error configuring source", "err", err)
+				return sources, err
+			}
+			sourceInstance, err = prometheussd.NewPrometheusSDSource(c.state, prometheusSDConfig)
+		case "snmp":
+			var snmpConfig snmp.SNMPSourceConfig
+			err = gluamapper.Map(sourceConfig, &snmpConfig)
+			if err != nil {
+				sourceLogger.Errorw("error configuring source", "err", err)
+				return sources, err
+			}
+			sourceInstance, err = snmp.NewSNMPSource(snmpConfig)
+		case "tailscale":
+			var tailscaleConfig tailscale.TailscaleSourceConfig
+			err = gluamapper.Map(sourceConfig, &tailscaleConfig)
+			if err != nil {
+				sourceLogger.Errorw("error configuring source", "err", err)
+				return sources, err
+			}
+			sourceInstance, err = tailscale.NewTailscaleSource(tailscaleConfig)
 		case "vyos_ssh":
 			var vyosConfig vyos.VyOSSSHSourceConfig
 			err = gluamapper.Map(sourceConfig, &vyosConfig)
@@ -155,17 +261,109 @@ func (c *luaConfig) Sources() ([]source.NamedSource, error) {
 			sourceLogger.Errorw("error configuring source", "err", err)
 			return sources, err
 		}
+		invalidAddresses := source.InvalidAddressesReject
+		if policy, ok := sourceDeclaration.RawGetString("invalid_addresses").(lua.LString); ok {
+			invalidAddresses = string(policy)
+		}
+		if invalidAddresses != source.InvalidAddressesReject && invalidAddresses != source.InvalidAddressesQuarantine {
+			err = fmt.Errorf("config: invalid_addresses for %s must be %q or %q", sourceName, source.InvalidAddressesReject, source.InvalidAddressesQuarantine)
+			sourceLogger.Error(err)
+			return sources, err
+		}
 		if sourceInstance != nil {
 			sources = append(sources, source.NamedSource{
-				Name:   sourceName,
-				Source: sourceInstance,
+				Name:             sourceName,
+				Source:           sourceInstance,
+				InvalidAddresses: invalidAddresses,
 			})
 			sourceLogger.Info("config: Finished configuration")
 		}
 	}
+	for _, s := range sources {
+		ds, ok := s.Source.(source.DependentSource)
+		if !ok {
+			continue
+		}
+		dependencies := make([]source.NamedSource, 0)
+		for _, name := range ds.SourceDependencies() {
+			i := slices.IndexFunc(sources, func(ns source.NamedSource) bool {
+				return ns.Name == name
+			})
+			if i == -1 {
+				err := fmt.Errorf("config: source %s depends on unknown source %s", s.Name, name)
+				c.logger.Error(err.Error())
+				return sources, err
+			}
+			dependencies = append(dependencies, sources[i])
+		}
+		ds.SetSourceDependencies(dependencies)
+	}
 	return sources, nil
 }
 
+// Enrichers parses the enricher declarations into a slice of initialized and
+// configured enrichers in the order they were declared.
+func (c *luaConfig) Enrichers() ([]enricher.NamedEnricher, error) {
+	enrichers := make([]enricher.NamedEnricher, 0)
+	for _, enricherDeclaration := range c.enricherDeclarations {
+		var enricherInstance enricher.Enricher
+		var err error
+
+		kind := enricherDeclaration.RawGetInt(1).String()
+		enricherName := kind
+		if name, ok := enricherDeclaration.RawGetString("name").(lua.LString); ok {
+			enricherName = string(name)
+		}
+		enricherLogger := c.logger.With(zap.String("enricher", enricherName)).Sugar()
+		enricherLogger.Infof("config: processing enricher %s", enricherName)
+
+		enricherConfig := c.state.NewTable()
+		if enricherConfigRaw := enricherDeclaration.RawGetString("config"); enricherConfigRaw != lua.LNil {
+			var ok bool
+			enricherConfig, ok = enricherConfigRaw.(*lua.LTable)
+			if !ok {
+				err = fmt.Errorf("config: config for %s could not convert value %#v to LTable", enricherName, enricherConfigRaw)
+				enricherLogger.Error(err)
+				return enrichers, err
+			}
+		}
+
+		enricherLogger = enricherLogger.With("kind", kind)
+		enricherLogger.Infof("config: enricher %s is kind %s", enricherName, kind)
+		switch kind {
+		case "hostname":
+			var hostnameConfig hostname.HostnameEnricherConfig
+			err = gluamapper.Map(enricherConfig, &hostnameConfig)
+			if err != nil {
+				enricherLogger.Errorw("error configuring enricher", "err", err)
+				return enrichers, err
+			}
+			enricherInstance, err = hostname.NewHostnameEnricher(c.state, hostnameConfig)
+		case "mac_vendor":
+			var macVendorConfig macvendor.MACVendorEnricherConfig
+			err = gluamapper.Map(enricherConfig, &macVendorConfig)
+			if err != nil {
+				enricherLogger.Errorw("error configuring enricher", "err", err)
+				return enrichers, err
+			}
+			enricherInstance, err = macvendor.NewMACVendorEnricher(macVendorConfig)
+		}
+
+		if err != nil {
+			enricherLogger.Errorw("error configuring enricher", "err", err)
+			return enrichers, err
+		}
+		if enricherInstance != nil {
+			enrichers = append(enrichers, enricher.NamedEnricher{
+				Name:     enricherName,
+				Enricher: enricherInstance,
+			})
+			enricherLogger.Info("config: Finished configuration")
+		}
+	}
+	return enrichers, nil
+}
+
 // Providers parses the provider declarations into a slice of initialized and
 // configured providers.
 func (c *luaConfig) Providers() ([]provider.NamedProvider, error) {
@@ -178,6 +376,11 @@ func (c *luaConfig) Providers() ([]provider.NamedProvider, error) {
 		var err error
 
 		kind := providerDeclaration.RawGetInt(1).String()
+		// the name labels the metrics of the provider instance
+		name := providerName
+		if name == "" {
+			name = kind
+		}
 		providerConfigRaw := providerDeclaration.RawGetString("config")
 		providerConfig, ok := providerConfigRaw.(*lua.LTable)
 		if !ok {
@@ -191,6 +394,14 @@ func (c *luaConfig) Providers() ([]provider.NamedProvider, error) {
 		forwardFilterFunc := c.createEndpointFilterFunction(providerConfig, "forward_lookup_filter")
 		reverseFilterFunc := c.createEndpointFilterFunction(providerConfig, "reverse_lookup_filter")
 		switch kind {
+		case "adguard_home":
+			var agConfig adguardhome.AdGuardHomeProviderConfig
+			err = gluamapper.Map(providerConfig, &agConfig)
+			if err != nil {
+				providerLogger.Errorw("error configuring provider", "err", err)
+				return providers, err
+			}
+			providerInstance, err = adguardhome.NewAdGuardHomeProvider(name, agConfig, forwardFilterFunc)
 		case "aws_route53":
 			var r53Config aws.Route53ProviderConfig
 			err = gluamapper.Map(providerConfig, &r53Config)
@@ -200,10 +411,40 @@ func (c *luaConfig) Providers() ([]provider.NamedProvider, error) {
 			}
 
 			providerInstance, err = aws.NewRoute53Provider(
+				name,
 				r53Config,
 				forwardFilterFunc,
 				reverseFilterFunc,
 			)
+		case "azure_dns":
+			var azureConfig azure.AzureDNSProviderConfig
+			err = gluamapper.Map(providerConfig, &azureConfig)
+			if err != nil {
+				providerLogger.Errorw("error configuring provider", "err", err)
+				return providers, err
+			}
+			providerInstance, err = azure.NewAzureDNSProvider(
+				name,
+				azureConfig,
+				forwardFilterFunc,
+				reverseFilterFunc,
+			)
+		case "cloudflare":
+			var cfConfig cloudflare.CloudflareProviderConfig
+			err = gluamapper.Map(providerConfig, &cfConfig)
+			if err != nil {
+				providerLogger.Errorw("error configuring provider", "err", err)
+				return providers, err
+			}
+			providerInstance, err = cloudflare.NewCloudflareProvider(name, cfConfig, forwardFilterFunc)
+		case "coredns":
+			var corednsConfig coredns.CoreDNSProviderConfig
+			err = gluamapper.Map(providerConfig, &corednsConfig)
+			if err != nil {
+				providerLogger.Errorw("error configuring provider", "err", err)
+				return providers, err
+			}
+			providerInstance, err = coredns.NewCoreDNSProvider(name, corednsConfig, forwardFilterFunc)
 		case "custom":
 			updateEndpointsFunc, ok := providerConfig.RawGetString("update_endpoints").(*lua.LFunction)
 			if ok {
@@ -214,6 +455,27 @@ func (c *luaConfig) Providers() ([]provider.NamedProvider, error) {
 					reverseFilterFunc,
 				)
 			}
+		case "dns_server":
+			var dnsServerConfig dnsserver.DNSServerProviderConfig
+			err = gluamapper.Map(providerConfig, &dnsServerConfig)
+			if err != nil {
+				providerLogger.Errorw("error configuring provider", "err", err)
+				return providers, err
+			}
+			providerInstance, err = dnsserver.NewDNSServerProvider(
+				name,
+				dnsServerConfig,
+				forwardFilterFunc,
+				reverseFilterFunc,
+			)
+		case "dnsmasq":
+			var dnsmasqConfig dnsmasq.DnsmasqProviderConfig
+			err = gluamapper.Map(providerConfig, &dnsmasqConfig)
+			if err != nil {
+				providerLogger.Errorw("error configuring provider", "err", err)
+				return providers, err
+			}
+			providerInstance, err = dnsmasq.NewDnsmasqProvider(name, dnsmasqConfig, forwardFilterFunc)
 		case "file":
 			var fileConfig file.FileProviderConfig
 			err = gluamapper.Map(providerConfig, &fileConfig)
@@ -222,11 +484,25 @@ func (c *luaConfig) Providers() ([]provider.NamedProvider, error) {
 				return providers, err
 			}
 			providerInstance, err = file.NewFileProvider(
+				name,
 				c.state,
 				fileConfig,
 				forwardFilterFunc,
 				reverseFilterFunc,
 			)
+		case "gcp_cloud_dns":
+			var cloudDNSConfig gcp.CloudDNSProviderConfig
+			err = gluamapper.Map(providerConfig, &cloudDNSConfig)
+			if err != nil {
+				providerLogger.Errorw("error configuring provider", "err", err)
+				return providers, err
+			}
+			providerInstance, err = gcp.NewCloudDNSProvider(
+				name,
+				cloudDNSConfig,
+				forwardFilterFunc,
+				reverseFilterFunc,
+			)
 		case "hosts_file":
 			var hfConfig hostsfile.HostsFileProviderConfig
 			err = gluamapper.Map(providerConfig, &hfConfig)
@@ -235,6 +511,7 @@ func (c *luaConfig) Providers() ([]provider.NamedProvider, error) {
 				return providers, err
 			}
 			providerInstance, err = hostsfile.NewHostsFileProvider(
+				name,
 				hfConfig,
 				forwardFilterFunc,
 			)
@@ -245,7 +522,28 @@ func (c *luaConfig) Providers() ([]provider.NamedProvider, error) {
 				providerLogger.Errorw("error configuring provider", "err", err)
 				return providers, err
 			}
-			providerInstance, err = http_provider.NewHTTPProvider(httpConfig, forwardFilterFunc, reverseFilterFunc)
+			providerInstance, err = http_provider.NewHTTPProvider(name, httpConfig, forwardFilterFunc, reverseFilterFunc)
+		case "pihole":
+			var piholeConfig pihole.PiholeProviderConfig
+			err = gluamapper.Map(providerConfig, &piholeConfig)
+			if err != nil {
+				providerLogger.Errorw("error configuring provider", "err", err)
+				return providers, err
+			}
+			providerInstance, err = pihole.NewPiholeProvider(name, piholeConfig, forwardFilterFunc)
+		case "powerdns":
+			var pdnsConfig powerdns.PowerDNSProviderConfig
+			err = gluamapper.Map(providerConfig, &pdnsConfig)
+			if err != nil {
+				providerLogger.Errorw("error configuring provider", "err", err)
+				return providers, err
+			}
+			providerInstance, err = powerdns.NewPowerDNSProvider(
+				name,
+				pdnsConfig,
+				forwardFilterFunc,
+				reverseFilterFunc,
+			)
 		case "prometheus_metrics":
 			var pmConfig prometheusmetrics.PrometheusMetricsProviderConfig
 			err = gluamapper.Map(providerConfig, &pmConfig)
@@ -254,10 +552,23 @@ func (c *luaConfig) Providers() ([]provider.NamedProvider, error) {
 				return providers, err
 			}
 			providerInstance, err = prometheusmetrics.NewPrometheusMetricsProvider(
-				providerName,
+				name,
 				pmConfig,
 				forwardFilterFunc,
 			)
+		case "unbound":
+			var unboundConfig unbound.UnboundProviderConfig
+			err = gluamapper.Map(providerConfig, &unboundConfig)
+			if err != nil {
+				providerLogger.Errorw("error configuring provider", "err", err)
+				return providers, err
+			}
+			providerInstance, err = unbound.NewUnboundProvider(
+				name,
+				unboundConfig,
+				forwardFilterFunc,
+				reverseFilterFunc,
+			)
 		}
 
 		if err != nil {
@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/enricher"
 	"github.com/sapslaj/zonepop/provider"
 	"github.com/sapslaj/zonepop/source"
 )
@@ -43,6 +44,15 @@ func configSources(t *testing.T, config Config) []source.NamedSource {
 	return s
 }
 
+func configEnrichers(t *testing.T, config Config) []enricher.NamedEnricher {
+	t.Helper()
+	e, err := config.Enrichers()
+	if err != nil {
+		t.Fatalf("config.Enrichers returned error: %v", err)
+	}
+	return e
+}
+
 func assertType(t *testing.T, v any, want string) {
 	t.Helper()
 	got := reflect.TypeOf(v).String()
@@ -79,21 +89,56 @@ func TestLuaConfig_Providers(t *testing.T) {
 		providerName   string
 		configFileName string
 	}{
+		"adguard_home": {
+			providerType:   "*adguardhome.adGuardHomeProvider",
+			providerName:   "adguard",
+			configFileName: "test_lua/lua_config_providers_adguard_home.lua",
+		},
 		"aws_route53": {
 			providerType:   "*aws.route53Provider",
 			providerName:   "route53",
 			configFileName: "test_lua/lua_config_providers_aws_route53.lua",
 		},
+		"azure_dns": {
+			providerType:   "*azure.azureDNSProvider",
+			providerName:   "azure",
+			configFileName: "test_lua/lua_config_providers_azure_dns.lua",
+		},
+		"cloudflare": {
+			providerType:   "*cloudflare.cloudflareProvider",
+			providerName:   "cloudflare",
+			configFileName: "test_lua/lua_config_providers_cloudflare.lua",
+		},
+		"coredns": {
+			providerType:   "*coredns.coreDNSProvider",
+			providerName:   "coredns",
+			configFileName: "test_lua/lua_config_providers_coredns.lua",
+		},
 		"custom": {
 			providerType:   "*custom.customLuaProvider",
 			providerName:   "custom",
 			configFileName: "test_lua/lua_config_providers_custom.lua",
 		},
+		"dns_server": {
+			providerType:   "*dnsserver.dnsServerProvider",
+			providerName:   "dns",
+			configFileName: "test_lua/lua_config_providers_dns_server.lua",
+		},
+		"dnsmasq": {
+			providerType:   "*dnsmasq.dnsmasqProvider",
+			providerName:   "dnsmasq",
+			configFileName: "test_lua/lua_config_providers_dnsmasq.lua",
+		},
 		"file": {
 			providerType:   "*file.FileProvider",
 			providerName:   "file",
 			configFileName: "test_lua/lua_config_providers_file.lua",
 		},
+		"gcp_cloud_dns": {
+			providerType:   "*gcp.cloudDNSProvider",
+			providerName:   "gcp",
+			configFileName: "test_lua/lua_config_providers_gcp_cloud_dns.lua",
+		},
 		"hosts_file": {
 			providerType:   "*hostsfile.hostsFileProvider",
 			providerName:   "hostsfile",
@@ -104,11 +149,26 @@ func TestLuaConfig_Providers(t *testing.T) {
 			providerName:   "http",
 			configFileName: "test_lua/lua_config_providers_http.lua",
 		},
+		"pihole": {
+			providerType:   "*pihole.piholeProvider",
+			providerName:   "pihole",
+			configFileName: "test_lua/lua_config_providers_pihole.lua",
+		},
+		"powerdns": {
+			providerType:   "*powerdns.powerDNSProvider",
+			providerName:   "powerdns",
+			configFileName: "test_lua/lua_config_providers_powerdns.lua",
+		},
 		"prometheus_metrics": {
 			providerType:   "*prometheusmetrics.PrometheusMetricsProvider",
 			providerName:   "prom",
 			configFileName: "test_lua/lua_config_providers_prometheus_metrics.lua",
 		},
+		"unbound": {
+			providerType:   "*unbound.unboundProvider",
+			providerName:   "unbound",
+			configFileName: "test_lua/lua_config_providers_unbound.lua",
+		},
 	}
 	for n, tc := range luaConfig {
 		t.Run(n, func(t *testing.T) {
@@ -127,11 +187,51 @@ func TestLuaConfig_Sources(t *testing.T) {
 		sourceName     string
 		configFileName string
 	}{
+		"axfr": {
+			sourceType:     "*axfr.axfrSource",
+			sourceName:     "axfr",
+			configFileName: "test_lua/lua_config_sources_axfr.lua",
+		},
+		"consul": {
+			sourceType:     "*consul.consulSource",
+			sourceName:     "consul",
+			configFileName: "test_lua/lua_config_sources_consul.lua",
+		},
 		"custom": {
 			sourceType:     "*custom.customLuaSource",
 			sourceName:     "custom",
 			configFileName: "test_lua/lua_config_sources_custom.lua",
 		},
+		"http_json": {
+			sourceType:     "*httpjson.httpJSONSource",
+			sourceName:     "inventory",
+			configFileName: "test_lua/lua_config_sources_http_json.lua",
+		},
+		"mdns": {
+			sourceType:     "*mdns.mdnsSource",
+			sourceName:     "mdns",
+			configFileName: "test_lua/lua_config_sources_mdns.lua",
+		},
+		"netbox": {
+			sourceType:     "*netbox.netboxSource",
+			sourceName:     "netbox",
+			configFileName: "test_lua/lua_config_sources_netbox.lua",
+		},
+		"prometheus_sd": {
+			sourceType:     "*prometheussd.prometheusSDSource",
+			sourceName:     "prometheus_sd",
+			configFileName: "test_lua/lua_config_sources_prometheus_sd.lua",
+		},
+		"snmp": {
+			sourceType:     "*snmp.snmpSource",
+			sourceName:     "snmp",
+			configFileName: "test_lua/lua_config_sources_snmp.lua",
+		},
+		"tailscale": {
+			sourceType:     "*tailscale.tailscaleSource",
+			sourceName:     "tailscale",
+			configFileName: "test_lua/lua_config_sources_tailscale.lua",
+		},
 		"vyos_ssh": {
 			sourceType:     "*vyos.vyosSSHSource",
 			sourceName:     "vyos",
@@ -149,6 +249,49 @@ func TestLuaConfig_Sources(t *testing.T) {
 	}
 }
 
+func TestLuaConfig_Enrichers(t *testing.T) {
+	config := newTestLuaConfig(t, "test_lua/lua_config_enrichers.lua")
+	enrichers := configEnrichers(t, config)
+	assert.Len(t, enrichers, 4)
+	assert.Equal(t, "mac_vendor", enrichers[0].Name)
+	assertType(t, enrichers[0].Enricher, "*macvendor.macVendorEnricher")
+	assert.Equal(t, "custom_oui", enrichers[1].Name)
+	assertType(t, enrichers[1].Enricher, "*macvendor.macVendorEnricher")
+	assert.Equal(t, "hostname", enrichers[2].Name)
+	assertType(t, enrichers[2].Enricher, "*hostname.hostnameEnricher")
+	assert.Equal(t, "lua_hostname", enrichers[3].Name)
+	assertType(t, enrichers[3].Enricher, "*hostname.hostnameEnricher")
+
+	config = newTestLuaConfig(t, "test_lua/lua_config_basic_basic.lua")
+	assert.Empty(t, configEnrichers(t, config))
+}
+
+func TestLuaConfig_SourceDependencies(t *testing.T) {
+	config := newTestLuaConfig(t, "test_lua/lua_config_sources_dependencies.lua")
+	sources := configSources(t, config)
+	assert.Len(t, sources, 2)
+
+	config = newTestLuaConfig(t, "test_lua/lua_config_sources_dependencies_unknown.lua")
+	_, err := config.Sources()
+	assert.ErrorContains(t, err, "source snmp depends on unknown source dhcp")
+}
+
+func TestLuaConfig_SourceInvalidAddresses(t *testing.T) {
+	config := newTestLuaConfig(t, "test_lua/lua_config_sources_invalid_addresses.lua")
+	policies := map[string]string{}
+	for _, s := range configSources(t, config) {
+		policies[s.Name] = s.InvalidAddresses
+	}
+	assert.Equal(t, map[string]string{
+		"quarantined": source.InvalidAddressesQuarantine,
+		"rejected":    source.InvalidAddressesReject,
+	}, policies)
+
+	config = newTestLuaConfig(t, "test_lua/lua_config_sources_invalid_addresses_unknown.lua")
+	_, err := config.Sources()
+	assert.ErrorContains(t, err, "invalid_addresses for consul")
+}
+
 func TestLuaConfig_LookupFilter(t *testing.T) {
 	luaConfig := map[string]struct {
 		configFileName string
@@ -0,0 +1,28 @@
+return {
+  enrichers = {
+    { "mac_vendor" },
+    {
+      "mac_vendor",
+      name = "custom_oui",
+      config = {
+        database_files = { "../enricher/macvendor/testdata/oui.csv" },
+        property = "mac_address",
+      },
+    },
+    {
+      "hostname",
+      config = {
+        template = "{{ .Pool | toLower }}-{{ .MAC | replace \":\" \"\" }}",
+      },
+    },
+    {
+      "hostname",
+      name = "lua_hostname",
+      config = {
+        generate = function(endpoint)
+          return "host-" .. endpoint.source_properties.hardware_address
+        end,
+      },
+    },
+  },
+}
@@ -0,0 +1,13 @@
+return {
+  providers = {
+    adguard = {
+      "adguard_home",
+      config = {
+        url = "http://localhost:3000",
+        username = "admin",
+        password = "test-password",
+        record_suffix = ".lan",
+      },
+    }
+  }
+}
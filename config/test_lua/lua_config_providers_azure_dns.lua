@@ -0,0 +1,13 @@
+return {
+  providers = {
+    azure = {
+      "azure_dns",
+      config = {
+        subscription_id = "00000000-0000-0000-0000-000000000000",
+        resource_group = "dns",
+        forward_zone = "example.com",
+        ipv4_reverse_zone = "2.0.192.in-addr.arpa",
+      },
+    }
+  }
+}
@@ -0,0 +1,11 @@
+return {
+  providers = {
+    cloudflare = {
+      "cloudflare",
+      config = {
+        api_token = "test-token",
+        zone_name = "example.com",
+      },
+    }
+  }
+}
@@ -0,0 +1,17 @@
+return {
+  providers = {
+    coredns = {
+      "coredns",
+      config = {
+        format = "zone",
+        record_suffix = ".lan",
+        file = "/etc/coredns/db.lan",
+        ssh = {
+          host = "resolver.lan",
+          username = "zonepop",
+          password = "test-password",
+        },
+      },
+    }
+  }
+}
@@ -0,0 +1,15 @@
+return {
+  providers = {
+    dns = {
+      "dns_server",
+      config = {
+        listen_address = "127.0.0.1:5353",
+        record_suffix = ".lan",
+        reverse_zones = { "2.0.192.in-addr.arpa" },
+        secondaries = { "192.0.2.53" },
+        forwarders = { "192.0.2.1" },
+        allowed_networks = { "192.0.2.0/24" },
+      },
+    }
+  }
+}
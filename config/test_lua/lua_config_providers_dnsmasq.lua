@@ -0,0 +1,15 @@
+return {
+  providers = {
+    dnsmasq = {
+      "dnsmasq",
+      config = {
+        record_suffix = ".lan",
+        file = "/etc/dnsmasq.d/zonepop.conf",
+        reload = {
+          signal = "HUP",
+          pid_file = "/run/dnsmasq.pid",
+        },
+      },
+    }
+  }
+}
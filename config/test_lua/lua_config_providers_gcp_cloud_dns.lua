@@ -0,0 +1,12 @@
+return {
+  providers = {
+    gcp = {
+      "gcp_cloud_dns",
+      config = {
+        project = "test-project",
+        forward_zone = "example-com",
+        ipv4_reverse_zone = "reverse-ipv4",
+      },
+    }
+  }
+}
@@ -0,0 +1,13 @@
+return {
+  providers = {
+    pihole = {
+      "pihole",
+      config = {
+        url = "http://pi.hole",
+        password = "test-password",
+        record_suffix = ".lan",
+        clean = true,
+      },
+    }
+  }
+}
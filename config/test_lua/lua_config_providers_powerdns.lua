@@ -0,0 +1,13 @@
+return {
+  providers = {
+    powerdns = {
+      "powerdns",
+      config = {
+        url = "http://localhost:8081",
+        api_key = "test-key",
+        forward_zone = "example.com",
+        ipv4_reverse_zones = { "2.0.192.in-addr.arpa" },
+      },
+    }
+  }
+}
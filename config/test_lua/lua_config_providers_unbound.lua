@@ -0,0 +1,17 @@
+return {
+  providers = {
+    unbound = {
+      "unbound",
+      config = {
+        record_suffix = ".lan",
+        command = "sudo unbound-control",
+        include_file = "/etc/unbound/unbound.conf.d/zonepop.conf",
+        ssh = {
+          host = "resolver.lan",
+          username = "zonepop",
+          password = "test-password",
+        },
+      },
+    }
+  }
+}
@@ -0,0 +1,13 @@
+return {
+  sources = {
+    axfr = {
+      "axfr",
+      config = {
+        zone = "example.com",
+        server = "192.0.2.53",
+        tsig_key_name = "transfer-key",
+        tsig_secret = "em9uZXBvcC10ZXN0LXNlY3JldA==",
+      },
+    }
+  }
+}
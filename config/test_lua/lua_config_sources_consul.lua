@@ -0,0 +1,15 @@
+return {
+  sources = {
+    consul = {
+      "consul",
+      config = {
+        address = "http://127.0.0.1:8500",
+        service_tags = { "dns" },
+        node_meta = {
+          env = "prod",
+        },
+        watch = true,
+      },
+    }
+  }
+}
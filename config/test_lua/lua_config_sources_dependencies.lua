@@ -0,0 +1,17 @@
+return {
+  sources = {
+    dhcp = {
+      "custom",
+      config = {
+        endpoints = function(config) return {} end,
+      }
+    },
+    snmp = {
+      "snmp",
+      config = {
+        host = "192.0.2.1",
+        name_sources = { "dhcp" },
+      },
+    }
+  }
+}
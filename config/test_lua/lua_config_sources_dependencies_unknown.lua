@@ -0,0 +1,11 @@
+return {
+  sources = {
+    snmp = {
+      "snmp",
+      config = {
+        host = "192.0.2.1",
+        name_sources = { "dhcp" },
+      },
+    }
+  }
+}
@@ -0,0 +1,22 @@
+return {
+  sources = {
+    inventory = {
+      "http_json",
+      config = {
+        url = "https://inventory.example.com/api/hosts",
+        bearer_token = "token",
+        headers = {
+          ["X-Requested-By"] = "zonepop",
+        },
+        pagination = "link",
+        items_path = "data.hosts",
+        hostname_path = "name",
+        addresses_path = "interfaces[*].address",
+        strip_prefix_length = true,
+        properties = {
+          site = "location.site",
+        },
+      },
+    }
+  }
+}
@@ -0,0 +1,13 @@
+return {
+  sources = {
+    quarantined = {
+      "consul",
+      invalid_addresses = "quarantine",
+      config = {},
+    },
+    rejected = {
+      "consul",
+      config = {},
+    },
+  }
+}
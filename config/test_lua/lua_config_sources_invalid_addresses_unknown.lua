@@ -0,0 +1,9 @@
+return {
+  sources = {
+    consul = {
+      "consul",
+      invalid_addresses = "ignore",
+      config = {},
+    },
+  }
+}
@@ -0,0 +1,12 @@
+return {
+  sources = {
+    mdns = {
+      "mdns",
+      config = {
+        interfaces = { "eth0" },
+        ipv6 = true,
+        browse_services = { "_http._tcp", "_ipp._tcp" },
+      },
+    }
+  }
+}
@@ -0,0 +1,14 @@
+return {
+  sources = {
+    netbox = {
+      "netbox",
+      config = {
+        url = "https://netbox.example.com",
+        token = "0123456789abcdef0123456789abcdef01234567",
+        prefixes = { "192.0.2.0/24" },
+        statuses = { "active" },
+        strip_domain = ".example.com",
+      },
+    }
+  }
+}
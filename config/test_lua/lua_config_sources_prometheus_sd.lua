@@ -0,0 +1,14 @@
+return {
+  sources = {
+    prometheus_sd = {
+      "prometheus_sd",
+      config = {
+        files = { "/etc/prometheus/targets/*.json" },
+        hostname = function(labels)
+          return labels.__meta_hostname
+        end,
+        strip_domain = ".example.com",
+      },
+    }
+  }
+}
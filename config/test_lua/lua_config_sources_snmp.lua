@@ -0,0 +1,15 @@
+return {
+  sources = {
+    snmp = {
+      "snmp",
+      config = {
+        host = "192.0.2.1",
+        community = "public",
+        hostnames = {
+          ["00:53:00:00:00:01"] = "router",
+        },
+        collect_ipv6_addresses = true,
+      },
+    }
+  }
+}
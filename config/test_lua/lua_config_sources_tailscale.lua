@@ -0,0 +1,11 @@
+return {
+  sources = {
+    tailscale = {
+      "tailscale",
+      config = {
+        api_key = "tskey-api-test",
+        tags = { "tag:server" },
+      },
+    }
+  }
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -10,12 +11,15 @@ import (
 
 	"github.com/sapslaj/zonepop/config/configtypes"
 	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/enricher"
 	"github.com/sapslaj/zonepop/provider"
 	"github.com/sapslaj/zonepop/source"
 )
 
 type Controller struct {
-	Sources   []source.NamedSource
+	Sources []source.NamedSource
+	// Enrichers run in order on the endpoints of all sources
+	Enrichers []enricher.NamedEnricher
 	Providers []provider.NamedProvider
 	// The interval between individual synchronizations
 	Interval time.Duration
@@ -47,8 +51,16 @@ func (c *Controller) RunOnce(ctx context.Context) error {
 	}()
 	logger := c.Logger.Sugar()
 	endpoints := make([]*endpoint.Endpoint, 0)
-	for _, s := range c.Sources {
-		e, err := s.Source.Endpoints(ctx)
+	// endpoints of the sources so far, for the sources depending on them
+	fetched := map[string][]*endpoint.Endpoint{}
+	for _, s := range c.sourceOrder() {
+		var e []*endpoint.Endpoint
+		var err error
+		if ds, ok := s.Source.(source.DependentSource); ok {
+			e, err = c.dependentEndpoints(ctx, ds, fetched)
+		} else {
+			e, err = s.Source.Endpoints(ctx)
+		}
 		if err != nil {
 			logger.Errorw(
 				"error getting endpoints from source",
@@ -60,6 +72,7 @@ func (c *Controller) RunOnce(ctx context.Context) error {
 		} else {
 			MetricSourceUp.WithLabelValues(s.Name).Set(1)
 		}
+		e = c.validateEndpoints(s, e)
 		MetricEndpoints.WithLabelValues(s.Name).Set(float64(len(e)))
 		for i := range e {
 			if e[i].SourceProperties == nil {
@@ -67,6 +80,9 @@ func (c *Controller) RunOnce(ctx context.Context) error {
 			}
 			e[i].SourceProperties["source"] = s.Name
 		}
+		if err == nil {
+			fetched[s.Name] = e
+		}
 		endpoints = append(endpoints, e...)
 	}
 	if errors != nil {
@@ -76,6 +92,22 @@ func (c *Controller) RunOnce(ctx context.Context) error {
 		}
 		return errors
 	}
+	for _, e := range c.Enrichers {
+		var err error
+		endpoints, err = e.Enricher.Enrich(ctx, endpoints)
+		if err != nil {
+			logger.Errorw(
+				"error enriching endpoints",
+				"enricher", e.Name,
+				"err", err,
+			)
+			for _, p := range c.Providers {
+				MetricProviderUp.WithLabelValues(p.Name).Set(0)
+			}
+			errors = err
+			return errors
+		}
+	}
 	for _, endpoint := range endpoints {
 		logger.Infow(
 			"registered new endpoint",
@@ -110,10 +142,95 @@ func (c *Controller) RunOnce(ctx context.Context) error {
 	return errors
 }
 
-// ScheduleRunOnce makes sure execution happens at most once per interval.
+// validateEndpoints canonicalizes the addresses of the endpoints from s.
+// Invalid addresses are removed, or the whole endpoint is if s quarantines
+// them.
+func (c *Controller) validateEndpoints(s source.NamedSource, endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	logger := c.Logger.Sugar()
+	valid := make([]*endpoint.Endpoint, 0, len(endpoints))
+	invalidAddresses := 0
+	quarantined := 0
+	for _, e := range endpoints {
+		errs := e.ValidateAddresses()
+		for _, err := range errs {
+			logger.Warnw(
+				"invalid address from source",
+				"source", s.Name,
+				"err", err,
+			)
+		}
+		invalidAddresses += len(errs)
+		if len(errs) > 0 && s.InvalidAddresses == source.InvalidAddressesQuarantine {
+			logger.Warnw(
+				"quarantining endpoint with invalid addresses",
+				"source", s.Name,
+				"hostname", e.Hostname,
+			)
+			quarantined++
+			continue
+		}
+		valid = append(valid, e)
+	}
+	MetricInvalidAddresses.WithLabelValues(s.Name).Set(float64(invalidAddresses))
+	MetricQuarantinedEndpoints.WithLabelValues(s.Name).Set(float64(quarantined))
+	return valid
+}
+
+// sourceOrder returns c.Sources ordered so that dependencies come before the
+// sources depending on them. Sources in a dependency cycle keep their
+// relative order at the end.
+func (c *Controller) sourceOrder() []source.NamedSource {
+	ordered := make([]source.NamedSource, 0, len(c.Sources))
+	done := map[string]bool{}
+	remaining := c.Sources
+	for len(remaining) > 0 {
+		deferred := make([]source.NamedSource, 0)
+		for _, s := range remaining {
+			if ds, ok := s.Source.(source.DependentSource); ok && !allDone(ds.SourceDependencies(), done) {
+				deferred = append(deferred, s)
+				continue
+			}
+			ordered = append(ordered, s)
+			done[s.Name] = true
+		}
+		if len(deferred) == len(remaining) {
+			return append(ordered, deferred...)
+		}
+		remaining = deferred
+	}
+	return ordered
+}
+
+func allDone(names []string, done map[string]bool) bool {
+	for _, name := range names {
+		if !done[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// dependentEndpoints gets the endpoints of ds from the endpoints already
+// fetched from its dependencies. It fails without querying ds if any of the
+// dependencies failed.
+func (c *Controller) dependentEndpoints(ctx context.Context, ds source.DependentSource, fetched map[string][]*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+	dependencies := map[string][]*endpoint.Endpoint{}
+	for _, name := range ds.SourceDependencies() {
+		e, ok := fetched[name]
+		if !ok {
+			return nil, fmt.Errorf("dependency %s has no endpoints", name)
+		}
+		dependencies[name] = e
+	}
+	return ds.EndpointsWithDependencies(ctx, dependencies)
+}
+
+// ScheduleRunOnce schedules a run for the next tick of the controller loop
+// instead of waiting for the rest of the interval.
 func (c *Controller) ScheduleRunOnce(now time.Time) {
 	c.nextRunAtMux.Lock()
 	defer c.nextRunAtMux.Unlock()
+	c.nextRunAt = now
 }
 
 func (c *Controller) ShouldRunOnce(now time.Time) bool {
@@ -130,6 +247,15 @@ func (c *Controller) ShouldRunOnce(now time.Time) bool {
 func (c *Controller) Run(ctx context.Context) {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
+	for _, s := range c.Sources {
+		if es, ok := s.Source.(source.EventSource); ok {
+			c.Logger.Sugar().Infof("registering event handler for source %s", s.Name)
+			es.AddEventHandler(ctx, func() {
+				c.Logger.Sugar().Infof("received change event from source %s", s.Name)
+				c.ScheduleRunOnce(time.Now())
+			})
+		}
+	}
 	for {
 		if c.ShouldRunOnce(time.Now()) {
 			if err := c.RunOnce(ctx); err != nil {
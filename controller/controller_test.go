@@ -6,11 +6,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
 	"github.com/sapslaj/zonepop/config/configtypes"
 	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/enricher"
 	"github.com/sapslaj/zonepop/provider"
 	"github.com/sapslaj/zonepop/source"
 )
@@ -40,6 +43,27 @@ func TestShouldRunOnce(t *testing.T) {
 	}
 }
 
+func TestScheduleRunOnce(t *testing.T) {
+	ctrl := &Controller{
+		Interval: 10 * time.Minute,
+	}
+
+	now := time.Now()
+
+	if !ctrl.ShouldRunOnce(now) {
+		t.Errorf("controller.ShouldRunOnce(now) should be true on first run")
+	}
+
+	now = now.Add(10 * time.Second)
+	ctrl.ScheduleRunOnce(now)
+	if !ctrl.ShouldRunOnce(now) {
+		t.Errorf("controller.ShouldRunOnce(now) should be true after ScheduleRunOnce")
+	}
+	if ctrl.ShouldRunOnce(now) {
+		t.Errorf("controller.ShouldRunOnce(now) should be false after the scheduled run")
+	}
+}
+
 type mockSource struct {
 	endpoints     []*endpoint.Endpoint
 	endpointsFunc func(ctx context.Context) ([]*endpoint.Endpoint, error)
@@ -52,6 +76,23 @@ func (s *mockSource) Endpoints(ctx context.Context) ([]*endpoint.Endpoint, error
 	return s.endpoints, nil
 }
 
+type mockDependentSource struct {
+	mockSource
+	dependencies []string
+	got          map[string][]*endpoint.Endpoint
+}
+
+func (s *mockDependentSource) SourceDependencies() []string {
+	return s.dependencies
+}
+
+func (s *mockDependentSource) SetSourceDependencies(sources []source.NamedSource) {}
+
+func (s *mockDependentSource) EndpointsWithDependencies(ctx context.Context, dependencies map[string][]*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+	s.got = dependencies
+	return s.endpoints, nil
+}
+
 type mockProvider struct {
 	endpoints           []*endpoint.Endpoint
 	updateEndpointsFunc func(ctx context.Context, endpoints []*endpoint.Endpoint) error
@@ -65,6 +106,14 @@ func (p *mockProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoin
 	return nil
 }
 
+type mockEnricher struct {
+	enrichFunc func(ctx context.Context, endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error)
+}
+
+func (e *mockEnricher) Enrich(ctx context.Context, endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+	return e.enrichFunc(ctx, endpoints)
+}
+
 func TestRunOnce(t *testing.T) {
 	for n, dryRun := range map[string]bool{"realRun": false, "dryRun": true} {
 		t.Run(n, func(t *testing.T) {
@@ -183,3 +232,149 @@ func TestMultierr(t *testing.T) {
 	assert.True(t, sourceCalled)
 	assert.True(t, providerCalled)
 }
+
+func TestRunOnce_DependentSources(t *testing.T) {
+	calls := 0
+	names := &mockSource{
+		endpointsFunc: func(ctx context.Context) ([]*endpoint.Endpoint, error) {
+			calls++
+			return []*endpoint.Endpoint{{Hostname: "named", IPv4s: []string{"192.0.2.1"}}}, nil
+		},
+	}
+	dependent := &mockDependentSource{
+		mockSource: mockSource{
+			endpoints: []*endpoint.Endpoint{{Hostname: "dependent", IPv4s: []string{"192.0.2.2"}}},
+		},
+		dependencies: []string{"names"},
+	}
+	p := &mockProvider{}
+	ctrl := &Controller{
+		// dependents are fetched after their dependencies regardless of order
+		Sources: []source.NamedSource{
+			{Name: "dependent", Source: dependent},
+			{Name: "names", Source: names},
+		},
+		Providers: []provider.NamedProvider{
+			{Name: "mock_provider", Provider: p},
+		},
+		Interval: 1 * time.Minute,
+		Logger:   zap.NewNop(),
+	}
+
+	err := ctrl.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	require.Len(t, dependent.got["names"], 1)
+	assert.Equal(t, "named", dependent.got["names"][0].Hostname)
+	require.Len(t, p.endpoints, 2)
+	assert.Equal(t, "named", p.endpoints[0].Hostname)
+	assert.Equal(t, "dependent", p.endpoints[1].Hostname)
+
+	// a failed dependency fails the dependent without querying it
+	names.endpointsFunc = func(ctx context.Context) ([]*endpoint.Endpoint, error) {
+		return nil, errors.New("source error")
+	}
+	dependent.got = nil
+	err = ctrl.RunOnce(context.Background())
+	assert.ErrorContains(t, err, "dependency names has no endpoints")
+	assert.Nil(t, dependent.got)
+}
+
+func TestRunOnce_Enrichers(t *testing.T) {
+	s := &mockSource{
+		endpoints: []*endpoint.Endpoint{{Hostname: "test-host", IPv4s: []string{"192.0.2.1"}}},
+	}
+	calls := []string{}
+	first := &mockEnricher{
+		enrichFunc: func(ctx context.Context, endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+			calls = append(calls, "first")
+			for _, e := range endpoints {
+				e.SourceProperties["enriched"] = true
+			}
+			return endpoints, nil
+		},
+	}
+	second := &mockEnricher{
+		enrichFunc: func(ctx context.Context, endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+			calls = append(calls, "second")
+			return append(endpoints, &endpoint.Endpoint{Hostname: "added"}), nil
+		},
+	}
+	p := &mockProvider{}
+	ctrl := &Controller{
+		Sources: []source.NamedSource{
+			{Name: "mock_source", Source: s},
+		},
+		Enrichers: []enricher.NamedEnricher{
+			{Name: "first", Enricher: first},
+			{Name: "second", Enricher: second},
+		},
+		Providers: []provider.NamedProvider{
+			{Name: "mock_provider", Provider: p},
+		},
+		Interval: 1 * time.Minute,
+		Logger:   zap.NewNop(),
+	}
+
+	err := ctrl.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, calls)
+	require.Len(t, p.endpoints, 2)
+	assert.Equal(t, true, p.endpoints[0].SourceProperties["enriched"])
+	assert.Equal(t, "added", p.endpoints[1].Hostname)
+
+	// providers aren't updated if an enricher fails
+	first.enrichFunc = func(ctx context.Context, endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+		return nil, errors.New("enricher error")
+	}
+	p.endpoints = nil
+	err = ctrl.RunOnce(context.Background())
+	assert.ErrorContains(t, err, "enricher error")
+	assert.Nil(t, p.endpoints)
+}
+
+func TestRunOnce_InvalidAddresses(t *testing.T) {
+	newEndpoints := func() []*endpoint.Endpoint {
+		return []*endpoint.Endpoint{
+			{Hostname: "valid", IPv4s: []string{"192.0.2.1"}},
+			{Hostname: "misfiled", IPv4s: []string{"2001:DB8::1"}},
+			{Hostname: "invalid", IPv4s: []string{"192.0.2.3", "192.0.2.300"}},
+		}
+	}
+	for policy, expect := range map[string][]*endpoint.Endpoint{
+		source.InvalidAddressesReject: {
+			{Hostname: "valid", IPv4s: []string{"192.0.2.1"}},
+			{Hostname: "misfiled", IPv4s: []string{}, IPv6s: []string{"2001:db8::1"}},
+			{Hostname: "invalid", IPv4s: []string{"192.0.2.3"}},
+		},
+		source.InvalidAddressesQuarantine: {
+			{Hostname: "valid", IPv4s: []string{"192.0.2.1"}},
+			{Hostname: "misfiled", IPv4s: []string{}, IPv6s: []string{"2001:db8::1"}},
+		},
+	} {
+		t.Run(policy, func(t *testing.T) {
+			s := &mockSource{endpoints: newEndpoints()}
+			p := &mockProvider{}
+			sourceName := "mock_source_" + policy
+			ctrl := &Controller{
+				Sources: []source.NamedSource{
+					{Name: sourceName, Source: s, InvalidAddresses: policy},
+				},
+				Providers: []provider.NamedProvider{
+					{Name: "mock_provider", Provider: p},
+				},
+				Interval: 1 * time.Minute,
+				Logger:   zap.NewNop(),
+			}
+
+			err := ctrl.RunOnce(context.Background())
+			require.NoError(t, err)
+			for _, e := range p.endpoints {
+				e.SourceProperties = nil
+			}
+			assert.Equal(t, expect, p.endpoints)
+			assert.Equal(t, 1.0, testutil.ToFloat64(MetricInvalidAddresses.WithLabelValues(sourceName)))
+			assert.Equal(t, float64(3-len(expect)), testutil.ToFloat64(MetricQuarantinedEndpoints.WithLabelValues(sourceName)))
+		})
+	}
+}
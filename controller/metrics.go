@@ -28,6 +28,18 @@ var (
 		},
 		[]string{"source"},
 	)
+	MetricInvalidAddresses = metrics.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "invalid_addresses",
+		},
+		[]string{"source"},
+	)
+	MetricQuarantinedEndpoints = metrics.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "quarantined_endpoints",
+		},
+		[]string{"source"},
+	)
 	// Controller Subsystem.
 	MetricRuns = metrics.NewCounterVec(
 		prometheus.CounterOpts{
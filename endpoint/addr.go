@@ -0,0 +1,106 @@
+package endpoint
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// AddressError is returned for addresses of an endpoint that aren't valid IP
+// addresses.
+type AddressError struct {
+	Hostname string
+	Address  string
+	Err      error
+}
+
+func (e *AddressError) Error() string {
+	hostname := e.Hostname
+	if hostname == "" {
+		hostname = "(no hostname)"
+	}
+	return fmt.Sprintf("endpoint %s: invalid address %q: %v", hostname, e.Address, e.Err)
+}
+
+func (e *AddressError) Unwrap() error {
+	return e.Err
+}
+
+// ParseAddr parses an address the way sources may report it, in canonical
+// form. Prefix lengths and zones are dropped, and IPv4-mapped IPv6 addresses
+// are converted to IPv4.
+func ParseAddr(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	var addr netip.Addr
+	var err error
+	if strings.Contains(s, "/") {
+		var prefix netip.Prefix
+		prefix, err = netip.ParsePrefix(s)
+		addr = prefix.Addr()
+	} else {
+		addr, err = netip.ParseAddr(s)
+	}
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addr = addr.Unmap().WithZone("")
+	if addr.IsUnspecified() {
+		return netip.Addr{}, fmt.Errorf("unspecified address")
+	}
+	return addr, nil
+}
+
+// ValidateAddresses canonicalizes the addresses of the endpoint, moves IPv6
+// addresses in IPv4s to IPv6s and vice versa, and removes duplicates. Invalid
+// addresses are removed and returned as AddressErrors.
+func (e *Endpoint) ValidateAddresses() []error {
+	errs := make([]error, 0)
+	// keep nil slices nil
+	ipv4s := e.IPv4s[:0:0]
+	ipv6s := e.IPv6s[:0:0]
+	for _, raw := range slices.Concat(e.IPv4s, e.IPv6s) {
+		addr, err := ParseAddr(raw)
+		if err != nil {
+			errs = append(errs, &AddressError{Hostname: e.Hostname, Address: raw, Err: err})
+			continue
+		}
+		s := addr.String()
+		if addr.Is4() && !slices.Contains(ipv4s, s) {
+			if ipv4s == nil {
+				ipv4s = []string{}
+			}
+			ipv4s = append(ipv4s, s)
+		}
+		if addr.Is6() && !slices.Contains(ipv6s, s) {
+			if ipv6s == nil {
+				ipv6s = []string{}
+			}
+			ipv6s = append(ipv6s, s)
+		}
+	}
+	e.IPv4s = ipv4s
+	e.IPv6s = ipv6s
+	return errs
+}
+
+// IPv4Addrs returns the parsed IPv4s of the endpoint, skipping invalid ones.
+func (e *Endpoint) IPv4Addrs() []netip.Addr {
+	return parseAddrs(e.IPv4s)
+}
+
+// IPv6Addrs returns the parsed IPv6s of the endpoint, skipping invalid ones.
+func (e *Endpoint) IPv6Addrs() []netip.Addr {
+	return parseAddrs(e.IPv6s)
+}
+
+func parseAddrs(addresses []string) []netip.Addr {
+	addrs := make([]netip.Addr, 0, len(addresses))
+	for _, address := range addresses {
+		addr, err := ParseAddr(address)
+		if err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
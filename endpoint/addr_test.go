@@ -0,0 +1,113 @@
+package endpoint
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddr(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		input  string
+		expect string
+		err    bool
+	}{
+		"ipv4": {
+			input:  "192.0.2.1",
+			expect: "192.0.2.1",
+		},
+		"ipv6 canonicalized": {
+			input:  "2001:DB8:0:0::1",
+			expect: "2001:db8::1",
+		},
+		"whitespace": {
+			input:  " 192.0.2.1\n",
+			expect: "192.0.2.1",
+		},
+		"prefix": {
+			input:  "192.0.2.1/24",
+			expect: "192.0.2.1",
+		},
+		"zone": {
+			input:  "fe80::1%eth0",
+			expect: "fe80::1",
+		},
+		"ipv4-mapped ipv6": {
+			input:  "::ffff:192.0.2.1",
+			expect: "192.0.2.1",
+		},
+		"garbage": {
+			input: "not-an-ip",
+			err:   true,
+		},
+		"empty": {
+			input: "",
+			err:   true,
+		},
+		"unspecified": {
+			input: "0.0.0.0",
+			err:   true,
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseAddr(tc.input)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expect, got.String())
+		})
+	}
+}
+
+func TestValidateAddresses(t *testing.T) {
+	t.Parallel()
+
+	e := &Endpoint{
+		Hostname: "test-host",
+		IPv4s:    []string{"192.0.2.1", "2001:db8::1", "bogus", "192.0.2.1/24"},
+		IPv6s:    []string{"2001:0db8::1", "::ffff:192.0.2.2"},
+	}
+	errs := e.ValidateAddresses()
+
+	assert.Equal(t, []string{"192.0.2.1", "192.0.2.2"}, e.IPv4s)
+	assert.Equal(t, []string{"2001:db8::1"}, e.IPv6s)
+	require.Len(t, errs, 1)
+	var addrErr *AddressError
+	require.ErrorAs(t, errs[0], &addrErr)
+	assert.Equal(t, "bogus", addrErr.Address)
+	assert.Equal(t, "test-host", addrErr.Hostname)
+}
+
+func TestValidateAddresses_KeepsNil(t *testing.T) {
+	t.Parallel()
+
+	e := &Endpoint{
+		Hostname: "test-host",
+		IPv4s:    []string{"192.0.2.1"},
+	}
+	assert.Empty(t, e.ValidateAddresses())
+	assert.Equal(t, []string{"192.0.2.1"}, e.IPv4s)
+	assert.Nil(t, e.IPv6s)
+}
+
+func TestAddrs(t *testing.T) {
+	t.Parallel()
+
+	e := &Endpoint{
+		IPv4s: []string{"192.0.2.1", "bogus"},
+		IPv6s: []string{"2001:db8::1"},
+	}
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.1")}, e.IPv4Addrs())
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("2001:db8::1")}, e.IPv6Addrs())
+}
@@ -19,6 +19,10 @@ type Endpoint struct {
 	IPv6s []string `json:"ipv6s,omitempty" gluamapper:"ipv6s"`
 	// Preferred TTL for resulting records
 	RecordTTL int64 `json:"ttl,omitempty" gluamapper:"record_ttl"`
+	// Additional names for CNAME records pointing to Hostname
+	Aliases []string `json:"aliases,omitempty" gluamapper:"aliases"`
+	// Additional records like TXT, SRV or MX
+	Records []Record `json:"records,omitempty" gluamapper:"records"`
 	// Additional key, value pairs from the source
 	SourceProperties map[string]any `json:"source_properties,omitempty" gluamapper:"source_properties"`
 	// Additional key, value pairs for the provider
@@ -54,6 +58,23 @@ func (e *Endpoint) ToLuaTable(state *lua.LState) *lua.LTable {
 		ipv6s.Append(lua.LString(ipv6))
 	}
 	lt.RawSetString("ipv6s", ipv6s)
+
+	// only set when present so endpoints without them round-trip unchanged
+	if len(e.Aliases) > 0 {
+		aliases := state.NewTable()
+		for _, alias := range e.Aliases {
+			aliases.Append(lua.LString(alias))
+		}
+		lt.RawSetString("aliases", aliases)
+	}
+
+	if len(e.Records) > 0 {
+		records := state.NewTable()
+		for _, record := range e.Records {
+			records.Append(record.ToLuaTable(state))
+		}
+		lt.RawSetString("records", records)
+	}
 	sourceProperties := state.NewTable()
 	for k, v := range e.SourceProperties {
 		sourceProperties.RawSetString(k, luar.New(state, v))
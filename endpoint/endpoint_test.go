@@ -53,6 +53,8 @@ func TestFromLuaTable(t *testing.T) {
 	assert.Equal(t, int64(60), endpoint.RecordTTL)
 	assert.Equal(t, map[string]any{"source_prop": "prop"}, endpoint.SourceProperties)
 	assert.Equal(t, map[string]any{"provider_prop": "prop"}, endpoint.ProviderProperties)
+	assert.Equal(t, []string{"nas"}, endpoint.Aliases)
+	assert.Equal(t, []Record{{Type: "MX", Value: "10 mail", TTL: 300}}, endpoint.Records)
 }
 
 func TestLuaTableDiff(t *testing.T) {
@@ -71,6 +73,21 @@ func TestLuaTableDiff(t *testing.T) {
 				ProviderProperties: map[string]any{},
 			},
 		},
+		"aliases and records": {
+			endpoint: &Endpoint{
+				Hostname:  "synology-ds920",
+				IPv4s:     []string{"192.0.2.1"},
+				IPv6s:     []string{},
+				RecordTTL: 60,
+				Aliases:   []string{"nas", "backup"},
+				Records: []Record{
+					{Type: "TXT", Value: "owner=ops"},
+					{Type: "SRV", Name: "_smb._tcp", Value: "0 0 445 synology-ds920", TTL: 300},
+				},
+				SourceProperties:   map[string]any{},
+				ProviderProperties: map[string]any{},
+			},
+		},
 		"all ips and props": {
 			endpoint: &Endpoint{
 				Hostname:  "test-host",
@@ -0,0 +1,137 @@
+package endpoint
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Record types supported in Endpoint.Records
+const (
+	RecordTypeCNAME = "CNAME"
+	RecordTypeTXT   = "TXT"
+	RecordTypeSRV   = "SRV"
+	RecordTypeMX    = "MX"
+)
+
+// Record is an additional DNS record of an endpoint.
+type Record struct {
+	// One of the RecordType constants
+	Type string `json:"type" gluamapper:"type"`
+	// Name of the record. It is relative to the provider's record suffix like
+	// the hostname, and defaults to the hostname of the endpoint.
+	Name string `json:"name,omitempty" gluamapper:"name"`
+	// Record data in zone file presentation format, e.g. "mail" for CNAME,
+	// "10 mail" for MX or "0 5 5060 sip" for SRV. TXT values are the plain
+	// text without quotes. Target names without a trailing dot are relative
+	// to the record suffix.
+	Value string `json:"value" gluamapper:"value"`
+	// TTL of the record, defaults to the RecordTTL of the endpoint
+	TTL int64 `json:"ttl,omitempty" gluamapper:"ttl"`
+}
+
+// Validate checks that the record type is supported and that the value has
+// the right format for it.
+func (r Record) Validate() error {
+	fields := strings.Fields(r.Value)
+	switch r.Type {
+	case RecordTypeTXT:
+		return nil
+	case RecordTypeCNAME:
+		// the whole value is the name, so unnormalized hostnames work too
+		if len(fields) == 0 {
+			return fmt.Errorf("empty CNAME value")
+		}
+	case RecordTypeMX:
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid MX value %q: expected \"preference exchange\"", r.Value)
+		}
+		if _, err := strconv.ParseUint(fields[0], 10, 16); err != nil {
+			return fmt.Errorf("invalid MX preference %q: %w", fields[0], err)
+		}
+	case RecordTypeSRV:
+		if len(fields) != 4 {
+			return fmt.Errorf("invalid SRV value %q: expected \"priority weight port target\"", r.Value)
+		}
+		for _, field := range fields[:3] {
+			if _, err := strconv.ParseUint(field, 10, 16); err != nil {
+				return fmt.Errorf("invalid SRV value %q: %w", r.Value, err)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported record type %q", r.Type)
+	}
+	return nil
+}
+
+// Target returns the name the record points to, or "" for TXT records.
+func (r Record) Target() string {
+	fields := strings.Fields(r.Value)
+	switch {
+	case r.Type == RecordTypeCNAME:
+		return strings.TrimSpace(r.Value)
+	case r.Type == RecordTypeMX && len(fields) == 2:
+		return fields[1]
+	case r.Type == RecordTypeSRV && len(fields) == 4:
+		return fields[3]
+	}
+	return ""
+}
+
+// WithTarget returns a copy of the record pointing to target instead.
+func (r Record) WithTarget(target string) Record {
+	if r.Target() == "" {
+		return r
+	}
+	if r.Type == RecordTypeCNAME {
+		r.Value = target
+		return r
+	}
+	fields := strings.Fields(r.Value)
+	fields[len(fields)-1] = target
+	r.Value = strings.Join(fields, " ")
+	return r
+}
+
+// AllRecords returns the aliases of the endpoint as CNAME records followed by
+// its other records, with the defaults for the name and TTL applied. Aliases
+// and records without a name are left out if the endpoint has no hostname.
+func (e *Endpoint) AllRecords() []Record {
+	records := make([]Record, 0, len(e.Aliases)+len(e.Records))
+	for _, alias := range e.Aliases {
+		if e.Hostname == "" {
+			break
+		}
+		records = append(records, Record{
+			Type:  RecordTypeCNAME,
+			Name:  alias,
+			Value: e.Hostname,
+			TTL:   e.RecordTTL,
+		})
+	}
+	for _, record := range e.Records {
+		record.Type = strings.ToUpper(record.Type)
+		if record.Name == "" {
+			record.Name = e.Hostname
+		}
+		if record.Name == "" {
+			continue
+		}
+		if record.TTL == 0 {
+			record.TTL = e.RecordTTL
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func (r Record) ToLuaTable(state *lua.LState) *lua.LTable {
+	lt := state.NewTable()
+	lt.RawSetString("type", lua.LString(r.Type))
+	lt.RawSetString("name", lua.LString(r.Name))
+	lt.RawSetString("value", lua.LString(r.Value))
+	lt.RawSetString("ttl", lua.LNumber(r.TTL))
+	return lt
+}
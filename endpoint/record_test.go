@@ -0,0 +1,92 @@
+package endpoint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		record Record
+		valid  bool
+	}{
+		"cname": {
+			record: Record{Type: RecordTypeCNAME, Value: "synology-ds920"},
+			valid:  true,
+		},
+		"empty cname": {
+			record: Record{Type: RecordTypeCNAME, Value: " "},
+		},
+		"txt": {
+			record: Record{Type: RecordTypeTXT, Value: "v=spf1 -all"},
+			valid:  true,
+		},
+		"mx": {
+			record: Record{Type: RecordTypeMX, Value: "10 mail.example.com."},
+			valid:  true,
+		},
+		"mx without preference": {
+			record: Record{Type: RecordTypeMX, Value: "mail"},
+		},
+		"srv": {
+			record: Record{Type: RecordTypeSRV, Value: "0 5 5060 sip"},
+			valid:  true,
+		},
+		"srv with invalid port": {
+			record: Record{Type: RecordTypeSRV, Value: "0 5 99999 sip"},
+		},
+		"unsupported type": {
+			record: Record{Type: "A", Value: "192.0.2.1"},
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.record.Validate()
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestRecordWithTarget(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "nas.example.com.", Record{Type: RecordTypeCNAME, Value: "nas"}.WithTarget("nas.example.com.").Value)
+	assert.Equal(t, "10 mail.example.com.", Record{Type: RecordTypeMX, Value: "10 mail"}.WithTarget("mail.example.com.").Value)
+	assert.Equal(t, "0 5 5060 sip.example.com.", Record{Type: RecordTypeSRV, Value: "0 5 5060 sip"}.WithTarget("sip.example.com.").Value)
+	assert.Equal(t, "text", Record{Type: RecordTypeTXT, Value: "text"}.WithTarget("ignored").Value)
+}
+
+func TestAllRecords(t *testing.T) {
+	t.Parallel()
+
+	e := &Endpoint{
+		Hostname:  "synology-ds920",
+		RecordTTL: 60,
+		Aliases:   []string{"nas"},
+		Records: []Record{
+			{Type: "txt", Value: "owner=ops"},
+			{Type: "SRV", Name: "_smb._tcp", Value: "0 0 445 synology-ds920", TTL: 300},
+		},
+	}
+	assert.Equal(t, []Record{
+		{Type: RecordTypeCNAME, Name: "nas", Value: "synology-ds920", TTL: 60},
+		{Type: RecordTypeTXT, Name: "synology-ds920", Value: "owner=ops", TTL: 60},
+		{Type: RecordTypeSRV, Name: "_smb._tcp", Value: "0 0 445 synology-ds920", TTL: 300},
+	}, e.AllRecords())
+
+	e.Hostname = ""
+	assert.Equal(t, []Record{
+		{Type: RecordTypeSRV, Name: "_smb._tcp", Value: "0 0 445 synology-ds920", TTL: 300},
+	}, e.AllRecords())
+}
@@ -4,6 +4,10 @@ return function ()
     ipv4s = { "192.0.2.1" },
     ipv6s = { "2001:db8::1" },
     record_ttl = 60,
+    aliases = { "nas" },
+    records = {
+      { type = "MX", value = "10 mail", ttl = 300 },
+    },
     source_properties = {
       source_prop = "prop",
     },
@@ -0,0 +1,22 @@
+package enricher
+
+import (
+	"context"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+// Enricher defines the interface for stages that add information to the
+// endpoints of all sources before they are passed to the providers.
+type Enricher interface {
+	// Enrich returns the enriched endpoints. Enrichers may modify the given
+	// endpoints in place.
+	Enrich(ctx context.Context, endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error)
+}
+
+// NamedEnricher is a struct that pairs an Enricher instance with a logical
+// name.
+type NamedEnricher struct {
+	Name     string
+	Enricher Enricher
+}
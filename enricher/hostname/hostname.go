@@ -0,0 +1,155 @@
+package hostname
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	lua "github.com/yuin/gopher-lua"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/enricher"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/utils"
+)
+
+// DefaultTemplate generates the same "ip-192-0-2-1" style hostnames that are
+// used for PTR records of endpoints without a hostname.
+const DefaultTemplate = `ip-{{ if .IPv4 }}{{ .IPv4 | replace "." "-" }}{{ else }}{{ .IPv6 | replace ":" "-" }}{{ end }}`
+
+// SynthesizedProperty is the source property set to true on endpoints that
+// got their hostname from this enricher.
+const SynthesizedProperty = "hostname_synthesized"
+
+type HostnameEnricherConfig struct {
+	// Go template for the hostname, executed with TemplateData (default
+	// DefaultTemplate)
+	Template string
+	// Function called with the endpoint that returns the hostname, or nil to
+	// leave the endpoint without one. Overrides Template.
+	Generate *lua.LFunction
+}
+
+// TemplateData is passed to the hostname template.
+type TemplateData struct {
+	Endpoint *endpoint.Endpoint
+	// First IPv4 and IPv6 address of the endpoint, if any
+	IPv4 string
+	IPv6 string
+	// The hardware_address source property
+	MAC string
+	// The mac_vendor source property set by the mac_vendor enricher
+	Vendor string
+	// The dhcp_pool source property
+	Pool string
+	// Name of the source the endpoint came from
+	Source           string
+	SourceProperties map[string]any
+}
+
+type hostnameEnricher struct {
+	config   HostnameEnricherConfig
+	state    *lua.LState
+	logger   *zap.Logger
+	template *template.Template
+}
+
+func NewHostnameEnricher(state *lua.LState, enricherConfig HostnameEnricherConfig) (enricher.Enricher, error) {
+	if enricherConfig.Template == "" {
+		enricherConfig.Template = DefaultTemplate
+	}
+	tpl, err := template.New("hostname").Funcs(utils.NewSprout().Build()).Parse(enricherConfig.Template)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse hostname template: %w", err)
+	}
+	return &hostnameEnricher{
+		config:   enricherConfig,
+		state:    state,
+		logger:   log.MustNewLogger().Named("hostname_enricher"),
+		template: tpl,
+	}, nil
+}
+
+// Enrich synthesizes hostnames for the endpoints that don't have one.
+func (e *hostnameEnricher) Enrich(ctx context.Context, endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+	for _, ep := range endpoints {
+		if ep.Hostname != "" || (len(ep.IPv4s) == 0 && len(ep.IPv6s) == 0) {
+			continue
+		}
+		var hostname string
+		var err error
+		if e.config.Generate != nil {
+			hostname, err = e.generate(ep)
+		} else {
+			hostname, err = e.execute(ep)
+		}
+		if err != nil {
+			e.logger.Error(
+				"could not synthesize hostname",
+				zap.Strings("ipv4", ep.IPv4s),
+				zap.Strings("ipv6", ep.IPv6s),
+				zap.Error(err),
+			)
+			return nil, err
+		}
+		hostname = strings.TrimSpace(hostname)
+		if hostname == "" {
+			continue
+		}
+		e.logger.Sugar().Debugf("No hostname defined for endpoint, using synthesized hostname of %s", hostname)
+		ep.Hostname = hostname
+		if ep.SourceProperties == nil {
+			ep.SourceProperties = map[string]any{}
+		}
+		ep.SourceProperties[SynthesizedProperty] = true
+	}
+	return endpoints, nil
+}
+
+func (e *hostnameEnricher) execute(ep *endpoint.Endpoint) (string, error) {
+	data := TemplateData{
+		Endpoint:         ep,
+		SourceProperties: ep.SourceProperties,
+	}
+	if len(ep.IPv4s) > 0 {
+		data.IPv4 = ep.IPv4s[0]
+	}
+	if len(ep.IPv6s) > 0 {
+		data.IPv6 = ep.IPv6s[0]
+	}
+	data.MAC, _ = ep.SourceProperties["hardware_address"].(string)
+	data.Vendor, _ = ep.SourceProperties["mac_vendor"].(string)
+	data.Pool, _ = ep.SourceProperties["dhcp_pool"].(string)
+	data.Source, _ = ep.SourceProperties["source"].(string)
+	var sb strings.Builder
+	err := e.template.Execute(&sb, data)
+	if err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func (e *hostnameEnricher) generate(ep *endpoint.Endpoint) (string, error) {
+	hostname := ""
+	co, _ := e.state.NewThread()
+	for {
+		st, err, values := e.state.Resume(co, e.config.Generate, ep.ToLuaTable(e.state))
+
+		if st == lua.ResumeError {
+			return "", fmt.Errorf("generate lua.ResumeError: %w", err)
+		}
+
+		for _, lv := range values {
+			if r, ok := lv.(lua.LString); ok {
+				hostname = string(r)
+			}
+		}
+
+		if st == lua.ResumeOK {
+			break
+		}
+	}
+	return hostname, nil
+}
@@ -0,0 +1,106 @@
+package hostname
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+func testEndpoints() []*endpoint.Endpoint {
+	return []*endpoint.Endpoint{
+		{
+			Hostname: "named",
+			IPv4s:    []string{"192.0.2.1"},
+		},
+		{
+			IPv4s: []string{"192.0.2.5", "192.0.2.6"},
+			IPv6s: []string{"2001:db8::5"},
+			SourceProperties: map[string]any{
+				"source":           "vyos",
+				"hardware_address": "b8:27:eb:01:02:03",
+				"mac_vendor":       "Raspberry Pi Foundation",
+				"dhcp_pool":        "LAN_Internal",
+			},
+		},
+		{
+			IPv6s: []string{"2001:db8::6"},
+		},
+		{
+			// nothing to make records for
+		},
+	}
+}
+
+func hostnames(endpoints []*endpoint.Endpoint) []string {
+	result := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		result = append(result, e.Hostname)
+	}
+	return result
+}
+
+func TestEnrich_DefaultTemplate(t *testing.T) {
+	e, err := NewHostnameEnricher(nil, HostnameEnricherConfig{})
+	require.NoError(t, err)
+	endpoints, err := e.Enrich(context.Background(), testEndpoints())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"named", "ip-192-0-2-5", "ip-2001-db8--6", ""}, hostnames(endpoints))
+	assert.Nil(t, endpoints[0].SourceProperties)
+	assert.Equal(t, true, endpoints[1].SourceProperties[SynthesizedProperty])
+	assert.Equal(t, true, endpoints[2].SourceProperties[SynthesizedProperty])
+	assert.Nil(t, endpoints[3].SourceProperties)
+}
+
+func TestEnrich_Template(t *testing.T) {
+	e, err := NewHostnameEnricher(nil, HostnameEnricherConfig{
+		Template: `{{ if .MAC }}{{ .Vendor | splitList " " | first | toLower }}-{{ .MAC | replace ":" "" }}.{{ .Pool | toLower }}{{ end }}`,
+	})
+	require.NoError(t, err)
+	endpoints, err := e.Enrich(context.Background(), testEndpoints())
+	require.NoError(t, err)
+	// empty results leave the endpoint without a hostname
+	assert.Equal(t, []string{"named", "raspberry-b827eb010203.lan_internal", "", ""}, hostnames(endpoints))
+	assert.Nil(t, endpoints[2].SourceProperties)
+
+	_, err = NewHostnameEnricher(nil, HostnameEnricherConfig{Template: "{{ .Nope "})
+	assert.Error(t, err)
+
+	e, err = NewHostnameEnricher(nil, HostnameEnricherConfig{Template: "{{ .Nope }}"})
+	require.NoError(t, err)
+	_, err = e.Enrich(context.Background(), testEndpoints())
+	assert.Error(t, err)
+}
+
+func TestEnrich_Generate(t *testing.T) {
+	state := lua.NewState()
+	defer state.Close()
+	err := state.DoString(`
+		return function(endpoint)
+			if endpoint.source_properties.dhcp_pool == nil then
+				return nil
+			end
+			return string.lower(endpoint.source_properties.dhcp_pool) .. "-" .. string.gsub(endpoint.ipv4s[2], "%.", "-")
+		end
+	`)
+	require.NoError(t, err)
+	e, err := NewHostnameEnricher(state, HostnameEnricherConfig{
+		Generate: state.Get(-1).(*lua.LFunction),
+	})
+	require.NoError(t, err)
+	endpoints, err := e.Enrich(context.Background(), testEndpoints())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"named", "lan_internal-192-0-2-6", "", ""}, hostnames(endpoints))
+
+	require.NoError(t, state.DoString(`return function(endpoint) error("boom") end`))
+	e, err = NewHostnameEnricher(state, HostnameEnricherConfig{
+		Generate: state.Get(-1).(*lua.LFunction),
+	})
+	require.NoError(t, err)
+	_, err = e.Enrich(context.Background(), testEndpoints())
+	assert.ErrorContains(t, err, "boom")
+}
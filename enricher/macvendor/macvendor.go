@@ -0,0 +1,213 @@
+package macvendor
+
+//go:generate python3 ../../hack/update-oui.py oui.csv
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/enricher"
+	"github.com/sapslaj/zonepop/pkg/log"
+)
+
+// DefaultProperty is the source property the MAC address is read from by
+// default. It's set by the vyos_ssh and snmp sources.
+const DefaultProperty = "hardware_address"
+
+// ouiCSV is the merged IEEE MA-L, MA-M and MA-S registries. Run
+// `go generate` to update it.
+//
+//go:embed oui.csv
+var ouiCSV []byte
+
+var embeddedDatabase = sync.OnceValues(func() (*Database, error) {
+	db := NewDatabase()
+	err := loadEmbedded(db)
+	return db, err
+})
+
+func loadEmbedded(db *Database) error {
+	return db.Load(bytes.NewReader(ouiCSV))
+}
+
+type MACVendorEnricherConfig struct {
+	// Paths of IEEE registry CSV files (oui.csv, mam.csv and oui36.csv from
+	// https://standards-oui.ieee.org/, or their merge by hack/update-oui.py)
+	// that are loaded on top of the embedded database. Their assignments
+	// take precedence over the embedded ones.
+	DatabaseFiles []string
+	// Source property to read the MAC address from (default
+	// "hardware_address")
+	Property string
+}
+
+// Database maps MAC address prefixes of the IEEE registries to the
+// organizations they are assigned to.
+type Database struct {
+	// prefix length in bits -> prefix -> organization
+	prefixes map[int]map[uint64]string
+}
+
+func NewDatabase() *Database {
+	return &Database{
+		prefixes: map[int]map[uint64]string{},
+	}
+}
+
+// Load adds the assignments from an IEEE registry CSV file. Only the
+// "Assignment" and "Organization Name" columns are used.
+func (db *Database) Load(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("could not read header: %w", err)
+	}
+	assignmentColumn := -1
+	organizationColumn := -1
+	for i, name := range header {
+		switch strings.TrimSpace(name) {
+		case "Assignment":
+			assignmentColumn = i
+		case "Organization Name":
+			organizationColumn = i
+		}
+	}
+	if assignmentColumn == -1 || organizationColumn == -1 {
+		return fmt.Errorf("missing Assignment or Organization Name column")
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) <= assignmentColumn || len(record) <= organizationColumn {
+			continue
+		}
+		assignment := strings.TrimSpace(record[assignmentColumn])
+		prefix, err := strconv.ParseUint(assignment, 16, 64)
+		if err != nil || len(assignment) > 12 {
+			return fmt.Errorf("invalid assignment %q", assignment)
+		}
+		bits := len(assignment) * 4
+		if db.prefixes[bits] == nil {
+			db.prefixes[bits] = map[uint64]string{}
+		}
+		db.prefixes[bits][prefix] = strings.TrimSpace(record[organizationColumn])
+	}
+}
+
+// Lookup returns the organization with the longest prefix matching mac.
+func (db *Database) Lookup(mac net.HardwareAddr) (string, bool) {
+	if len(mac) < 3 {
+		return "", false
+	}
+	var value uint64
+	for _, b := range mac[:min(len(mac), 8)] {
+		value = value<<8 | uint64(b)
+	}
+	macBits := min(len(mac), 8) * 8
+	for bits := macBits; bits > 0; bits -= 4 {
+		prefixes, ok := db.prefixes[bits]
+		if !ok {
+			continue
+		}
+		if organization, ok := prefixes[value>>(macBits-bits)]; ok {
+			return organization, true
+		}
+	}
+	return "", false
+}
+
+type macVendorEnricher struct {
+	config   MACVendorEnricherConfig
+	logger   *zap.Logger
+	database *Database
+}
+
+func NewMACVendorEnricher(enricherConfig MACVendorEnricherConfig) (enricher.Enricher, error) {
+	if enricherConfig.Property == "" {
+		enricherConfig.Property = DefaultProperty
+	}
+	var db *Database
+	if len(enricherConfig.DatabaseFiles) == 0 {
+		var err error
+		db, err = embeddedDatabase()
+		if err != nil {
+			return nil, fmt.Errorf("could not load embedded OUI database: %w", err)
+		}
+	} else {
+		// the shared embedded database isn't modified, so the files are
+		// loaded into a copy of it
+		db = NewDatabase()
+		err := loadEmbedded(db)
+		if err != nil {
+			return nil, fmt.Errorf("could not load embedded OUI database: %w", err)
+		}
+		for _, filename := range enricherConfig.DatabaseFiles {
+			err := loadFile(db, filename)
+			if err != nil {
+				return nil, fmt.Errorf("could not load OUI database %s: %w", filename, err)
+			}
+		}
+	}
+	return &macVendorEnricher{
+		config:   enricherConfig,
+		logger:   log.MustNewLogger().Named("mac_vendor_enricher"),
+		database: db,
+	}, nil
+}
+
+func loadFile(db *Database, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return db.Load(f)
+}
+
+func (e *macVendorEnricher) Enrich(ctx context.Context, endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+	for _, ep := range endpoints {
+		raw, ok := ep.SourceProperties[e.config.Property].(string)
+		if !ok || raw == "" {
+			continue
+		}
+		mac, err := net.ParseMAC(raw)
+		if err != nil {
+			e.logger.Debug(
+				"ignoring invalid MAC address",
+				zap.String("hostname", ep.Hostname),
+				zap.String(e.config.Property, raw),
+				zap.Error(err),
+			)
+			continue
+		}
+		// the U/L and I/G bits of the first octet
+		locallyAdministered := mac[0]&0b10 != 0
+		multicast := mac[0]&0b01 != 0
+		vendor := ""
+		if !locallyAdministered {
+			vendor, _ = e.database.Lookup(mac)
+		}
+		ep.SourceProperties["mac_vendor"] = vendor
+		ep.SourceProperties["mac_is_locally_administered"] = locallyAdministered
+		ep.SourceProperties["mac_is_multicast"] = multicast
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,186 @@
+package macvendor
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+const testRegistries = "testdata/oui.csv"
+
+func mustParseMAC(t *testing.T, s string) net.HardwareAddr {
+	t.Helper()
+	mac, err := net.ParseMAC(s)
+	require.NoError(t, err)
+	return mac
+}
+
+func TestDatabase_Lookup(t *testing.T) {
+	db := NewDatabase()
+	require.NoError(t, loadFile(db, testRegistries))
+
+	tests := map[string]struct {
+		mac          string
+		organization string
+		ok           bool
+	}{
+		"MA-L":           {mac: "b8:27:eb:01:02:03", organization: "Raspberry Pi Foundation", ok: true},
+		"MA-M":           {mac: "70:b3:d5:1f:ff:ff", organization: "Example Medium, Inc.", ok: true},
+		"MA-S":           {mac: "70:b3:d5:12:3a:bc", organization: "Example Small GmbH", ok: true},
+		"MA-L fallback":  {mac: "70:b3:d5:f0:00:00", organization: "IEEE Registration Authority", ok: true},
+		"EUI-64":         {mac: "b8:27:eb:ff:fe:01:02:03", organization: "Raspberry Pi Foundation", ok: true},
+		"not registered": {mac: "00:53:00:00:00:01"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			organization, ok := db.Lookup(mustParseMAC(t, tc.mac))
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.organization, organization)
+		})
+	}
+}
+
+func TestDatabase_Load(t *testing.T) {
+	db := NewDatabase()
+	assert.Error(t, db.Load(strings.NewReader("")))
+	assert.Error(t, db.Load(strings.NewReader("Registry,Assignment\nMA-L,B827EB\n")))
+	assert.Error(t, db.Load(strings.NewReader("Assignment,Organization Name\nnope,Nope Inc.\n")))
+}
+
+func TestEnrich(t *testing.T) {
+	e, err := NewMACVendorEnricher(MACVendorEnricherConfig{
+		DatabaseFiles: []string{testRegistries},
+	})
+	require.NoError(t, err)
+
+	endpoints := []*endpoint.Endpoint{
+		{
+			Hostname:         "pi",
+			SourceProperties: map[string]any{"hardware_address": "B8-27-EB-01-02-03"},
+		},
+		{
+			Hostname:         "phone",
+			SourceProperties: map[string]any{"hardware_address": "da:a1:19:01:02:03"},
+		},
+		{
+			Hostname:         "unknown",
+			SourceProperties: map[string]any{"hardware_address": "00:53:00:00:00:01"},
+		},
+		{
+			Hostname:         "multicast",
+			SourceProperties: map[string]any{"hardware_address": "01:00:5e:00:00:fb"},
+		},
+		{
+			Hostname:         "invalid",
+			SourceProperties: map[string]any{"hardware_address": "not-a-mac"},
+		},
+		{
+			Hostname:         "no-mac",
+			SourceProperties: map[string]any{},
+		},
+	}
+	got, err := e.Enrich(context.Background(), endpoints)
+	require.NoError(t, err)
+
+	expected := []map[string]any{
+		{
+			"hardware_address":            "B8-27-EB-01-02-03",
+			"mac_vendor":                  "Raspberry Pi Foundation",
+			"mac_is_locally_administered": false,
+			"mac_is_multicast":            false,
+		},
+		{
+			"hardware_address":            "da:a1:19:01:02:03",
+			"mac_vendor":                  "",
+			"mac_is_locally_administered": true,
+			"mac_is_multicast":            false,
+		},
+		{
+			"hardware_address":            "00:53:00:00:00:01",
+			"mac_vendor":                  "",
+			"mac_is_locally_administered": false,
+			"mac_is_multicast":            false,
+		},
+		{
+			"hardware_address":            "01:00:5e:00:00:fb",
+			"mac_vendor":                  "",
+			"mac_is_locally_administered": false,
+			"mac_is_multicast":            true,
+		},
+		{
+			"hardware_address": "not-a-mac",
+		},
+		{},
+	}
+	gotProperties := make([]map[string]any, 0, len(got))
+	for _, e := range got {
+		gotProperties = append(gotProperties, e.SourceProperties)
+	}
+	if diff := cmp.Diff(expected, gotProperties); diff != "" {
+		t.Errorf("Enrich() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEnrich_Property(t *testing.T) {
+	e, err := NewMACVendorEnricher(MACVendorEnricherConfig{
+		DatabaseFiles: []string{testRegistries},
+		Property:      "mac",
+	})
+	require.NoError(t, err)
+	endpoints := []*endpoint.Endpoint{
+		{
+			Hostname:         "pi",
+			SourceProperties: map[string]any{"mac": "b8:27:eb:01:02:03", "hardware_address": "00:53:00:00:00:01"},
+		},
+	}
+	got, err := e.Enrich(context.Background(), endpoints)
+	require.NoError(t, err)
+	assert.Equal(t, "Raspberry Pi Foundation", got[0].SourceProperties["mac_vendor"])
+
+	_, err = NewMACVendorEnricher(MACVendorEnricherConfig{DatabaseFiles: []string{"does-not-exist.csv"}})
+	assert.Error(t, err)
+}
+
+func TestNewMACVendorEnricher_Embedded(t *testing.T) {
+	db, err := embeddedDatabase()
+	require.NoError(t, err)
+
+	e, err := NewMACVendorEnricher(MACVendorEnricherConfig{})
+	require.NoError(t, err)
+	assert.Same(t, db, e.(*macVendorEnricher).database)
+
+	// database_files extend a copy of the embedded database
+	e, err = NewMACVendorEnricher(MACVendorEnricherConfig{DatabaseFiles: []string{testRegistries}})
+	require.NoError(t, err)
+	assert.NotSame(t, db, e.(*macVendorEnricher).database)
+	organization, ok := e.(*macVendorEnricher).database.Lookup(mustParseMAC(t, "70:b3:d5:12:34:56"))
+	assert.True(t, ok)
+	assert.Equal(t, "Example Small GmbH", organization)
+}
+
+func TestEnrich_EmbeddedDatabase(t *testing.T) {
+	e, err := NewMACVendorEnricher(MACVendorEnricherConfig{})
+	require.NoError(t, err)
+
+	endpoints := []*endpoint.Endpoint{
+		{
+			Hostname:         "pi",
+			SourceProperties: map[string]any{"hardware_address": "b8:27:eb:01:02:03"},
+		},
+		{
+			Hostname:         "router",
+			SourceProperties: map[string]any{"hardware_address": "00:00:0c:01:02:03"},
+		},
+	}
+	got, err := e.Enrich(context.Background(), endpoints)
+	require.NoError(t, err)
+	assert.Equal(t, "Raspberry Pi Foundation", got[0].SourceProperties["mac_vendor"])
+	assert.Equal(t, "Cisco Systems, Inc", got[1].SourceProperties["mac_vendor"])
+}
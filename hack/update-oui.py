@@ -0,0 +1,53 @@
+#!/usr/bin/env python3
+"""Regenerates the embedded OUI database of the mac_vendor enricher from the
+IEEE MA-L, MA-M and MA-S registries. The merged file can also be used in the
+database_files of the enricher to update it without rebuilding.
+
+The registries are downloaded from standards-oui.ieee.org unless URLs or paths
+of local copies are given after OUTPUT."""
+import csv
+import io
+import sys
+import urllib.request
+
+REGISTRIES = [
+    "https://standards-oui.ieee.org/oui/oui.csv",
+    "https://standards-oui.ieee.org/oui28/mam.csv",
+    "https://standards-oui.ieee.org/oui36/oui36.csv",
+]
+
+
+def fetch(source: str) -> list[tuple[str, str, str]]:
+    print("fetching", source, file=sys.stderr)
+    if "://" in source:
+        request = urllib.request.Request(source, headers={"User-Agent": "zonepop-update-oui"})
+        with urllib.request.urlopen(request) as response:
+            return parse(io.TextIOWrapper(response, encoding="utf-8"))
+    with open(source, encoding="utf-8", newline="") as f:
+        return parse(f)
+
+
+def parse(f) -> list[tuple[str, str, str]]:
+    reader = csv.DictReader(f)
+    return [
+        (row["Registry"].strip(), row["Assignment"].strip().upper(), row["Organization Name"].strip())
+        for row in reader
+    ]
+
+
+def main():
+    if len(sys.argv) < 2:
+        print(f"usage: {sys.argv[0]} OUTPUT [REGISTRY...]", file=sys.stderr)
+        sys.exit(1)
+    rows = []
+    for source in sys.argv[2:] or REGISTRIES:
+        rows.extend(fetch(source))
+    rows.sort(key=lambda row: (row[1], row[0]))
+    with open(sys.argv[1], "w", newline="") as f:
+        writer = csv.writer(f, lineterminator="\n")
+        writer.writerow(["Registry", "Assignment", "Organization Name"])
+        writer.writerows(rows)
+
+
+if __name__ == "__main__":
+    main()
@@ -61,6 +61,10 @@ func main() {
 	if err != nil {
 		logger.Sugar().Panicf("could not get sources from configuration: %v", err)
 	}
+	enrichers, err := c.Enrichers()
+	if err != nil {
+		logger.Sugar().Panicf("could not get enrichers from configuration: %v", err)
+	}
 	providers, err := c.Providers()
 	if err != nil {
 		logger.Sugar().Panicf("could not get providers from configuration: %v", err)
@@ -68,6 +72,7 @@ func main() {
 
 	ctrl := controller.Controller{
 		Sources:   sources,
+		Enrichers: enrichers,
 		Providers: providers,
 		Interval:  *interval,
 		Logger:    logger.Named("controller"),
@@ -0,0 +1,95 @@
+// Package accesstoken fetches and caches OAuth 2.0 access tokens for cloud
+// provider APIs.
+package accesstoken
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokens are renewed this long before they expire
+const expiryDelta = time.Minute
+
+type Token struct {
+	AccessToken string
+	ExpiresAt   time.Time
+}
+
+// Source caches the tokens of Fetch until shortly before they expire.
+type Source struct {
+	Fetch func(ctx context.Context) (Token, error)
+
+	mu    sync.Mutex
+	token Token
+	// replaces time.Now in tests
+	now func() time.Time
+}
+
+func (s *Source) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	if s.token.AccessToken != "" && now().Add(expiryDelta).Before(s.token.ExpiresAt) {
+		return s.token.AccessToken, nil
+	}
+	token, err := s.Fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	return token.AccessToken, nil
+}
+
+// response is the token response of OAuth 2.0 token endpoints and the
+// metadata services of cloud providers, which return expires_in as a string.
+type response struct {
+	AccessToken      string      `json:"access_token"`
+	ExpiresIn        json.Number `json:"expires_in"`
+	Error            string      `json:"error"`
+	ErrorDescription string      `json:"error_description"`
+}
+
+// Request sends a token request and returns the token of the response.
+func Request(client *http.Client, req *http.Request) (Token, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("could not request access token: %w", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return Token{}, fmt.Errorf("could not read access token response: %w", err)
+	}
+	var tokenRes response
+	err = json.Unmarshal(body, &tokenRes)
+	if res.StatusCode >= 300 {
+		message := strings.TrimSpace(string(body))
+		if tokenRes.Error != "" {
+			message = strings.TrimSpace(tokenRes.Error + ": " + tokenRes.ErrorDescription)
+		}
+		return Token{}, fmt.Errorf("access token request failed with status %d: %s", res.StatusCode, message)
+	}
+	if err != nil {
+		return Token{}, fmt.Errorf("could not decode access token response: %w", err)
+	}
+	if tokenRes.AccessToken == "" {
+		return Token{}, fmt.Errorf("access token response has no access token")
+	}
+	expiresIn, err := tokenRes.ExpiresIn.Int64()
+	if err != nil {
+		expiresIn = 0
+	}
+	return Token{
+		AccessToken: tokenRes.AccessToken,
+		ExpiresAt:   time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}, nil
+}
@@ -0,0 +1,94 @@
+package accesstoken
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fetches := 0
+	s := &Source{
+		Fetch: func(ctx context.Context) (Token, error) {
+			fetches++
+			return Token{AccessToken: "token", ExpiresAt: now.Add(time.Hour)}, nil
+		},
+		now: func() time.Time { return now },
+	}
+
+	for i := 0; i < 2; i++ {
+		token, err := s.Token(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "token", token)
+	}
+	assert.Equal(t, 1, fetches)
+
+	now = now.Add(59*time.Minute + time.Second)
+	_, err := s.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, fetches)
+}
+
+func TestRequest(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		status    int
+		body      string
+		wantToken string
+		errMsg    string
+	}{
+		"numeric expires_in": {
+			status:    http.StatusOK,
+			body:      `{"access_token":"token","expires_in":3599,"token_type":"Bearer"}`,
+			wantToken: "token",
+		},
+		"string expires_in": {
+			status:    http.StatusOK,
+			body:      `{"access_token":"token","expires_in":"3599","token_type":"Bearer"}`,
+			wantToken: "token",
+		},
+		"OAuth error": {
+			status: http.StatusBadRequest,
+			body:   `{"error":"invalid_client","error_description":"bad secret"}`,
+			errMsg: "status 400: invalid_client: bad secret",
+		},
+		"missing token": {
+			status: http.StatusOK,
+			body:   `{}`,
+			errMsg: "has no access token",
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodPost, server.URL, nil)
+			require.NoError(t, err)
+			token, err := Request(server.Client(), req)
+			if tc.errMsg != "" {
+				assert.ErrorContains(t, err, tc.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantToken, token.AccessToken)
+			assert.WithinDuration(t, time.Now().Add(3599*time.Second), token.ExpiresAt, time.Minute)
+		})
+	}
+}
@@ -0,0 +1,230 @@
+// Package configfile writes config files generated for DNS servers, locally
+// or on a remote host over SSH, and reloads the servers when the content of
+// the files changed.
+package configfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	scp "github.com/bramvdbogaerde/go-scp"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/pkg/sshconnection"
+)
+
+const DefaultPermissions = "0644"
+
+type SSHConfig struct {
+	Host     string
+	Username string
+	Password string
+}
+
+// ReloadConfig is how the server is told to load the changed file. Without a
+// command or signal, the server is expected to notice the change itself,
+// like the CoreDNS reload, hosts and file plugins do.
+type ReloadConfig struct {
+	// Shell command, e.g. "systemctl reload dnsmasq"
+	Command string
+	// Signal sent to the process with the PID in PIDFile, e.g. "HUP"
+	Signal  string
+	PIDFile string
+}
+
+type Config struct {
+	File string
+	// Permissions of the file in octal (default "0644")
+	Permissions string
+	// Write the file and reload on a remote host
+	SSH    SSHConfig
+	Reload ReloadConfig
+}
+
+var signalPattern = regexp.MustCompile(`^(SIG)?[A-Z0-9]+$`)
+
+// WithDefaults validates the config and fills in the defaults.
+func (c Config) WithDefaults() (Config, error) {
+	if c.File == "" {
+		return c, errors.New("file is required")
+	}
+	if c.Permissions == "" {
+		c.Permissions = DefaultPermissions
+	}
+	if _, err := strconv.ParseUint(c.Permissions, 8, 32); err != nil {
+		return c, fmt.Errorf("invalid permissions %q: %w", c.Permissions, err)
+	}
+	if c.Reload.Signal != "" {
+		c.Reload.Signal = strings.ToUpper(c.Reload.Signal)
+		if !signalPattern.MatchString(c.Reload.Signal) {
+			return c, fmt.Errorf("invalid reload signal %q", c.Reload.Signal)
+		}
+		if c.Reload.PIDFile == "" {
+			return c, errors.New("reload pid_file is required with signal")
+		}
+	}
+	return c, nil
+}
+
+// Host reads and writes files and runs commands, locally or remotely.
+type Host interface {
+	// ReadFile returns the content of a file, or "" if it doesn't exist.
+	ReadFile(ctx context.Context, path string) (string, error)
+	WriteFile(ctx context.Context, path string, content string, permissions string) error
+	// Run runs a shell command and returns its output.
+	Run(ctx context.Context, command string) (string, error)
+	// RunWithInput runs a shell command with input as its standard input
+	// and returns its output.
+	RunWithInput(ctx context.Context, command string, input string) (string, error)
+	Close() error
+}
+
+type ConnectFunc func(ctx context.Context, c SSHConfig) (Host, error)
+
+// Connect connects to the SSH host, or returns the local host if there is
+// none.
+func Connect(ctx context.Context, c SSHConfig) (Host, error) {
+	if c.Host == "" {
+		return localHost{}, nil
+	}
+	conn, err := sshconnection.Connect(c.Host, c.Username, c.Password)
+	if err != nil {
+		return nil, err
+	}
+	return &sshHost{conn: conn}, nil
+}
+
+// State is what Update remembers about a file between calls.
+type State struct {
+	// The file was written but reloading the server failed
+	reloadPending bool
+}
+
+// Update renders the file from its current content and, if the content
+// changed, writes it and reloads the server. A reload that failed before is
+// retried even if the content didn't change. It reports whether the file
+// changed.
+func Update(ctx context.Context, host Host, c Config, state *State, logger *zap.Logger, render func(current string) (string, error)) (bool, error) {
+	current, err := host.ReadFile(ctx, c.File)
+	if err != nil {
+		return false, fmt.Errorf("could not read %s: %w", c.File, err)
+	}
+	content, err := render(current)
+	if err != nil {
+		return false, err
+	}
+	changed := content != current
+	if !changed && !state.reloadPending {
+		logger.Sugar().Debugf("%s is up to date", c.File)
+		return false, nil
+	}
+	if changed {
+		logger.Sugar().Infof("saving %s with permissions %s", c.File, c.Permissions)
+		err = host.WriteFile(ctx, c.File, content, c.Permissions)
+		if err != nil {
+			return false, fmt.Errorf("could not write %s: %w", c.File, err)
+		}
+	}
+	command := c.Reload.command()
+	if command == "" {
+		return changed, nil
+	}
+	state.reloadPending = true
+	logger.Sugar().Infof("reloading with %q", command)
+	output, err := host.Run(ctx, command)
+	if err != nil {
+		return changed, fmt.Errorf("could not reload: %w: %s", err, strings.TrimSpace(output))
+	}
+	state.reloadPending = false
+	return changed, nil
+}
+
+func (r ReloadConfig) command() string {
+	if r.Command != "" {
+		return r.Command
+	}
+	if r.Signal != "" {
+		return fmt.Sprintf(`kill -s %s "$(cat %s)"`, strings.TrimPrefix(r.Signal, "SIG"), Quote(r.PIDFile))
+	}
+	return ""
+}
+
+// Quote quotes s for POSIX shells.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+type localHost struct{}
+
+func (localHost) ReadFile(ctx context.Context, path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	return string(content), err
+}
+
+func (localHost) WriteFile(ctx context.Context, path string, content string, permissions string) error {
+	perm, err := strconv.ParseUint(permissions, 8, 32)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), fs.FileMode(perm))
+}
+
+func (h localHost) Run(ctx context.Context, command string) (string, error) {
+	return h.RunWithInput(ctx, command, "")
+}
+
+func (localHost) RunWithInput(ctx context.Context, command string, input string) (string, error) {
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	return output.String(), err
+}
+
+func (localHost) Close() error {
+	return nil
+}
+
+type sshHost struct {
+	conn *sshconnection.SSHConnection
+}
+
+func (h *sshHost) ReadFile(ctx context.Context, path string) (string, error) {
+	output, err := h.conn.OutputWithInput(ctx, fmt.Sprintf("if [ -e %[1]s ]; then cat %[1]s; fi", Quote(path)), nil)
+	return string(output), err
+}
+
+func (h *sshHost) WriteFile(ctx context.Context, path string, content string, permissions string) error {
+	client, err := scp.NewClientBySSH(h.conn.Client)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.CopyFile(ctx, strings.NewReader(content), path, permissions)
+}
+
+func (h *sshHost) Run(ctx context.Context, command string) (string, error) {
+	return h.RunWithInput(ctx, command, "")
+}
+
+func (h *sshHost) RunWithInput(ctx context.Context, command string, input string) (string, error) {
+	output, err := h.conn.OutputWithInput(ctx, command+" 2>&1", strings.NewReader(input))
+	return string(output), err
+}
+
+func (h *sshHost) Close() error {
+	return h.conn.Disconnect()
+}
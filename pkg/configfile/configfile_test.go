@@ -0,0 +1,174 @@
+package configfile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "records.conf")
+	reloaded := filepath.Join(dir, "reloaded")
+	config, err := Config{
+		File:   file,
+		Reload: ReloadConfig{Command: "echo reload >> " + Quote(reloaded)},
+	}.WithDefaults()
+	require.NoError(t, err)
+	host, err := Connect(context.Background(), config.SSH)
+	require.NoError(t, err)
+
+	var state State
+	var currents []string
+	render := func(content string) func(string) (string, error) {
+		return func(current string) (string, error) {
+			currents = append(currents, current)
+			return content, nil
+		}
+	}
+
+	changed, err := Update(context.Background(), host, config, &state, zap.NewNop(), render("first\n"))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = Update(context.Background(), host, config, &state, zap.NewNop(), render("first\n"))
+	require.NoError(t, err)
+	assert.False(t, changed)
+	changed, err = Update(context.Background(), host, config, &state, zap.NewNop(), render("second\n"))
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	assert.Equal(t, []string{"", "first\n", "first\n"}, currents)
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", string(content))
+	content, err = os.ReadFile(reloaded)
+	require.NoError(t, err)
+	assert.Equal(t, "reload\nreload\n", string(content), "should reload only after changes")
+}
+
+func TestUpdate_ReloadError(t *testing.T) {
+	t.Parallel()
+
+	config, err := Config{
+		File:   filepath.Join(t.TempDir(), "records.conf"),
+		Reload: ReloadConfig{Command: "echo not running >&2; exit 1"},
+	}.WithDefaults()
+	require.NoError(t, err)
+	changed, err := Update(context.Background(), localHost{}, config, &State{}, zap.NewNop(), func(string) (string, error) {
+		return "records\n", nil
+	})
+	assert.True(t, changed)
+	assert.EqualError(t, err, "could not reload: exit status 1: not running")
+}
+
+func TestUpdate_RetriesFailedReload(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	failed := filepath.Join(dir, "failed")
+	reloaded := filepath.Join(dir, "reloaded")
+	config, err := Config{
+		File: filepath.Join(dir, "records.conf"),
+		Reload: ReloadConfig{Command: fmt.Sprintf(
+			"if [ ! -e %[1]s ]; then touch %[1]s; echo not running >&2; exit 1; fi; echo reload >> %[2]s",
+			Quote(failed),
+			Quote(reloaded),
+		)},
+	}.WithDefaults()
+	require.NoError(t, err)
+	render := func(string) (string, error) {
+		return "records\n", nil
+	}
+
+	var state State
+	changed, err := Update(context.Background(), localHost{}, config, &state, zap.NewNop(), render)
+	assert.True(t, changed)
+	assert.EqualError(t, err, "could not reload: exit status 1: not running")
+
+	changed, err = Update(context.Background(), localHost{}, config, &state, zap.NewNop(), render)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	content, err := os.ReadFile(reloaded)
+	require.NoError(t, err)
+	assert.Equal(t, "reload\n", string(content), "should retry the failed reload")
+
+	_, err = Update(context.Background(), localHost{}, config, &state, zap.NewNop(), render)
+	require.NoError(t, err)
+	content, err = os.ReadFile(reloaded)
+	require.NoError(t, err)
+	assert.Equal(t, "reload\n", string(content), "should not reload again after the retry")
+}
+
+func TestConfig_WithDefaults(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config  Config
+		want    Config
+		wantErr string
+	}{
+		"defaults": {
+			config: Config{File: "/etc/dnsmasq.d/zonepop.conf"},
+			want:   Config{File: "/etc/dnsmasq.d/zonepop.conf", Permissions: "0644"},
+		},
+		"signal": {
+			config: Config{File: "zonepop.conf", Reload: ReloadConfig{Signal: "sighup", PIDFile: "/run/dnsmasq.pid"}},
+			want:   Config{File: "zonepop.conf", Permissions: "0644", Reload: ReloadConfig{Signal: "SIGHUP", PIDFile: "/run/dnsmasq.pid"}},
+		},
+		"missing file": {
+			config:  Config{},
+			wantErr: "file is required",
+		},
+		"invalid permissions": {
+			config:  Config{File: "zonepop.conf", Permissions: "rw-r--r--"},
+			wantErr: `invalid permissions "rw-r--r--"`,
+		},
+		"invalid signal": {
+			config:  Config{File: "zonepop.conf", Reload: ReloadConfig{Signal: "HUP; rm -rf /", PIDFile: "/run/dnsmasq.pid"}},
+			wantErr: `invalid reload signal "HUP; RM -RF /"`,
+		},
+		"signal without pid file": {
+			config:  Config{File: "zonepop.conf", Reload: ReloadConfig{Signal: "HUP"}},
+			wantErr: "reload pid_file is required with signal",
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tc.config.WithDefaults()
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestReloadConfig_Command(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "", ReloadConfig{}.command())
+	assert.Equal(t, "systemctl reload dnsmasq", ReloadConfig{Command: "systemctl reload dnsmasq", Signal: "HUP"}.command())
+	assert.Equal(t, `kill -s HUP "$(cat '/run/it'\''s.pid')"`, ReloadConfig{Signal: "SIGHUP", PIDFile: "/run/it's.pid"}.command())
+}
+
+func TestLocalHost_RunWithInput(t *testing.T) {
+	t.Parallel()
+
+	out, err := localHost{}.RunWithInput(context.Background(), "cat", "local-data: \"a.lan. A 10.0.0.1\"\n")
+	require.NoError(t, err)
+	assert.Equal(t, "local-data: \"a.lan. A 10.0.0.1\"\n", out)
+}
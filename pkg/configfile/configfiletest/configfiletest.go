@@ -0,0 +1,81 @@
+// Package configfiletest provides an in-memory [configfile.Host] for tests of
+// providers writing config files.
+package configfiletest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sapslaj/zonepop/pkg/configfile"
+)
+
+var _ configfile.Host = (*Host)(nil)
+
+// Host is an in-memory stand-in for the file system and shell of the host
+// running a DNS server.
+type Host struct {
+	mu    sync.Mutex
+	files map[string]string
+	// Commands run so far
+	commands []string
+	// Errors returned by the next runs, in order
+	runErrors []error
+}
+
+func NewHost() *Host {
+	return &Host{files: map[string]string{}}
+}
+
+// File returns the content of a file, or "" if it doesn't exist.
+func (h *Host) File(path string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.files[path]
+}
+
+// Commands returns the commands run so far.
+func (h *Host) Commands() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.commands...)
+}
+
+// FailRuns makes the next runs fail with errs, one run per error.
+func (h *Host) FailRuns(errs ...error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runErrors = append(h.runErrors, errs...)
+}
+
+func (h *Host) ReadFile(ctx context.Context, path string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.files[path], nil
+}
+
+func (h *Host) WriteFile(ctx context.Context, path string, content string, permissions string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.files[path] = content
+	return nil
+}
+
+func (h *Host) Run(ctx context.Context, command string) (string, error) {
+	return h.RunWithInput(ctx, command, "")
+}
+
+func (h *Host) RunWithInput(ctx context.Context, command string, input string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.commands = append(h.commands, command)
+	if len(h.runErrors) > 0 {
+		err := h.runErrors[0]
+		h.runErrors = h.runErrors[1:]
+		return "", err
+	}
+	return "", nil
+}
+
+func (h *Host) Close() error {
+	return nil
+}
@@ -0,0 +1,279 @@
+// Package dnsname normalizes externally-supplied hostnames into names that are
+// valid in DNS records according to RFC 1123.
+package dnsname
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// NonASCIIPunycode converts labels with non-ASCII letters to IDNA
+	// A-labels, e.g. "café" to "xn--caf-dma".
+	NonASCIIPunycode = "punycode"
+	// NonASCIITransliterate replaces non-ASCII letters with their closest
+	// ASCII equivalent, e.g. "café" to "cafe".
+	NonASCIITransliterate = "transliterate"
+
+	// TooLongTruncate shortens labels and names that are too long.
+	TooLongTruncate = "truncate"
+	// TooLongReject rejects names with labels or a length that is too long.
+	TooLongReject = "reject"
+
+	// MaxLabelLength is the maximum length of a single label in bytes.
+	MaxLabelLength = 63
+	// MaxNameLength is the maximum length of a name in bytes, without the
+	// trailing dot.
+	MaxNameLength = 253
+)
+
+// Reasons a name can be rejected for.
+const (
+	ReasonEmpty         = "empty"
+	ReasonLabelTooLong  = "label_too_long"
+	ReasonNameTooLong   = "name_too_long"
+	ReasonInvalidSuffix = "invalid_suffix"
+)
+
+var (
+	whitespaceRe = regexp.MustCompile(`\s+`)
+
+	// quotes are dropped rather than replaced, so "Bob's iPhone" becomes
+	// "bobs-iphone"
+	quoteReplacer = strings.NewReplacer("'", "", "‘", "", "’", "", "\"", "", "`", "")
+
+	// letters that don't decompose into an ASCII letter and a combining mark
+	transliterations = map[rune]string{
+		'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O",
+		'đ': "d", 'Đ': "D", 'ð': "d", 'Ð': "D", 'þ': "th", 'Þ': "TH", 'ł': "l",
+		'Ł': "L", 'ı': "i",
+	}
+)
+
+// Policy configures how names are normalized.
+type Policy struct {
+	// How to handle non-ASCII letters: "punycode" (default) or
+	// "transliterate". Other non-ASCII characters like emoji are always
+	// replaced.
+	NonASCII string
+	// What to do with labels longer than 63 bytes and names longer than 253
+	// bytes: "truncate" (default) or "reject"
+	TooLong string
+	// Keep underscores instead of replacing them, e.g. for service names
+	AllowUnderscores bool
+	// Keep upper case letters. DNS is case-insensitive, but some providers
+	// store names as given.
+	PreserveCase bool
+}
+
+// RejectedError is returned for names that can't be normalized.
+type RejectedError struct {
+	Name   string
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("invalid DNS name %q: %s", e.Name, strings.ReplaceAll(e.Reason, "_", " "))
+}
+
+// Normalizer normalizes names according to a Policy and counts the rejected
+// ones in MetricRejectedNames.
+type Normalizer struct {
+	policy   Policy
+	provider string
+}
+
+// DefaultNormalizer uses the default Policy.
+var DefaultNormalizer = &Normalizer{
+	provider: "default",
+	policy: Policy{
+		NonASCII: NonASCIIPunycode,
+		TooLong:  TooLongTruncate,
+	},
+}
+
+// NewNormalizer returns a Normalizer for policy. The provider is the
+// configured name of the provider instance and is used as the label of
+// MetricRejectedNames.
+func NewNormalizer(provider string, policy Policy) (*Normalizer, error) {
+	switch policy.NonASCII {
+	case "":
+		policy.NonASCII = NonASCIIPunycode
+	case NonASCIIPunycode, NonASCIITransliterate:
+	default:
+		return nil, fmt.Errorf("invalid non_ascii policy %q", policy.NonASCII)
+	}
+	switch policy.TooLong {
+	case "":
+		policy.TooLong = TooLongTruncate
+	case TooLongTruncate, TooLongReject:
+	default:
+		return nil, fmt.Errorf("invalid too_long policy %q", policy.TooLong)
+	}
+	return &Normalizer{
+		policy:   policy,
+		provider: provider,
+	}, nil
+}
+
+// Normalize returns the normalized name with suffix (e.g. ".example.com")
+// appended. The suffix is expected to be valid already and is only taken
+// into account for the length of the name.
+func (n *Normalizer) Normalize(name string, suffix string) (string, error) {
+	result, err := n.policy.normalize(name, suffix)
+	if err != nil {
+		reason := "unknown"
+		if rejected, ok := err.(*RejectedError); ok {
+			reason = rejected.Reason
+		}
+		MetricRejectedNames.WithLabelValues(n.provider, reason).Inc()
+		return "", err
+	}
+	return result, nil
+}
+
+func (p Policy) normalize(name string, suffix string) (string, error) {
+	rejected := func(reason string) error {
+		return &RejectedError{Name: name, Reason: reason}
+	}
+
+	s := whitespaceRe.ReplaceAllString(strings.TrimSpace(name), "-")
+	s = strings.Trim(s, ".")
+	if !p.PreserveCase {
+		s = strings.ToLower(s)
+	}
+	labels := make([]string, 0)
+	for _, label := range strings.Split(s, ".") {
+		label, err := p.normalizeLabel(label)
+		if err != nil {
+			return "", rejected(ReasonLabelTooLong)
+		}
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	if len(labels) == 0 {
+		return "", rejected(ReasonEmpty)
+	}
+	s = strings.Join(labels, ".")
+
+	maxLength := MaxNameLength - len(strings.TrimSuffix(suffix, "."))
+	if maxLength <= 0 {
+		return "", rejected(ReasonInvalidSuffix)
+	}
+	if len(s) > maxLength {
+		if p.TooLong == TooLongReject {
+			return "", rejected(ReasonNameTooLong)
+		}
+		// drop labels from the end first so no label is cut off
+		for len(s) > maxLength && strings.Contains(s, ".") {
+			s = s[:strings.LastIndex(s, ".")]
+		}
+		if len(s) > maxLength {
+			s = strings.TrimRight(s[:maxLength], "-")
+		}
+		if s == "" {
+			return "", rejected(ReasonNameTooLong)
+		}
+	}
+	return s + suffix, nil
+}
+
+// normalizeLabel normalizes a single label. It returns an error if the label
+// is too long and can't be truncated.
+func (p Policy) normalizeLabel(label string) (string, error) {
+	label = quoteReplacer.Replace(label)
+	if !isASCII(label) && p.NonASCII == NonASCIIPunycode {
+		aLabel, ok := punycode(p.cleanLabel(label, true))
+		if ok && len(aLabel) <= MaxLabelLength {
+			return aLabel, nil
+		}
+		if ok && p.TooLong == TooLongReject {
+			return "", fmt.Errorf("label too long")
+		}
+		// fall back to transliteration if the label can't be encoded or
+		// would have to be truncated, since that breaks the encoding
+	}
+	label = p.cleanLabel(transliterate(label), false)
+	if len(label) > MaxLabelLength {
+		if p.TooLong == TooLongReject {
+			return "", fmt.Errorf("label too long")
+		}
+		label = strings.TrimRight(label[:MaxLabelLength], "-")
+	}
+	return label, nil
+}
+
+// cleanLabel replaces each run of characters that aren't allowed in a label
+// and the hyphens around it with a single hyphen, and removes leading and
+// trailing hyphens. Non-ASCII
+// letters, digits and marks are kept if keepLetters is set.
+func (p Policy) cleanLabel(label string, keepLetters bool) string {
+	var sb strings.Builder
+	replaced := false
+	for _, r := range label {
+		switch {
+		case r == '-' && replaced:
+			continue
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+		case r >= 'A' && r <= 'Z' && !p.PreserveCase:
+			r = unicode.ToLower(r)
+		case r >= 'A' && r <= 'Z':
+		case r == '_' && p.AllowUnderscores:
+		case r > unicode.MaxASCII && keepLetters && (unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)):
+		default:
+			if !replaced && !strings.HasSuffix(sb.String(), "-") {
+				sb.WriteRune('-')
+			}
+			replaced = true
+			continue
+		}
+		sb.WriteRune(r)
+		replaced = false
+	}
+	return strings.Trim(sb.String(), "-")
+}
+
+// punycode converts a label to an IDNA A-label.
+func punycode(label string) (string, bool) {
+	if isASCII(label) {
+		return label, true
+	}
+	aLabel, err := idna.Lookup.ToASCII(norm.NFC.String(label))
+	if err != nil || strings.Contains(aLabel, ".") {
+		return "", false
+	}
+	return aLabel, true
+}
+
+// transliterate replaces non-ASCII letters with their closest ASCII
+// equivalent by removing diacritics. Other non-ASCII characters are kept so
+// they are replaced by cleanLabel.
+func transliterate(label string) string {
+	var sb strings.Builder
+	for _, r := range norm.NFKD.String(label) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if replacement, ok := transliterations[r]; ok {
+			sb.WriteString(replacement)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+package dnsname
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		name   string
+		suffix string
+		policy Policy
+		expect string
+		reason string
+	}{
+		"already valid": {
+			name:   "test-host",
+			expect: "test-host",
+		},
+		"suffix": {
+			name:   "test-host",
+			suffix: ".example.com",
+			expect: "test-host.example.com",
+		},
+		"whitespace and case": {
+			name:   " Living Room  TV ",
+			expect: "living-room-tv",
+		},
+		"apostrophe": {
+			name:   "Bob’s iPhone",
+			expect: "bobs-iphone",
+		},
+		"invalid characters": {
+			name:   "printer (2nd floor)/color",
+			expect: "printer-2nd-floor-color",
+		},
+		"leading and trailing dots and hyphens": {
+			name:   ".-host-.",
+			expect: "host",
+		},
+		"empty labels": {
+			name:   "a..b",
+			expect: "a.b",
+		},
+		"keeps existing double hyphens": {
+			name:   "ip-2001-db8--1",
+			expect: "ip-2001-db8--1",
+		},
+		"underscores replaced": {
+			name:   "_http_host",
+			expect: "http-host",
+		},
+		"underscores allowed": {
+			name:   "_http_host",
+			policy: Policy{AllowUnderscores: true},
+			expect: "_http_host",
+		},
+		"preserve case": {
+			name:   "Test-Host",
+			policy: Policy{PreserveCase: true},
+			expect: "Test-Host",
+		},
+		"punycode": {
+			name:   "Café",
+			expect: "xn--caf-dma",
+		},
+		"punycode with invalid characters": {
+			name:   "Jürgen's Laptop",
+			expect: "xn--jrgens-laptop-wob",
+		},
+		"transliterate": {
+			name:   "Jürgen's Straße",
+			policy: Policy{NonASCII: NonASCIITransliterate},
+			expect: "jurgens-strasse",
+		},
+		"emoji replaced": {
+			name:   "🎮 console",
+			policy: Policy{NonASCII: NonASCIITransliterate},
+			expect: "console",
+		},
+		"long label truncated": {
+			name:   strings.Repeat("a", 70) + ".b",
+			expect: strings.Repeat("a", 63) + ".b",
+		},
+		"long label rejected": {
+			name:   strings.Repeat("a", 70) + ".b",
+			policy: Policy{TooLong: TooLongReject},
+			reason: ReasonLabelTooLong,
+		},
+		"long name truncated": {
+			name:   strings.Repeat("abcdefghi.", 30),
+			suffix: ".example.com",
+			expect: strings.Repeat("abcdefghi.", 23) + "abcdefghi.example.com",
+		},
+		"long single label name truncated": {
+			name:   strings.Repeat("a", 60),
+			suffix: "." + strings.Repeat("b", 200),
+			expect: strings.Repeat("a", 52) + "." + strings.Repeat("b", 200),
+		},
+		"long name rejected": {
+			name:   strings.Repeat("abcdefghi.", 30),
+			suffix: ".example.com",
+			policy: Policy{TooLong: TooLongReject},
+			reason: ReasonNameTooLong,
+		},
+		"empty": {
+			name:   " . ",
+			reason: ReasonEmpty,
+		},
+		"only invalid characters": {
+			name:   "!!!",
+			reason: ReasonEmpty,
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			n, err := NewNormalizer("test", tc.policy)
+			require.NoError(t, err)
+			got, err := n.Normalize(tc.name, tc.suffix)
+			if tc.reason != "" {
+				var rejected *RejectedError
+				require.ErrorAs(t, err, &rejected)
+				assert.Equal(t, tc.reason, rejected.Reason)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expect, got)
+			assert.LessOrEqual(t, len(got), MaxNameLength)
+		})
+	}
+}
+
+func TestNewNormalizer_InvalidPolicy(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewNormalizer("test", Policy{NonASCII: "ignore"})
+	assert.Error(t, err)
+	_, err = NewNormalizer("test", Policy{TooLong: "ignore"})
+	assert.Error(t, err)
+}
+
+func TestNormalize_RejectedMetric(t *testing.T) {
+	t.Parallel()
+
+	n, err := NewNormalizer("metric_test", Policy{})
+	require.NoError(t, err)
+	_, err = n.Normalize("???", "")
+	require.Error(t, err)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(MetricRejectedNames.WithLabelValues("metric_test", ReasonEmpty)))
+}
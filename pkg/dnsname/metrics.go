@@ -0,0 +1,18 @@
+package dnsname
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sapslaj/zonepop/pkg/metrics"
+)
+
+const MetricSubsystem = "dnsname"
+
+var MetricRejectedNames = metrics.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: MetricSubsystem,
+		Name:      "rejected_names",
+		Help:      "Number of hostnames that could not be normalized into a valid DNS name",
+	},
+	[]string{"provider", "reason"},
+)
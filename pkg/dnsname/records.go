@@ -0,0 +1,72 @@
+package dnsname
+
+import (
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+// Records returns the aliases and additional records of the endpoints with
+// their names normalized and suffix appended. Relative target names are
+// normalized the same way and, if there is a suffix, made absolute with a
+// trailing dot so values are valid in zone files. Invalid records are skipped
+// with a warning.
+func (n *Normalizer) Records(endpoints []*endpoint.Endpoint, suffix string, logger *zap.Logger) []endpoint.Record {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	// owner names of TXT and SRV records like "_dmarc" or "_sip._tcp" use
+	// underscores (RFC 8552)
+	underscored := &Normalizer{policy: n.policy, provider: n.provider}
+	underscored.policy.AllowUnderscores = true
+
+	records := make([]endpoint.Record, 0)
+	seen := map[endpoint.Record]bool{}
+	for _, e := range endpoints {
+		for _, record := range e.AllRecords() {
+			recordLogger := logger.With(
+				zap.String("hostname", e.Hostname),
+				zap.String("record_type", record.Type),
+				zap.String("record_name", record.Name),
+				zap.String("record_value", record.Value),
+			)
+			err := record.Validate()
+			if err != nil {
+				recordLogger.Warn("skipping invalid record", zap.Error(err))
+				continue
+			}
+
+			nameNormalizer := n
+			if record.Type == endpoint.RecordTypeTXT || record.Type == endpoint.RecordTypeSRV {
+				nameNormalizer = underscored
+			}
+			record.Name, err = nameNormalizer.Normalize(record.Name, suffix)
+			if err != nil {
+				recordLogger.Warn("skipping record with invalid name", zap.Error(err))
+				continue
+			}
+
+			target := record.Target()
+			if target != "" && !strings.HasSuffix(target, ".") {
+				target, err = n.Normalize(target, suffix)
+				if err != nil {
+					recordLogger.Warn("skipping record with invalid target", zap.Error(err))
+					continue
+				}
+				if suffix != "" {
+					target += "."
+				}
+				record = record.WithTarget(target)
+			}
+
+			if seen[record] {
+				continue
+			}
+			seen[record] = true
+			records = append(records, record)
+		}
+	}
+	return records
+}
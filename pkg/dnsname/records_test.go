@@ -0,0 +1,35 @@
+package dnsname
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+func TestRecords(t *testing.T) {
+	t.Parallel()
+
+	endpoints := []*endpoint.Endpoint{
+		{
+			Hostname:  "Synology DS920",
+			RecordTTL: 60,
+			Aliases:   []string{"nas", "NAS"},
+			Records: []endpoint.Record{
+				{Type: "TXT", Name: "_owner", Value: "ops team"},
+				{Type: "SRV", Name: "_smb._tcp", Value: "0 0 445 synology-ds920"},
+				{Type: "MX", Value: "10 mail.example.net."},
+				{Type: "MX", Value: "mail"},
+				{Type: "A", Value: "192.0.2.1"},
+			},
+		},
+	}
+	got := DefaultNormalizer.Records(endpoints, ".example.com", nil)
+	assert.Equal(t, []endpoint.Record{
+		{Type: "CNAME", Name: "nas.example.com", Value: "synology-ds920.example.com.", TTL: 60},
+		{Type: "TXT", Name: "_owner.example.com", Value: "ops team", TTL: 60},
+		{Type: "SRV", Name: "_smb._tcp.example.com", Value: "0 0 445 synology-ds920.example.com.", TTL: 60},
+		{Type: "MX", Name: "synology-ds920.example.com", Value: "10 mail.example.net.", TTL: 60},
+	}, got)
+}
@@ -0,0 +1,192 @@
+// Package localdns plans the changes to the local DNS entries of resolvers
+// like Pi-hole and AdGuard Home: entries that map a name to an address or, as
+// a CNAME, to another name. The resolvers answer reverse lookups for the
+// addresses themselves.
+package localdns
+
+import (
+	"net/netip"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
+)
+
+type Entry struct {
+	// Lower case name without trailing dot
+	Name string
+	// Address, or target name of a CNAME
+	Value string
+}
+
+// IsCNAME reports whether the entry points to another name instead of an
+// address.
+func (e Entry) IsCNAME() bool {
+	_, err := netip.ParseAddr(e.Value)
+	return err != nil
+}
+
+// key returns the entry in a form that can be compared.
+func (e Entry) key() Entry {
+	e.Name = Canonical(e.Name)
+	addr, err := netip.ParseAddr(e.Value)
+	if err == nil {
+		e.Value = addr.Unmap().String()
+	} else {
+		e.Value = Canonical(e.Value)
+	}
+	return e
+}
+
+// Canonical returns name in lower case without trailing dot.
+func Canonical(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+type Config struct {
+	// Appended to hostnames
+	RecordSuffix string
+	Normalizer   *dnsname.Normalizer
+	Logger       *zap.Logger
+	// Remove entries that aren't desired of all names ending with the record
+	// suffix, not just of the names of the endpoints. Without a record suffix
+	// only the names of the endpoints are managed.
+	Clean bool
+}
+
+// Desired returns the desired entries of the endpoints: their addresses and
+// their aliases as CNAMEs. Other records can't be expressed as entries and
+// are skipped. It also returns a function that reports whether the entries
+// of a name are managed, which is the case for the names of the endpoints
+// and, if cleaning, for all names ending with a non-empty record suffix.
+func Desired(endpoints []*endpoint.Endpoint, c Config) ([]Entry, func(name string) bool) {
+	if c.Logger == nil {
+		c.Logger = zap.NewNop()
+	}
+	if c.Normalizer == nil {
+		c.Normalizer = dnsname.DefaultNormalizer
+	}
+
+	desired := make([]Entry, 0)
+	names := map[string]bool{}
+	addressNames := map[string]bool{}
+	for _, e := range endpoints {
+		if e.Hostname == "" {
+			c.Logger.Sugar().Debugw(
+				"skipping endpoint without hostname, configure the hostname enricher to generate one",
+				"ipv4", e.IPv4s,
+				"ipv6", e.IPv6s,
+			)
+			continue
+		}
+		fullHostname, err := c.Normalizer.Normalize(e.Hostname, c.RecordSuffix)
+		if err != nil {
+			c.Logger.Sugar().Warnw("skipping endpoint with invalid hostname", "hostname", e.Hostname, "err", err)
+			continue
+		}
+		name := Canonical(fullHostname)
+		names[name] = true
+		for _, addr := range e.IPv4Addrs() {
+			desired = append(desired, Entry{Name: name, Value: addr.String()})
+			addressNames[name] = true
+		}
+		for _, addr := range e.IPv6Addrs() {
+			desired = append(desired, Entry{Name: name, Value: addr.String()})
+			addressNames[name] = true
+		}
+	}
+	cnames := map[string]string{}
+	for _, record := range c.Normalizer.Records(endpoints, c.RecordSuffix, c.Logger) {
+		if record.Type != endpoint.RecordTypeCNAME {
+			c.Logger.Sugar().Debugf("skipping %s record %q, only addresses and CNAMEs are supported", record.Type, record.Name)
+			continue
+		}
+		name := Canonical(record.Name)
+		names[name] = true
+		if addressNames[name] {
+			c.Logger.Sugar().Warnf("ignoring CNAME %q for %q, it already has addresses", record.Name, record.Value)
+			continue
+		}
+		if target, ok := cnames[name]; ok {
+			c.Logger.Sugar().Warnf("ignoring CNAME %q for %q, it already points to %q", record.Name, record.Value, target)
+			continue
+		}
+		cnames[name] = Canonical(record.Value)
+		desired = append(desired, Entry{Name: name, Value: cnames[name]})
+	}
+
+	suffix := Canonical(strings.TrimPrefix(c.RecordSuffix, "."))
+	managed := func(name string) bool {
+		name = Canonical(name)
+		if names[name] {
+			return true
+		}
+		if !c.Clean || suffix == "" || strings.HasPrefix(name, "*") {
+			return false
+		}
+		return name == suffix || strings.HasSuffix(name, "."+suffix)
+	}
+	return dedupe(desired), managed
+}
+
+// Plan returns the existing entries to remove, those of managed names that
+// aren't desired, and the desired entries to add. Both lists are sorted by
+// name and value.
+func Plan(desired []Entry, existing []Entry, managed func(name string) bool) ([]Entry, []Entry) {
+	desiredKeys := map[Entry]bool{}
+	for _, e := range desired {
+		desiredKeys[e.key()] = true
+	}
+	existingKeys := map[Entry]bool{}
+	remove := make([]Entry, 0)
+	for _, e := range existing {
+		existingKeys[e.key()] = true
+		if !desiredKeys[e.key()] && managed(e.Name) {
+			remove = append(remove, e)
+		}
+	}
+	add := make([]Entry, 0)
+	for _, e := range desired {
+		if !existingKeys[e.key()] {
+			add = append(add, e)
+		}
+	}
+	sortEntries(remove)
+	sortEntries(add)
+	return remove, add
+}
+
+func dedupe(entries []Entry) []Entry {
+	seen := map[Entry]bool{}
+	deduped := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if seen[e.key()] {
+			continue
+		}
+		seen[e.key()] = true
+		deduped = append(deduped, e)
+	}
+	return deduped
+}
+
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Value < entries[j].Value
+	})
+}
+
+// LogChanges logs the entries that are removed and added.
+func LogChanges(logger *zap.Logger, remove []Entry, add []Entry) {
+	for _, e := range remove {
+		logger.Sugar().With("full_hostname", e.Name, "value", e.Value).Infof("removing entry %q", e.Name)
+	}
+	for _, e := range add {
+		logger.Sugar().With("full_hostname", e.Name, "value", e.Value).Infof("adding entry %q", e.Name)
+	}
+}
@@ -0,0 +1,71 @@
+package localdns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+func TestDesired(t *testing.T) {
+	t.Parallel()
+
+	endpoints := []*endpoint.Endpoint{
+		{Hostname: "Host1", IPv4s: []string{"192.0.2.1"}, IPv6s: []string{"2001:db8::1"}, Aliases: []string{"nas", "host2"}},
+		{Hostname: "host2", IPv4s: []string{"192.0.2.2", "192.0.2.2"}},
+		{Hostname: "host3", Records: []endpoint.Record{
+			{Type: endpoint.RecordTypeTXT, Name: "host3", Value: "v=spf1 -all"},
+		}},
+		{IPv4s: []string{"192.0.2.4"}},
+	}
+
+	desired, managed := Desired(endpoints, Config{RecordSuffix: ".lan"})
+	assert.ElementsMatch(t, []Entry{
+		{Name: "host1.lan", Value: "192.0.2.1"},
+		{Name: "host1.lan", Value: "2001:db8::1"},
+		{Name: "host2.lan", Value: "192.0.2.2"},
+		{Name: "nas.lan", Value: "host1.lan"},
+	}, desired)
+	assert.True(t, managed("host3.lan"), "names of endpoints without addresses are managed")
+	assert.True(t, managed("NAS.lan."))
+	assert.False(t, managed("manual.lan"))
+
+	_, managed = Desired(endpoints, Config{RecordSuffix: ".lan", Clean: true})
+	assert.True(t, managed("manual.lan"))
+	assert.True(t, managed("lan"))
+	assert.False(t, managed("*.lan"), "wildcards are never managed")
+	assert.False(t, managed("example.com"))
+
+	_, managed = Desired(endpoints, Config{Clean: true})
+	assert.True(t, managed("host1"))
+	assert.False(t, managed("manual.lan"), "without a record suffix, cleaning doesn't manage other names")
+	assert.False(t, managed("example.com"))
+}
+
+func TestPlan(t *testing.T) {
+	t.Parallel()
+
+	desired := []Entry{
+		{Name: "host1.lan", Value: "192.0.2.1"},
+		{Name: "host1.lan", Value: "2001:db8::1"},
+		{Name: "host2.lan", Value: "192.0.2.2"},
+		{Name: "nas.lan", Value: "host1.lan"},
+	}
+	existing := []Entry{
+		{Name: "HOST1.lan", Value: "2001:DB8:0::1"},
+		{Name: "host1.lan", Value: "192.0.2.9"},
+		{Name: "nas.lan", Value: "host1.lan."},
+		{Name: "manual.lan", Value: "192.0.2.10"},
+	}
+	managed := func(name string) bool {
+		return name != "manual.lan"
+	}
+
+	remove, add := Plan(desired, existing, managed)
+	assert.Equal(t, []Entry{{Name: "host1.lan", Value: "192.0.2.9"}}, remove)
+	assert.Equal(t, []Entry{
+		{Name: "host1.lan", Value: "192.0.2.1"},
+		{Name: "host2.lan", Value: "192.0.2.2"},
+	}, add)
+}
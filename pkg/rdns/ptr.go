@@ -6,7 +6,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/sapslaj/zonepop/endpoint"
-	"github.com/sapslaj/zonepop/pkg/utils"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
 )
 
 type PTRRecord struct {
@@ -42,6 +42,10 @@ type Config struct {
 
 	RecordSuffix string
 
+	// normalizes hostnames into valid DNS names (default
+	// dnsname.DefaultNormalizer)
+	Normalizer *dnsname.Normalizer
+
 	Logger *zap.Logger
 }
 
@@ -57,6 +61,13 @@ func RFC1035DomainName(ptr string, zone string) string {
 	return rfc1035DomainName
 }
 
+// isSynthesized reports whether the hostname of e was generated by the
+// hostname enricher rather than coming from the source.
+func isSynthesized(e *endpoint.Endpoint) bool {
+	synthesized, _ := e.SourceProperties["hostname_synthesized"].(bool)
+	return synthesized
+}
+
 func PTRsForEndpoints(endpoints []*endpoint.Endpoint, c Config) ([]PTRRecord, error) {
 	logger := c.Logger
 	if logger == nil {
@@ -74,18 +85,24 @@ func PTRsForEndpoints(endpoints []*endpoint.Endpoint, c Config) ([]PTRRecord, er
 		logger = logger.With(zap.String("zone", c.Zone))
 	}
 
+	normalizer := c.Normalizer
+	if normalizer == nil {
+		normalizer = dnsname.DefaultNormalizer
+	}
+
 	ptrRecords := []PTRRecord{}
 	seenHostnames := map[string]string{}
 
 	for _, e := range endpoints {
-		for _, ipv4 := range e.IPv4s {
+		for _, addr := range e.IPv4Addrs() {
+			ipv4 := addr.String()
 			addrKind := AddressKindIPv4
 			addrLogger := logger.With(
 				zap.String("address", ipv4),
 				zap.String("address_kind", string(addrKind)),
 			)
 
-			autogeneratedHostname := false
+			autogeneratedHostname := isSynthesized(e)
 			hostname := e.Hostname
 			if hostname == "" {
 				autogeneratedHostname = true
@@ -94,7 +111,11 @@ func PTRsForEndpoints(endpoints []*endpoint.Endpoint, c Config) ([]PTRRecord, er
 					With("autogenerated_hostname", true).
 					Infof("No hostname defined for endpoint, using generated hostname of %s", hostname)
 			}
-			fullHostname := utils.DNSSafeName(hostname) + c.RecordSuffix
+			fullHostname, err := normalizer.Normalize(hostname, c.RecordSuffix)
+			if err != nil {
+				addrLogger.Warn("skipping PTR record for invalid hostname", zap.Error(err))
+				continue
+			}
 
 			if c.Zone != "" {
 				fits, err := FitsInReverseZone(ipv4, c.Zone)
@@ -111,11 +132,7 @@ func PTRsForEndpoints(endpoints []*endpoint.Endpoint, c Config) ([]PTRRecord, er
 				}
 			}
 
-			ptr, err := ReverseAddr(ipv4)
-			if err != nil {
-				addrLogger.Error("could not determine PTR record", zap.Error(err))
-				return ptrRecords, err
-			}
+			ptr := ReverseNetipAddr(addr)
 			addrLogger = addrLogger.With(zap.String("ptr", ptr))
 
 			if existingHostname, seen := seenHostnames[ptr]; seen {
@@ -143,14 +160,15 @@ func PTRsForEndpoints(endpoints []*endpoint.Endpoint, c Config) ([]PTRRecord, er
 			addrLogger.Sugar().Infof("adding IPv4 PTR record %q for hostname %q", ptr, hostname)
 		}
 
-		for _, ipv6 := range e.IPv6s {
+		for _, addr := range e.IPv6Addrs() {
+			ipv6 := addr.String()
 			addrKind := AddressKindIPv6
 			addrLogger := logger.With(
 				zap.String("address", ipv6),
 				zap.String("address_kind", string(addrKind)),
 			)
 
-			autogeneratedHostname := false
+			autogeneratedHostname := isSynthesized(e)
 			hostname := e.Hostname
 			if hostname == "" {
 				autogeneratedHostname = true
@@ -164,9 +182,10 @@ func PTRsForEndpoints(endpoints []*endpoint.Endpoint, c Config) ([]PTRRecord, er
 					Infof("No hostname defined for endpoint, using generated hostname of %s", hostname)
 			}
 
-			fullHostname := ""
-			if hostname != "" {
-				fullHostname = utils.DNSSafeName(hostname) + c.RecordSuffix
+			fullHostname, err := normalizer.Normalize(hostname, c.RecordSuffix)
+			if err != nil {
+				addrLogger.Warn("skipping PTR record for invalid hostname", zap.Error(err))
+				continue
 			}
 
 			addrLogger = logger.With(
@@ -190,11 +209,7 @@ func PTRsForEndpoints(endpoints []*endpoint.Endpoint, c Config) ([]PTRRecord, er
 				}
 			}
 
-			ptr, err := ReverseAddr(ipv6)
-			if err != nil {
-				addrLogger.Error("could not determine PTR record", zap.Error(err))
-				return ptrRecords, err
-			}
+			ptr := ReverseNetipAddr(addr)
 			addrLogger = addrLogger.With(zap.String("ptr", ptr))
 
 			if existingHostname, seen := seenHostnames[ptr]; seen {
@@ -257,6 +257,37 @@ func TestPTRsForEndpoints(t *testing.T) {
 				},
 			},
 		},
+		"synthesized hostname": {
+			endpoints: []*endpoint.Endpoint{
+				{
+					Hostname:  "lan-b827eb010203",
+					IPv4s:     []string{"192.0.2.1"},
+					RecordTTL: 60,
+					SourceProperties: map[string]any{
+						"hostname_synthesized": true,
+					},
+				},
+			},
+			expect: []PTRRecord{
+				{
+					Endpoint: &endpoint.Endpoint{
+						Hostname:  "lan-b827eb010203",
+						IPv4s:     []string{"192.0.2.1"},
+						RecordTTL: 60,
+						SourceProperties: map[string]any{
+							"hostname_synthesized": true,
+						},
+					},
+					Hostname:              "lan-b827eb010203",
+					AutoGeneratedHostname: true,
+					FullHostname:          "lan-b827eb010203",
+					Address:               "192.0.2.1",
+					AddressKind:           AddressKindIPv4,
+					DomainName:            "1.2.0.192.in-addr.arpa.",
+					RFC1035DomainName:     "1.2.0.192.in-addr.arpa.",
+				},
+			},
+		},
 		"dedup": {
 			endpoints: []*endpoint.Endpoint{
 				{
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -57,14 +59,23 @@ const hexDigit = "0123456789abcdef"
 
 // ReverseAddr calculates the rDNS record of an IPv4 or IPv6 address.
 func ReverseAddr(addr string) (string, error) {
-	// Adapted from src/net/dnsclient.go
 	ip := net.ParseIP(addr)
 	if ip == nil {
 		return "", fmt.Errorf("failed to parse address %q", addr)
 	}
+	return reverseIP(ip), nil
+}
+
+// ReverseNetipAddr calculates the rDNS record of an already parsed address.
+func ReverseNetipAddr(addr netip.Addr) string {
+	return reverseIP(net.IP(addr.Unmap().AsSlice()).To16())
+}
+
+func reverseIP(ip net.IP) string {
+	// Adapted from src/net/dnsclient.go
 	if ip.To4() != nil {
 		r := strconv.Itoa(int(ip[15])) + "." + strconv.Itoa(int(ip[14])) + "." + strconv.Itoa(int(ip[13])) + "." + strconv.Itoa(int(ip[12])) + ".in-addr.arpa."
-		return r, nil
+		return r
 	}
 	// Must be IPv6
 	buf := make([]byte, 0, len(ip)*4+len("ip6.arpa."))
@@ -80,7 +91,44 @@ func ReverseAddr(addr string) (string, error) {
 	}
 	// Append "ip6.arpa." and return (buf already has the final .)
 	buf = append(buf, "ip6.arpa."...)
-	return string(buf), nil
+	return string(buf)
+}
+
+// AddrFromReverse returns the address of a full rDNS record name, the
+// reverse of ReverseAddr.
+func AddrFromReverse(name string) (netip.Addr, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	var s string
+	switch {
+	case strings.HasSuffix(name, ".in-addr.arpa"):
+		labels := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa"), ".")
+		if len(labels) != 4 {
+			return netip.Addr{}, fmt.Errorf("%q is not the rDNS record of an IPv4 address", name)
+		}
+		slices.Reverse(labels)
+		s = strings.Join(labels, ".")
+	case strings.HasSuffix(name, ".ip6.arpa"):
+		labels := strings.Split(strings.TrimSuffix(name, ".ip6.arpa"), ".")
+		if len(labels) != 32 || slices.ContainsFunc(labels, func(label string) bool { return len(label) != 1 }) {
+			return netip.Addr{}, fmt.Errorf("%q is not the rDNS record of an IPv6 address", name)
+		}
+		slices.Reverse(labels)
+		var b strings.Builder
+		for i, label := range labels {
+			if i > 0 && i%4 == 0 {
+				b.WriteByte(':')
+			}
+			b.WriteString(label)
+		}
+		s = b.String()
+	default:
+		return netip.Addr{}, fmt.Errorf("%w: %q is not a rDNS record", ErrInvalidZone, name)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%q is not the rDNS record of an address: %w", name, err)
+	}
+	return addr, nil
 }
 
 // FitsInReverseZone calculates whether an IPv4 or IPv6 address fits in a
@@ -1,6 +1,7 @@
 package rdns
 
 import (
+	"net/netip"
 	"strings"
 	"testing"
 
@@ -109,6 +110,9 @@ func TestReverseAddr(t *testing.T) {
 			t.Parallel()
 
 			got, err := ReverseAddr(tc.input)
+			if addr, parseErr := netip.ParseAddr(tc.input); parseErr == nil && tc.expected != ReverseNetipAddr(addr) {
+				t.Errorf("%s: expected %q from ReverseNetipAddr, got %q", desc, tc.expected, ReverseNetipAddr(addr))
+			}
 			if tc.errMsg == "" && err != nil {
 				t.Errorf("%s: expected no error but got error %v", desc, err)
 			}
@@ -125,6 +129,61 @@ func TestReverseAddr(t *testing.T) {
 	}
 }
 
+func TestAddrFromReverse(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		input    string
+		expected string
+		errMsg   string
+	}{
+		"IPv4": {
+			input:    "69.2.0.192.in-addr.arpa.",
+			expected: "192.0.2.69",
+		},
+		"IPv6": {
+			input:    "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.B.D.0.1.0.0.2.ip6.arpa",
+			expected: "2001:db8::1",
+		},
+		"IPv4 zone": {
+			input:  "2.0.192.in-addr.arpa.",
+			errMsg: "is not the rDNS record of an IPv4 address",
+		},
+		"invalid IPv6 labels": {
+			input:  "10.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+			errMsg: "is not the rDNS record of an IPv6 address",
+		},
+		"invalid IPv4 octet": {
+			input:  "256.2.0.192.in-addr.arpa.",
+			errMsg: "is not the rDNS record of an address",
+		},
+		"forward name": {
+			input:  "host.example.com.",
+			errMsg: "is not a rDNS record",
+		},
+	}
+	for desc, tc := range tests {
+		tc := tc
+		t.Run(desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := AddrFromReverse(tc.input)
+			if tc.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+					t.Errorf("%s: expected error %q but got %v", desc, tc.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%s: expected no error but got error %v", desc, err)
+			}
+			if got.String() != tc.expected {
+				t.Errorf("%s: expected %q but got %q", desc, tc.expected, got)
+			}
+		})
+	}
+}
+
 func TestFitsInReverseZone(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,242 @@
+// Package recordset plans the record set changes that bring the forward and
+// reverse lookup zones of a DNS provider in line with the endpoints, for
+// providers whose APIs work on record sets: all records of a name and type.
+package recordset
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+// Record types managed for endpoints in forward and reverse lookup zones.
+const (
+	TypeA    = "A"
+	TypeAAAA = "AAAA"
+	TypePTR  = "PTR"
+	TypeTXT  = "TXT"
+)
+
+type Key struct {
+	// Fully qualified lower case name
+	Name string
+	Type string
+}
+
+type Set struct {
+	// Full name as added or returned by the provider, see Key for the
+	// canonical form
+	Name   string
+	Type   string
+	TTL    int64
+	Values []string
+}
+
+func (s Set) Key() Key {
+	return Key{Name: Canonical(s.Name), Type: s.Type}
+}
+
+// Equal reports whether s and other have the same TTL and values, in any
+// order.
+func (s Set) Equal(other Set) bool {
+	if s.TTL != other.TTL || len(s.Values) != len(other.Values) {
+		return false
+	}
+	for _, value := range s.Values {
+		found := slices.ContainsFunc(other.Values, func(otherValue string) bool {
+			return Value(s.Type, value) == Value(s.Type, otherValue)
+		})
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Desired collects the desired record sets of one or more zones.
+type Desired map[Key]*Set
+
+// Add adds values to the record set of name and type, creating it with ttl if
+// it doesn't exist yet. Duplicate values are ignored.
+func (d Desired) Add(name string, recordType string, ttl int64, values ...string) {
+	key := Key{Name: Canonical(name), Type: recordType}
+	s, ok := d[key]
+	if !ok {
+		s = &Set{
+			Name:   name,
+			Type:   recordType,
+			TTL:    ttl,
+			Values: make([]string, 0, len(values)),
+		}
+		d[key] = s
+	}
+	for _, value := range values {
+		if !slices.Contains(s.Values, value) {
+			s.Values = append(s.Values, value)
+		}
+	}
+}
+
+// AddRecord adds value to the record set of name and type like Add, unless a
+// CNAME would share its name with other records, which DNS doesn't allow. The
+// error says why the record was skipped.
+func (d Desired) AddRecord(name string, recordType string, ttl int64, value string) error {
+	canonical := Canonical(name)
+	if cname, ok := d[Key{Name: canonical, Type: endpoint.RecordTypeCNAME}]; ok {
+		if recordType == endpoint.RecordTypeCNAME {
+			return fmt.Errorf("it already points to %q", cname.Values[0])
+		}
+		return fmt.Errorf("it already is a CNAME for %q", cname.Values[0])
+	}
+	if recordType == endpoint.RecordTypeCNAME {
+		for _, otherType := range []string{TypeA, TypeAAAA, TypePTR, TypeTXT, endpoint.RecordTypeMX, endpoint.RecordTypeSRV} {
+			if _, ok := d[Key{Name: canonical, Type: otherType}]; ok {
+				return errors.New("it already has other records")
+			}
+		}
+	}
+	d.Add(name, recordType, ttl, value)
+	return nil
+}
+
+// Canonical returns name in lower case and fully qualified.
+func Canonical(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// Value returns value in a form that can be compared. Names in values are
+// case-insensitive and may or may not be fully qualified.
+func Value(recordType string, value string) string {
+	if recordType == TypeTXT {
+		return value
+	}
+	return strings.TrimSuffix(strings.ToLower(value), ".")
+}
+
+// Update replaces the Old record set with the New one.
+type Update struct {
+	Old Set
+	New Set
+}
+
+// Changes turn the existing record sets of a zone into the desired ones.
+type Changes struct {
+	Delete []Set
+	Create []Set
+	Update []Update
+}
+
+func (c Changes) Empty() bool {
+	return len(c.Delete) == 0 && len(c.Create) == 0 && len(c.Update) == 0
+}
+
+// Plan returns the changes that turn the existing record sets of a zone into
+// the desired ones: new record sets are created, ones with different values
+// or TTL are updated and existing record sets that aren't desired are deleted
+// if owned reports their key. Each list is sorted by name and type.
+func Plan(desired Desired, existing []Set, owned func(key Key) bool) Changes {
+	changes := Changes{
+		Delete: make([]Set, 0),
+		Create: make([]Set, 0),
+		Update: make([]Update, 0),
+	}
+	existingByKey := map[Key]Set{}
+	for _, s := range existing {
+		key := s.Key()
+		existingByKey[key] = s
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		if !owned(key) {
+			continue
+		}
+		changes.Delete = append(changes.Delete, s)
+	}
+	for _, key := range desired.keys() {
+		s := desired[key]
+		if len(s.Values) == 0 {
+			continue
+		}
+		existingSet, ok := existingByKey[key]
+		if !ok {
+			changes.Create = append(changes.Create, *s)
+			continue
+		}
+		if existingSet.Equal(*s) {
+			continue
+		}
+		changes.Update = append(changes.Update, Update{Old: existingSet, New: *s})
+	}
+	sort.Slice(changes.Delete, func(i, j int) bool {
+		return less(changes.Delete[i].Key(), changes.Delete[j].Key())
+	})
+	return changes
+}
+
+// Written tracks the record sets a provider created or updated, so they can be
+// removed once they aren't desired anymore even without cleaning the zone.
+type Written map[Key]bool
+
+// Track remembers the record sets created or updated by changes, and forgets
+// deleted ones if the changes were applied. Changes that failed might still
+// have been applied in part.
+func (w Written) Track(changes Changes, applied bool) {
+	if w == nil {
+		return
+	}
+	for _, s := range changes.Create {
+		w[s.Key()] = true
+	}
+	for _, u := range changes.Update {
+		w[u.New.Key()] = true
+	}
+	if !applied {
+		return
+	}
+	for _, s := range changes.Delete {
+		delete(w, s.Key())
+	}
+}
+
+func (d Desired) keys() []Key {
+	keys := make([]Key, 0, len(d))
+	for key := range d {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return less(keys[i], keys[j])
+	})
+	return keys
+}
+
+func less(a Key, b Key) bool {
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.Type < b.Type
+}
+
+// QuotedTXT quotes TXT record text the way it appears in zone files,
+// splitting it into strings of at most 255 characters.
+func QuotedTXT(text string) string {
+	parts := make([]string, 0)
+	for len(text) > 255 {
+		parts = append(parts, text[:255])
+		text = text[255:]
+	}
+	parts = append(parts, text)
+	for i, part := range parts {
+		part = strings.ReplaceAll(part, `\`, `\\`)
+		part = strings.ReplaceAll(part, `"`, `\"`)
+		parts[i] = `"` + part + `"`
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,289 @@
+package recordset
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+func changeSummary(changes Changes) []string {
+	summary := make([]string, 0)
+	for _, s := range changes.Delete {
+		summary = append(summary, fmt.Sprintf("DELETE %s %s", s.Type, s.Name))
+	}
+	for _, s := range changes.Create {
+		summary = append(summary, fmt.Sprintf("CREATE %s %s %s", s.Type, s.Name, strings.Join(s.Values, " ")))
+	}
+	for _, u := range changes.Update {
+		summary = append(summary, fmt.Sprintf("UPDATE %s %s %s", u.New.Type, u.New.Name, strings.Join(u.New.Values, " ")))
+	}
+	return summary
+}
+
+func TestPlan(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		desired  func(d Desired)
+		existing []Set
+		owned    bool
+		want     []string
+	}{
+		"creates missing record sets": {
+			desired: func(d Desired) {
+				d.Add("host1.example.com", TypeA, 300, "192.0.2.1", "192.0.2.1")
+				d.Add("host1.example.com", TypeAAAA, 300, "2001:db8::1")
+			},
+			want: []string{
+				"CREATE A host1.example.com 192.0.2.1",
+				"CREATE AAAA host1.example.com 2001:db8::1",
+			},
+		},
+		"ignores equal record sets": {
+			desired: func(d Desired) {
+				d.Add("host1.example.com", TypeA, 300, "192.0.2.1", "192.0.2.2")
+				d.Add("1.2.0.192.in-addr.arpa.", TypePTR, 300, "host1.example.com.")
+			},
+			existing: []Set{
+				{Name: "HOST1.example.com.", Type: TypeA, TTL: 300, Values: []string{"192.0.2.2", "192.0.2.1"}},
+				{Name: "1.2.0.192.in-addr.arpa.", Type: TypePTR, TTL: 300, Values: []string{"Host1.example.com"}},
+			},
+			want: []string{},
+		},
+		"updates changed values and TTL": {
+			desired: func(d Desired) {
+				d.Add("host1.example.com", TypeA, 300, "192.0.2.1")
+				d.Add("host2.example.com", TypeA, 60, "192.0.2.2")
+			},
+			existing: []Set{
+				{Name: "host1.example.com.", Type: TypeA, TTL: 300, Values: []string{"192.0.2.9"}},
+				{Name: "host2.example.com.", Type: TypeA, TTL: 300, Values: []string{"192.0.2.2"}},
+			},
+			want: []string{
+				"UPDATE A host1.example.com 192.0.2.1",
+				"UPDATE A host2.example.com 192.0.2.2",
+			},
+		},
+		"deletes owned record sets of managed types": {
+			existing: []Set{
+				{Name: "host1.example.com.", Type: TypeAAAA, TTL: 300, Values: []string{"2001:db8::1"}},
+				{Name: "host1.example.com.", Type: TypeTXT, TTL: 300, Values: []string{`"keep"`}},
+			},
+			owned: true,
+			want: []string{
+				"DELETE AAAA host1.example.com.",
+			},
+		},
+		"keeps record sets that are not owned": {
+			existing: []Set{
+				{Name: "host1.example.com.", Type: TypeA, TTL: 300, Values: []string{"192.0.2.1"}},
+			},
+			want: []string{},
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			desired := Desired{}
+			if tc.desired != nil {
+				tc.desired(desired)
+			}
+			changes := Plan(desired, tc.existing, func(key Key) bool {
+				return tc.owned && key.Type != TypeTXT
+			})
+			assert.Equal(t, tc.want, changeSummary(changes))
+		})
+	}
+}
+
+func TestDesired_AddRecord(t *testing.T) {
+	t.Parallel()
+
+	desired := Desired{}
+	desired.Add("nas.example.com.", TypeA, 300, "192.0.2.1")
+	require.NoError(t, desired.AddRecord("www.example.com.", endpoint.RecordTypeCNAME, 300, "nas.example.com."))
+
+	assert.EqualError(t, desired.AddRecord("NAS.example.com.", endpoint.RecordTypeCNAME, 300, "other.example.com."), "it already has other records")
+	assert.EqualError(t, desired.AddRecord("www.example.com.", endpoint.RecordTypeCNAME, 300, "other.example.com."), `it already points to "nas.example.com."`)
+	assert.EqualError(t, desired.AddRecord("www.example.com.", TypeTXT, 300, `"hi"`), `it already is a CNAME for "nas.example.com."`)
+	require.NoError(t, desired.AddRecord("nas.example.com.", TypeTXT, 300, `"hi"`))
+	assert.Len(t, desired, 3)
+}
+
+func TestWritten_Track(t *testing.T) {
+	t.Parallel()
+
+	written := Written{}
+	changes := Changes{
+		Delete: []Set{{Name: "old.example.com.", Type: TypeA}},
+		Create: []Set{{Name: "New.example.com", Type: TypeA}},
+		Update: []Update{{New: Set{Name: "changed.example.com.", Type: TypeTXT}}},
+	}
+	written[Key{Name: "old.example.com.", Type: TypeA}] = true
+
+	written.Track(changes, false)
+	assert.Equal(t, Written{
+		{Name: "old.example.com.", Type: TypeA}:       true,
+		{Name: "new.example.com.", Type: TypeA}:       true,
+		{Name: "changed.example.com.", Type: TypeTXT}: true,
+	}, written, "deletions that failed might not have been applied")
+
+	written.Track(changes, true)
+	assert.NotContains(t, written, Key{Name: "old.example.com.", Type: TypeA})
+
+	var disabled Written
+	disabled.Track(changes, true)
+}
+
+func TestZones(t *testing.T) {
+	t.Parallel()
+
+	zones := Zones{}
+	assert.True(t, zones.Add(Zone{ID: "1", Name: "example.com"}))
+	assert.True(t, zones.Add(Zone{ID: "2", Name: "lan.example.com."}))
+	assert.True(t, zones.Add(Zone{ID: "3", Name: "2.0.192.in-addr.arpa."}))
+	assert.True(t, zones.Add(Zone{ID: "4", Name: "0.192.in-addr.arpa."}))
+	assert.True(t, zones.Add(Zone{ID: "5", Name: "8.b.d.0.1.0.0.2.ip6.arpa."}))
+	assert.False(t, zones.Add(Zone{ID: "6", Name: "Example.com."}))
+
+	assert.Equal(t, []Zone{{ID: "1", Name: "example.com."}, {ID: "2", Name: "lan.example.com."}}, zones.Forward)
+	assert.Len(t, zones.IPv4Reverse, 2)
+	assert.Len(t, zones.IPv6Reverse, 1)
+
+	assert.Equal(t, "2", ZoneFor(zones.Forward, "host1.lan.example.com").ID)
+	assert.Equal(t, "1", ZoneFor(zones.Forward, "host1.example.com.").ID)
+	assert.Equal(t, "1", ZoneFor(zones.Forward, "example.com.").ID)
+	assert.Nil(t, ZoneFor(zones.Forward, "host1.example.net."))
+	assert.Equal(t, "3", ZoneFor(zones.IPv4Reverse, "1.2.0.192.in-addr.arpa.").ID)
+	assert.Equal(t, "4", ZoneFor(zones.IPv4Reverse, "1.3.0.192.in-addr.arpa.").ID)
+
+	desired := Desired{}
+	desired.Add("host1.lan.example.com.", TypeA, 60, "192.0.2.1")
+	desired.Add("host1.example.com.", TypeA, 60, "192.0.2.2")
+	desired.Add("host1.example.net.", TypeA, 60, "192.0.2.3")
+	routed := Route(zones.Forward, desired, "forward lookup", zap.NewNop())
+	assert.Len(t, routed, 2)
+	assert.Contains(t, routed["2"], Key{Name: "host1.lan.example.com.", Type: TypeA})
+	assert.Contains(t, routed["1"], Key{Name: "host1.example.com.", Type: TypeA})
+}
+
+type fakeClient struct {
+	sets    map[string][]Set
+	applied map[string][]string
+}
+
+func (c *fakeClient) RecordSets(ctx context.Context, zone Zone) ([]Set, error) {
+	return c.sets[zone.ID], nil
+}
+
+func (c *fakeClient) Apply(ctx context.Context, zone Zone, changes Changes) error {
+	c.applied[zone.ID] = append(c.applied[zone.ID], changeSummary(changes)...)
+	return nil
+}
+
+func TestSync(t *testing.T) {
+	t.Parallel()
+
+	zones := Zones{}
+	zones.Add(Zone{ID: "forward", Name: "example.com."})
+	zones.Add(Zone{ID: "lan", Name: "lan.example.com."})
+	zones.Add(Zone{ID: "ipv4", Name: "2.0.192.in-addr.arpa."})
+	zones.Add(Zone{ID: "ipv6", Name: "8.b.d.0.1.0.0.2.ip6.arpa."})
+	client := &fakeClient{
+		sets: map[string][]Set{
+			"forward": {
+				{Name: "host1.lan.example.com.", Type: TypeA, TTL: 300, Values: []string{"192.0.2.1"}},
+				{Name: "host2.example.com.", Type: TypeAAAA, TTL: 300, Values: []string{"2001:db8::2"}},
+			},
+			"ipv4": {
+				{Name: "9.2.0.192.in-addr.arpa.", Type: TypePTR, TTL: 300, Values: []string{"old.example.com."}},
+			},
+		},
+		applied: map[string][]string{},
+	}
+	endpoints := []*endpoint.Endpoint{
+		{Hostname: "host1.lan", IPv4s: []string{"192.0.2.1"}, RecordTTL: 60},
+		{Hostname: "host2", IPv4s: []string{"192.0.2.2"}, IPv6s: []string{"2001:db8::2"}, Aliases: []string{"www"}},
+	}
+
+	err := Sync(context.Background(), client, zones, endpoints, endpoints, SyncConfig{
+		RecordSuffix:         ".example.com",
+		CleanIPv4ReverseZone: true,
+	})
+	require.NoError(t, err)
+	for _, applied := range client.applied {
+		sort.Strings(applied)
+	}
+	assert.Equal(t, map[string][]string{
+		"forward": {
+			"CREATE A host2.example.com. 192.0.2.2",
+			"CREATE CNAME www.example.com. host2.example.com.",
+		},
+		"lan": {
+			"CREATE A host1.lan.example.com. 192.0.2.1",
+		},
+		"ipv4": {
+			"CREATE PTR 1.2.0.192.in-addr.arpa. host1.lan.example.com.",
+			"CREATE PTR 2.2.0.192.in-addr.arpa. host2.example.com.",
+			"DELETE PTR 9.2.0.192.in-addr.arpa.",
+		},
+		"ipv6": {
+			"CREATE PTR 2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. host2.example.com.",
+		},
+	}, client.applied)
+}
+
+func TestSync_Written(t *testing.T) {
+	t.Parallel()
+
+	zones := Zones{}
+	zones.Add(Zone{ID: "forward", Name: "example.com."})
+	client := &fakeClient{
+		sets: map[string][]Set{
+			"forward": {
+				{Name: "manual.example.com.", Type: TypeTXT, TTL: 300, Values: []string{`"keep"`}},
+				{Name: "www.example.com.", Type: endpoint.RecordTypeCNAME, TTL: 300, Values: []string{"host1.example.com."}},
+				{Name: "host1.example.com.", Type: TypeTXT, TTL: 300, Values: []string{`"hi"`}},
+			},
+		},
+		applied: map[string][]string{},
+	}
+	c := SyncConfig{
+		RecordSuffix: ".example.com",
+		TXTValue:     QuotedTXT,
+		Written: Written{
+			{Name: "www.example.com.", Type: endpoint.RecordTypeCNAME}: true,
+			{Name: "host1.example.com.", Type: TypeTXT}:                true,
+		},
+	}
+	endpoints := []*endpoint.Endpoint{
+		{Hostname: "host1", IPv4s: []string{"192.0.2.1"}},
+		// the alias conflicts with the address of host1
+		{Hostname: "host2", IPv4s: []string{"192.0.2.2"}, Aliases: []string{"host1"}},
+	}
+
+	err := Sync(context.Background(), client, zones, endpoints, nil, c)
+	require.NoError(t, err)
+	sort.Strings(client.applied["forward"])
+	assert.Equal(t, []string{
+		"CREATE A host1.example.com. 192.0.2.1",
+		"CREATE A host2.example.com. 192.0.2.2",
+		"DELETE CNAME www.example.com.",
+		"DELETE TXT host1.example.com.",
+	}, client.applied["forward"])
+	assert.Equal(t, Written{
+		{Name: "host1.example.com.", Type: TypeA}: true,
+		{Name: "host2.example.com.", Type: TypeA}: true,
+	}, c.Written)
+}
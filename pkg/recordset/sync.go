@@ -0,0 +1,316 @@
+package recordset
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
+	"github.com/sapslaj/zonepop/pkg/rdns"
+)
+
+const DefaultTTL = 300
+
+type Zone struct {
+	// Provider specific ID of the zone
+	ID string
+	// Fully qualified lower case name
+	Name string
+}
+
+// Zones are the zones records are routed to, by kind.
+type Zones struct {
+	Forward     []Zone
+	IPv4Reverse []Zone
+	IPv6Reverse []Zone
+}
+
+// Add adds a zone to the zones of its kind, determined by its name. It
+// reports false if a zone with the same name was added before.
+func (z *Zones) Add(zone Zone) bool {
+	zone.Name = Canonical(zone.Name)
+	for _, zones := range [][]Zone{z.Forward, z.IPv4Reverse, z.IPv6Reverse} {
+		for _, existing := range zones {
+			if existing.Name == zone.Name {
+				return false
+			}
+		}
+	}
+	kind, err := rdns.DetermineReverseZoneKind(zone.Name)
+	switch {
+	case err != nil:
+		z.Forward = append(z.Forward, zone)
+	case kind == rdns.AddressKindIPv4:
+		z.IPv4Reverse = append(z.IPv4Reverse, zone)
+	default:
+		z.IPv6Reverse = append(z.IPv6Reverse, zone)
+	}
+	return true
+}
+
+// ZoneFor returns the most specific of zones that name fits in, or nil if it
+// fits in none of them.
+func ZoneFor(zones []Zone, name string) *Zone {
+	name = Canonical(name)
+	var found *Zone
+	for i, zone := range zones {
+		if name != zone.Name && !strings.HasSuffix(name, "."+zone.Name) {
+			continue
+		}
+		if found == nil || len(zone.Name) > len(found.Name) {
+			found = &zones[i]
+		}
+	}
+	return found
+}
+
+// Route splits the desired record sets by the ID of the most specific of
+// zones they fit in. Record sets that fit in none of them are left out with a
+// warning.
+func Route(zones []Zone, desired Desired, description string, logger *zap.Logger) map[string]Desired {
+	zoneDesired := map[string]Desired{}
+	for key, s := range desired {
+		zone := ZoneFor(zones, key.Name)
+		if zone == nil {
+			logger.Sugar().Warnf("%s record %q does not fit in any %s zone", key.Type, key.Name, description)
+			continue
+		}
+		if zoneDesired[zone.ID] == nil {
+			zoneDesired[zone.ID] = Desired{}
+		}
+		zoneDesired[zone.ID][key] = s
+	}
+	return zoneDesired
+}
+
+// Client reads and changes the record sets of zones of a provider.
+type Client interface {
+	RecordSets(ctx context.Context, zone Zone) ([]Set, error)
+	Apply(ctx context.Context, zone Zone, changes Changes) error
+}
+
+type SyncConfig struct {
+	// Appended to hostnames
+	RecordSuffix string
+	Normalizer   *dnsname.Normalizer
+	Logger       *zap.Logger
+	// TTL of records of endpoints without one (default 300)
+	DefaultTTL int64
+	// Converts TXT record text into the value the provider expects, e.g. by
+	// quoting it
+	TXTValue func(text string) string
+	// Record sets written by earlier syncs, which are removed once they
+	// aren't desired anymore. Nil disables tracking.
+	Written Written
+	// Remove A and AAAA records that aren't desired from the forward zones
+	CleanForwardZone bool
+	// Remove PTR records that aren't desired from the reverse zones
+	CleanIPv4ReverseZone bool
+	CleanIPv6ReverseZone bool
+}
+
+func (c SyncConfig) withDefaults() SyncConfig {
+	if c.Logger == nil {
+		c.Logger = zap.NewNop()
+	}
+	if c.Normalizer == nil {
+		c.Normalizer = dnsname.DefaultNormalizer
+	}
+	return c
+}
+
+func (c SyncConfig) ttl(ttl int64) int64 {
+	if ttl > 0 {
+		return ttl
+	}
+	if c.DefaultTTL > 0 {
+		return c.DefaultTTL
+	}
+	return DefaultTTL
+}
+
+// Sync brings the record sets of the zones in line with the endpoints: A,
+// AAAA and additional records of forwardEndpoints in the forward zones and
+// PTR records of reverseEndpoints in the reverse zones. A and AAAA records of
+// hostnames that aren't desired anymore are removed, as are record sets that
+// were written before, other A, AAAA and PTR records only if the zone is
+// cleaned.
+func Sync(ctx context.Context, client Client, zones Zones, forwardEndpoints []*endpoint.Endpoint, reverseEndpoints []*endpoint.Endpoint, c SyncConfig) error {
+	c = c.withDefaults()
+
+	var errs error
+	if len(zones.Forward) == 0 {
+		c.Logger.Warn("Forward lookup zone disabled")
+	} else {
+		desired, hostnames := Forward(forwardEndpoints, c)
+		if c.CleanForwardZone {
+			c.Logger.Info("cleanup: cleaning forward lookup zone")
+		}
+		errs = multierr.Append(errs, syncZones(ctx, client, zones.Forward, desired, "forward lookup", func(key Key) bool {
+			address := key.Type == TypeA || key.Type == TypeAAAA
+			return address && (c.CleanForwardZone || hostnames[key.Name]) || c.Written[key]
+		}, c))
+	}
+
+	reverse := []struct {
+		kind   rdns.AddressKind
+		zones  []Zone
+		clean  bool
+		prefix string
+	}{
+		{rdns.AddressKindIPv4, zones.IPv4Reverse, c.CleanIPv4ReverseZone, "IPv4"},
+		{rdns.AddressKindIPv6, zones.IPv6Reverse, c.CleanIPv6ReverseZone, "IPv6"},
+	}
+	var reverseDesired map[rdns.AddressKind]Desired
+	for _, r := range reverse {
+		if len(r.zones) == 0 {
+			c.Logger.Sugar().Warnf("%s reverse lookup zone disabled", r.prefix)
+			continue
+		}
+		if reverseDesired == nil {
+			var err error
+			reverseDesired, err = PTRs(reverseEndpoints, c)
+			if err != nil {
+				return multierr.Append(errs, err)
+			}
+		}
+		desired := reverseDesired[r.kind]
+		if r.clean {
+			c.Logger.Sugar().Infof("cleanup: cleaning %s reverse lookup zone", r.prefix)
+		}
+		clean := r.clean
+		errs = multierr.Append(errs, syncZones(ctx, client, r.zones, desired, r.prefix+" reverse lookup", func(key Key) bool {
+			return key.Type == TypePTR && clean || c.Written[key]
+		}, c))
+	}
+	return errs
+}
+
+// Forward returns the desired record sets of the forward lookup zones and the
+// hostnames of the endpoints.
+func Forward(endpoints []*endpoint.Endpoint, c SyncConfig) (Desired, map[string]bool) {
+	c = c.withDefaults()
+	desired := Desired{}
+	hostnames := map[string]bool{}
+	for _, e := range endpoints {
+		if e.Hostname == "" {
+			c.Logger.Sugar().Debugw(
+				"skipping endpoint without hostname for forward lookup zone, configure the hostname enricher to generate one",
+				"ipv4", e.IPv4s,
+				"ipv6", e.IPv6s,
+			)
+			continue
+		}
+		fullHostname, err := c.Normalizer.Normalize(e.Hostname, c.RecordSuffix)
+		if err != nil {
+			c.Logger.Sugar().Warnw("skipping endpoint with invalid hostname", "hostname", e.Hostname, "err", err)
+			continue
+		}
+		name := Canonical(fullHostname)
+		hostnames[name] = true
+		for _, addr := range e.IPv4Addrs() {
+			desired.Add(name, TypeA, c.ttl(e.RecordTTL), addr.String())
+		}
+		for _, addr := range e.IPv6Addrs() {
+			desired.Add(name, TypeAAAA, c.ttl(e.RecordTTL), addr.String())
+		}
+	}
+	for _, record := range c.Normalizer.Records(endpoints, c.RecordSuffix, c.Logger) {
+		value := record.Value
+		if record.Type == endpoint.RecordTypeTXT && c.TXTValue != nil {
+			value = c.TXTValue(value)
+		}
+		err := desired.AddRecord(Canonical(record.Name), record.Type, c.ttl(record.TTL), value)
+		if err != nil {
+			c.Logger.Sugar().Warnf("ignoring %s record %q for %q, %s", record.Type, record.Name, record.Value, err)
+		}
+	}
+	return desired, hostnames
+}
+
+// PTRs returns the desired PTR record sets of the reverse lookup zones by
+// address kind.
+func PTRs(endpoints []*endpoint.Endpoint, c SyncConfig) (map[rdns.AddressKind]Desired, error) {
+	c = c.withDefaults()
+	records, err := rdns.PTRsForEndpoints(endpoints, rdns.Config{
+		RecordSuffix: c.RecordSuffix,
+		Normalizer:   c.Normalizer,
+		Logger:       c.Logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not generate PTR records: %w", err)
+	}
+	desired := map[rdns.AddressKind]Desired{
+		rdns.AddressKindIPv4: {},
+		rdns.AddressKindIPv6: {},
+	}
+	for _, record := range records {
+		desired[record.AddressKind].Add(Canonical(record.DomainName), TypePTR, c.ttl(record.Endpoint.RecordTTL), Canonical(record.FullHostname))
+	}
+	return desired, nil
+}
+
+// syncZones routes the desired record sets to the most specific zone and
+// applies the changes to each zone.
+func syncZones(
+	ctx context.Context,
+	client Client,
+	zones []Zone,
+	desired Desired,
+	description string,
+	owned func(key Key) bool,
+	c SyncConfig,
+) error {
+	zoneDesired := Route(zones, desired, description, c.Logger)
+
+	var errs error
+	for _, zone := range zones {
+		existing, err := client.RecordSets(ctx, zone)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("could not list records of %s zone %s: %w", description, zone.Name, err))
+			continue
+		}
+		// records of more specific zones are deleted from those zones only
+		zoneOwned := func(key Key) bool {
+			mostSpecific := ZoneFor(zones, key.Name)
+			return owned(key) && mostSpecific != nil && mostSpecific.ID == zone.ID
+		}
+		changes := Plan(zoneDesired[zone.ID], existing, zoneOwned)
+		if changes.Empty() {
+			c.Logger.Sugar().Debugf("no changes for %s zone %s", description, zone.Name)
+			continue
+		}
+		logChanges(c.Logger, zone, changes)
+		err = client.Apply(ctx, zone, changes)
+		c.Written.Track(changes, err == nil)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("could not update %s zone %s: %w", description, zone.Name, err))
+		}
+	}
+	return errs
+}
+
+func logChanges(logger *zap.Logger, zone Zone, changes Changes) {
+	log := func(verb string, s Set) {
+		logger.Sugar().With(
+			"zone", zone.Name,
+			"full_hostname", s.Name,
+			"record_type", s.Type,
+			"values", s.Values,
+			"ttl", s.TTL,
+		).Infof("%s %s record %q", verb, s.Type, s.Name)
+	}
+	for _, s := range changes.Delete {
+		log("removing", s)
+	}
+	for _, s := range changes.Create {
+		log("adding", s)
+	}
+	for _, u := range changes.Update {
+		log("updating", u.New)
+	}
+}
@@ -1,7 +1,9 @@
 package sshconnection
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"strings"
 
 	"golang.org/x/crypto/ssh"
@@ -47,3 +49,23 @@ func (c *SSHConnection) Output(cmd string) ([]byte, error) {
 	defer session.Close()
 	return session.Output(cmd)
 }
+
+// OutputWithInput runs cmd with input as its standard input and returns its
+// standard output. The session is closed if ctx is done before cmd exits.
+func (c *SSHConnection) OutputWithInput(ctx context.Context, cmd string, input io.Reader) ([]byte, error) {
+	session, err := c.Client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("sshconnection: could not start new session to host %s: %w", c.host, err)
+	}
+	defer session.Close()
+	stop := context.AfterFunc(ctx, func() {
+		session.Close()
+	})
+	defer stop()
+	session.Stdin = input
+	output, err := session.Output(cmd)
+	if ctx.Err() != nil {
+		return output, ctx.Err()
+	}
+	return output, err
+}
@@ -7,6 +7,9 @@ import (
 
 // DNSSafeName converts an externally-supplied hostname into one that is safe to
 // be used in a DNS record.
+//
+// Deprecated: DNSSafeName only replaces whitespace. Use [dnsname.Normalizer],
+// which produces RFC 1123 names.
 func DNSSafeName(name string) string {
 	re := regexp.MustCompile(`\s+`)
 	return strings.Trim(re.ReplaceAllString(name, "-"), ".")
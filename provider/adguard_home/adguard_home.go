@@ -0,0 +1,172 @@
+package adguardhome
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/config/configtypes"
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
+	"github.com/sapslaj/zonepop/pkg/localdns"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/utils"
+	"github.com/sapslaj/zonepop/provider"
+)
+
+type AdGuardHomeProviderConfig struct {
+	// Base URL of the web interface, e.g. http://192.168.1.2:3000
+	URL      string
+	Username string
+	Password string
+	// Appended to hostnames
+	RecordSuffix string
+	// Remove rewrites that aren't desired of all names ending with the record
+	// suffix, not just of the hostnames of the endpoints. Wildcard rewrites
+	// are never removed.
+	Clean bool
+	// How hostnames are normalized into valid DNS names
+	Normalization dnsname.Policy
+}
+
+type adGuardHomeProvider struct {
+	config              AdGuardHomeProviderConfig
+	forwardLookupFilter configtypes.EndpointFilterFunc
+	logger              *zap.Logger
+	normalizer          *dnsname.Normalizer
+	httpClient          *http.Client
+}
+
+// rewrite is a DNS rewrite rule, answering queries for the domain with the
+// address or, as a CNAME, the domain name in the answer.
+type rewrite struct {
+	Domain string `json:"domain"`
+	Answer string `json:"answer"`
+}
+
+func NewAdGuardHomeProvider(
+	name string,
+	providerConfig AdGuardHomeProviderConfig,
+	forwardLookupFilter configtypes.EndpointFilterFunc,
+) (provider.Provider, error) {
+	if providerConfig.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	if providerConfig.Clean && strings.Trim(providerConfig.RecordSuffix, ".") == "" {
+		return nil, errors.New("clean requires a record_suffix")
+	}
+	providerConfig.URL = strings.TrimSuffix(providerConfig.URL, "/")
+	normalizer, err := dnsname.NewNormalizer(name, providerConfig.Normalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure hostname normalization: %w", err)
+	}
+	return &adGuardHomeProvider{
+		config:              providerConfig,
+		forwardLookupFilter: forwardLookupFilter,
+		logger:              log.MustNewLogger().Named("adguard_home_provider"),
+		normalizer:          normalizer,
+		httpClient:          http.DefaultClient,
+	}, nil
+}
+
+func (p *adGuardHomeProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) error {
+	var rewrites []rewrite
+	err := p.do(ctx, http.MethodGet, "/control/rewrite/list", nil, &rewrites)
+	if err != nil {
+		p.logger.Sugar().Errorw("could not list DNS rewrites", "err", err)
+		return fmt.Errorf("could not list DNS rewrites: %w", err)
+	}
+	existing := make([]localdns.Entry, 0, len(rewrites))
+	// rewrites are only deleted when they match exactly, so the domains are
+	// kept as AdGuard Home stored them
+	stored := map[localdns.Entry][]rewrite{}
+	for _, r := range rewrites {
+		e := localdns.Entry{Name: localdns.Canonical(r.Domain), Value: r.Answer}
+		existing = append(existing, e)
+		stored[e] = append(stored[e], r)
+	}
+
+	desired, managed := localdns.Desired(utils.Filter(p.forwardLookupFilter, endpoints), localdns.Config{
+		RecordSuffix: p.config.RecordSuffix,
+		Normalizer:   p.normalizer,
+		Logger:       p.logger,
+		Clean:        p.config.Clean,
+	})
+	if p.config.Clean {
+		p.logger.Info("cleanup: cleaning DNS rewrites")
+	}
+	remove, add := localdns.Plan(desired, existing, managed)
+	if len(remove) == 0 && len(add) == 0 {
+		p.logger.Debug("no changes for DNS rewrites")
+		return nil
+	}
+	localdns.LogChanges(p.logger, remove, add)
+
+	var errs error
+	for _, e := range remove {
+		for _, r := range stored[e] {
+			err := p.do(ctx, http.MethodPost, "/control/rewrite/delete", r, nil)
+			if err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("could not remove rewrite of %q to %q: %w", r.Domain, r.Answer, err))
+			}
+		}
+	}
+	for _, e := range add {
+		err := p.do(ctx, http.MethodPost, "/control/rewrite/add", rewrite{Domain: e.Name, Answer: e.Value}, nil)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("could not add rewrite of %q to %q: %w", e.Name, e.Value, err))
+		}
+	}
+	if errs != nil {
+		p.logger.Sugar().Errorw("failed to update some DNS rewrites", "err", errs)
+	}
+	return errs
+}
+
+// do sends a request to the API and decodes the response into result if it's
+// not nil. Errors are reported as plain text.
+func (p *adGuardHomeProvider) do(ctx context.Context, method string, path string, body any, result any) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.config.URL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	if p.config.Username != "" || p.config.Password != "" {
+		req.SetBasicAuth(p.config.Username, p.config.Password)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send %s %s request: %w", method, path, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s %s failed with status %d: %s", method, path, res.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if result == nil {
+		return nil
+	}
+	err = json.NewDecoder(res.Body).Decode(result)
+	if err != nil {
+		return fmt.Errorf("could not decode %s %s response: %w", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package adguardhome
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+// fakeAdGuardHome is an in-memory stand-in for the DNS rewrite endpoints of
+// the AdGuard Home API.
+type fakeAdGuardHome struct {
+	t        *testing.T
+	mu       sync.Mutex
+	rewrites []rewrite
+	changes  []string
+	// rejects added rewrites with the error if set
+	addError string
+}
+
+func newFakeAdGuardHome(t *testing.T, rewrites []rewrite) (*fakeAdGuardHome, *httptest.Server) {
+	api := &fakeAdGuardHome{t: t, rewrites: rewrites}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	return api, server
+}
+
+func (api *fakeAdGuardHome) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	username, password, ok := r.BasicAuth()
+	if !ok || username != "admin" || password != "test-password" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	switch {
+	case r.URL.Path == "/control/rewrite/list" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(api.t, json.NewEncoder(w).Encode(api.rewrites))
+	case r.URL.Path == "/control/rewrite/add" && r.Method == http.MethodPost:
+		var req rewrite
+		require.NoError(api.t, json.NewDecoder(r.Body).Decode(&req))
+		if api.addError != "" {
+			http.Error(w, api.addError, http.StatusBadRequest)
+			return
+		}
+		if slices.Contains(api.rewrites, req) {
+			http.Error(w, "rewrite already exists", http.StatusBadRequest)
+			return
+		}
+		api.rewrites = append(api.rewrites, req)
+		api.changes = append(api.changes, fmt.Sprintf("add %s %s", req.Domain, req.Answer))
+	case r.URL.Path == "/control/rewrite/delete" && r.Method == http.MethodPost:
+		var req rewrite
+		require.NoError(api.t, json.NewDecoder(r.Body).Decode(&req))
+		i := slices.Index(api.rewrites, req)
+		if i < 0 {
+			http.Error(w, "rewrite not found", http.StatusBadRequest)
+			return
+		}
+		api.rewrites = slices.Delete(api.rewrites, i, i+1)
+		api.changes = append(api.changes, fmt.Sprintf("delete %s %s", req.Domain, req.Answer))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestProvider(t *testing.T, server *httptest.Server, config AdGuardHomeProviderConfig) *adGuardHomeProvider {
+	config.URL = server.URL
+	config.Username = "admin"
+	if config.Password == "" {
+		config.Password = "test-password"
+	}
+	p, err := NewAdGuardHomeProvider("test", config, func(e *endpoint.Endpoint) bool { return true })
+	require.NoError(t, err)
+	return p.(*adGuardHomeProvider)
+}
+
+func TestUpdateEndpoints(t *testing.T) {
+	t.Parallel()
+
+	rewrites := []rewrite{
+		{Domain: "Host1.LAN", Answer: "192.0.2.9"},
+		{Domain: "host1.lan", Answer: "2001:db8::1"},
+		{Domain: "nas.lan", Answer: "host1.lan"},
+		{Domain: "Manual.lan", Answer: "192.0.2.10"},
+		{Domain: "*.lan", Answer: "192.0.2.254"},
+		{Domain: "nas.example.com", Answer: "192.0.2.20"},
+	}
+
+	tests := map[string]struct {
+		config      AdGuardHomeProviderConfig
+		wantChanges []string
+	}{
+		"hostnames of endpoints": {
+			wantChanges: []string{
+				"delete Host1.LAN 192.0.2.9",
+				"add host1.lan 192.0.2.1",
+				"add host2.lan 192.0.2.2",
+				"add www.lan host2.lan",
+			},
+		},
+		"clean": {
+			config: AdGuardHomeProviderConfig{Clean: true},
+			wantChanges: []string{
+				"delete Host1.LAN 192.0.2.9",
+				"delete Manual.lan 192.0.2.10",
+				"add host1.lan 192.0.2.1",
+				"add host2.lan 192.0.2.2",
+				"add www.lan host2.lan",
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			api, server := newFakeAdGuardHome(t, slices.Clone(rewrites))
+			tc.config.RecordSuffix = ".lan"
+			p := newTestProvider(t, server, tc.config)
+			endpoints := []*endpoint.Endpoint{
+				{Hostname: "Host1", IPv4s: []string{"192.0.2.1"}, IPv6s: []string{"2001:db8::1"}, Aliases: []string{"nas"}},
+				{Hostname: "host2", IPv4s: []string{"192.0.2.2"}, Aliases: []string{"www"}},
+			}
+
+			err := p.UpdateEndpoints(context.Background(), endpoints)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantChanges, api.changes)
+
+			api.changes = nil
+			err = p.UpdateEndpoints(context.Background(), endpoints)
+			require.NoError(t, err)
+			assert.Empty(t, api.changes, "second sync should not change anything")
+		})
+	}
+}
+
+func TestUpdateEndpoints_Errors(t *testing.T) {
+	t.Parallel()
+
+	t.Run("wrong password", func(t *testing.T) {
+		t.Parallel()
+
+		_, server := newFakeAdGuardHome(t, nil)
+		p := newTestProvider(t, server, AdGuardHomeProviderConfig{Password: "wrong-password"})
+		err := p.UpdateEndpoints(context.Background(), nil)
+		assert.ErrorContains(t, err, "could not list DNS rewrites: GET /control/rewrite/list failed with status 403: Forbidden")
+	})
+
+	t.Run("failed change", func(t *testing.T) {
+		t.Parallel()
+
+		api, server := newFakeAdGuardHome(t, []rewrite{{Domain: "host1.lan", Answer: "192.0.2.9"}})
+		api.addError = "validating rewrite: disallowed answer"
+		p := newTestProvider(t, server, AdGuardHomeProviderConfig{RecordSuffix: ".lan"})
+		err := p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+			{Hostname: "host1", IPv4s: []string{"192.0.2.1"}},
+		})
+		assert.Equal(t, []string{"delete host1.lan 192.0.2.9"}, api.changes)
+		assert.ErrorContains(t, err, `could not add rewrite of "host1.lan" to "192.0.2.1": POST /control/rewrite/add failed with status 400: validating rewrite: disallowed answer`)
+	})
+}
+
+func TestNewAdGuardHomeProvider_Invalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewAdGuardHomeProvider("test", AdGuardHomeProviderConfig{}, nil)
+	assert.ErrorContains(t, err, "url is required")
+
+	_, err = NewAdGuardHomeProvider("test", AdGuardHomeProviderConfig{URL: "http://localhost", Clean: true}, nil)
+	assert.ErrorContains(t, err, "clean requires a record_suffix")
+}
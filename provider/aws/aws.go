@@ -2,21 +2,78 @@ package aws
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
-func defaultAWSConfig() (aws.Config, error) {
-	return config.LoadDefaultConfig(context.TODO())
+// Route53 is a global service, so any region works unless a custom endpoint
+// expects a specific one.
+const defaultRegion = "us-east-1"
+
+const defaultAssumeRoleSessionName = "zonepop"
+
+// newAWSConfig loads the AWS config with the region, profile and credentials
+// set in the provider config. Everything else comes from the environment,
+// shared config files or instance metadata as usual. stsOptFns are applied to
+// the STS client used to assume a role.
+func newAWSConfig(ctx context.Context, c Route53ProviderConfig, stsOptFns ...func(*sts.Options)) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithDefaultRegion(defaultRegion),
+	}
+	if c.Region != "" {
+		opts = append(opts, config.WithRegion(c.Region))
+	}
+	if c.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(c.Profile))
+	}
+	if c.AccessKeyID != "" || c.SecretAccessKey != "" {
+		if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+			return aws.Config{}, errors.New("access_key_id and secret_access_key have to be set together")
+		}
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(c.AccessKeyID, c.SecretAccessKey, c.SessionToken),
+		))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("could not load AWS config: %w", err)
+	}
+
+	if c.AssumeRoleARN != "" {
+		sessionName := c.AssumeRoleSessionName
+		if sessionName == "" {
+			sessionName = defaultAssumeRoleSessionName
+		}
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg, stsOptFns...), c.AssumeRoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = sessionName
+			if c.AssumeRoleExternalID != "" {
+				o.ExternalID = aws.String(c.AssumeRoleExternalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	} else if c.AssumeRoleExternalID != "" {
+		return aws.Config{}, errors.New("assume_role_external_id requires assume_role_arn")
+	}
+	return cfg, nil
 }
 
-func defaultR53Client() (*route53.Client, error) {
-	cfg, err := defaultAWSConfig()
+// newRoute53Client returns a Route53 client for the account, credentials and
+// endpoint set in the provider config.
+func newRoute53Client(ctx context.Context, c Route53ProviderConfig) (*route53.Client, error) {
+	cfg, err := newAWSConfig(ctx, c)
 	if err != nil {
-		return nil, fmt.Errorf("could not get default AWS config: %w", err)
+		return nil, err
 	}
-	return route53.NewFromConfig(cfg), nil
+	return route53.NewFromConfig(cfg, func(o *route53.Options) {
+		if c.EndpointURL != "" {
+			o.BaseEndpoint = aws.String(c.EndpointURL)
+		}
+	}), nil
 }
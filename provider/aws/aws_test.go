@@ -0,0 +1,143 @@
+package aws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testGetHostedZoneResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<GetHostedZoneResponse xmlns="https://route53.amazonaws.com/doc/2013-04-01/">
+  <HostedZone>
+    <Id>/hostedzone/Z2FDTNDATAQYW2</Id>
+    <Name>example.com.</Name>
+    <CallerReference>zonepop</CallerReference>
+    <Config><PrivateZone>true</PrivateZone></Config>
+    <ResourceRecordSetCount>2</ResourceRecordSetCount>
+  </HostedZone>
+</GetHostedZoneResponse>`
+
+const testAssumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAASSUMEDROLE</AccessKeyId>
+      <SecretAccessKey>assumed-secret</SecretAccessKey>
+      <SessionToken>assumed-token</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/zonepop/zonepop</Arn>
+      <AssumedRoleId>AROAEXAMPLE:zonepop</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+
+func TestNewRoute53Client_EndpointURL(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		assert.Equal(t, "/2013-04-01/hostedzone/Z2FDTNDATAQYW2", r.URL.Path)
+		w.Header().Set("Content-Type", "text/xml")
+		_, _ = w.Write([]byte(testGetHostedZoneResponse))
+	}))
+	defer server.Close()
+
+	client, err := newRoute53Client(context.Background(), Route53ProviderConfig{
+		Region:          "eu-central-1",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "secret",
+		EndpointURL:     server.URL,
+	})
+	require.NoError(t, err)
+
+	out, err := client.GetHostedZone(context.Background(), &route53.GetHostedZoneInput{
+		Id: aws.String("Z2FDTNDATAQYW2"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "example.com.", aws.ToString(out.HostedZone.Name))
+	assert.True(t, out.HostedZone.Config.PrivateZone)
+	assert.Contains(t, authorization, "Credential=AKIDEXAMPLE/")
+	assert.Contains(t, authorization, "/eu-central-1/route53/")
+}
+
+func TestNewAWSConfig_AssumeRole(t *testing.T) {
+	var form map[string][]string
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		form = r.PostForm
+		authorization = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "text/xml")
+		_, _ = w.Write([]byte(testAssumeRoleResponse))
+	}))
+	defer server.Close()
+
+	cfg, err := newAWSConfig(
+		context.Background(),
+		Route53ProviderConfig{
+			AccessKeyID:          "AKIDEXAMPLE",
+			SecretAccessKey:      "secret",
+			AssumeRoleARN:        "arn:aws:iam::123456789012:role/zonepop",
+			AssumeRoleExternalID: "external-id",
+		},
+		func(o *sts.Options) {
+			o.EndpointResolver = sts.EndpointResolverFromURL(server.URL)
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, defaultRegion, cfg.Region)
+
+	creds, err := cfg.Credentials.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ASIAASSUMEDROLE", creds.AccessKeyID)
+	assert.Equal(t, "assumed-token", creds.SessionToken)
+	assert.Equal(t, []string{"AssumeRole"}, form["Action"])
+	assert.Equal(t, []string{"arn:aws:iam::123456789012:role/zonepop"}, form["RoleArn"])
+	assert.Equal(t, []string{"external-id"}, form["ExternalId"])
+	assert.Equal(t, []string{"zonepop"}, form["RoleSessionName"])
+	assert.True(t, strings.Contains(authorization, "Credential=AKIDEXAMPLE/"))
+}
+
+func TestNewAWSConfig_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config Route53ProviderConfig
+		errMsg string
+	}{
+		"access key without secret": {
+			config: Route53ProviderConfig{AccessKeyID: "AKIDEXAMPLE"},
+			errMsg: "have to be set together",
+		},
+		"secret without access key": {
+			config: Route53ProviderConfig{SecretAccessKey: "secret"},
+			errMsg: "have to be set together",
+		},
+		"external ID without role": {
+			config: Route53ProviderConfig{
+				AccessKeyID:          "AKIDEXAMPLE",
+				SecretAccessKey:      "secret",
+				AssumeRoleExternalID: "external-id",
+			},
+			errMsg: "requires assume_role_arn",
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := newAWSConfig(context.Background(), tc.config)
+			assert.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package aws
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sapslaj/zonepop/pkg/metrics"
+)
+
+const MetricSubsystem = "aws_route53"
+
+var (
+	MetricCacheHits = metrics.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: MetricSubsystem,
+			Name:      "cache_hits",
+			Help:      "Number of times the cached record sets of a hosted zone were used",
+		},
+		[]string{"zone"},
+	)
+	MetricCacheMisses = metrics.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: MetricSubsystem,
+			Name:      "cache_misses",
+			Help:      "Number of times the record sets of a hosted zone were listed because they weren't cached, expired or had to be refreshed",
+		},
+		[]string{"zone"},
+	)
+)
@@ -3,7 +3,6 @@ package aws
 import (
 	"context"
 	"fmt"
-	"slices"
 	"strings"
 	"time"
 
@@ -14,23 +13,55 @@ import (
 
 	"github.com/sapslaj/zonepop/config/configtypes"
 	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
 	"github.com/sapslaj/zonepop/pkg/log"
-	"github.com/sapslaj/zonepop/pkg/rdns"
+	"github.com/sapslaj/zonepop/pkg/recordset"
 	"github.com/sapslaj/zonepop/pkg/utils"
 	"github.com/sapslaj/zonepop/provider"
 )
 
 type Route53ProviderConfig struct {
-	RecordSuffix         string
-	ForwardZoneID        string
-	ForwardZoneName      string
-	Ipv4ReverseZoneID    string
-	Ipv4ReverseZoneName  string
-	Ipv6ReverseZoneID    string
-	Ipv6ReverseZoneName  string
+	RecordSuffix        string
+	ForwardZoneID       string
+	ForwardZoneName     string
+	Ipv4ReverseZoneID   string
+	Ipv4ReverseZoneName string
+	Ipv6ReverseZoneID   string
+	Ipv6ReverseZoneName string
+	// Additional zones, records are routed to the most specific zone
+	ForwardZoneIDs     []string
+	Ipv4ReverseZoneIDs []string
+	Ipv6ReverseZoneIDs []string
+	// Use all hosted zones of the account in addition to the configured ones
+	DiscoverZones        bool
 	CleanForwardZone     bool
 	CleanIPv4ReverseZone bool
 	CleanIPv6ReverseZone bool
+	// AWS region, only needed for custom endpoints and STS (default us-east-1)
+	Region string
+	// Profile from the shared config and credentials files
+	Profile string
+	// Static credentials instead of the default credential chain
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Role to assume with the credentials, e.g. for a zone in another account
+	AssumeRoleARN         string
+	AssumeRoleExternalID  string
+	AssumeRoleSessionName string
+	// Route53 API endpoint, e.g. of a local Route53-compatible stand-in
+	EndpointURL string
+	// How hostnames are normalized into valid DNS names
+	Normalization dnsname.Policy
+	// How often to retry throttled requests (default 5, negative to disable)
+	MaxRetries int
+	// Wait until changes have propagated to all Route53 DNS servers
+	WaitForSync bool
+	// How long to wait for changes to propagate (default 300)
+	WaitForSyncTimeoutSeconds int
+	// How long to cache the record sets of zones (default 3600, negative to
+	// disable)
+	CacheTTLSeconds int
 }
 
 type Route53Client interface {
@@ -46,11 +77,24 @@ type Route53Client interface {
 		params *route53.ChangeResourceRecordSetsInput,
 		optFns ...func(*route53.Options),
 	) (*route53.ChangeResourceRecordSetsOutput, error)
+	// Implementation of [github.com/aws/aws-sdk-go-v2/service/route53.Client.ListResourceRecordSets]
 	ListResourceRecordSets(
 		ctx context.Context,
 		params *route53.ListResourceRecordSetsInput,
 		optFns ...func(*route53.Options),
 	) (*route53.ListResourceRecordSetsOutput, error)
+	// Implementation of [github.com/aws/aws-sdk-go-v2/service/route53.Client.ListHostedZones]
+	ListHostedZones(
+		ctx context.Context,
+		params *route53.ListHostedZonesInput,
+		optFns ...func(*route53.Options),
+	) (*route53.ListHostedZonesOutput, error)
+	// Implementation of [github.com/aws/aws-sdk-go-v2/service/route53.Client.GetChange]
+	GetChange(
+		ctx context.Context,
+		params *route53.GetChangeInput,
+		optFns ...func(*route53.Options),
+	) (*route53.GetChangeOutput, error)
 }
 
 type route53Provider struct {
@@ -59,8 +103,14 @@ type route53Provider struct {
 	reverseLookupFilter configtypes.EndpointFilterFunc
 	client              Route53Client
 	logger              *zap.Logger
-	cachedRecordSets    map[string][]types.ResourceRecordSet
-	cacheExpiry         map[string]time.Time
+	normalizer          *dnsname.Normalizer
+	cache               *recordSetCache
+	zoneNames           map[string]string
+	written             recordset.Written
+	// record sets listed during the current sync by zone ID, see Apply
+	listed map[string][]types.ResourceRecordSet
+	// replaces time.Sleep in tests
+	sleepFunc func(ctx context.Context, d time.Duration) error
 }
 
 func getRoute53ZoneName(ctx context.Context, client Route53Client, zoneID string) (string, error) {
@@ -74,13 +124,18 @@ func getRoute53ZoneName(ctx context.Context, client Route53Client, zoneID string
 }
 
 func NewRoute53Provider(
+	name string,
 	providerConfig Route53ProviderConfig,
 	forwardLookupFilter configtypes.EndpointFilterFunc,
 	reverseLookupFilter configtypes.EndpointFilterFunc,
 ) (provider.Provider, error) {
-	client, err := defaultR53Client()
+	client, err := newRoute53Client(context.Background(), providerConfig)
 	if err != nil {
-		return nil, fmt.Errorf("could not get default Route53 client: %w", err)
+		return nil, fmt.Errorf("could not get Route53 client: %w", err)
+	}
+	normalizer, err := dnsname.NewNormalizer(name, providerConfig.Normalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure hostname normalization: %w", err)
 	}
 	p := &route53Provider{
 		config:              providerConfig,
@@ -88,390 +143,53 @@ func NewRoute53Provider(
 		reverseLookupFilter: reverseLookupFilter,
 		client:              client,
 		logger:              log.MustNewLogger().Named("aws_route53_provider"),
-		cachedRecordSets:    map[string][]types.ResourceRecordSet{},
-		cacheExpiry:         map[string]time.Time{},
+		normalizer:          normalizer,
+		cache:               newRecordSetCache(providerConfig.CacheTTLSeconds),
+		zoneNames:           map[string]string{},
+		written:             recordset.Written{},
 	}
 	return p, nil
 }
 
 func (p *route53Provider) UpdateEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) error {
-	forwardEndpoints := utils.Filter(p.forwardLookupFilter, endpoints)
-	err := p.updateForward(ctx, forwardEndpoints)
+	zones, err := p.zones(ctx)
 	if err != nil {
-		p.logger.Sugar().Errorw("failed to update forward lookup zone", "err", err)
 		return err
 	}
-	reverseEndpoints := utils.Filter(p.reverseLookupFilter, endpoints)
-	err = p.updateIPv4Reverse(ctx, reverseEndpoints)
-	if err != nil {
-		p.logger.Sugar().Errorw("failed to update IPv4 reverse lookup zone", "err", err)
-		return err
-	}
-	err = p.updateIPv6Reverse(ctx, reverseEndpoints)
+	if p.config.RecordSuffix == "" && len(zones.Forward) > 0 {
+		p.config.RecordSuffix = "." + strings.TrimSuffix(zones.Forward[0].Name, ".")
+	}
+	p.listed = map[string][]types.ResourceRecordSet{}
+	err = recordset.Sync(
+		ctx,
+		p,
+		zones,
+		utils.Filter(p.forwardLookupFilter, endpoints),
+		utils.Filter(p.reverseLookupFilter, endpoints),
+		recordset.SyncConfig{
+			RecordSuffix:         p.config.RecordSuffix,
+			Normalizer:           p.normalizer,
+			Logger:               p.logger,
+			TXTValue:             recordset.QuotedTXT,
+			CleanForwardZone:     p.config.CleanForwardZone,
+			CleanIPv4ReverseZone: p.config.CleanIPv4ReverseZone,
+			CleanIPv6ReverseZone: p.config.CleanIPv6ReverseZone,
+			Written:              p.written,
+		},
+	)
 	if err != nil {
-		p.logger.Sugar().Errorw("failed to update IPv6 reverse lookup zone", "err", err)
-		return err
-	}
-	return nil
-}
-
-func (p *route53Provider) updateForward(ctx context.Context, endpoints []*endpoint.Endpoint) error {
-	if p.config.ForwardZoneID == "" {
-		p.logger.Warn("Forward lookup zone disabled")
-		return nil
-	}
-	if p.config.ForwardZoneID != "" && p.config.ForwardZoneName == "" {
-		forwardZoneName, err := getRoute53ZoneName(ctx, p.client, p.config.ForwardZoneID)
-		if err != nil {
-			p.logger.Sugar().Errorw("could not get Route53 zone name", "err", err)
-			return err
-		}
-		p.config.ForwardZoneName = forwardZoneName
-	}
-
-	if p.config.RecordSuffix == "" {
-		p.config.RecordSuffix = "." + p.config.ForwardZoneName
-	}
-
-	hostnameEndpoints := make(map[string][]*endpoint.Endpoint)
-	for _, endpoint := range endpoints {
-		if endpoint.Hostname == "" {
-			continue
-		}
-		hostnameEndpoints[endpoint.Hostname] = append(hostnameEndpoints[endpoint.Hostname], endpoint)
-	}
-
-	if p.config.CleanForwardZone {
-		p.logger.Info("cleanup: cleaning forward lookup zone")
-		p.cleanupZone(ctx, p.config.ForwardZoneID, []types.RRType{types.RRTypeA, types.RRTypeAaaa}, func(name string) bool {
-			for hostname := range hostnameEndpoints {
-				if hostname == name {
-					return true
-				}
-			}
-			return false
-		})
-	}
-
-	clearCache := false
-
-	changes := make([]types.Change, 0)
-	for hostname, endpoints := range hostnameEndpoints {
-		fullHostname := utils.DNSSafeName(hostname) + p.config.RecordSuffix
-		fullyQualifiedHostname := fullHostname
-		if !strings.HasSuffix(fullyQualifiedHostname, ".") {
-			fullyQualifiedHostname = fullyQualifiedHostname + "."
-		}
-
-		hostnameLogger := p.logger.Sugar().With(
-			"hostname", hostname,
-			"full_hostname", fullHostname,
-		)
-
-		ipv4 := make([]string, 0)
-		ipv6 := make([]string, 0)
-		if len(endpoints) == 0 {
-			hostnameLogger.Warnf("No endpoints for hostname %q", hostname)
-			continue
-		}
-		for _, endpoint := range endpoints {
-			// make sure its deduped otherwise Route53 will get angry with us
-			for _, newIpv4 := range endpoint.IPv4s {
-				if !slices.Contains(ipv4, newIpv4) {
-					ipv4 = append(ipv4, newIpv4)
-				}
-			}
-			for _, newIpv6 := range endpoint.IPv6s {
-				if !slices.Contains(ipv6, newIpv6) {
-					ipv6 = append(ipv6, newIpv6)
-				}
-			}
-		}
-		ttl := endpoints[0].RecordTTL
-		hostnameLogger = hostnameLogger.With("ttl", ttl)
-		if len(ipv4) > 0 {
-			existingRR := p.getCachedResourceRecord(ctx, p.config.ForwardZoneID, fullyQualifiedHostname, types.RRTypeA)
-			needsUpdate := false
-			if existingRR != nil {
-				for _, rr := range existingRR.ResourceRecords {
-					if !slices.Contains(ipv4, *rr.Value) {
-						needsUpdate = true
-						break
-					}
-				}
-			} else {
-				needsUpdate = true
-			}
-			if needsUpdate {
-				clearCache = true
-				hostnameLogger.With(
-					"ipv4", ipv4,
-					"record_type", "A",
-				).Infof("adding IPv4 (A) record %q for hostname %q", ipv4, hostname)
-				changes = append(changes, p.dnsChange(
-					fullHostname,
-					ipv4,
-					"A",
-					endpoints[0].RecordTTL,
-				))
-			} else {
-				hostnameLogger.With(
-					"ipv4", ipv4,
-					"record_type", "A",
-				).Infof("IPv4 (A) record %q for hostname %q does not need update", ipv4, hostname)
-			}
-		}
-		if len(ipv6) > 0 {
-			existingRR := p.getCachedResourceRecord(ctx, p.config.ForwardZoneID, fullyQualifiedHostname, types.RRTypeAaaa)
-			needsUpdate := false
-			if existingRR != nil {
-				for _, rr := range existingRR.ResourceRecords {
-					if !slices.Contains(ipv4, *rr.Value) {
-						needsUpdate = true
-						break
-					}
-				}
-			} else {
-				needsUpdate = true
-			}
-			if needsUpdate {
-				clearCache = true
-				hostnameLogger.With(
-					"ipv6", ipv6,
-					"record_type", "AAAA",
-				).Infof("adding IPv6 (AAAA) record %q for hostname %q", ipv6, hostname)
-				changes = append(changes, p.dnsChange(
-					fullHostname,
-					ipv6,
-					"AAAA",
-					endpoints[0].RecordTTL,
-				))
-			} else {
-				hostnameLogger.With(
-					"ipv6", ipv6,
-					"record_type", "AAAA",
-				).Infof("IPv6 (AAAA) record %q for hostname %q does not need update", ipv6, hostname)
-			}
-		}
-	}
-	if len(changes) == 0 {
-		p.logger.Info("No forward lookup changes.")
-		return nil
-	}
-
-	_, err := p.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
-		HostedZoneId: aws.String(p.config.ForwardZoneID),
-		ChangeBatch:  &types.ChangeBatch{Changes: changes},
-	})
-
-	if clearCache {
-		p.clearCache(ctx, p.config.ForwardZoneID)
-	}
-
-	return err
-}
-
-func (p *route53Provider) updateIPv4Reverse(ctx context.Context, endpoints []*endpoint.Endpoint) error {
-	if p.config.Ipv4ReverseZoneID == "" {
-		p.logger.Warn("IPv4 reverse lookup zone disabled")
-		return nil
-	}
-	if p.config.Ipv4ReverseZoneName == "" {
-		ipv4ReverseZoneName, err := getRoute53ZoneName(ctx, p.client, p.config.Ipv4ReverseZoneID)
-		if err != nil {
-			p.logger.Sugar().Errorw("could not get Route53 zone name", "err", err)
-			return err
-		}
-		p.config.Ipv4ReverseZoneName = ipv4ReverseZoneName
-	}
-
-	if p.config.CleanIPv4ReverseZone {
-		p.logger.Info("cleanup: cleaning IPv4 reverse lookup zone")
-		p.cleanupZone(ctx, p.config.Ipv4ReverseZoneID, []types.RRType{types.RRTypePtr}, func(name string) bool {
-			for _, endpoint := range endpoints {
-				for _, ipv4 := range endpoint.IPv4s {
-					ptr, err := rdns.ReverseAddr(ipv4)
-					if err != nil {
-						p.logger.Sugar().Errorw("cleanup: could not determine PTR record", "err", err)
-						break
-					}
-					if ptr == name {
-						return true
-					}
-				}
-			}
-			return false
-		})
-	}
-
-	ptrHostnames := map[string]string{}
-	changes := make([]types.Change, 0)
-	for _, endpoint := range endpoints {
-		hostname := endpoint.Hostname
-		for _, ipv4 := range endpoint.IPv4s {
-			addrLogger := p.logger.Sugar().With(
-				"addr", ipv4,
-				"zone", p.config.Ipv4ReverseZoneName,
-				"ttl", endpoint.RecordTTL,
-			)
-			if hostname == "" {
-				hostname = "ip-" + strings.ReplaceAll(ipv4, ".", "-")
-				addrLogger.Infof("No hostname defined for endpoint, using generated hostname of %s", hostname)
-			}
-			fullHostname := utils.DNSSafeName(hostname) + p.config.RecordSuffix
-			addrLogger = addrLogger.With(
-				"hostname", hostname,
-				"full_hostname", fullHostname,
-			)
-			fits, err := rdns.FitsInReverseZone(ipv4, p.config.Ipv4ReverseZoneName)
-			if err != nil {
-				addrLogger.Errorw(
-					"could not determine if address fits in reverse zone",
-					"err", err,
-				)
-				return err
-			}
-			if !fits {
-				addrLogger.Warnf("IPv4 %q does not fit in zone %q", ipv4, p.config.Ipv4ReverseZoneName)
-				continue
-			}
-			ptr, err := rdns.ReverseAddr(ipv4)
-			if err != nil {
-				addrLogger.Errorw("could not determine PTR record", "err", err)
-				return err
-			}
-			addrLogger = addrLogger.With("ptr", ptr)
-			if existingHostname, seen := ptrHostnames[ptr]; seen {
-				addrLogger.Warnf("already registered PTR %q with hostname %q", ptr, existingHostname)
-				continue
-			}
-			ptrHostnames[ptr] = fullHostname
-			changes = append(changes, p.dnsChange(
-				ptr,
-				[]string{fullHostname},
-				"PTR",
-				endpoint.RecordTTL,
-			))
-			addrLogger.Infof("adding IPv4 PTR record %q for hostname %q", ptr, hostname)
-		}
+		p.logger.Sugar().Errorw("failed to update Route53 zones", "err", err)
 	}
-	if len(changes) == 0 {
-		p.logger.Info("No reverse IPv4 changes.")
-		return nil
-	}
-	_, err := p.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
-		HostedZoneId: aws.String(p.config.Ipv4ReverseZoneID),
-		ChangeBatch:  &types.ChangeBatch{Changes: changes},
-	})
-
-	return err
-}
-
-func (p *route53Provider) updateIPv6Reverse(ctx context.Context, endpoints []*endpoint.Endpoint) error {
-	if p.config.Ipv6ReverseZoneID == "" {
-		p.logger.Warn("IPv6 reverse lookup zone disabled")
-		return nil
-	}
-	if p.config.Ipv6ReverseZoneName == "" {
-		ipv6ReverseZoneName, err := getRoute53ZoneName(ctx, p.client, p.config.Ipv6ReverseZoneID)
-		if err != nil {
-			p.logger.Sugar().Errorw("could not get Route53 zone name", "err", err)
-			return err
-		}
-		p.config.Ipv6ReverseZoneName = ipv6ReverseZoneName
-	}
-
-	if p.config.CleanIPv6ReverseZone {
-		p.logger.Info("cleanup: cleaning IPv6 reverse lookup zone")
-		p.cleanupZone(ctx, p.config.Ipv6ReverseZoneID, []types.RRType{types.RRTypePtr}, func(name string) bool {
-			for _, endpoint := range endpoints {
-				for _, ipv6 := range endpoint.IPv6s {
-					ptr, err := rdns.ReverseAddr(ipv6)
-					if err != nil {
-						p.logger.Sugar().Errorw("cleanup: could not determine PTR record", "err", err)
-						break
-					}
-					if ptr == name {
-						return true
-					}
-				}
-			}
-			return false
-		})
-	}
-
-	ptrHostnames := map[string]string{}
-	changes := make([]types.Change, 0)
-	for _, endpoint := range endpoints {
-		hostname := endpoint.Hostname
-		if hostname == "" {
-			if len(endpoint.IPv4s) == 0 {
-				p.logger.Warn("Cannot generate hostname for endpoint due to missing IPv4 address.")
-				return nil
-			}
-			hostname = "ip-" + strings.ReplaceAll(endpoint.IPv4s[0], ".", "-")
-			p.logger.Sugar().Infof("No hostname defined for endpoint, using generated hostname of %s", hostname)
-		}
-		fullHostname := utils.DNSSafeName(hostname) + p.config.RecordSuffix
-		for _, ipv6 := range endpoint.IPv6s {
-			addrLogger := p.logger.Sugar().With(
-				"addr", ipv6,
-				"zone", p.config.Ipv6ReverseZoneName,
-				"ttl", endpoint.RecordTTL,
-				"full_hostname", fullHostname,
-				"hostname", hostname,
-			)
-			fits, err := rdns.FitsInReverseZone(ipv6, p.config.Ipv6ReverseZoneName)
-			if err != nil {
-				addrLogger.Errorw(
-					"could not determine if address fits in reverse zone",
-					"err", err,
-				)
-				return err
-			}
-			if !fits {
-				addrLogger.Warnf("IPv6 %q does not fit in zone %q", ipv6, p.config.Ipv6ReverseZoneName)
-				continue
-			}
-			ptr, err := rdns.ReverseAddr(ipv6)
-			if err != nil {
-				addrLogger.Errorw("could not determine PTR record", "err", err)
-				return err
-			}
-			addrLogger = addrLogger.With("ptr", ptr)
-			if existingHostname, seen := ptrHostnames[ptr]; seen {
-				addrLogger.Warnf("already registered PTR %q with hostname %q", ptr, existingHostname)
-				continue
-			}
-			ptrHostnames[ptr] = fullHostname
-			changes = append(changes, p.dnsChange(
-				ptr,
-				[]string{fullHostname},
-				"PTR",
-				endpoint.RecordTTL,
-			))
-			addrLogger.Infof("adding IPv6 PTR record %q for hostname %q", ptr, hostname)
-		}
-	}
-	if len(changes) == 0 {
-		p.logger.Info("No reverse IPv6 changes.")
-		return nil
-	}
-	_, err := p.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
-		HostedZoneId: aws.String(p.config.Ipv6ReverseZoneID),
-		ChangeBatch:  &types.ChangeBatch{Changes: changes},
-	})
-
 	return err
 }
 
-func (p *route53Provider) dnsChange(name string, answers []string, recordType string, ttl int64) types.Change {
+func (p *route53Provider) dnsChange(action types.ChangeAction, name string, answers []string, recordType string, ttl int64) types.Change {
 	resourceRecords := make([]types.ResourceRecord, 0)
 	for _, address := range answers {
 		resourceRecords = append(resourceRecords, types.ResourceRecord{Value: aws.String(address)})
 	}
 	return types.Change{
-		Action: types.ChangeActionUpsert,
+		Action: action,
 		ResourceRecordSet: &types.ResourceRecordSet{
 			Name:            aws.String(name),
 			Type:            types.RRType(recordType),
@@ -481,126 +199,55 @@ func (p *route53Provider) dnsChange(name string, answers []string, recordType st
 	}
 }
 
-func (p *route53Provider) cleanupZone(ctx context.Context, zoneID string, cleanTypes []types.RRType, foundFunc func(string) bool) error {
-	isTruncated := true
-	nextRecordIdentifier := ""
-	var nextRecordType types.RRType
-
-	for isTruncated {
-		cleanChanges := make([]types.Change, 0)
-		input := &route53.ListResourceRecordSetsInput{
-			HostedZoneId: aws.String(zoneID),
-		}
-		if nextRecordIdentifier != "" {
-			input.StartRecordIdentifier = aws.String(nextRecordIdentifier)
-			input.StartRecordType = nextRecordType
-		}
-		listOutput, err := p.client.ListResourceRecordSets(ctx, input)
-		if err != nil {
-			p.logger.Sugar().Errorw("cleanup: failed to list resource records for hosted zone", "zone", zoneID, "err", err)
-			return err
-		}
-
-		isTruncated = listOutput.IsTruncated
-		if listOutput.NextRecordIdentifier != nil {
-			nextRecordIdentifier = *listOutput.NextRecordIdentifier
-			nextRecordType = listOutput.NextRecordType
-		}
-
-		for i, rr := range listOutput.ResourceRecordSets {
-			if !slices.Contains(cleanTypes, rr.Type) {
-				continue
-			}
-			if !foundFunc(*rr.Name) {
-				p.logger.Sugar().Infof("cleanup: removing record %s", *rr.Name)
-				cleanChanges = append(cleanChanges, types.Change{
-					Action:            types.ChangeActionDelete,
-					ResourceRecordSet: &listOutput.ResourceRecordSets[i],
-				})
-			}
-		}
-
-		if len(cleanChanges) == 0 {
-			p.logger.Info("cleanup: no changes needed")
-			return nil
-		}
-
-		_, err = p.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
-			HostedZoneId: aws.String(zoneID),
-			ChangeBatch:  &types.ChangeBatch{Changes: cleanChanges},
-		})
-		if err != nil {
-			p.logger.Sugar().Errorw("cleanup: failed to delete resource records for hosted zone", "err", err)
-			return err
-		}
-	}
-	return nil
-}
-
 func (p *route53Provider) listResourceRecordSets(ctx context.Context, zoneID string) ([]types.ResourceRecordSet, error) {
 	result := []types.ResourceRecordSet{}
 
-	var nextRecordName *string
+	input := &route53.ListResourceRecordSetsInput{
+		HostedZoneId: aws.String(zoneID),
+	}
 	for {
-		query, err := p.client.ListResourceRecordSets(ctx, &route53.ListResourceRecordSetsInput{
-			HostedZoneId:    aws.String(zoneID),
-			StartRecordName: nextRecordName,
-		})
+		query, err := p.client.ListResourceRecordSets(ctx, input)
 		if err != nil {
 			return result, err
 		}
 
-		nextRecordName = query.NextRecordName
 		result = append(result, query.ResourceRecordSets...)
 
-		if !query.IsTruncated || nextRecordName == nil {
+		if !query.IsTruncated || query.NextRecordName == nil {
 			break
 		}
+		input.StartRecordName = query.NextRecordName
+		input.StartRecordType = query.NextRecordType
+		input.StartRecordIdentifier = query.NextRecordIdentifier
 	}
 
 	return result, nil
 }
 
-func (p *route53Provider) cachedListResourceRecordSets(ctx context.Context, zoneID string) ([]types.ResourceRecordSet, error) {
-	if p.cachedRecordSets == nil {
-		p.cachedRecordSets = map[string][]types.ResourceRecordSet{}
+func (p *route53Provider) getCache() *recordSetCache {
+	if p.cache == nil {
+		p.cache = newRecordSetCache(p.config.CacheTTLSeconds)
 	}
+	return p.cache
+}
 
-	if p.cachedRecordSets[zoneID] != nil && time.Now().Before(p.cacheExpiry[zoneID]) {
-		return p.cachedRecordSets[zoneID], nil
+// recordSets returns the record sets of a zone from the cache, or lists them
+// if they aren't cached or refresh is set. It also reports whether they were
+// listed.
+func (p *route53Provider) recordSets(ctx context.Context, zoneID string, refresh bool) ([]types.ResourceRecordSet, bool, error) {
+	cache := p.getCache()
+	if !refresh {
+		if rrs, ok := cache.get(zoneID); ok {
+			return rrs, false, nil
+		}
+	} else {
+		MetricCacheMisses.WithLabelValues(zoneID).Inc()
 	}
 
 	rrs, err := p.listResourceRecordSets(ctx, zoneID)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-
-	p.cachedRecordSets[zoneID] = rrs
-	p.cacheExpiry[zoneID] = time.Now().Add(24 * time.Hour)
-
-	return rrs, nil
-}
-
-func (p *route53Provider) getCachedResourceRecord(ctx context.Context, zoneID string, name string, rrtype types.RRType) *types.ResourceRecordSet {
-	rrs, err := p.cachedListResourceRecordSets(ctx, zoneID)
-	if err != nil {
-		return nil
-	}
-
-	for _, rr := range rrs {
-		if *rr.Name != name {
-			continue
-		}
-		if rr.Type != rrtype {
-			continue
-		}
-		return &rr
-	}
-
-	return nil
-}
-
-func (p *route53Provider) clearCache(ctx context.Context, zoneID string) {
-	p.cachedRecordSets[zoneID] = nil
-	p.cacheExpiry[zoneID] = time.Time{}
+	cache.set(zoneID, rrs)
+	return rrs, true, nil
 }
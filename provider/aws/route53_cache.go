@@ -0,0 +1,99 @@
+package aws
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+
+	"github.com/sapslaj/zonepop/pkg/recordset"
+)
+
+const defaultCacheTTLSeconds = 3600
+
+type cachedZone struct {
+	recordSets []types.ResourceRecordSet
+	expiry     time.Time
+}
+
+// recordSetCache caches the record sets of hosted zones. Changes made by
+// ZonePop are applied to it so it doesn't have to be refreshed after each
+// sync.
+type recordSetCache struct {
+	ttl   time.Duration
+	zones map[string]*cachedZone
+	// replaces time.Now in tests
+	now func() time.Time
+}
+
+func newRecordSetCache(ttlSeconds int) *recordSetCache {
+	if ttlSeconds == 0 {
+		ttlSeconds = defaultCacheTTLSeconds
+	}
+	return &recordSetCache{
+		ttl:   time.Duration(ttlSeconds) * time.Second,
+		zones: map[string]*cachedZone{},
+		now:   time.Now,
+	}
+}
+
+// get returns the cached record sets of a zone unless they expired. A
+// negative TTL disables the cache.
+func (c *recordSetCache) get(zoneID string) ([]types.ResourceRecordSet, bool) {
+	zone, ok := c.zones[zoneID]
+	if !ok || c.ttl < 0 || !c.now().Before(zone.expiry) {
+		MetricCacheMisses.WithLabelValues(zoneID).Inc()
+		return nil, false
+	}
+	MetricCacheHits.WithLabelValues(zoneID).Inc()
+	return zone.recordSets, true
+}
+
+func (c *recordSetCache) set(zoneID string, recordSets []types.ResourceRecordSet) {
+	c.zones[zoneID] = &cachedZone{
+		recordSets: recordSets,
+		expiry:     c.now().Add(c.ttl),
+	}
+}
+
+func (c *recordSetCache) invalidate(zoneID string) {
+	delete(c.zones, zoneID)
+}
+
+// apply applies changes that were submitted successfully to the cached
+// record sets of a zone without changing when they expire.
+func (c *recordSetCache) apply(zoneID string, changes []types.Change) {
+	zone, ok := c.zones[zoneID]
+	if !ok {
+		return
+	}
+	recordSets := make([]types.ResourceRecordSet, 0, len(zone.recordSets)+len(changes))
+	recordSets = append(recordSets, zone.recordSets...)
+	for _, change := range changes {
+		if change.ResourceRecordSet == nil {
+			continue
+		}
+		key := cacheKey(*change.ResourceRecordSet)
+		i := -1
+		for j, rrs := range recordSets {
+			if rrs.SetIdentifier == nil && cacheKey(rrs) == key {
+				i = j
+				break
+			}
+		}
+		switch {
+		case change.Action == types.ChangeActionDelete && i >= 0:
+			recordSets = append(recordSets[:i], recordSets[i+1:]...)
+		case change.Action == types.ChangeActionDelete:
+		case i >= 0:
+			recordSets[i] = *change.ResourceRecordSet
+		default:
+			recordSets = append(recordSets, *change.ResourceRecordSet)
+		}
+	}
+	zone.recordSets = recordSets
+}
+
+func cacheKey(rrs types.ResourceRecordSet) recordset.Key {
+	return recordset.Key{Name: recordset.Canonical(aws.ToString(rrs.Name)), Type: string(rrs.Type)}
+}
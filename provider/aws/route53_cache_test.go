@@ -0,0 +1,183 @@
+package aws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/config/configtypes"
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+func TestRecordSetCache_Get(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rrs := []types.ResourceRecordSet{
+		newTestRecordSet("host.example.com.", types.RRTypeA, 60, "192.0.2.1"),
+	}
+
+	tests := map[string]struct {
+		ttlSeconds int
+		cached     bool
+		after      time.Duration
+		hit        bool
+	}{
+		"not cached": {
+			cached: false,
+			hit:    false,
+		},
+		"default TTL": {
+			cached: true,
+			after:  59 * time.Minute,
+			hit:    true,
+		},
+		"expired": {
+			cached: true,
+			after:  time.Hour,
+			hit:    false,
+		},
+		"custom TTL": {
+			ttlSeconds: 86400,
+			cached:     true,
+			after:      23 * time.Hour,
+			hit:        true,
+		},
+		"disabled": {
+			ttlSeconds: -1,
+			cached:     true,
+			hit:        false,
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			zoneID := "Z-get-" + name
+			c := newRecordSetCache(tc.ttlSeconds)
+			c.now = func() time.Time { return now }
+			if tc.cached {
+				c.set(zoneID, rrs)
+			}
+			c.now = func() time.Time { return now.Add(tc.after) }
+
+			got, hit := c.get(zoneID)
+			assert.Equal(t, tc.hit, hit)
+			if tc.hit {
+				assert.Equal(t, rrs, got)
+				assert.Equal(t, 1.0, testutil.ToFloat64(MetricCacheHits.WithLabelValues(zoneID)))
+			} else {
+				assert.Equal(t, 1.0, testutil.ToFloat64(MetricCacheMisses.WithLabelValues(zoneID)))
+			}
+		})
+	}
+}
+
+func TestRecordSetCache_Apply(t *testing.T) {
+	t.Parallel()
+
+	c := newRecordSetCache(0)
+	routed := newTestRecordSet("routed.example.com.", types.RRTypeA, 60, "192.0.2.9")
+	routed.SetIdentifier = aws.String("primary")
+	c.set("Z1", []types.ResourceRecordSet{
+		newTestRecordSet("changed.example.com.", types.RRTypeA, 60, "192.0.2.1"),
+		newTestRecordSet("removed.example.com.", types.RRTypeA, 60, "192.0.2.2"),
+		newTestRecordSet("kept.example.com.", types.RRTypeA, 60, "192.0.2.3"),
+		routed,
+	})
+	p := &route53Provider{}
+	removed := newTestRecordSet("removed.example.com.", types.RRTypeA, 60, "192.0.2.2")
+	c.apply("Z1", []types.Change{
+		{
+			Action:            types.ChangeActionDelete,
+			ResourceRecordSet: &removed,
+		},
+		p.dnsChange(types.ChangeActionUpsert, "Changed.example.com", []string{"192.0.2.4"}, "A", 300),
+		p.dnsChange(types.ChangeActionCreate, "added.example.com", []string{"192.0.2.5"}, "A", 60),
+		p.dnsChange(types.ChangeActionUpsert, "routed.example.com", []string{"192.0.2.6"}, "A", 60),
+	})
+	// not cached
+	c.apply("Z2", []types.Change{
+		p.dnsChange(types.ChangeActionCreate, "added.example.com", []string{"192.0.2.5"}, "A", 60),
+	})
+
+	got, ok := c.get("Z1")
+	require.True(t, ok)
+	summary := make([]string, 0)
+	for _, rrs := range got {
+		summary = append(summary, changeSummary([]types.Change{{Action: "", ResourceRecordSet: &rrs}})...)
+	}
+	assert.Equal(t, []string{
+		" A Changed.example.com 192.0.2.4",
+		" A kept.example.com. 192.0.2.3",
+		" A routed.example.com. 192.0.2.9",
+		" A added.example.com 192.0.2.5",
+		" A routed.example.com 192.0.2.6",
+	}, summary)
+	_, ok = c.get("Z2")
+	assert.False(t, ok)
+}
+
+func TestUpdateEndpoints_Cache(t *testing.T) {
+	zoneID := "Z-update-cache"
+	mockClient := &mockRoute53Client{
+		ResourceRecordSets: map[string][]types.ResourceRecordSet{
+			zoneID: {
+				newTestRecordSet("old-host.example.com.", types.RRTypeA, 60, "192.0.2.1"),
+			},
+		},
+	}
+	p, err := newMockNewRoute53Provider(
+		mockClient,
+		zap.NewNop(),
+		Route53ProviderConfig{
+			RecordSuffix:     ".example.com",
+			ForwardZoneID:    zoneID,
+			CleanForwardZone: true,
+		},
+		configtypes.DefaultEndpointFilterFunc,
+		configtypes.DefaultEndpointFilterFunc,
+	)
+	require.NoError(t, err)
+
+	endpoints := []*endpoint.Endpoint{
+		{Hostname: "host", IPv4s: []string{"192.0.2.2"}, RecordTTL: 60},
+	}
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	require.Len(t, mockClient.ChangeResourceRecordSetsCalls, 1)
+	assert.Equal(t, []string{
+		"DELETE A old-host.example.com. 192.0.2.1",
+		"CREATE A host.example.com. 192.0.2.2",
+	}, changeSummary(mockClient.ChangeResourceRecordSetsCalls[0].Input.ChangeBatch.Changes))
+	assert.Len(t, mockClient.ListResourceRecordSetsCalls, 1)
+
+	// the changes were applied to the cache, so nothing is listed or changed
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	assert.Len(t, mockClient.ChangeResourceRecordSetsCalls, 1)
+	assert.Len(t, mockClient.ListResourceRecordSetsCalls, 1)
+	assert.Equal(t, 1.0, testutil.ToFloat64(MetricCacheHits.WithLabelValues(zoneID)))
+
+	// the cache is refreshed before changes are submitted, so a record that
+	// was created by someone else isn't created again
+	mockClient.ResourceRecordSets[zoneID] = []types.ResourceRecordSet{
+		newTestRecordSet("host.example.com.", types.RRTypeA, 60, "192.0.2.2"),
+		newTestRecordSet("other-host.example.com.", types.RRTypeA, 60, "192.0.2.3"),
+	}
+	endpoints = append(endpoints, &endpoint.Endpoint{Hostname: "other-host", IPv4s: []string{"192.0.2.3"}, RecordTTL: 60})
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	assert.Len(t, mockClient.ChangeResourceRecordSetsCalls, 1)
+	assert.Len(t, mockClient.ListResourceRecordSetsCalls, 2)
+	assert.Equal(t, 2.0, testutil.ToFloat64(MetricCacheMisses.WithLabelValues(zoneID)))
+}
@@ -0,0 +1,206 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/aws/smithy-go"
+)
+
+// Limits of a single ChangeResourceRecordSets request. UPSERT changes count
+// twice towards both.
+// https://docs.aws.amazon.com/Route53/latest/DeveloperGuide/DNSLimitations.html#limits-api-requests-changeresourcerecordsets
+const (
+	maxChangeBatchRecords         = 1000
+	maxChangeBatchValueCharacters = 32000
+)
+
+const (
+	defaultMaxRetries                = 5
+	defaultWaitForSyncTimeoutSeconds = 300
+	retryBaseDelay                   = 500 * time.Millisecond
+	retryMaxDelay                    = 30 * time.Second
+	syncPollInterval                 = 5 * time.Second
+)
+
+// changeBatches splits changes into batches within the limits of a single
+// request, keeping their order.
+func changeBatches(changes []types.Change) [][]types.Change {
+	batches := make([][]types.Change, 0)
+	batch := make([]types.Change, 0)
+	batchRecords := 0
+	batchCharacters := 0
+	for _, change := range changes {
+		records, characters := changeSize(change)
+		if len(batch) > 0 && (batchRecords+records > maxChangeBatchRecords || batchCharacters+characters > maxChangeBatchValueCharacters) {
+			batches = append(batches, batch)
+			batch = make([]types.Change, 0)
+			batchRecords = 0
+			batchCharacters = 0
+		}
+		batch = append(batch, change)
+		batchRecords += records
+		batchCharacters += characters
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// changeSize returns the number of resource records and value characters a
+// change counts as.
+func changeSize(change types.Change) (int, int) {
+	records := 0
+	characters := 0
+	if change.ResourceRecordSet != nil {
+		records = len(change.ResourceRecordSet.ResourceRecords)
+		for _, rr := range change.ResourceRecordSet.ResourceRecords {
+			characters += len(aws.ToString(rr.Value))
+		}
+	}
+	if change.Action == types.ChangeActionUpsert {
+		return records * 2, characters * 2
+	}
+	return records, characters
+}
+
+// isRetryable reports whether err is Route53 asking to slow down.
+func isRetryable(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "Throttling", "ThrottlingException", "PriorRequestNotComplete":
+		return true
+	}
+	return false
+}
+
+// retryDelay returns the exponential backoff delay with jitter before the
+// given retry, starting at 0.
+func retryDelay(retry int) time.Duration {
+	delay := retryBaseDelay << retry
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// sleep waits for d or until ctx is done.
+func (p *route53Provider) sleep(ctx context.Context, d time.Duration) error {
+	if p.sleepFunc != nil {
+		return p.sleepFunc(ctx, d)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withoutSDKRetries disables the retries of the SDK for requests that
+// changeBatch and waitForSync retry themselves, so they don't multiply.
+func withoutSDKRetries(o *route53.Options) {
+	o.Retryer = aws.NopRetryer{}
+}
+
+// changeResourceRecordSets applies changes to a zone in batches within the
+// request limits, retrying throttled requests with exponential backoff. If
+// WaitForSync is set, it waits for every batch to be INSYNC.
+func (p *route53Provider) changeResourceRecordSets(ctx context.Context, zoneID string, changes []types.Change) error {
+	batches := changeBatches(changes)
+	changeIDs := make([]string, 0, len(batches))
+	for i, batch := range batches {
+		if len(batches) > 1 {
+			p.logger.Sugar().Infof("submitting change batch %d/%d with %d changes", i+1, len(batches), len(batch))
+		}
+		changeInfo, err := p.changeBatch(ctx, zoneID, batch)
+		if err != nil {
+			return err
+		}
+		if changeInfo != nil && changeInfo.Id != nil {
+			changeIDs = append(changeIDs, aws.ToString(changeInfo.Id))
+		}
+	}
+	if !p.config.WaitForSync {
+		return nil
+	}
+	timeout := p.config.WaitForSyncTimeoutSeconds
+	if timeout == 0 {
+		timeout = defaultWaitForSyncTimeoutSeconds
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	for _, changeID := range changeIDs {
+		err := p.waitForSync(ctx, changeID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *route53Provider) changeBatch(ctx context.Context, zoneID string, batch []types.Change) (*types.ChangeInfo, error) {
+	maxRetries := p.config.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultMaxRetries
+	}
+	for retry := 0; ; retry++ {
+		out, err := p.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+			HostedZoneId: aws.String(zoneID),
+			ChangeBatch:  &types.ChangeBatch{Changes: batch},
+		}, withoutSDKRetries)
+		if err == nil {
+			if out == nil {
+				return nil, nil
+			}
+			return out.ChangeInfo, nil
+		}
+		if !isRetryable(err) || retry >= maxRetries {
+			return nil, err
+		}
+		delay := retryDelay(retry)
+		p.logger.Sugar().Warnw(
+			"Route53 request throttled, retrying",
+			"zone", zoneID,
+			"retry", retry+1,
+			"delay", delay,
+			"err", err,
+		)
+		err = p.sleep(ctx, delay)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// waitForSync polls GetChange until the change is INSYNC.
+func (p *route53Provider) waitForSync(ctx context.Context, changeID string) error {
+	for {
+		out, err := p.client.GetChange(ctx, &route53.GetChangeInput{
+			Id: aws.String(changeID),
+		}, withoutSDKRetries)
+		if err != nil && !isRetryable(err) {
+			return fmt.Errorf("could not get status of change %s: %w", changeID, err)
+		}
+		if err == nil && out.ChangeInfo != nil && out.ChangeInfo.Status == types.ChangeStatusInsync {
+			p.logger.Sugar().Infof("change %s is in sync", changeID)
+			return nil
+		}
+		err = p.sleep(ctx, syncPollInterval)
+		if err != nil {
+			return fmt.Errorf("change %s is not in sync yet: %w", changeID, err)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/config/configtypes"
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+func newTestChange(action types.ChangeAction, values ...string) types.Change {
+	rrs := make([]types.ResourceRecord, 0, len(values))
+	for _, value := range values {
+		rrs = append(rrs, types.ResourceRecord{Value: aws.String(value)})
+	}
+	return types.Change{
+		Action: action,
+		ResourceRecordSet: &types.ResourceRecordSet{
+			Name:            aws.String("test-host.example.com"),
+			Type:            types.RRTypeTxt,
+			ResourceRecords: rrs,
+		},
+	}
+}
+
+func TestChangeBatches(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		changes []types.Change
+		expect  []int
+	}{
+		"empty": {
+			changes: []types.Change{},
+			expect:  []int{},
+		},
+		"single batch": {
+			changes: []types.Change{
+				newTestChange(types.ChangeActionUpsert, "192.0.2.1"),
+				newTestChange(types.ChangeActionDelete, "192.0.2.2"),
+			},
+			expect: []int{2},
+		},
+		"upserts count twice towards record limit": {
+			changes: func() []types.Change {
+				changes := make([]types.Change, 0)
+				for i := 0; i < 501; i++ {
+					changes = append(changes, newTestChange(types.ChangeActionUpsert, "192.0.2.1"))
+				}
+				return changes
+			}(),
+			expect: []int{500, 1},
+		},
+		"deletes count once towards record limit": {
+			changes: func() []types.Change {
+				changes := make([]types.Change, 0)
+				for i := 0; i < 1001; i++ {
+					changes = append(changes, newTestChange(types.ChangeActionDelete, "192.0.2.1"))
+				}
+				return changes
+			}(),
+			expect: []int{1000, 1},
+		},
+		"character limit": {
+			changes: []types.Change{
+				newTestChange(types.ChangeActionUpsert, strings.Repeat("a", 10000)),
+				newTestChange(types.ChangeActionUpsert, strings.Repeat("a", 10000)),
+				newTestChange(types.ChangeActionCreate, strings.Repeat("a", 10000)),
+			},
+			expect: []int{1, 2},
+		},
+		"oversized change goes alone": {
+			changes: []types.Change{
+				newTestChange(types.ChangeActionDelete, "192.0.2.1"),
+				newTestChange(types.ChangeActionUpsert, strings.Repeat("a", 20000)),
+				newTestChange(types.ChangeActionDelete, "192.0.2.1"),
+			},
+			expect: []int{1, 1, 1},
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := make([]int, 0)
+			for _, batch := range changeBatches(tc.changes) {
+				got = append(got, len(batch))
+			}
+			assert.Equal(t, tc.expect, got)
+		})
+	}
+}
+
+func newTestThrottledProvider(t *testing.T, client *mockRoute53Client, config Route53ProviderConfig) (*route53Provider, *[]time.Duration) {
+	p, err := newMockNewRoute53Provider(
+		client,
+		zap.NewNop(),
+		config,
+		configtypes.DefaultEndpointFilterFunc,
+		configtypes.DefaultEndpointFilterFunc,
+	)
+	require.NoError(t, err)
+	delays := []time.Duration{}
+	p.sleepFunc = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	return p, &delays
+}
+
+func TestChangeResourceRecordSets_Throttling(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"}
+	priorRequest := &types.PriorRequestNotComplete{Message: aws.String("prior request not complete")}
+
+	mockClient := &mockRoute53Client{
+		ChangeResourceRecordSetsErrors: []error{throttled, priorRequest},
+	}
+	p, delays := newTestThrottledProvider(t, mockClient, Route53ProviderConfig{
+		RecordSuffix:  ".example.com",
+		ForwardZoneID: "Z2FDTNDATAQYW2",
+	})
+
+	err := p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+		{Hostname: "test-host", IPv4s: []string{"192.0.2.1"}, RecordTTL: 60},
+	})
+	require.NoError(t, err)
+	assert.Len(t, mockClient.ChangeResourceRecordSetsCalls, 3)
+	for _, call := range mockClient.ChangeResourceRecordSetsCalls {
+		var options route53.Options
+		for _, fn := range call.OptFns {
+			fn(&options)
+		}
+		assert.Equal(t, aws.NopRetryer{}, options.Retryer, "the SDK should not retry on top")
+	}
+	require.Len(t, *delays, 2)
+	assert.Less(t, (*delays)[0], (*delays)[1]+retryBaseDelay/2, "backoff should grow")
+	for i, delay := range *delays {
+		assert.LessOrEqual(t, delay, retryBaseDelay<<i)
+		assert.GreaterOrEqual(t, delay, (retryBaseDelay<<i)/2)
+	}
+}
+
+func TestChangeResourceRecordSets_RetriesExhausted(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"}
+
+	mockClient := &mockRoute53Client{
+		ChangeResourceRecordSetsError: throttled,
+	}
+	p, delays := newTestThrottledProvider(t, mockClient, Route53ProviderConfig{
+		RecordSuffix:  ".example.com",
+		ForwardZoneID: "Z2FDTNDATAQYW2",
+		MaxRetries:    2,
+	})
+
+	err := p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+		{Hostname: "test-host", IPv4s: []string{"192.0.2.1"}, RecordTTL: 60},
+	})
+	assert.ErrorIs(t, err, throttled)
+	assert.Len(t, mockClient.ChangeResourceRecordSetsCalls, 3)
+	assert.Len(t, *delays, 2)
+}
+
+func TestChangeResourceRecordSets_OtherErrorsNotRetried(t *testing.T) {
+	mockClient := &mockRoute53Client{
+		ChangeResourceRecordSetsError: &types.InvalidChangeBatch{Message: aws.String("invalid")},
+	}
+	p, delays := newTestThrottledProvider(t, mockClient, Route53ProviderConfig{
+		RecordSuffix:  ".example.com",
+		ForwardZoneID: "Z2FDTNDATAQYW2",
+	})
+
+	err := p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+		{Hostname: "test-host", IPv4s: []string{"192.0.2.1"}, RecordTTL: 60},
+	})
+	var invalidChangeBatch *types.InvalidChangeBatch
+	assert.True(t, errors.As(err, &invalidChangeBatch))
+	assert.Len(t, mockClient.ChangeResourceRecordSetsCalls, 1)
+	assert.Empty(t, *delays)
+}
+
+func TestChangeResourceRecordSets_Batches(t *testing.T) {
+	mockClient := &mockRoute53Client{}
+	p, _ := newTestThrottledProvider(t, mockClient, Route53ProviderConfig{
+		RecordSuffix:  ".example.com",
+		ForwardZoneID: "Z2FDTNDATAQYW2",
+	})
+
+	endpoints := make([]*endpoint.Endpoint, 0)
+	for i := 0; i < 1200; i++ {
+		endpoints = append(endpoints, &endpoint.Endpoint{
+			Hostname:  fmt.Sprintf("host-%d", i),
+			IPv4s:     []string{fmt.Sprintf("192.0.%d.%d", i/256, i%256)},
+			RecordTTL: 60,
+		})
+	}
+	err := p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	require.Len(t, mockClient.ChangeResourceRecordSetsCalls, 2)
+	assert.Len(t, mockClient.ChangeResourceRecordSetsCalls[0].Input.ChangeBatch.Changes, 1000)
+	assert.Len(t, mockClient.ChangeResourceRecordSetsCalls[1].Input.ChangeBatch.Changes, 200)
+}
+
+func TestChangeResourceRecordSets_WaitForSync(t *testing.T) {
+	mockClient := &mockRoute53Client{
+		GetChangeStatuses: []types.ChangeStatus{types.ChangeStatusPending, types.ChangeStatusPending},
+	}
+	p, delays := newTestThrottledProvider(t, mockClient, Route53ProviderConfig{
+		RecordSuffix:  ".example.com",
+		ForwardZoneID: "Z2FDTNDATAQYW2",
+		WaitForSync:   true,
+	})
+
+	err := p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+		{Hostname: "test-host", IPv4s: []string{"192.0.2.1"}, RecordTTL: 60},
+	})
+	require.NoError(t, err)
+	require.Len(t, mockClient.GetChangeCalls, 3)
+	assert.Equal(t, "C1", aws.ToString(mockClient.GetChangeCalls[0].Id))
+	for _, optFns := range mockClient.GetChangeOptFns {
+		var options route53.Options
+		for _, fn := range optFns {
+			fn(&options)
+		}
+		assert.Equal(t, aws.NopRetryer{}, options.Retryer, "the SDK should not retry on top")
+	}
+	assert.Equal(t, []time.Duration{syncPollInterval, syncPollInterval}, *delays)
+}
+
+func TestChangeResourceRecordSets_WaitForSyncTimeout(t *testing.T) {
+	mockClient := &mockRoute53Client{
+		GetChangeStatuses: []types.ChangeStatus{types.ChangeStatusPending},
+	}
+	p, _ := newTestThrottledProvider(t, mockClient, Route53ProviderConfig{
+		RecordSuffix:  ".example.com",
+		ForwardZoneID: "Z2FDTNDATAQYW2",
+		WaitForSync:   true,
+	})
+	p.sleepFunc = func(ctx context.Context, d time.Duration) error {
+		return context.DeadlineExceeded
+	}
+
+	err := p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+		{Hostname: "test-host", IPv4s: []string{"192.0.2.1"}, RecordTTL: 60},
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "not in sync")
+}
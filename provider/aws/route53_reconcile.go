@@ -0,0 +1,126 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+
+	"github.com/sapslaj/zonepop/pkg/recordset"
+)
+
+// RecordSets implements [recordset.Client]. The record sets are read from the
+// cache if possible. Alias and routing policy record sets are left out, they
+// are never touched.
+func (p *route53Provider) RecordSets(ctx context.Context, zone recordset.Zone) ([]recordset.Set, error) {
+	existing, fresh, err := p.recordSets(ctx, zone.ID, false)
+	if err != nil {
+		return nil, err
+	}
+	if fresh {
+		if p.listed == nil {
+			p.listed = map[string][]types.ResourceRecordSet{}
+		}
+		p.listed[zone.ID] = existing
+	}
+	sets := make([]recordset.Set, 0, len(existing))
+	for _, rrs := range existing {
+		if isAliasOrRoutingPolicy(rrs) {
+			continue
+		}
+		sets = append(sets, toRecordSet(rrs))
+	}
+	return sets, nil
+}
+
+// Apply implements [recordset.Client]. If the record sets of the zone were
+// read from the cache, they are listed again first and the changes are
+// adjusted to them, in case someone else changed the zone in the meantime.
+// The changes are submitted in batches, see changeResourceRecordSets.
+func (p *route53Provider) Apply(ctx context.Context, zone recordset.Zone, changes recordset.Changes) error {
+	existing, ok := p.listed[zone.ID]
+	if !ok {
+		var err error
+		existing, _, err = p.recordSets(ctx, zone.ID, true)
+		if err != nil {
+			return fmt.Errorf("could not list record sets: %w", err)
+		}
+	}
+	route53Changes := p.route53Changes(changes, existing)
+	if len(route53Changes) == 0 {
+		return nil
+	}
+	err := p.changeResourceRecordSets(ctx, zone.ID, route53Changes)
+	if err != nil {
+		p.getCache().invalidate(zone.ID)
+		return err
+	}
+	p.getCache().apply(zone.ID, route53Changes)
+	return nil
+}
+
+// route53Changes returns changes as Route53 changes against the existing
+// record sets of a zone: record sets that are gone already aren't deleted,
+// ones that already have the new values aren't changed and alias and routing
+// policy record sets are never touched. Deletions come first so that
+// conflicting record sets are gone before new ones are created.
+func (p *route53Provider) route53Changes(changes recordset.Changes, existing []types.ResourceRecordSet) []types.Change {
+	current := map[recordset.Key]recordset.Set{}
+	routed := map[recordset.Key]bool{}
+	for _, rrs := range existing {
+		key := cacheKey(rrs)
+		if isAliasOrRoutingPolicy(rrs) {
+			routed[key] = true
+			continue
+		}
+		current[key] = toRecordSet(rrs)
+	}
+
+	result := make([]types.Change, 0, len(changes.Delete)+len(changes.Create)+len(changes.Update))
+	for _, s := range changes.Delete {
+		old, ok := current[s.Key()]
+		if !ok {
+			continue
+		}
+		// Route53 only deletes record sets with exactly their current values
+		result = append(result, p.dnsChange(types.ChangeActionDelete, old.Name, old.Values, old.Type, old.TTL))
+	}
+	sets := make([]recordset.Set, 0, len(changes.Create)+len(changes.Update))
+	sets = append(sets, changes.Create...)
+	for _, u := range changes.Update {
+		sets = append(sets, u.New)
+	}
+	for _, s := range sets {
+		key := s.Key()
+		if routed[key] {
+			p.logger.Sugar().Warnf("not changing %s record %q, it is an alias or uses a routing policy", key.Type, key.Name)
+			continue
+		}
+		old, ok := current[key]
+		switch {
+		case !ok:
+			result = append(result, p.dnsChange(types.ChangeActionCreate, s.Name, s.Values, s.Type, s.TTL))
+		case !old.Equal(s):
+			result = append(result, p.dnsChange(types.ChangeActionUpsert, s.Name, s.Values, s.Type, s.TTL))
+		}
+	}
+	return result
+}
+
+func isAliasOrRoutingPolicy(rrs types.ResourceRecordSet) bool {
+	return rrs.AliasTarget != nil || rrs.SetIdentifier != nil
+}
+
+func toRecordSet(rrs types.ResourceRecordSet) recordset.Set {
+	values := make([]string, 0, len(rrs.ResourceRecords))
+	for _, rr := range rrs.ResourceRecords {
+		values = append(values, aws.ToString(rr.Value))
+	}
+	return recordset.Set{
+		Name:   aws.ToString(rrs.Name),
+		Type:   string(rrs.Type),
+		TTL:    aws.ToInt64(rrs.TTL),
+		Values: values,
+	}
+}
@@ -0,0 +1,309 @@
+package aws
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/config/configtypes"
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/recordset"
+)
+
+func newTestRecordSet(name string, recordType types.RRType, ttl int64, values ...string) types.ResourceRecordSet {
+	rrs := make([]types.ResourceRecord, 0, len(values))
+	for _, value := range values {
+		rrs = append(rrs, types.ResourceRecord{Value: aws.String(value)})
+	}
+	return types.ResourceRecordSet{
+		Name:            aws.String(name),
+		Type:            recordType,
+		TTL:             aws.Int64(ttl),
+		ResourceRecords: rrs,
+	}
+}
+
+// changeSummary returns "ACTION TYPE name values..." for each change.
+func changeSummary(changes []types.Change) []string {
+	summary := make([]string, 0, len(changes))
+	for _, change := range changes {
+		s := string(change.Action) + " " + string(change.ResourceRecordSet.Type) + " " + aws.ToString(change.ResourceRecordSet.Name)
+		for _, rr := range change.ResourceRecordSet.ResourceRecords {
+			s += " " + aws.ToString(rr.Value)
+		}
+		summary = append(summary, s)
+	}
+	return summary
+}
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+
+	type desiredRecord struct {
+		name       string
+		recordType types.RRType
+		ttl        int64
+		values     []string
+	}
+
+	tests := map[string]struct {
+		desired      []desiredRecord
+		existing     []types.ResourceRecordSet
+		managedTypes []types.RRType
+		owned        []string
+		clean        bool
+		expect       []string
+	}{
+		"create missing record set": {
+			desired: []desiredRecord{
+				{"host.example.com", types.RRTypeA, 60, []string{"192.0.2.1"}},
+			},
+			managedTypes: []types.RRType{types.RRTypeA, types.RRTypeAaaa},
+			expect:       []string{"CREATE A host.example.com 192.0.2.1"},
+		},
+		"unchanged record set": {
+			desired: []desiredRecord{
+				{"host.example.com", types.RRTypeA, 60, []string{"192.0.2.1", "192.0.2.2"}},
+			},
+			existing: []types.ResourceRecordSet{
+				newTestRecordSet("host.example.com.", types.RRTypeA, 60, "192.0.2.2", "192.0.2.1"),
+			},
+			managedTypes: []types.RRType{types.RRTypeA, types.RRTypeAaaa},
+			expect:       []string{},
+		},
+		"unchanged PTR with different case and trailing dot": {
+			desired: []desiredRecord{
+				{"1.2.0.192.in-addr.arpa.", types.RRTypePtr, 60, []string{"host.example.com"}},
+			},
+			existing: []types.ResourceRecordSet{
+				newTestRecordSet("1.2.0.192.in-addr.arpa.", types.RRTypePtr, 60, "Host.example.com."),
+			},
+			managedTypes: []types.RRType{types.RRTypePtr},
+			expect:       []string{},
+		},
+		"added address": {
+			desired: []desiredRecord{
+				{"host.example.com", types.RRTypeA, 60, []string{"192.0.2.1", "192.0.2.2"}},
+			},
+			existing: []types.ResourceRecordSet{
+				newTestRecordSet("host.example.com.", types.RRTypeA, 60, "192.0.2.1"),
+			},
+			managedTypes: []types.RRType{types.RRTypeA, types.RRTypeAaaa},
+			expect:       []string{"UPSERT A host.example.com 192.0.2.1 192.0.2.2"},
+		},
+		"removed address": {
+			desired: []desiredRecord{
+				{"host.example.com", types.RRTypeAaaa, 60, []string{"2001:db8::1"}},
+			},
+			existing: []types.ResourceRecordSet{
+				newTestRecordSet("host.example.com.", types.RRTypeAaaa, 60, "2001:db8::1", "2001:db8::2"),
+			},
+			managedTypes: []types.RRType{types.RRTypeA, types.RRTypeAaaa},
+			expect:       []string{"UPSERT AAAA host.example.com 2001:db8::1"},
+		},
+		"TTL change": {
+			desired: []desiredRecord{
+				{"host.example.com", types.RRTypeA, 300, []string{"192.0.2.1"}},
+			},
+			existing: []types.ResourceRecordSet{
+				newTestRecordSet("host.example.com.", types.RRTypeA, 60, "192.0.2.1"),
+			},
+			managedTypes: []types.RRType{types.RRTypeA, types.RRTypeAaaa},
+			expect:       []string{"UPSERT A host.example.com 192.0.2.1"},
+		},
+		"host loses IPv6": {
+			desired: []desiredRecord{
+				{"host.example.com", types.RRTypeA, 60, []string{"192.0.2.1"}},
+			},
+			existing: []types.ResourceRecordSet{
+				newTestRecordSet("host.example.com.", types.RRTypeA, 60, "192.0.2.1"),
+				newTestRecordSet("host.example.com.", types.RRTypeAaaa, 60, "2001:db8::1"),
+				newTestRecordSet("host.example.com.", types.RRTypeTxt, 60, `"hello"`),
+			},
+			managedTypes: []types.RRType{types.RRTypeA, types.RRTypeAaaa},
+			owned:        []string{"host.example.com."},
+			expect:       []string{"DELETE AAAA host.example.com. 2001:db8::1"},
+		},
+		"records of names that aren't owned are kept": {
+			desired: []desiredRecord{
+				{"host.example.com", types.RRTypeA, 60, []string{"192.0.2.1"}},
+			},
+			existing: []types.ResourceRecordSet{
+				newTestRecordSet("host.example.com.", types.RRTypeA, 60, "192.0.2.1"),
+				newTestRecordSet("other.example.com.", types.RRTypeA, 60, "192.0.2.2"),
+			},
+			managedTypes: []types.RRType{types.RRTypeA, types.RRTypeAaaa},
+			owned:        []string{"host.example.com."},
+			expect:       []string{},
+		},
+		"clean removes all undesired record sets of managed types": {
+			desired: []desiredRecord{
+				{"2.2.0.192.in-addr.arpa.", types.RRTypePtr, 60, []string{"host.example.com"}},
+			},
+			existing: []types.ResourceRecordSet{
+				newTestRecordSet("0.192.in-addr.arpa.", types.RRTypeNs, 172800, "ns-01.awsdns-01.com."),
+				newTestRecordSet("1.2.0.192.in-addr.arpa.", types.RRTypePtr, 60, "old-host.example.com"),
+			},
+			managedTypes: []types.RRType{types.RRTypePtr},
+			clean:        true,
+			expect: []string{
+				"DELETE PTR 1.2.0.192.in-addr.arpa. old-host.example.com",
+				"CREATE PTR 2.2.0.192.in-addr.arpa. host.example.com",
+			},
+		},
+		"alias record sets are not touched": {
+			desired: []desiredRecord{
+				{"host.example.com", types.RRTypeA, 60, []string{"192.0.2.1"}},
+			},
+			existing: []types.ResourceRecordSet{
+				{
+					Name: aws.String("host.example.com."),
+					Type: types.RRTypeA,
+					AliasTarget: &types.AliasTarget{
+						DNSName:      aws.String("lb.example.net."),
+						HostedZoneId: aws.String("Z2FDTNDATAQYW2"),
+					},
+				},
+			},
+			managedTypes: []types.RRType{types.RRTypeA, types.RRTypeAaaa},
+			clean:        true,
+			expect:       []string{},
+		},
+		"deletes, creates and upserts in order": {
+			desired: []desiredRecord{
+				{"b.example.com", types.RRTypeA, 60, []string{"192.0.2.2"}},
+				{"a.example.com", types.RRTypeA, 60, []string{"192.0.2.1"}},
+				{"c.example.com", types.RRTypeA, 60, []string{"192.0.2.3"}},
+			},
+			existing: []types.ResourceRecordSet{
+				newTestRecordSet("c.example.com.", types.RRTypeA, 60, "192.0.2.4"),
+				newTestRecordSet("d.example.com.", types.RRTypeA, 60, "192.0.2.5"),
+			},
+			managedTypes: []types.RRType{types.RRTypeA, types.RRTypeAaaa},
+			clean:        true,
+			expect: []string{
+				"DELETE A d.example.com. 192.0.2.5",
+				"CREATE A a.example.com 192.0.2.1",
+				"CREATE A b.example.com 192.0.2.2",
+				"UPSERT A c.example.com 192.0.2.3",
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &route53Provider{logger: zap.NewNop()}
+			desired := recordset.Desired{}
+			for _, record := range tc.desired {
+				desired.Add(record.name, string(record.recordType), record.ttl, record.values...)
+			}
+			owned := func(key recordset.Key) bool {
+				if !slices.Contains(tc.managedTypes, types.RRType(key.Type)) {
+					return false
+				}
+				return tc.clean || slices.Contains(tc.owned, key.Name)
+			}
+			existing, err := (&route53Provider{
+				client: &mockRoute53Client{ResourceRecordSets: map[string][]types.ResourceRecordSet{"Z": tc.existing}},
+				cache:  newRecordSetCache(0),
+			}).RecordSets(context.Background(), recordset.Zone{ID: "Z"})
+			require.NoError(t, err)
+			changes := p.route53Changes(recordset.Plan(desired, existing, owned), tc.existing)
+			assert.Equal(t, tc.expect, changeSummary(changes))
+		})
+	}
+}
+
+func TestUpdateEndpoints_Reconcile(t *testing.T) {
+	mockClient := &mockRoute53Client{
+		ResourceRecordSets: map[string][]types.ResourceRecordSet{
+			"ex-forward": {
+				newTestRecordSet("test-host.example.com.", types.RRTypeA, 69, "192.0.2.1"),
+				newTestRecordSet("test-host.example.com.", types.RRTypeAaaa, 69, "2001:db8::1"),
+			},
+			"ex-ipv4-reverse": {
+				newTestRecordSet("1.2.0.192.in-addr.arpa.", types.RRTypePtr, 69, "test-host.example.com"),
+			},
+			"ex-ipv6-reverse": {
+				newTestRecordSet("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", types.RRTypePtr, 69, "test-host.example.com"),
+			},
+		},
+	}
+	p, err := newMockNewRoute53Provider(
+		mockClient,
+		zap.NewNop(),
+		Route53ProviderConfig{
+			RecordSuffix:      ".example.com",
+			ForwardZoneID:     "ex-forward",
+			Ipv4ReverseZoneID: "ex-ipv4-reverse",
+			Ipv6ReverseZoneID: "ex-ipv6-reverse",
+		},
+		configtypes.DefaultEndpointFilterFunc,
+		configtypes.DefaultEndpointFilterFunc,
+	)
+	require.NoError(t, err)
+
+	endpoints := []*endpoint.Endpoint{
+		{Hostname: "test-host", IPv4s: []string{"192.0.2.1"}, IPv6s: []string{"2001:db8::1"}, RecordTTL: 69},
+	}
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	assert.Empty(t, mockClient.ChangeResourceRecordSetsCalls)
+	assert.Len(t, mockClient.ListResourceRecordSetsCalls, 3)
+
+	// the record sets of all three zones are cached
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	assert.Empty(t, mockClient.ChangeResourceRecordSetsCalls)
+	assert.Len(t, mockClient.ListResourceRecordSetsCalls, 3)
+
+	endpoints[0].IPv6s = nil
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	require.Len(t, mockClient.ChangeResourceRecordSetsCalls, 1)
+	assert.Equal(t, []string{
+		"DELETE AAAA test-host.example.com. 2001:db8::1",
+	}, changeSummary(mockClient.ChangeResourceRecordSetsCalls[0].Input.ChangeBatch.Changes))
+}
+
+func TestRoute53Changes_Existing(t *testing.T) {
+	t.Parallel()
+
+	p := &route53Provider{logger: zap.NewNop()}
+	// planned with record sets that someone else changed in the meantime
+	changes := recordset.Changes{
+		Delete: []recordset.Set{
+			{Name: "gone.example.com.", Type: "A", TTL: 60, Values: []string{"192.0.2.1"}},
+			{Name: "changed.example.com.", Type: "A", TTL: 60, Values: []string{"192.0.2.2"}},
+		},
+		Create: []recordset.Set{
+			{Name: "created.example.com", Type: "A", TTL: 60, Values: []string{"192.0.2.3"}},
+			{Name: "conflict.example.com", Type: "A", TTL: 60, Values: []string{"192.0.2.4"}},
+		},
+		Update: []recordset.Update{
+			{
+				Old: recordset.Set{Name: "updated.example.com.", Type: "A", TTL: 60, Values: []string{"192.0.2.5"}},
+				New: recordset.Set{Name: "updated.example.com", Type: "A", TTL: 60, Values: []string{"192.0.2.6"}},
+			},
+		},
+	}
+	existing := []types.ResourceRecordSet{
+		newTestRecordSet("changed.example.com.", types.RRTypeA, 300, "192.0.2.7"),
+		newTestRecordSet("created.example.com.", types.RRTypeA, 60, "192.0.2.3"),
+		newTestRecordSet("conflict.example.com.", types.RRTypeA, 60, "192.0.2.8"),
+	}
+	assert.Equal(t, []string{
+		"DELETE A changed.example.com. 192.0.2.7",
+		"UPSERT A conflict.example.com 192.0.2.4",
+		"CREATE A updated.example.com 192.0.2.6",
+	}, changeSummary(p.route53Changes(changes, existing)))
+}
@@ -3,6 +3,7 @@ package aws
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 
 	"github.com/sapslaj/zonepop/config/configtypes"
 	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/recordset"
 	"github.com/sapslaj/zonepop/pkg/utils"
 )
 
@@ -32,6 +34,21 @@ type mockRoute53Client struct {
 	}
 	ChangeResourceRecordSetsOutput *route53.ChangeResourceRecordSetsOutput
 	ChangeResourceRecordSetsError  error
+	// returned by the first calls before ChangeResourceRecordSetsError
+	ChangeResourceRecordSetsErrors []error
+
+	GetChangeCalls  []*route53.GetChangeInput
+	GetChangeOptFns [][]func(*route53.Options)
+	// statuses returned by successive calls, INSYNC once exhausted
+	GetChangeStatuses []types.ChangeStatus
+
+	ListResourceRecordSetsCalls []*route53.ListResourceRecordSetsInput
+	// existing record sets by zone ID
+	ResourceRecordSets map[string][]types.ResourceRecordSet
+
+	ListHostedZonesCalls []*route53.ListHostedZonesInput
+	// returned one per page
+	HostedZones []types.HostedZone
 }
 
 func (m *mockRoute53Client) GetHostedZone(
@@ -143,11 +160,15 @@ func (m *mockRoute53Client) ChangeResourceRecordSets(
 		OptFns: optFns,
 	})
 	err := m.ChangeResourceRecordSetsError
+	if len(m.ChangeResourceRecordSetsErrors) > 0 {
+		err = m.ChangeResourceRecordSetsErrors[0]
+		m.ChangeResourceRecordSetsErrors = m.ChangeResourceRecordSetsErrors[1:]
+	}
 	out := m.ChangeResourceRecordSetsOutput
 	if out == nil {
 		out = &route53.ChangeResourceRecordSetsOutput{
 			ChangeInfo: &types.ChangeInfo{
-				Id:          aws.String(""),
+				Id:          aws.String(fmt.Sprintf("C%d", len(m.ChangeResourceRecordSetsCalls))),
 				Status:      "PENDING",
 				SubmittedAt: aws.Time(time.Now()),
 			},
@@ -156,18 +177,67 @@ func (m *mockRoute53Client) ChangeResourceRecordSets(
 	return out, err
 }
 
+func (m *mockRoute53Client) GetChange(
+	ctx context.Context,
+	params *route53.GetChangeInput,
+	optFns ...func(*route53.Options),
+) (*route53.GetChangeOutput, error) {
+	m.GetChangeCalls = append(m.GetChangeCalls, params)
+	m.GetChangeOptFns = append(m.GetChangeOptFns, optFns)
+	status := types.ChangeStatusInsync
+	if len(m.GetChangeStatuses) > 0 {
+		status = m.GetChangeStatuses[0]
+		m.GetChangeStatuses = m.GetChangeStatuses[1:]
+	}
+	return &route53.GetChangeOutput{
+		ChangeInfo: &types.ChangeInfo{
+			Id:     params.Id,
+			Status: status,
+		},
+	}, nil
+}
+
 func (m *mockRoute53Client) ListResourceRecordSets(
 	ctx context.Context,
 	params *route53.ListResourceRecordSetsInput,
 	optFns ...func(*route53.Options),
 ) (*route53.ListResourceRecordSetsOutput, error) {
+	m.ListResourceRecordSetsCalls = append(m.ListResourceRecordSetsCalls, params)
+	rrs := m.ResourceRecordSets[aws.ToString(params.HostedZoneId)]
+	if rrs == nil {
+		rrs = []types.ResourceRecordSet{}
+	}
 	return &route53.ListResourceRecordSetsOutput{
 		IsTruncated:        false,
-		MaxItems:           aws.Int32(0),
-		ResourceRecordSets: []types.ResourceRecordSet{},
+		MaxItems:           aws.Int32(int32(len(rrs))),
+		ResourceRecordSets: rrs,
 	}, nil
 }
 
+func (m *mockRoute53Client) ListHostedZones(
+	ctx context.Context,
+	params *route53.ListHostedZonesInput,
+	optFns ...func(*route53.Options),
+) (*route53.ListHostedZonesOutput, error) {
+	m.ListHostedZonesCalls = append(m.ListHostedZonesCalls, params)
+	i := 0
+	if params.Marker != nil {
+		fmt.Sscanf(aws.ToString(params.Marker), "%d", &i)
+	}
+	out := &route53.ListHostedZonesOutput{
+		HostedZones: []types.HostedZone{},
+		MaxItems:    aws.Int32(1),
+	}
+	if i < len(m.HostedZones) {
+		out.HostedZones = append(out.HostedZones, m.HostedZones[i])
+	}
+	if i+1 < len(m.HostedZones) {
+		out.IsTruncated = true
+		out.NextMarker = aws.String(fmt.Sprintf("%d", i+1))
+	}
+	return out, nil
+}
+
 func newMockNewRoute53Provider(
 	client Route53Client,
 	logger *zap.Logger,
@@ -181,8 +251,9 @@ func newMockNewRoute53Provider(
 		reverseLookupFilter: reverseLookupFilter,
 		client:              client,
 		logger:              logger,
-		cachedRecordSets:    map[string][]types.ResourceRecordSet{},
-		cacheExpiry:         map[string]time.Time{},
+		cache:               newRecordSetCache(providerConfig.CacheTTLSeconds),
+		zoneNames:           map[string]string{},
+		written:             recordset.Written{},
 	}, nil
 }
 
@@ -224,9 +295,9 @@ func TestUpdateEndpoints_Minimal(t *testing.T) {
 
 	assert.Len(t, changes, 1)
 	for _, change := range changes {
-		assert.Equal(t, types.ChangeActionUpsert, change.Action)
+		assert.Equal(t, types.ChangeActionCreate, change.Action)
 		assert.Equal(t, int64(69), aws.ToInt64(change.ResourceRecordSet.TTL))
-		assert.Equal(t, "test-host.example.com", aws.ToString(change.ResourceRecordSet.Name))
+		assert.Equal(t, "test-host.example.com.", aws.ToString(change.ResourceRecordSet.Name))
 		assert.Equal(t, types.RRTypeA, change.ResourceRecordSet.Type)
 		assert.Len(t, change.ResourceRecordSet.ResourceRecords, 1)
 		assert.Equal(t, "192.0.2.1", aws.ToString(change.ResourceRecordSet.ResourceRecords[0].Value))
@@ -272,21 +343,21 @@ func TestUpdateEndpoints_ForwardAndReverse(t *testing.T) {
 	for _, call := range mockClient.ChangeResourceRecordSetsCalls {
 		changes := call.Input.ChangeBatch.Changes
 		for _, change := range changes {
-			assert.Equal(t, types.ChangeActionUpsert, change.Action)
+			assert.Equal(t, types.ChangeActionCreate, change.Action)
 			assert.Equal(t, int64(69), aws.ToInt64(change.ResourceRecordSet.TTL))
 			assert.Len(t, change.ResourceRecordSet.ResourceRecords, 1)
 			switch change.ResourceRecordSet.Type {
 			case types.RRTypeA:
 				assert.Len(t, changes, 2)
-				assert.Equal(t, "test-host.example.com", aws.ToString(change.ResourceRecordSet.Name))
+				assert.Equal(t, "test-host.example.com.", aws.ToString(change.ResourceRecordSet.Name))
 				assert.Equal(t, "192.0.2.1", aws.ToString(change.ResourceRecordSet.ResourceRecords[0].Value))
 			case types.RRTypeAaaa:
 				assert.Len(t, changes, 2)
-				assert.Equal(t, "test-host.example.com", aws.ToString(change.ResourceRecordSet.Name))
+				assert.Equal(t, "test-host.example.com.", aws.ToString(change.ResourceRecordSet.Name))
 				assert.Equal(t, "2001:db8::1", aws.ToString(change.ResourceRecordSet.ResourceRecords[0].Value))
 			case types.RRTypePtr:
 				assert.Len(t, changes, 1)
-				assert.Equal(t, "test-host.example.com", aws.ToString(change.ResourceRecordSet.ResourceRecords[0].Value))
+				assert.Equal(t, "test-host.example.com.", aws.ToString(change.ResourceRecordSet.ResourceRecords[0].Value))
 				record := aws.ToString(change.ResourceRecordSet.Name)
 				if utils.All([]bool{
 					record != "1.2.0.192.in-addr.arpa.",
@@ -354,7 +425,7 @@ func TestUpdateEndpoints_Filtering(t *testing.T) {
 	for _, call := range mockClient.ChangeResourceRecordSetsCalls {
 		changes := call.Input.ChangeBatch.Changes
 		for _, change := range changes {
-			assert.Equal(t, types.ChangeActionUpsert, change.Action)
+			assert.Equal(t, types.ChangeActionCreate, change.Action)
 			assert.Equal(t, int64(69), aws.ToInt64(change.ResourceRecordSet.TTL))
 			assert.Len(t, change.ResourceRecordSet.ResourceRecords, 1)
 			switch change.ResourceRecordSet.Type {
@@ -362,10 +433,10 @@ func TestUpdateEndpoints_Filtering(t *testing.T) {
 				fallthrough
 			case types.RRTypeAaaa:
 				assert.Len(t, changes, 2)
-				assert.Equal(t, "only-forward.example.com", aws.ToString(change.ResourceRecordSet.Name))
+				assert.Equal(t, "only-forward.example.com.", aws.ToString(change.ResourceRecordSet.Name))
 			case types.RRTypePtr:
 				assert.Len(t, changes, 1)
-				assert.Equal(t, "only-reverse.example.com", aws.ToString(change.ResourceRecordSet.ResourceRecords[0].Value))
+				assert.Equal(t, "only-reverse.example.com.", aws.ToString(change.ResourceRecordSet.ResourceRecords[0].Value))
 			default:
 				t.Errorf("Unexpected ResourceRecordSet Type: expected A or AAAA, got %v", change.ResourceRecordSet.Type)
 			}
@@ -492,21 +563,21 @@ func TestUpdateEndpoints_NoHostname(t *testing.T) {
 	for _, call := range mockClient.ChangeResourceRecordSetsCalls {
 		changes := call.Input.ChangeBatch.Changes
 		for _, change := range changes {
-			assert.Equal(t, types.ChangeActionUpsert, change.Action)
+			assert.Equal(t, types.ChangeActionCreate, change.Action)
 			assert.Equal(t, int64(69), aws.ToInt64(change.ResourceRecordSet.TTL))
 			assert.Len(t, change.ResourceRecordSet.ResourceRecords, 1)
 			switch change.ResourceRecordSet.Type {
 			case types.RRTypeA:
 				assert.Len(t, changes, 2)
-				assert.Equal(t, "ip-192-0-2-1.example.com", aws.ToString(change.ResourceRecordSet.Name))
+				assert.Equal(t, "ip-192-0-2-1.example.com.", aws.ToString(change.ResourceRecordSet.Name))
 				assert.Equal(t, "192.0.2.1", aws.ToString(change.ResourceRecordSet.ResourceRecords[0].Value))
 			case types.RRTypeAaaa:
 				assert.Len(t, changes, 2)
-				assert.Equal(t, "ip-192-0-2-1.example.com", aws.ToString(change.ResourceRecordSet.Name))
+				assert.Equal(t, "ip-192-0-2-1.example.com.", aws.ToString(change.ResourceRecordSet.Name))
 				assert.Equal(t, "2001:db8::1", aws.ToString(change.ResourceRecordSet.ResourceRecords[0].Value))
 			case types.RRTypePtr:
 				assert.Len(t, changes, 1)
-				assert.Equal(t, "ip-192-0-2-1.example.com", aws.ToString(change.ResourceRecordSet.ResourceRecords[0].Value))
+				assert.Equal(t, "ip-192-0-2-1.example.com.", aws.ToString(change.ResourceRecordSet.ResourceRecords[0].Value))
 				record := aws.ToString(change.ResourceRecordSet.Name)
 				if utils.All([]bool{
 					record != "1.2.0.192.in-addr.arpa.",
@@ -635,9 +706,9 @@ func TestUpdateEndpoints_Deduplicate(t *testing.T) {
 
 	assert.Len(t, changes, 2)
 	for _, change := range changes {
-		assert.Equal(t, types.ChangeActionUpsert, change.Action)
+		assert.Equal(t, types.ChangeActionCreate, change.Action)
 		assert.Equal(t, int64(69), aws.ToInt64(change.ResourceRecordSet.TTL))
-		assert.Equal(t, "test-host.example.com", aws.ToString(change.ResourceRecordSet.Name))
+		assert.Equal(t, "test-host.example.com.", aws.ToString(change.ResourceRecordSet.Name))
 		assert.Len(t, change.ResourceRecordSet.ResourceRecords, 1)
 		switch change.ResourceRecordSet.Type {
 		case types.RRTypeA:
@@ -649,3 +720,192 @@ func TestUpdateEndpoints_Deduplicate(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdateEndpoints_Normalization(t *testing.T) {
+	mockClient := &mockRoute53Client{}
+	config := Route53ProviderConfig{
+		RecordSuffix:  ".example.com",
+		ForwardZoneID: "Z2FDTNDATAQYW2",
+	}
+	p, err := newMockNewRoute53Provider(
+		mockClient,
+		zap.NewExample(),
+		config,
+		configtypes.DefaultEndpointFilterFunc,
+		configtypes.DefaultEndpointFilterFunc,
+	)
+	require.NoErrorf(t, err, "something went wrong creating mock provider: %v", err)
+
+	endpoints := []*endpoint.Endpoint{
+		{
+			Hostname:  "Bob's iPhone",
+			IPv4s:     []string{"192.0.2.1"},
+			RecordTTL: 69,
+		},
+		{
+			Hostname:  "bobs-iphone",
+			IPv4s:     []string{"192.0.2.2"},
+			RecordTTL: 69,
+		},
+		{
+			Hostname:  "???",
+			IPv4s:     []string{"192.0.2.3"},
+			RecordTTL: 69,
+		},
+	}
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoErrorf(t, err, "error updating endpoints: %v", err)
+
+	require.Len(
+		t,
+		mockClient.ChangeResourceRecordSetsCalls,
+		1,
+		"mockRoute53Client.ChangeResourceRecordSets was never called",
+	)
+	changes := mockClient.ChangeResourceRecordSetsCalls[0].Input.ChangeBatch.Changes
+
+	require.Len(t, changes, 1)
+	assert.Equal(t, "bobs-iphone.example.com.", aws.ToString(changes[0].ResourceRecordSet.Name))
+	assert.Len(t, changes[0].ResourceRecordSet.ResourceRecords, 2)
+}
+
+func TestUpdateEndpoints_Records(t *testing.T) {
+	mockClient := &mockRoute53Client{}
+	config := Route53ProviderConfig{
+		RecordSuffix:  ".example.com",
+		ForwardZoneID: "Z2FDTNDATAQYW2",
+	}
+	p, err := newMockNewRoute53Provider(
+		mockClient,
+		zap.NewExample(),
+		config,
+		configtypes.DefaultEndpointFilterFunc,
+		configtypes.DefaultEndpointFilterFunc,
+	)
+	require.NoErrorf(t, err, "something went wrong creating mock provider: %v", err)
+
+	endpoints := []*endpoint.Endpoint{
+		{
+			Hostname:  "synology-ds920",
+			IPv4s:     []string{"192.0.2.1"},
+			RecordTTL: 69,
+			Aliases:   []string{"nas"},
+			Records: []endpoint.Record{
+				{Type: "TXT", Value: `say "hi"`},
+				{Type: "MX", Value: "10 mail"},
+				{Type: "MX", Value: "20 backup-mail"},
+				{Type: "SRV", Name: "_smb._tcp", Value: "0 0 445 synology-ds920", TTL: 300},
+			},
+		},
+	}
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoErrorf(t, err, "error updating endpoints: %v", err)
+
+	require.Len(t, mockClient.ChangeResourceRecordSetsCalls, 1)
+	changes := mockClient.ChangeResourceRecordSetsCalls[0].Input.ChangeBatch.Changes
+
+	got := map[string][]string{}
+	ttls := map[string]int64{}
+	for _, change := range changes {
+		key := string(change.ResourceRecordSet.Type) + " " + aws.ToString(change.ResourceRecordSet.Name)
+		for _, rr := range change.ResourceRecordSet.ResourceRecords {
+			got[key] = append(got[key], aws.ToString(rr.Value))
+		}
+		ttls[key] = aws.ToInt64(change.ResourceRecordSet.TTL)
+	}
+	assert.Equal(t, map[string][]string{
+		"A synology-ds920.example.com.":   {"192.0.2.1"},
+		"CNAME nas.example.com.":          {"synology-ds920.example.com."},
+		"TXT synology-ds920.example.com.": {`"say \"hi\""`},
+		"MX synology-ds920.example.com.":  {"10 mail.example.com.", "20 backup-mail.example.com."},
+		"SRV _smb._tcp.example.com.":      {"0 0 445 synology-ds920.example.com."},
+	}, got)
+	assert.Equal(t, int64(300), ttls["SRV _smb._tcp.example.com."])
+	assert.Equal(t, int64(69), ttls["MX synology-ds920.example.com."])
+}
+
+func TestUpdateEndpoints_RecordConflicts(t *testing.T) {
+	mockClient := &mockRoute53Client{}
+	p, err := newMockNewRoute53Provider(
+		mockClient,
+		zap.NewNop(),
+		Route53ProviderConfig{
+			RecordSuffix:  ".example.com",
+			ForwardZoneID: "Z2FDTNDATAQYW2",
+		},
+		configtypes.DefaultEndpointFilterFunc,
+		configtypes.DefaultEndpointFilterFunc,
+	)
+	require.NoError(t, err)
+
+	endpoints := []*endpoint.Endpoint{
+		{Hostname: "nas", IPv4s: []string{"192.0.2.1"}, RecordTTL: 60},
+		{
+			Hostname:  "synology-ds920",
+			IPv4s:     []string{"192.0.2.2"},
+			RecordTTL: 60,
+			Aliases:   []string{"nas", "files"},
+			Records: []endpoint.Record{
+				{Type: "TXT", Name: "files", Value: "conflicts with the CNAME"},
+			},
+		},
+	}
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	require.Len(t, mockClient.ChangeResourceRecordSetsCalls, 1)
+	assert.Equal(t, []string{
+		"CREATE CNAME files.example.com. synology-ds920.example.com.",
+		"CREATE A nas.example.com. 192.0.2.1",
+		"CREATE A synology-ds920.example.com. 192.0.2.2",
+	}, changeSummary(mockClient.ChangeResourceRecordSetsCalls[0].Input.ChangeBatch.Changes))
+}
+
+func TestUpdateEndpoints_RemovesWrittenRecords(t *testing.T) {
+	mockClient := &mockRoute53Client{
+		ResourceRecordSets: map[string][]types.ResourceRecordSet{
+			"Z2FDTNDATAQYW2": {
+				newTestRecordSet("manual.example.com.", types.RRTypeTxt, 300, `"not managed by zonepop"`),
+			},
+		},
+	}
+	p, err := newMockNewRoute53Provider(
+		mockClient,
+		zap.NewNop(),
+		Route53ProviderConfig{
+			RecordSuffix:  ".example.com",
+			ForwardZoneID: "Z2FDTNDATAQYW2",
+		},
+		configtypes.DefaultEndpointFilterFunc,
+		configtypes.DefaultEndpointFilterFunc,
+	)
+	require.NoError(t, err)
+
+	endpoints := []*endpoint.Endpoint{
+		{
+			Hostname:  "synology-ds920",
+			IPv4s:     []string{"192.0.2.1"},
+			RecordTTL: 60,
+			Aliases:   []string{"nas"},
+			Records: []endpoint.Record{
+				{Type: "TXT", Value: "hi"},
+			},
+		},
+		{Hostname: "gone", IPv4s: []string{"192.0.2.2"}, RecordTTL: 60},
+	}
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	require.Len(t, mockClient.ChangeResourceRecordSetsCalls, 1)
+	// the cache has the changes applied, the mock doesn't
+	mockClient.ResourceRecordSets["Z2FDTNDATAQYW2"] = p.cache.zones["Z2FDTNDATAQYW2"].recordSets
+
+	endpoints[0].Aliases = nil
+	endpoints[0].Records = nil
+	err = p.UpdateEndpoints(context.Background(), endpoints[:1])
+	require.NoError(t, err)
+	require.Len(t, mockClient.ChangeResourceRecordSetsCalls, 2)
+	assert.Equal(t, []string{
+		"DELETE A gone.example.com. 192.0.2.2",
+		"DELETE CNAME nas.example.com. synology-ds920.example.com.",
+		"DELETE TXT synology-ds920.example.com. \"hi\"",
+	}, changeSummary(mockClient.ChangeResourceRecordSetsCalls[1].Input.ChangeBatch.Changes))
+}
@@ -0,0 +1,120 @@
+package aws
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+
+	"github.com/sapslaj/zonepop/pkg/recordset"
+)
+
+// zoneName returns the name of a zone, looking it up only once.
+func (p *route53Provider) zoneName(ctx context.Context, zoneID string) (string, error) {
+	if p.zoneNames == nil {
+		p.zoneNames = map[string]string{}
+	}
+	if name, ok := p.zoneNames[zoneID]; ok {
+		return name, nil
+	}
+	name, err := getRoute53ZoneName(ctx, p.client, zoneID)
+	if err != nil {
+		return "", err
+	}
+	p.zoneNames[zoneID] = recordset.Canonical(name)
+	return p.zoneNames[zoneID], nil
+}
+
+// zones returns the configured zones followed by the discovered ones.
+func (p *route53Provider) zones(ctx context.Context) (recordset.Zones, error) {
+	zones := recordset.Zones{}
+	seen := map[string]bool{}
+	configured := []struct {
+		ids  []string
+		name string
+	}{
+		{append(optionalZoneID(p.config.ForwardZoneID), p.config.ForwardZoneIDs...), p.config.ForwardZoneName},
+		{append(optionalZoneID(p.config.Ipv4ReverseZoneID), p.config.Ipv4ReverseZoneIDs...), p.config.Ipv4ReverseZoneName},
+		{append(optionalZoneID(p.config.Ipv6ReverseZoneID), p.config.Ipv6ReverseZoneIDs...), p.config.Ipv6ReverseZoneName},
+	}
+	for _, c := range configured {
+		for i, id := range c.ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			// the name can only be given for the single zone ID
+			if i == 0 && c.name != "" && p.zoneNames[id] == "" {
+				if p.zoneNames == nil {
+					p.zoneNames = map[string]string{}
+				}
+				p.zoneNames[id] = recordset.Canonical(c.name)
+			}
+			name, err := p.zoneName(ctx, id)
+			if err != nil {
+				p.logger.Sugar().Errorw("could not get Route53 zone name", "err", err)
+				return zones, err
+			}
+			if !zones.Add(recordset.Zone{ID: id, Name: name}) {
+				p.logger.Sugar().Warnf("ignoring hosted zone %s, another zone is already named %q", id, name)
+			}
+		}
+	}
+
+	if !p.config.DiscoverZones {
+		return zones, nil
+	}
+	discovered, err := p.discoverZones(ctx)
+	if err != nil {
+		p.logger.Sugar().Errorw("could not discover Route53 hosted zones", "err", err)
+		return zones, err
+	}
+	for _, zone := range discovered {
+		if seen[zone.ID] {
+			continue
+		}
+		seen[zone.ID] = true
+		if !zones.Add(zone) {
+			p.logger.Sugar().Warnf("ignoring hosted zone %s, another zone is already named %q", zone.ID, zone.Name)
+		}
+	}
+	return zones, nil
+}
+
+func optionalZoneID(zoneID string) []string {
+	if zoneID == "" {
+		return []string{}
+	}
+	return []string{zoneID}
+}
+
+// discoverZones lists all hosted zones of the account, sorted by name.
+func (p *route53Provider) discoverZones(ctx context.Context) ([]recordset.Zone, error) {
+	if p.zoneNames == nil {
+		p.zoneNames = map[string]string{}
+	}
+	zones := make([]recordset.Zone, 0)
+	input := &route53.ListHostedZonesInput{}
+	for {
+		out, err := p.client.ListHostedZones(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, zone := range out.HostedZones {
+			id := strings.TrimPrefix(aws.ToString(zone.Id), "/hostedzone/")
+			name := recordset.Canonical(aws.ToString(zone.Name))
+			p.zoneNames[id] = name
+			zones = append(zones, recordset.Zone{ID: id, Name: name})
+		}
+		if !out.IsTruncated || out.NextMarker == nil {
+			break
+		}
+		input.Marker = out.NextMarker
+	}
+	sort.SliceStable(zones, func(i, j int) bool {
+		return zones[i].Name < zones[j].Name
+	})
+	return zones, nil
+}
@@ -0,0 +1,154 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/config/configtypes"
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+func TestUpdateEndpoints_DiscoverZones(t *testing.T) {
+	mockClient := &mockRoute53Client{
+		HostedZones: []types.HostedZone{
+			{Id: aws.String("/hostedzone/Z0192"), Name: aws.String("0.192.in-addr.arpa.")},
+			{Id: aws.String("/hostedzone/ZLAB"), Name: aws.String("lab.example.com.")},
+			{Id: aws.String("/hostedzone/Z2"), Name: aws.String("2.0.192.in-addr.arpa.")},
+			{Id: aws.String("/hostedzone/ZFWD"), Name: aws.String("example.com.")},
+			{Id: aws.String("/hostedzone/ZIP6"), Name: aws.String("8.b.d.0.1.0.0.2.ip6.arpa.")},
+		},
+	}
+	p, err := newMockNewRoute53Provider(
+		mockClient,
+		zap.NewNop(),
+		Route53ProviderConfig{
+			RecordSuffix:  ".example.com",
+			DiscoverZones: true,
+		},
+		configtypes.DefaultEndpointFilterFunc,
+		configtypes.DefaultEndpointFilterFunc,
+	)
+	require.NoError(t, err)
+
+	err = p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+		{Hostname: "host", IPv4s: []string{"192.0.2.1"}, IPv6s: []string{"2001:db8::1"}, RecordTTL: 60},
+		{Hostname: "other.lab", IPv4s: []string{"192.0.3.1"}, RecordTTL: 60},
+	})
+	require.NoError(t, err)
+	assert.Len(t, mockClient.ListHostedZonesCalls, 5)
+	assert.Empty(t, mockClient.GetHostedZoneCalls)
+
+	got := map[string][]string{}
+	for _, call := range mockClient.ChangeResourceRecordSetsCalls {
+		zoneID := aws.ToString(call.Input.HostedZoneId)
+		got[zoneID] = append(got[zoneID], changeSummary(call.Input.ChangeBatch.Changes)...)
+	}
+	assert.Equal(t, map[string][]string{
+		"ZFWD": {
+			"CREATE A host.example.com. 192.0.2.1",
+			"CREATE AAAA host.example.com. 2001:db8::1",
+		},
+		"ZLAB": {
+			"CREATE A other.lab.example.com. 192.0.3.1",
+		},
+		"Z2": {
+			"CREATE PTR 1.2.0.192.in-addr.arpa. host.example.com.",
+		},
+		"Z0192": {
+			"CREATE PTR 1.3.0.192.in-addr.arpa. other.lab.example.com.",
+		},
+		"ZIP6": {
+			"CREATE PTR 1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. host.example.com.",
+		},
+	}, got)
+}
+
+func TestUpdateEndpoints_NestedZones(t *testing.T) {
+	expectedErr := errors.New("injected error")
+	mockClient := &mockRoute53Client{
+		HostedZones: []types.HostedZone{
+			{Id: aws.String("/hostedzone/ZFWD"), Name: aws.String("example.com.")},
+			{Id: aws.String("/hostedzone/ZLAB"), Name: aws.String("lab.example.com.")},
+		},
+		ResourceRecordSets: map[string][]types.ResourceRecordSet{
+			"ZFWD": {
+				newTestRecordSet("host.lab.example.com.", types.RRTypeA, 60, "192.0.2.9"),
+			},
+			"ZLAB": {
+				newTestRecordSet("old.lab.example.com.", types.RRTypeA, 60, "192.0.2.8"),
+			},
+		},
+		// the parent zone is updated first
+		ChangeResourceRecordSetsErrors: []error{expectedErr},
+	}
+	p, err := newMockNewRoute53Provider(
+		mockClient,
+		zap.NewNop(),
+		Route53ProviderConfig{
+			RecordSuffix:     ".example.com",
+			DiscoverZones:    true,
+			CleanForwardZone: true,
+		},
+		configtypes.DefaultEndpointFilterFunc,
+		configtypes.DefaultEndpointFilterFunc,
+	)
+	require.NoError(t, err)
+
+	err = p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+		{Hostname: "web", IPv4s: []string{"192.0.2.1"}, RecordTTL: 60},
+	})
+	assert.ErrorIs(t, err, expectedErr)
+
+	// the failing parent zone doesn't stop the child zone from being updated,
+	// and records of names in the child zone are only cleaned from it
+	got := map[string][]string{}
+	for _, call := range mockClient.ChangeResourceRecordSetsCalls {
+		zoneID := aws.ToString(call.Input.HostedZoneId)
+		got[zoneID] = append(got[zoneID], changeSummary(call.Input.ChangeBatch.Changes)...)
+	}
+	assert.Equal(t, map[string][]string{
+		"ZFWD": {
+			"CREATE A web.example.com. 192.0.2.1",
+		},
+		"ZLAB": {
+			"DELETE A old.lab.example.com. 192.0.2.8",
+		},
+	}, got)
+}
+
+func TestUpdateEndpoints_ZoneLists(t *testing.T) {
+	mockClient := &mockRoute53Client{}
+	p, err := newMockNewRoute53Provider(
+		mockClient,
+		zap.NewNop(),
+		Route53ProviderConfig{
+			RecordSuffix:       ".example.com",
+			ForwardZoneID:      "Z2FDTNDATAQYW2",
+			Ipv4ReverseZoneIDs: []string{"ex-ipv4-reverse"},
+			Ipv6ReverseZoneIDs: []string{"ex-ipv6-reverse"},
+		},
+		configtypes.DefaultEndpointFilterFunc,
+		configtypes.DefaultEndpointFilterFunc,
+	)
+	require.NoError(t, err)
+
+	endpoints := []*endpoint.Endpoint{
+		{Hostname: "host", IPv4s: []string{"192.0.2.1"}, IPv6s: []string{"2001:db8::1"}, RecordTTL: 60},
+	}
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	assert.Len(t, mockClient.ChangeResourceRecordSetsCalls, 3)
+	assert.Empty(t, mockClient.ListHostedZonesCalls)
+
+	// zone names are only looked up once
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	assert.Len(t, mockClient.GetHostedZoneCalls, 3)
+}
@@ -0,0 +1,127 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/sapslaj/zonepop/pkg/accesstoken"
+)
+
+const (
+	armScope    = "https://management.azure.com/.default"
+	armResource = "https://management.azure.com/"
+)
+
+const defaultAuthorityHost = "https://login.microsoftonline.com/"
+
+// Instance Metadata Service endpoint for managed identities, replaced in tests
+var imdsTokenEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// credentials are the credentials set in the provider config, defaulting to
+// the environment variables the Azure SDKs use.
+type credentials struct {
+	tenantID           string
+	clientID           string
+	clientSecret       string
+	federatedTokenFile string
+	authorityHost      string
+}
+
+func configCredentials(c AzureDNSProviderConfig) credentials {
+	orEnv := func(value string, key string) string {
+		if value != "" {
+			return value
+		}
+		return os.Getenv(key)
+	}
+	creds := credentials{
+		tenantID:           orEnv(c.TenantID, "AZURE_TENANT_ID"),
+		clientID:           orEnv(c.ClientID, "AZURE_CLIENT_ID"),
+		clientSecret:       orEnv(c.ClientSecret, "AZURE_CLIENT_SECRET"),
+		federatedTokenFile: orEnv(c.FederatedTokenFile, "AZURE_FEDERATED_TOKEN_FILE"),
+		authorityHost:      orEnv(c.AuthorityHost, "AZURE_AUTHORITY_HOST"),
+	}
+	if creds.authorityHost == "" {
+		creds.authorityHost = defaultAuthorityHost
+	}
+	if !strings.HasSuffix(creds.authorityHost, "/") {
+		creds.authorityHost += "/"
+	}
+	return creds
+}
+
+// newTokenSource returns the access token source for the credentials set in
+// the provider config: a client secret of a service principal, a federated
+// token of AKS workload identity, or else a managed identity.
+func newTokenSource(c AzureDNSProviderConfig, client *http.Client) (*accesstoken.Source, error) {
+	creds := configCredentials(c)
+	switch {
+	case creds.clientSecret != "" || creds.federatedTokenFile != "":
+		if creds.tenantID == "" || creds.clientID == "" {
+			return nil, errors.New("tenant_id and client_id are required with client_secret or federated_token_file")
+		}
+		return &accesstoken.Source{
+			Fetch: func(ctx context.Context) (accesstoken.Token, error) {
+				return creds.clientCredentialsToken(ctx, client)
+			},
+		}, nil
+	default:
+		return &accesstoken.Source{
+			Fetch: func(ctx context.Context) (accesstoken.Token, error) {
+				return creds.managedIdentityToken(ctx, client)
+			},
+		}, nil
+	}
+}
+
+// clientCredentialsToken requests an access token for the service principal
+// with its client secret or, if there is none, the federated token.
+func (creds credentials) clientCredentialsToken(ctx context.Context, client *http.Client) (accesstoken.Token, error) {
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {creds.clientID},
+		"scope":      {armScope},
+	}
+	if creds.clientSecret != "" {
+		form.Set("client_secret", creds.clientSecret)
+	} else {
+		// the token file is rotated by Kubernetes, so it's read every time
+		assertion, err := os.ReadFile(creds.federatedTokenFile)
+		if err != nil {
+			return accesstoken.Token{}, fmt.Errorf("could not read federated token file: %w", err)
+		}
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", strings.TrimSpace(string(assertion)))
+	}
+	u := creds.authorityHost + url.PathEscape(creds.tenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return accesstoken.Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return accesstoken.Request(client, req)
+}
+
+// managedIdentityToken gets an access token for the managed identity of the
+// VM from the Instance Metadata Service. A client ID selects a user-assigned
+// identity.
+func (creds credentials) managedIdentityToken(ctx context.Context, client *http.Client) (accesstoken.Token, error) {
+	query := url.Values{
+		"api-version": {"2018-02-01"},
+		"resource":    {armResource},
+	}
+	if creds.clientID != "" {
+		query.Set("client_id", creds.clientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imdsTokenEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return accesstoken.Token{}, err
+	}
+	req.Header.Set("Metadata", "true")
+	return accesstoken.Request(client, req)
+}
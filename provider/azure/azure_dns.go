@@ -0,0 +1,466 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/config/configtypes"
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/accesstoken"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/recordset"
+	"github.com/sapslaj/zonepop/pkg/utils"
+	"github.com/sapslaj/zonepop/provider"
+)
+
+const DefaultEndpointURL = "https://management.azure.com"
+
+const apiVersion = "2018-05-01"
+
+type AzureDNSProviderConfig struct {
+	SubscriptionID string
+	// Resource group of the DNS zones
+	ResourceGroup string
+	RecordSuffix  string
+	// Names of the DNS zones
+	ForwardZone     string
+	Ipv4ReverseZone string
+	Ipv6ReverseZone string
+	// Additional zones, records are routed to the most specific zone
+	ForwardZones     []string
+	Ipv4ReverseZones []string
+	Ipv6ReverseZones []string
+	// Use all DNS zones of the resource group in addition to the configured
+	// ones
+	DiscoverZones        bool
+	CleanForwardZone     bool
+	CleanIPv4ReverseZone bool
+	CleanIPv6ReverseZone bool
+	// TTL of records of endpoints without one (default 300)
+	DefaultTTL int64
+	// Service principal, default from $AZURE_TENANT_ID, $AZURE_CLIENT_ID and
+	// $AZURE_CLIENT_SECRET. Without a client secret, a federated token file
+	// ($AZURE_FEDERATED_TOKEN_FILE with AKS workload identity) or else the
+	// managed identity (user-assigned if the client ID is set) is used.
+	TenantID           string
+	ClientID           string
+	ClientSecret       string
+	FederatedTokenFile string
+	// Microsoft Entra ID endpoint (default https://login.microsoftonline.com/)
+	AuthorityHost string
+	// Azure Resource Manager endpoint, e.g. of a local stand-in
+	EndpointURL string
+	// How hostnames are normalized into valid DNS names
+	Normalization dnsname.Policy
+}
+
+type azureDNSProvider struct {
+	config              AzureDNSProviderConfig
+	forwardLookupFilter configtypes.EndpointFilterFunc
+	reverseLookupFilter configtypes.EndpointFilterFunc
+	logger              *zap.Logger
+	normalizer          *dnsname.Normalizer
+	httpClient          *http.Client
+	tokenSource         *accesstoken.Source
+	written             recordset.Written
+}
+
+type dnsZone struct {
+	Name string `json:"name"`
+}
+
+type recordSet struct {
+	Name       string              `json:"name,omitempty"`
+	Type       string              `json:"type,omitempty"`
+	Properties recordSetProperties `json:"properties"`
+}
+
+type recordSetProperties struct {
+	TTL         int64        `json:"TTL"`
+	Fqdn        string       `json:"fqdn,omitempty"`
+	ARecords    []aRecord    `json:"ARecords,omitempty"`
+	AAAARecords []aaaaRecord `json:"AAAARecords,omitempty"`
+	PTRRecords  []ptrRecord  `json:"PTRRecords,omitempty"`
+	CNAMERecord *cnameRecord `json:"CNAMERecord,omitempty"`
+	TXTRecords  []txtRecord  `json:"TXTRecords,omitempty"`
+	MXRecords   []mxRecord   `json:"MXRecords,omitempty"`
+	SRVRecords  []srvRecord  `json:"SRVRecords,omitempty"`
+	NSRecords   []nsRecord   `json:"NSRecords,omitempty"`
+	// never changed, kept as is
+	SOARecord json.RawMessage `json:"SOARecord,omitempty"`
+}
+
+type aRecord struct {
+	IPv4Address string `json:"ipv4Address"`
+}
+
+type aaaaRecord struct {
+	IPv6Address string `json:"ipv6Address"`
+}
+
+type ptrRecord struct {
+	Ptrdname string `json:"ptrdname"`
+}
+
+type cnameRecord struct {
+	Cname string `json:"cname"`
+}
+
+type txtRecord struct {
+	Value []string `json:"value"`
+}
+
+type mxRecord struct {
+	Preference int    `json:"preference"`
+	Exchange   string `json:"exchange"`
+}
+
+type srvRecord struct {
+	Priority int    `json:"priority"`
+	Weight   int    `json:"weight"`
+	Port     int    `json:"port"`
+	Target   string `json:"target"`
+}
+
+type nsRecord struct {
+	Nsdname string `json:"nsdname"`
+}
+
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func NewAzureDNSProvider(
+	name string,
+	providerConfig AzureDNSProviderConfig,
+	forwardLookupFilter configtypes.EndpointFilterFunc,
+	reverseLookupFilter configtypes.EndpointFilterFunc,
+) (provider.Provider, error) {
+	if providerConfig.SubscriptionID == "" {
+		return nil, errors.New("subscription_id is required")
+	}
+	if providerConfig.ResourceGroup == "" {
+		return nil, errors.New("resource_group is required")
+	}
+	if providerConfig.EndpointURL == "" {
+		providerConfig.EndpointURL = DefaultEndpointURL
+	}
+	providerConfig.EndpointURL = strings.TrimSuffix(providerConfig.EndpointURL, "/")
+	httpClient := http.DefaultClient
+	tokenSource, err := newTokenSource(providerConfig, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure Azure credentials: %w", err)
+	}
+	normalizer, err := dnsname.NewNormalizer(name, providerConfig.Normalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure hostname normalization: %w", err)
+	}
+	return &azureDNSProvider{
+		config:              providerConfig,
+		forwardLookupFilter: forwardLookupFilter,
+		reverseLookupFilter: reverseLookupFilter,
+		logger:              log.MustNewLogger().Named("azure_dns_provider"),
+		normalizer:          normalizer,
+		httpClient:          httpClient,
+		tokenSource:         tokenSource,
+		written:             recordset.Written{},
+	}, nil
+}
+
+func (p *azureDNSProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) error {
+	zones, err := p.zones(ctx)
+	if err != nil {
+		p.logger.Sugar().Errorw("could not get Azure DNS zones", "err", err)
+		return err
+	}
+	if p.config.RecordSuffix == "" && len(zones.Forward) > 0 {
+		p.config.RecordSuffix = "." + strings.TrimSuffix(zones.Forward[0].Name, ".")
+	}
+	err = recordset.Sync(
+		ctx,
+		p,
+		zones,
+		utils.Filter(p.forwardLookupFilter, endpoints),
+		utils.Filter(p.reverseLookupFilter, endpoints),
+		recordset.SyncConfig{
+			RecordSuffix:         p.config.RecordSuffix,
+			Normalizer:           p.normalizer,
+			Logger:               p.logger,
+			DefaultTTL:           p.config.DefaultTTL,
+			CleanForwardZone:     p.config.CleanForwardZone,
+			CleanIPv4ReverseZone: p.config.CleanIPv4ReverseZone,
+			CleanIPv6ReverseZone: p.config.CleanIPv6ReverseZone,
+			Written:              p.written,
+		},
+	)
+	if err != nil {
+		p.logger.Sugar().Errorw("failed to update Azure DNS zones", "err", err)
+	}
+	return err
+}
+
+// zones returns the configured zones followed by the discovered ones.
+func (p *azureDNSProvider) zones(ctx context.Context) (recordset.Zones, error) {
+	zones := recordset.Zones{}
+	configured := slices.Concat(
+		optionalZone(p.config.ForwardZone), p.config.ForwardZones,
+		optionalZone(p.config.Ipv4ReverseZone), p.config.Ipv4ReverseZones,
+		optionalZone(p.config.Ipv6ReverseZone), p.config.Ipv6ReverseZones,
+	)
+	for _, name := range configured {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		zones.Add(recordset.Zone{ID: name, Name: name})
+	}
+	if !p.config.DiscoverZones {
+		return zones, nil
+	}
+	u := p.zonesURL("") + "?" + url.Values{"api-version": {apiVersion}}.Encode()
+	for u != "" {
+		var res struct {
+			Value    []dnsZone `json:"value"`
+			NextLink string    `json:"nextLink"`
+		}
+		err := p.do(ctx, http.MethodGet, u, nil, &res)
+		if err != nil {
+			return zones, fmt.Errorf("could not discover zones: %w", err)
+		}
+		for _, z := range res.Value {
+			name := strings.ToLower(z.Name)
+			zones.Add(recordset.Zone{ID: name, Name: name})
+		}
+		u = res.NextLink
+	}
+	return zones, nil
+}
+
+func optionalZone(name string) []string {
+	if name == "" {
+		return nil
+	}
+	return []string{name}
+}
+
+// zonesURL returns the URL of the DNS zones of the resource group, followed
+// by elems.
+func (p *azureDNSProvider) zonesURL(elems ...string) string {
+	u := p.config.EndpointURL +
+		"/subscriptions/" + url.PathEscape(p.config.SubscriptionID) +
+		"/resourceGroups/" + url.PathEscape(p.config.ResourceGroup) +
+		"/providers/Microsoft.Network/dnsZones"
+	for _, elem := range elems {
+		if elem != "" {
+			u += "/" + url.PathEscape(elem)
+		}
+	}
+	return u
+}
+
+// relativeName returns the name of a record set relative to its zone, "@" for
+// the apex.
+func relativeName(zone recordset.Zone, name string) string {
+	name = recordset.Canonical(name)
+	if name == zone.Name {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+zone.Name)
+}
+
+// RecordSets implements [recordset.Client].
+func (p *azureDNSProvider) RecordSets(ctx context.Context, zone recordset.Zone) ([]recordset.Set, error) {
+	sets := make([]recordset.Set, 0)
+	u := p.zonesURL(zone.ID, "recordsets") + "?" + url.Values{"api-version": {apiVersion}}.Encode()
+	for u != "" {
+		var res struct {
+			Value    []recordSet `json:"value"`
+			NextLink string      `json:"nextLink"`
+		}
+		err := p.do(ctx, http.MethodGet, u, nil, &res)
+		if err != nil {
+			return nil, err
+		}
+		for _, rs := range res.Value {
+			name := rs.Properties.Fqdn
+			if name == "" {
+				name = rs.Name + "." + zone.Name
+				if rs.Name == "@" {
+					name = zone.Name
+				}
+			}
+			sets = append(sets, recordset.Set{
+				Name:   name,
+				Type:   path.Base(rs.Type),
+				TTL:    rs.Properties.TTL,
+				Values: rs.Properties.values(),
+			})
+		}
+		u = res.NextLink
+	}
+	return sets, nil
+}
+
+// Apply implements [recordset.Client]. Azure DNS changes one record set per
+// request, deletions first.
+func (p *azureDNSProvider) Apply(ctx context.Context, zone recordset.Zone, changes recordset.Changes) error {
+	query := "?" + url.Values{"api-version": {apiVersion}}.Encode()
+	var errs error
+	for _, s := range changes.Delete {
+		u := p.zonesURL(zone.ID, s.Type, relativeName(zone, s.Name)) + query
+		err := p.do(ctx, http.MethodDelete, u, nil, nil)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("could not delete %s record %q: %w", s.Type, s.Name, err))
+		}
+	}
+	puts := slices.Clone(changes.Create)
+	for _, update := range changes.Update {
+		puts = append(puts, update.New)
+	}
+	for _, s := range puts {
+		properties, err := toProperties(s)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		u := p.zonesURL(zone.ID, s.Type, relativeName(zone, s.Name)) + query
+		err = p.do(ctx, http.MethodPut, u, recordSet{Properties: properties}, nil)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("could not set %s record %q: %w", s.Type, s.Name, err))
+		}
+	}
+	return errs
+}
+
+// values returns the record data in the form of zone files.
+func (props recordSetProperties) values() []string {
+	values := make([]string, 0)
+	for _, r := range props.ARecords {
+		values = append(values, r.IPv4Address)
+	}
+	for _, r := range props.AAAARecords {
+		values = append(values, r.IPv6Address)
+	}
+	for _, r := range props.PTRRecords {
+		values = append(values, r.Ptrdname)
+	}
+	if props.CNAMERecord != nil {
+		values = append(values, props.CNAMERecord.Cname)
+	}
+	for _, r := range props.TXTRecords {
+		values = append(values, strings.Join(r.Value, ""))
+	}
+	for _, r := range props.MXRecords {
+		values = append(values, fmt.Sprintf("%d %s", r.Preference, r.Exchange))
+	}
+	for _, r := range props.SRVRecords {
+		values = append(values, fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target))
+	}
+	for _, r := range props.NSRecords {
+		values = append(values, r.Nsdname)
+	}
+	return values
+}
+
+// toProperties converts a record set into the record data of its type.
+func toProperties(s recordset.Set) (recordSetProperties, error) {
+	props := recordSetProperties{TTL: s.TTL}
+	for _, value := range s.Values {
+		fields := strings.Fields(value)
+		switch s.Type {
+		case recordset.TypeA:
+			props.ARecords = append(props.ARecords, aRecord{IPv4Address: value})
+		case recordset.TypeAAAA:
+			props.AAAARecords = append(props.AAAARecords, aaaaRecord{IPv6Address: value})
+		case recordset.TypePTR:
+			props.PTRRecords = append(props.PTRRecords, ptrRecord{Ptrdname: value})
+		case endpoint.RecordTypeCNAME:
+			props.CNAMERecord = &cnameRecord{Cname: value}
+		case endpoint.RecordTypeTXT:
+			// strings of TXT records are limited to 255 characters
+			chunks := make([]string, 0)
+			for len(value) > 255 {
+				chunks = append(chunks, value[:255])
+				value = value[255:]
+			}
+			props.TXTRecords = append(props.TXTRecords, txtRecord{Value: append(chunks, value)})
+		case endpoint.RecordTypeMX:
+			preference, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return props, fmt.Errorf("invalid MX record %q: %w", value, err)
+			}
+			props.MXRecords = append(props.MXRecords, mxRecord{Preference: preference, Exchange: fields[1]})
+		case endpoint.RecordTypeSRV:
+			numbers, err := utils.MapErr(strconv.Atoi, fields[:3])
+			if err != nil {
+				return props, fmt.Errorf("invalid SRV record %q: %w", value, err)
+			}
+			props.SRVRecords = append(props.SRVRecords, srvRecord{
+				Priority: numbers[0],
+				Weight:   numbers[1],
+				Port:     numbers[2],
+				Target:   fields[3],
+			})
+		default:
+			return props, fmt.Errorf("unsupported record type %s", s.Type)
+		}
+	}
+	return props, nil
+}
+
+// do sends a request to Azure Resource Manager and decodes the response into
+// result if it's not nil.
+func (p *azureDNSProvider) do(ctx context.Context, method string, u string, body any, result any) error {
+	token, err := p.tokenSource.Token(ctx)
+	if err != nil {
+		return err
+	}
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send %s request: %w", method, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		var apiErr apiError
+		_ = json.NewDecoder(res.Body).Decode(&apiErr)
+		return fmt.Errorf("%s %s failed with status %d: %s: %s", method, req.URL.Path, res.StatusCode, apiErr.Error.Code, apiErr.Error.Message)
+	}
+	if result == nil {
+		return nil
+	}
+	err = json.NewDecoder(res.Body).Decode(result)
+	if err != nil {
+		return fmt.Errorf("could not decode %s %s response: %w", method, req.URL.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+const testZonesPath = "/subscriptions/test-subscription/resourceGroups/test-rg/providers/Microsoft.Network/dnsZones"
+
+// fakeAzureDNS is an in-memory stand-in for the Microsoft Entra ID token
+// endpoint and the DNS zone endpoints of Azure Resource Manager.
+type fakeAzureDNS struct {
+	t       *testing.T
+	mu      sync.Mutex
+	zones   map[string][]recordSet
+	changes []string
+	tokens  int
+}
+
+func newFakeAzureDNS(t *testing.T, zones map[string][]recordSet) (*fakeAzureDNS, *httptest.Server) {
+	api := &fakeAzureDNS{t: t, zones: zones}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	return api, server
+}
+
+func (api *fakeAzureDNS) respond(w http.ResponseWriter, status int, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	require.NoError(api.t, json.NewEncoder(w).Encode(result))
+}
+
+func (api *fakeAzureDNS) error(w http.ResponseWriter, status int, code string, message string) {
+	res := apiError{}
+	res.Error.Code = code
+	res.Error.Message = message
+	api.respond(w, status, res)
+}
+
+// page responds with the items of the page of the request, two items per
+// page, and the link to the next page.
+func page[T any](api *fakeAzureDNS, w http.ResponseWriter, r *http.Request, items []T) {
+	start, _ := strconv.Atoi(r.URL.Query().Get("$skipToken"))
+	end := min(start+2, len(items))
+	next := ""
+	if end < len(items) {
+		next = "http://" + r.Host + r.URL.Path + "?" + url.Values{
+			"api-version": {apiVersion},
+			"$skipToken":  {strconv.Itoa(end)},
+		}.Encode()
+	}
+	api.respond(w, http.StatusOK, map[string]any{"value": items[start:end], "nextLink": next})
+}
+
+func (api *fakeAzureDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if r.URL.Path == "/test-tenant/oauth2/v2.0/token" {
+		api.serveToken(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		api.error(w, http.StatusUnauthorized, "AuthenticationFailed", "Authentication failed.")
+		return
+	}
+	assert.Equal(api.t, apiVersion, r.URL.Query().Get("api-version"))
+	path, ok := strings.CutPrefix(r.URL.Path, testZonesPath)
+	if !ok {
+		api.error(w, http.StatusNotFound, "ResourceGroupNotFound", "Resource group could not be found.")
+		return
+	}
+	if path == "" {
+		names := make([]string, 0, len(api.zones))
+		for name := range api.zones {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		zones := make([]dnsZone, 0, len(names))
+		for _, name := range names {
+			zones = append(zones, dnsZone{Name: name})
+		}
+		page(api, w, r, zones)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	sets, ok := api.zones[parts[0]]
+	if !ok {
+		api.error(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("The Resource 'Microsoft.Network/dnszones/%s' was not found.", parts[0]))
+		return
+	}
+	switch {
+	case len(parts) == 2 && parts[1] == "recordsets" && r.Method == http.MethodGet:
+		page(api, w, r, sets)
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		i := indexOf(sets, parts[2], parts[1])
+		if i >= 0 {
+			api.zones[parts[0]] = append(sets[:i:i], sets[i+1:]...)
+		}
+		api.changes = append(api.changes, fmt.Sprintf("%s: delete %s %s", parts[0], parts[1], parts[2]))
+		w.WriteHeader(http.StatusOK)
+	case len(parts) == 3 && r.Method == http.MethodPut:
+		var rs recordSet
+		require.NoError(api.t, json.NewDecoder(r.Body).Decode(&rs))
+		rs.Name = parts[2]
+		rs.Type = "Microsoft.Network/dnszones/" + parts[1]
+		rs.Properties.Fqdn = parts[0] + "."
+		if parts[2] != "@" {
+			rs.Properties.Fqdn = parts[2] + "." + rs.Properties.Fqdn
+		}
+		i := indexOf(sets, parts[2], parts[1])
+		if i >= 0 {
+			sets[i] = rs
+		} else {
+			api.zones[parts[0]] = append(sets, rs)
+		}
+		api.changes = append(api.changes, fmt.Sprintf("%s: put %s %s %s ttl=%d", parts[0], parts[1], parts[2], strings.Join(rs.Properties.values(), ","), rs.Properties.TTL))
+		api.respond(w, http.StatusOK, rs)
+	default:
+		api.error(w, http.StatusNotFound, "NotFound", "Not Found")
+	}
+}
+
+func indexOf(sets []recordSet, name string, rrType string) int {
+	for i, rs := range sets {
+		if rs.Name == name && rs.Type == "Microsoft.Network/dnszones/"+rrType {
+			return i
+		}
+	}
+	return -1
+}
+
+// serveToken checks the client credentials of a service principal token
+// request.
+func (api *fakeAzureDNS) serveToken(w http.ResponseWriter, r *http.Request) {
+	api.tokens++
+	require.NoError(api.t, r.ParseForm())
+	assert.Equal(api.t, "client_credentials", r.PostForm.Get("grant_type"))
+	assert.Equal(api.t, "test-client", r.PostForm.Get("client_id"))
+	assert.Equal(api.t, armScope, r.PostForm.Get("scope"))
+	valid := r.PostForm.Get("client_secret") == "test-secret"
+	if r.PostForm.Has("client_assertion") {
+		assert.Equal(api.t, "urn:ietf:params:oauth:client-assertion-type:jwt-bearer", r.PostForm.Get("client_assertion_type"))
+		valid = r.PostForm.Get("client_assertion") == "test-federated-token"
+	}
+	if !valid {
+		api.respond(w, http.StatusUnauthorized, map[string]string{
+			"error":             "invalid_client",
+			"error_description": "AADSTS7000215: Invalid client secret provided.",
+		})
+		return
+	}
+	api.respond(w, http.StatusOK, map[string]any{"access_token": "test-token", "expires_in": 3599, "token_type": "Bearer"})
+}
+
+func newTestProvider(t *testing.T, server *httptest.Server, config AzureDNSProviderConfig) *azureDNSProvider {
+	config.SubscriptionID = "test-subscription"
+	config.ResourceGroup = "test-rg"
+	config.TenantID = "test-tenant"
+	config.ClientID = "test-client"
+	if config.ClientSecret == "" && config.FederatedTokenFile == "" {
+		config.ClientSecret = "test-secret"
+	}
+	config.AuthorityHost = server.URL
+	config.EndpointURL = server.URL
+	p, err := NewAzureDNSProvider(
+		"test",
+		config,
+		func(e *endpoint.Endpoint) bool { return true },
+		func(e *endpoint.Endpoint) bool { return true },
+	)
+	require.NoError(t, err)
+	return p.(*azureDNSProvider)
+}
+
+func testRecordSet(zone string, name string, rrType string, properties recordSetProperties) recordSet {
+	properties.Fqdn = name + "." + zone + "."
+	if name == "@" {
+		properties.Fqdn = zone + "."
+	}
+	return recordSet{Name: name, Type: "Microsoft.Network/dnszones/" + rrType, Properties: properties}
+}
+
+func testZones() map[string][]recordSet {
+	return map[string][]recordSet{
+		"example.com": {
+			testRecordSet("example.com", "@", "NS", recordSetProperties{TTL: 172800, NSRecords: []nsRecord{{Nsdname: "ns1-01.azure-dns.com."}}}),
+			testRecordSet("example.com", "@", "SOA", recordSetProperties{TTL: 3600, SOARecord: json.RawMessage(`{"host":"ns1-01.azure-dns.com.","serialNumber":1}`)}),
+			testRecordSet("example.com", "host1", "A", recordSetProperties{TTL: 300, ARecords: []aRecord{{IPv4Address: "192.0.2.9"}}}),
+			testRecordSet("example.com", "host1", "AAAA", recordSetProperties{TTL: 300, AAAARecords: []aaaaRecord{{IPv6Address: "2001:db8::1"}}}),
+			testRecordSet("example.com", "manual", "A", recordSetProperties{TTL: 300, ARecords: []aRecord{{IPv4Address: "192.0.2.10"}}}),
+		},
+		"2.0.192.in-addr.arpa": {
+			testRecordSet("2.0.192.in-addr.arpa", "10", "PTR", recordSetProperties{TTL: 300, PTRRecords: []ptrRecord{{Ptrdname: "manual.example.com."}}}),
+		},
+		"8.b.d.0.1.0.0.2.ip6.arpa": {},
+	}
+}
+
+func TestUpdateEndpoints(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config      AzureDNSProviderConfig
+		endpoints   []*endpoint.Endpoint
+		wantChanges []string
+	}{
+		"configured zones": {
+			config: AzureDNSProviderConfig{
+				ForwardZone:     "example.com",
+				Ipv4ReverseZone: "2.0.192.in-addr.arpa",
+			},
+			endpoints: []*endpoint.Endpoint{
+				{Hostname: "host1", IPv4s: []string{"192.0.2.1"}},
+				{Hostname: "host2", IPv4s: []string{"192.0.2.2"}, IPv6s: []string{"2001:db8::2"}, Records: []endpoint.Record{
+					{Type: endpoint.RecordTypeTXT, Name: "host2", Value: `v=spf1 -all`},
+					{Type: endpoint.RecordTypeMX, Name: "host2", Value: "10 mail.example.com."},
+				}},
+			},
+			wantChanges: []string{
+				"example.com: delete AAAA host1",
+				"example.com: put A host2 192.0.2.2 ttl=300",
+				"example.com: put AAAA host2 2001:db8::2 ttl=300",
+				"example.com: put MX host2 10 mail.example.com. ttl=300",
+				"example.com: put TXT host2 v=spf1 -all ttl=300",
+				"example.com: put A host1 192.0.2.1 ttl=300",
+				"2.0.192.in-addr.arpa: put PTR 1 host1.example.com. ttl=300",
+				"2.0.192.in-addr.arpa: put PTR 2 host2.example.com. ttl=300",
+			},
+		},
+		"discovered zones with cleanup": {
+			config: AzureDNSProviderConfig{
+				DiscoverZones:        true,
+				CleanForwardZone:     true,
+				CleanIPv4ReverseZone: true,
+				DefaultTTL:           60,
+			},
+			endpoints: []*endpoint.Endpoint{
+				{Hostname: "host1", IPv4s: []string{"192.0.2.9"}, IPv6s: []string{"2001:db8::1"}, RecordTTL: 300},
+			},
+			wantChanges: []string{
+				"example.com: delete A manual",
+				"2.0.192.in-addr.arpa: delete PTR 10",
+				"2.0.192.in-addr.arpa: put PTR 9 host1.example.com. ttl=300",
+				"8.b.d.0.1.0.0.2.ip6.arpa: put PTR 1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0 host1.example.com. ttl=300",
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			api, server := newFakeAzureDNS(t, testZones())
+			p := newTestProvider(t, server, tc.config)
+
+			err := p.UpdateEndpoints(context.Background(), tc.endpoints)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantChanges, api.changes)
+			assert.Equal(t, 1, api.tokens)
+
+			api.changes = nil
+			err = p.UpdateEndpoints(context.Background(), tc.endpoints)
+			require.NoError(t, err)
+			assert.Empty(t, api.changes, "second sync should not change anything")
+			assert.Equal(t, 1, api.tokens, "access token should be cached")
+		})
+	}
+}
+
+func TestUpdateEndpoints_FederatedToken(t *testing.T) {
+	t.Parallel()
+
+	api, server := newFakeAzureDNS(t, testZones())
+	tokenFile := filepath.Join(t.TempDir(), "azure-identity-token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("test-federated-token\n"), 0o600))
+	p := newTestProvider(t, server, AzureDNSProviderConfig{
+		ForwardZone:        "example.com",
+		FederatedTokenFile: tokenFile,
+	})
+
+	err := p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+		{Hostname: "host1", IPv4s: []string{"192.0.2.9"}, IPv6s: []string{"2001:db8::1"}},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, api.changes)
+	assert.Equal(t, 1, api.tokens)
+}
+
+func TestUpdateEndpoints_ManagedIdentity(t *testing.T) {
+	api, server := newFakeAzureDNS(t, testZones())
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.Header.Get("Metadata"))
+		assert.Equal(t, armResource, r.URL.Query().Get("resource"))
+		assert.Equal(t, "test-identity", r.URL.Query().Get("client_id"))
+		_, _ = w.Write([]byte(`{"access_token":"test-token","expires_in":"86399","token_type":"Bearer"}`))
+	}))
+	defer imds.Close()
+	defaultEndpoint := imdsTokenEndpoint
+	imdsTokenEndpoint = imds.URL + "/metadata/identity/oauth2/token"
+	t.Cleanup(func() { imdsTokenEndpoint = defaultEndpoint })
+	t.Setenv("AZURE_CLIENT_SECRET", "")
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", "")
+
+	p, err := NewAzureDNSProvider("test", AzureDNSProviderConfig{
+		SubscriptionID: "test-subscription",
+		ResourceGroup:  "test-rg",
+		ClientID:       "test-identity",
+		ForwardZone:    "example.com",
+		EndpointURL:    server.URL,
+	}, func(e *endpoint.Endpoint) bool { return true }, func(e *endpoint.Endpoint) bool { return true })
+	require.NoError(t, err)
+
+	err = p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+		{Hostname: "host3", IPv4s: []string{"192.0.2.3"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com: put A host3 192.0.2.3 ttl=300"}, api.changes)
+}
+
+func TestUpdateEndpoints_Errors(t *testing.T) {
+	t.Parallel()
+
+	t.Run("unknown zone", func(t *testing.T) {
+		t.Parallel()
+
+		_, server := newFakeAzureDNS(t, testZones())
+		p := newTestProvider(t, server, AzureDNSProviderConfig{ForwardZone: "example.org"})
+		err := p.UpdateEndpoints(context.Background(), nil)
+		assert.ErrorContains(t, err, "failed with status 404: ResourceNotFound")
+	})
+
+	t.Run("invalid client secret", func(t *testing.T) {
+		t.Parallel()
+
+		_, server := newFakeAzureDNS(t, testZones())
+		p := newTestProvider(t, server, AzureDNSProviderConfig{
+			ForwardZone:  "example.com",
+			ClientSecret: "wrong-secret",
+		})
+		err := p.UpdateEndpoints(context.Background(), nil)
+		assert.ErrorContains(t, err, "invalid_client: AADSTS7000215: Invalid client secret provided.")
+	})
+}
+
+func TestNewAzureDNSProvider_Invalid(t *testing.T) {
+	t.Setenv("AZURE_TENANT_ID", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+
+	tests := map[string]struct {
+		config AzureDNSProviderConfig
+		errMsg string
+	}{
+		"missing subscription": {
+			config: AzureDNSProviderConfig{ResourceGroup: "test-rg"},
+			errMsg: "subscription_id is required",
+		},
+		"missing resource group": {
+			config: AzureDNSProviderConfig{SubscriptionID: "test-subscription"},
+			errMsg: "resource_group is required",
+		},
+		"client secret without tenant": {
+			config: AzureDNSProviderConfig{
+				SubscriptionID: "test-subscription",
+				ResourceGroup:  "test-rg",
+				ClientID:       "test-client",
+				ClientSecret:   "test-secret",
+			},
+			errMsg: "tenant_id and client_id are required",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewAzureDNSProvider("test", tc.config, nil, nil)
+			assert.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
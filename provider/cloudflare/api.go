@@ -0,0 +1,161 @@
+package cloudflare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const DefaultBaseURL = "https://api.cloudflare.com/client/v4"
+
+const defaultPerPage = 100
+
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiResponse is the envelope of all v4 API responses.
+type apiResponse struct {
+	Success    bool            `json:"success"`
+	Errors     []apiError      `json:"errors"`
+	Result     json.RawMessage `json:"result"`
+	ResultInfo *resultInfo     `json:"result_info"`
+}
+
+type resultInfo struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	TotalPages int `json:"total_pages"`
+	Count      int `json:"count"`
+	TotalCount int `json:"total_count"`
+}
+
+type zone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type dnsRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int64  `json:"ttl"`
+	Proxied *bool  `json:"proxied,omitempty"`
+	Comment string `json:"comment"`
+}
+
+// do sends a request to the API and decodes the result into result if it's
+// not nil.
+func (p *cloudflareProvider) do(ctx context.Context, method string, path string, query url.Values, body any, result any) (*resultInfo, error) {
+	u := p.config.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.config.APIToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send %s %s request: %w", method, path, err)
+	}
+	defer res.Body.Close()
+
+	var apiRes apiResponse
+	err = json.NewDecoder(res.Body).Decode(&apiRes)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %s %s response with status %d: %w", method, path, res.StatusCode, err)
+	}
+	if !apiRes.Success || res.StatusCode >= 300 {
+		messages := make([]string, 0, len(apiRes.Errors))
+		for _, e := range apiRes.Errors {
+			messages = append(messages, fmt.Sprintf("%s (%d)", e.Message, e.Code))
+		}
+		return nil, fmt.Errorf("%s %s failed with status %d: %s", method, path, res.StatusCode, strings.Join(messages, ", "))
+	}
+	if result != nil {
+		err = json.Unmarshal(apiRes.Result, result)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode %s %s result: %w", method, path, err)
+		}
+	}
+	return apiRes.ResultInfo, nil
+}
+
+func (p *cloudflareProvider) findZone(ctx context.Context, name string) (zone, error) {
+	var zones []zone
+	_, err := p.do(ctx, http.MethodGet, "/zones", url.Values{"name": {name}}, nil, &zones)
+	if err != nil {
+		return zone{}, err
+	}
+	if len(zones) == 0 {
+		return zone{}, fmt.Errorf("zone %q not found", name)
+	}
+	return zones[0], nil
+}
+
+// listRecords returns all DNS records of the zone, page by page.
+func (p *cloudflareProvider) listRecords(ctx context.Context, zoneID string) ([]dnsRecord, error) {
+	perPage := p.perPage
+	if perPage == 0 {
+		perPage = defaultPerPage
+	}
+	records := make([]dnsRecord, 0)
+	for page := 1; ; page++ {
+		var pageRecords []dnsRecord
+		info, err := p.do(ctx, http.MethodGet, "/zones/"+zoneID+"/dns_records", url.Values{
+			"page":     {strconv.Itoa(page)},
+			"per_page": {strconv.Itoa(perPage)},
+		}, nil, &pageRecords)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, pageRecords...)
+		if info == nil || page >= info.TotalPages || len(pageRecords) == 0 {
+			return records, nil
+		}
+	}
+}
+
+func (p *cloudflareProvider) createRecord(ctx context.Context, zoneID string, record dnsRecord) error {
+	_, err := p.do(ctx, http.MethodPost, "/zones/"+zoneID+"/dns_records", nil, record, nil)
+	return err
+}
+
+func (p *cloudflareProvider) updateRecord(ctx context.Context, zoneID string, record dnsRecord) error {
+	id := record.ID
+	record.ID = ""
+	_, err := p.do(ctx, http.MethodPatch, "/zones/"+zoneID+"/dns_records/"+id, nil, record, nil)
+	return err
+}
+
+func (p *cloudflareProvider) deleteRecord(ctx context.Context, zoneID string, record dnsRecord) error {
+	_, err := p.do(ctx, http.MethodDelete, "/zones/"+zoneID+"/dns_records/"+record.ID, nil, nil, nil)
+	return err
+}
+
+func (p *cloudflareProvider) getZone(ctx context.Context, zoneID string) (zone, error) {
+	var z zone
+	_, err := p.do(ctx, http.MethodGet, "/zones/"+zoneID, nil, nil, &z)
+	return z, err
+}
@@ -0,0 +1,376 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/config/configtypes"
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/utils"
+	"github.com/sapslaj/zonepop/provider"
+)
+
+// Provider properties of endpoints that override the provider config.
+const (
+	// Whether the records are proxied through Cloudflare, as a bool or a
+	// string like "true"
+	PropertyProxied = "cloudflare_proxied"
+	// Comment on the records
+	PropertyComment = "cloudflare_comment"
+)
+
+const (
+	DefaultOwnerID   = "default"
+	DefaultTXTPrefix = "_zonepop."
+
+	// TTL of 1 means automatic, which is required for proxied records.
+	automaticTTL = 1
+	minTTL       = 60
+)
+
+type CloudflareProviderConfig struct {
+	// API token with the Zone.DNS edit permission
+	APIToken string
+	// ID of the zone, or the name to look it up by
+	ZoneID   string
+	ZoneName string
+	// Appended to hostnames (default is the zone name)
+	RecordSuffix string
+	// Proxy records through Cloudflare unless the cloudflare_proxied provider
+	// property says otherwise
+	Proxied bool
+	// Identifies the records managed by this provider in the ownership TXT
+	// records, so multiple instances can share a zone (default "default")
+	OwnerID string
+	// Prefix of the ownership TXT record names (default "_zonepop.")
+	TXTPrefix string
+	// Base URL of the API (default https://api.cloudflare.com/client/v4)
+	BaseURL string
+	// How hostnames are normalized into valid DNS names
+	Normalization dnsname.Policy
+}
+
+type cloudflareProvider struct {
+	config              CloudflareProviderConfig
+	forwardLookupFilter configtypes.EndpointFilterFunc
+	logger              *zap.Logger
+	normalizer          *dnsname.Normalizer
+	httpClient          *http.Client
+	zone                zone
+	// page size of record listings, for tests
+	perPage int
+}
+
+// desiredName holds the desired records of a name.
+type desiredName struct {
+	name     string
+	contents map[string][]string
+	ttl      int64
+	proxied  bool
+	comment  string
+}
+
+func NewCloudflareProvider(
+	name string,
+	providerConfig CloudflareProviderConfig,
+	forwardLookupFilter configtypes.EndpointFilterFunc,
+) (provider.Provider, error) {
+	if providerConfig.APIToken == "" {
+		return nil, errors.New("api_token is required")
+	}
+	if providerConfig.ZoneID == "" && providerConfig.ZoneName == "" {
+		return nil, errors.New("zone_id or zone_name is required")
+	}
+	if providerConfig.OwnerID == "" {
+		providerConfig.OwnerID = DefaultOwnerID
+	}
+	if providerConfig.TXTPrefix == "" {
+		providerConfig.TXTPrefix = DefaultTXTPrefix
+	}
+	if providerConfig.BaseURL == "" {
+		providerConfig.BaseURL = DefaultBaseURL
+	}
+	providerConfig.BaseURL = strings.TrimSuffix(providerConfig.BaseURL, "/")
+	normalizer, err := dnsname.NewNormalizer(name, providerConfig.Normalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure hostname normalization: %w", err)
+	}
+	return &cloudflareProvider{
+		config:              providerConfig,
+		forwardLookupFilter: forwardLookupFilter,
+		logger:              log.MustNewLogger().Named("cloudflare_provider"),
+		normalizer:          normalizer,
+		httpClient:          http.DefaultClient,
+	}, nil
+}
+
+func (p *cloudflareProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) error {
+	err := p.resolveZone(ctx)
+	if err != nil {
+		p.logger.Sugar().Errorw("could not get Cloudflare zone", "err", err)
+		return err
+	}
+	records, err := p.listRecords(ctx, p.zone.ID)
+	if err != nil {
+		p.logger.Sugar().Errorw("could not list DNS records", "zone", p.zone.Name, "err", err)
+		return fmt.Errorf("could not list DNS records of zone %s: %w", p.zone.Name, err)
+	}
+
+	desired := p.desiredNames(utils.Filter(p.forwardLookupFilter, endpoints))
+	existing := map[string][]dnsRecord{}
+	owners := map[string]dnsRecord{}
+	for _, record := range records {
+		name := strings.ToLower(record.Name)
+		switch record.Type {
+		case "A", "AAAA":
+			existing[name] = append(existing[name], record)
+		case "TXT":
+			if strings.HasPrefix(name, p.config.TXTPrefix) && strings.Trim(record.Content, `"`) == p.ownership() {
+				owners[strings.TrimPrefix(name, p.config.TXTPrefix)] = record
+			}
+		}
+	}
+
+	var errs error
+	for _, name := range sortedKeys(desired) {
+		d := desired[name]
+		if _, owned := owners[name]; !owned {
+			if len(existing[name]) > 0 {
+				p.logger.Sugar().Warnf("not managing records of %q, they are not owned by this provider", name)
+				continue
+			}
+			p.logger.Sugar().Infof("adding ownership TXT record for %q", name)
+			err := p.createRecord(ctx, p.zone.ID, dnsRecord{
+				Type:    "TXT",
+				Name:    p.config.TXTPrefix + name,
+				Content: p.ownershipContent(),
+				TTL:     automaticTTL,
+			})
+			if err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("could not create ownership record for %q: %w", name, err))
+				continue
+			}
+		}
+		for _, recordType := range []string{"A", "AAAA"} {
+			errs = multierr.Append(errs, p.syncRecords(ctx, d, recordType, existing[name]))
+		}
+	}
+
+	for _, name := range sortedKeys(owners) {
+		if _, ok := desired[name]; ok {
+			continue
+		}
+		var deleteErrs error
+		for _, record := range existing[name] {
+			p.logger.Sugar().Infof("removing %s record %q with %s", record.Type, name, record.Content)
+			deleteErrs = multierr.Append(deleteErrs, p.deleteRecord(ctx, p.zone.ID, record))
+		}
+		if deleteErrs == nil {
+			p.logger.Sugar().Infof("removing ownership TXT record for %q", name)
+			deleteErrs = p.deleteRecord(ctx, p.zone.ID, owners[name])
+		}
+		if deleteErrs != nil {
+			errs = multierr.Append(errs, fmt.Errorf("could not remove records of %q: %w", name, deleteErrs))
+		}
+	}
+
+	if errs != nil {
+		p.logger.Sugar().Errorw("failed to update some DNS records", "zone", p.zone.Name, "err", errs)
+	}
+	return errs
+}
+
+// resolveZone looks up the ID or name of the zone, whichever isn't
+// configured, and defaults the record suffix to the zone name.
+func (p *cloudflareProvider) resolveZone(ctx context.Context) error {
+	if p.zone.ID != "" {
+		return nil
+	}
+	var z zone
+	var err error
+	switch {
+	case p.config.ZoneID != "" && p.config.ZoneName != "":
+		z = zone{ID: p.config.ZoneID, Name: p.config.ZoneName}
+	case p.config.ZoneID != "":
+		z, err = p.getZone(ctx, p.config.ZoneID)
+	default:
+		z, err = p.findZone(ctx, p.config.ZoneName)
+	}
+	if err != nil {
+		return err
+	}
+	z.Name = strings.TrimSuffix(strings.ToLower(z.Name), ".")
+	if p.config.RecordSuffix == "" {
+		p.config.RecordSuffix = "." + z.Name
+	}
+	p.zone = z
+	return nil
+}
+
+func (p *cloudflareProvider) ownership() string {
+	return "heritage=zonepop,zonepop/owner=" + p.config.OwnerID
+}
+
+func (p *cloudflareProvider) ownershipContent() string {
+	return `"` + p.ownership() + `"`
+}
+
+// desiredNames groups the addresses of the endpoints by normalized name.
+// Names outside of the zone are skipped.
+func (p *cloudflareProvider) desiredNames(endpoints []*endpoint.Endpoint) map[string]*desiredName {
+	desired := map[string]*desiredName{}
+	for _, e := range endpoints {
+		if e.Hostname == "" {
+			continue
+		}
+		name, err := p.normalizer.Normalize(e.Hostname, p.config.RecordSuffix)
+		if err != nil {
+			p.logger.Sugar().Warnw("skipping endpoint with invalid hostname", "hostname", e.Hostname, "err", err)
+			continue
+		}
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		if name != p.zone.Name && !strings.HasSuffix(name, "."+p.zone.Name) {
+			p.logger.Sugar().Warnf("skipping %q, it is not in zone %q", name, p.zone.Name)
+			continue
+		}
+		d, ok := desired[name]
+		if !ok {
+			d = &desiredName{
+				name:     name,
+				contents: map[string][]string{},
+				ttl:      e.RecordTTL,
+				proxied:  p.proxied(e),
+				comment:  propertyString(e, PropertyComment),
+			}
+			desired[name] = d
+		}
+		// Cloudflare returns addresses in canonical form, so the contents
+		// must be too to compare equal
+		for _, addr := range e.IPv4Addrs() {
+			if !slices.Contains(d.contents["A"], addr.String()) {
+				d.contents["A"] = append(d.contents["A"], addr.String())
+			}
+		}
+		for _, addr := range e.IPv6Addrs() {
+			if !slices.Contains(d.contents["AAAA"], addr.String()) {
+				d.contents["AAAA"] = append(d.contents["AAAA"], addr.String())
+			}
+		}
+	}
+	return desired
+}
+
+func (p *cloudflareProvider) proxied(e *endpoint.Endpoint) bool {
+	switch v := e.ProviderProperties[PropertyProxied].(type) {
+	case bool:
+		return v
+	case string:
+		proxied, err := strconv.ParseBool(v)
+		if err == nil {
+			return proxied
+		}
+		p.logger.Sugar().Warnf("ignoring invalid %s provider property %q of %q", PropertyProxied, v, e.Hostname)
+	}
+	return p.config.Proxied
+}
+
+func propertyString(e *endpoint.Endpoint, key string) string {
+	if v, ok := e.ProviderProperties[key]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// syncRecords makes the records of a type match the desired addresses.
+// Records with addresses that aren't desired anymore are reused for new ones.
+func (p *cloudflareProvider) syncRecords(ctx context.Context, d *desiredName, recordType string, existing []dnsRecord) error {
+	ttl := d.ttl
+	switch {
+	case d.proxied || ttl <= 0:
+		ttl = automaticTTL
+	case ttl < minTTL:
+		ttl = minTTL
+	}
+	want := func(record dnsRecord) dnsRecord {
+		record.Type = recordType
+		record.Name = d.name
+		record.TTL = ttl
+		record.Proxied = &d.proxied
+		record.Comment = d.comment
+		return record
+	}
+
+	kept := make([]dnsRecord, 0)
+	extra := make([]dnsRecord, 0)
+	for _, record := range existing {
+		if record.Type != recordType {
+			continue
+		}
+		if slices.Contains(d.contents[recordType], record.Content) && !slices.ContainsFunc(kept, func(k dnsRecord) bool {
+			return k.Content == record.Content
+		}) {
+			kept = append(kept, record)
+		} else {
+			extra = append(extra, record)
+		}
+	}
+	missing := make([]string, 0)
+	for _, content := range d.contents[recordType] {
+		if !slices.ContainsFunc(kept, func(k dnsRecord) bool { return k.Content == content }) {
+			missing = append(missing, content)
+		}
+	}
+
+	var errs error
+	for i, content := range missing {
+		record := want(dnsRecord{Content: content})
+		if i < len(extra) {
+			record.ID = extra[i].ID
+			p.logger.Sugar().Infof("updating %s record %q from %s to %s", recordType, d.name, extra[i].Content, content)
+			errs = multierr.Append(errs, p.updateRecord(ctx, p.zone.ID, record))
+			continue
+		}
+		p.logger.Sugar().Infof("adding %s record %q with %s", recordType, d.name, content)
+		errs = multierr.Append(errs, p.createRecord(ctx, p.zone.ID, record))
+	}
+	for i := len(missing); i < len(extra); i++ {
+		p.logger.Sugar().Infof("removing %s record %q with %s", recordType, d.name, extra[i].Content)
+		errs = multierr.Append(errs, p.deleteRecord(ctx, p.zone.ID, extra[i]))
+	}
+	for _, record := range kept {
+		updated := want(record)
+		if record.TTL == updated.TTL && isProxied(record) == d.proxied && record.Comment == updated.Comment {
+			continue
+		}
+		p.logger.Sugar().Infof("updating %s record %q with %s", recordType, d.name, record.Content)
+		errs = multierr.Append(errs, p.updateRecord(ctx, p.zone.ID, updated))
+	}
+	if errs != nil {
+		return fmt.Errorf("could not update %s records of %q: %w", recordType, d.name, errs)
+	}
+	return nil
+}
+
+func isProxied(record dnsRecord) bool {
+	return record.Proxied != nil && *record.Proxied
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
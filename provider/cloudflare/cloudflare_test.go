@@ -0,0 +1,412 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+const testZoneID = "023e105f4ecef8ad9ca31a8372d0c353"
+
+// fakeAPI is an in-memory stand-in for the zones and DNS records endpoints of
+// the v4 API.
+type fakeAPI struct {
+	t       *testing.T
+	mu      sync.Mutex
+	records map[string]dnsRecord
+	nextID  int
+	calls   []string
+	fail    bool
+}
+
+func newFakeAPI(t *testing.T, records ...dnsRecord) (*fakeAPI, *httptest.Server) {
+	api := &fakeAPI{t: t, records: map[string]dnsRecord{}}
+	for _, record := range records {
+		api.add(record)
+	}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	return api, server
+}
+
+func (api *fakeAPI) add(record dnsRecord) dnsRecord {
+	api.nextID++
+	record.ID = fmt.Sprintf("record-%d", api.nextID)
+	api.records[record.ID] = record
+	return record
+}
+
+func (api *fakeAPI) respond(w http.ResponseWriter, status int, result any, info *resultInfo) {
+	res := map[string]any{
+		"success":  status < 300,
+		"errors":   []apiError{},
+		"messages": []any{},
+		"result":   result,
+	}
+	if status >= 300 {
+		res["errors"] = []apiError{{Code: 1004, Message: "DNS Validation Error"}}
+	}
+	if info != nil {
+		res["result_info"] = info
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	require.NoError(api.t, json.NewEncoder(w).Encode(res))
+}
+
+func (api *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		api.respond(w, http.StatusForbidden, nil, nil)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/client/v4")
+	if r.Method != http.MethodGet {
+		api.calls = append(api.calls, r.Method+" "+path)
+	}
+	recordsPath := "/zones/" + testZoneID + "/dns_records"
+	switch {
+	case r.Method == http.MethodGet && path == "/zones":
+		zones := []zone{}
+		if r.URL.Query().Get("name") == "example.com" {
+			zones = append(zones, zone{ID: testZoneID, Name: "example.com"})
+		}
+		api.respond(w, http.StatusOK, zones, nil)
+	case r.Method == http.MethodGet && path == "/zones/"+testZoneID:
+		api.respond(w, http.StatusOK, zone{ID: testZoneID, Name: "example.com"}, nil)
+	case r.Method == http.MethodGet && path == recordsPath:
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+		ids := make([]string, 0, len(api.records))
+		for id := range api.records {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		result := []dnsRecord{}
+		for i := (page - 1) * perPage; i < len(ids) && i < page*perPage; i++ {
+			result = append(result, api.records[ids[i]])
+		}
+		api.respond(w, http.StatusOK, result, &resultInfo{
+			Page:       page,
+			PerPage:    perPage,
+			Count:      len(result),
+			TotalCount: len(ids),
+			TotalPages: (len(ids) + perPage - 1) / perPage,
+		})
+	case api.fail:
+		api.respond(w, http.StatusBadRequest, nil, nil)
+	case r.Method == http.MethodPost && path == recordsPath:
+		var record dnsRecord
+		require.NoError(api.t, json.NewDecoder(r.Body).Decode(&record))
+		api.respond(w, http.StatusOK, api.add(record), nil)
+	case strings.HasPrefix(path, recordsPath+"/"):
+		id := strings.TrimPrefix(path, recordsPath+"/")
+		record, ok := api.records[id]
+		if !ok {
+			api.respond(w, http.StatusNotFound, nil, nil)
+			return
+		}
+		switch r.Method {
+		case http.MethodPatch:
+			var patch dnsRecord
+			require.NoError(api.t, json.NewDecoder(r.Body).Decode(&patch))
+			patch.ID = record.ID
+			api.records[id] = patch
+			api.respond(w, http.StatusOK, patch, nil)
+		case http.MethodDelete:
+			delete(api.records, id)
+			api.respond(w, http.StatusOK, map[string]string{"id": id}, nil)
+		}
+	default:
+		api.respond(w, http.StatusNotFound, nil, nil)
+	}
+}
+
+// summary returns the records in a comparable form.
+func (api *fakeAPI) summary() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	summary := make([]string, 0, len(api.records))
+	for _, record := range api.records {
+		s := fmt.Sprintf("%s %s %s ttl=%d", record.Type, record.Name, record.Content, record.TTL)
+		if isProxied(record) {
+			s += " proxied"
+		}
+		if record.Comment != "" {
+			s += " comment=" + record.Comment
+		}
+		summary = append(summary, s)
+	}
+	sort.Strings(summary)
+	return summary
+}
+
+func newTestProvider(t *testing.T, server *httptest.Server, config CloudflareProviderConfig) *cloudflareProvider {
+	config.APIToken = "test-token"
+	config.BaseURL = server.URL + "/client/v4"
+	if config.ZoneID == "" && config.ZoneName == "" {
+		config.ZoneName = "example.com"
+	}
+	p, err := NewCloudflareProvider("test", config, func(e *endpoint.Endpoint) bool { return true })
+	require.NoError(t, err)
+	cp := p.(*cloudflareProvider)
+	cp.httpClient = server.Client()
+	cp.perPage = 2
+	return cp
+}
+
+const testOwnership = `"heritage=zonepop,zonepop/owner=default"`
+
+func TestNewCloudflareProvider_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config CloudflareProviderConfig
+		errMsg string
+	}{
+		"missing token": {
+			config: CloudflareProviderConfig{ZoneName: "example.com"},
+			errMsg: "api_token is required",
+		},
+		"missing zone": {
+			config: CloudflareProviderConfig{APIToken: "test-token"},
+			errMsg: "zone_id or zone_name is required",
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewCloudflareProvider("test", tc.config, nil)
+			assert.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
+
+func TestUpdateEndpoints(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config    CloudflareProviderConfig
+		existing  []dnsRecord
+		endpoints []*endpoint.Endpoint
+		want      []string
+	}{
+		"creates records with ownership": {
+			endpoints: []*endpoint.Endpoint{
+				{
+					Hostname:  "host1",
+					IPv4s:     []string{"192.0.2.1"},
+					IPv6s:     []string{"2001:db8::1"},
+					RecordTTL: 300,
+				},
+			},
+			want: []string{
+				"A host1.example.com 192.0.2.1 ttl=300",
+				"AAAA host1.example.com 2001:db8::1 ttl=300",
+				"TXT _zonepop.host1.example.com " + testOwnership + " ttl=1",
+			},
+		},
+		"proxied and comment from provider properties": {
+			endpoints: []*endpoint.Endpoint{
+				{
+					Hostname:  "host1",
+					IPv4s:     []string{"192.0.2.1"},
+					RecordTTL: 300,
+					ProviderProperties: map[string]any{
+						PropertyProxied: true,
+						PropertyComment: "managed by zonepop",
+					},
+				},
+				{
+					Hostname:           "host2",
+					IPv4s:              []string{"192.0.2.2"},
+					ProviderProperties: map[string]any{PropertyProxied: "false"},
+				},
+			},
+			config: CloudflareProviderConfig{Proxied: true},
+			want: []string{
+				"A host1.example.com 192.0.2.1 ttl=1 proxied comment=managed by zonepop",
+				"A host2.example.com 192.0.2.2 ttl=1",
+				"TXT _zonepop.host1.example.com " + testOwnership + " ttl=1",
+				"TXT _zonepop.host2.example.com " + testOwnership + " ttl=1",
+			},
+		},
+		"updates changed addresses and settings": {
+			existing: []dnsRecord{
+				{Type: "TXT", Name: "_zonepop.host1.example.com", Content: testOwnership, TTL: 1},
+				{Type: "A", Name: "host1.example.com", Content: "192.0.2.1", TTL: 300},
+				{Type: "A", Name: "host1.example.com", Content: "192.0.2.2", TTL: 300},
+				{Type: "AAAA", Name: "host1.example.com", Content: "2001:db8::1", TTL: 300},
+			},
+			endpoints: []*endpoint.Endpoint{
+				{
+					Hostname:           "host1",
+					IPv4s:              []string{"192.0.2.1", "192.0.2.3"},
+					RecordTTL:          30,
+					ProviderProperties: map[string]any{PropertyComment: "web"},
+				},
+			},
+			want: []string{
+				"A host1.example.com 192.0.2.1 ttl=60 comment=web",
+				"A host1.example.com 192.0.2.3 ttl=60 comment=web",
+				"TXT _zonepop.host1.example.com " + testOwnership + " ttl=1",
+			},
+		},
+		"compares addresses in canonical form": {
+			existing: []dnsRecord{
+				{Type: "TXT", Name: "_zonepop.host1.example.com", Content: testOwnership, TTL: 1},
+				{Type: "A", Name: "host1.example.com", Content: "192.0.2.1", TTL: 300},
+				{Type: "AAAA", Name: "host1.example.com", Content: "2001:db8::1", TTL: 300},
+			},
+			endpoints: []*endpoint.Endpoint{
+				{
+					Hostname:  "host1",
+					IPv4s:     []string{"::ffff:192.0.2.1"},
+					IPv6s:     []string{"2001:DB8:0::1"},
+					RecordTTL: 300,
+				},
+			},
+			want: []string{
+				"A host1.example.com 192.0.2.1 ttl=300",
+				"AAAA host1.example.com 2001:db8::1 ttl=300",
+				"TXT _zonepop.host1.example.com " + testOwnership + " ttl=1",
+			},
+		},
+		"removes owned records only": {
+			existing: []dnsRecord{
+				{Type: "TXT", Name: "_zonepop.old.example.com", Content: testOwnership, TTL: 1},
+				{Type: "A", Name: "old.example.com", Content: "192.0.2.1", TTL: 300},
+				{Type: "TXT", Name: "_zonepop.other.example.com", Content: `"heritage=zonepop,zonepop/owner=other"`, TTL: 1},
+				{Type: "A", Name: "other.example.com", Content: "192.0.2.2", TTL: 300},
+				{Type: "A", Name: "manual.example.com", Content: "192.0.2.3", TTL: 300},
+			},
+			want: []string{
+				"A manual.example.com 192.0.2.3 ttl=300",
+				"A other.example.com 192.0.2.2 ttl=300",
+				`TXT _zonepop.other.example.com "heritage=zonepop,zonepop/owner=other" ttl=1`,
+			},
+		},
+		"skips names that are not owned": {
+			existing: []dnsRecord{
+				{Type: "A", Name: "manual.example.com", Content: "192.0.2.3", TTL: 300},
+			},
+			endpoints: []*endpoint.Endpoint{
+				{Hostname: "manual", IPv4s: []string{"192.0.2.4"}},
+			},
+			want: []string{
+				"A manual.example.com 192.0.2.3 ttl=300",
+			},
+		},
+		"skips names outside of the zone": {
+			config: CloudflareProviderConfig{RecordSuffix: ".example.net"},
+			endpoints: []*endpoint.Endpoint{
+				{Hostname: "host1", IPv4s: []string{"192.0.2.1"}},
+			},
+			want: []string{},
+		},
+		"looks up the zone by ID": {
+			config: CloudflareProviderConfig{ZoneID: testZoneID, OwnerID: "lab", TXTPrefix: "_owner."},
+			endpoints: []*endpoint.Endpoint{
+				{Hostname: "host1", IPv4s: []string{"192.0.2.1"}, RecordTTL: 120},
+			},
+			want: []string{
+				"A host1.example.com 192.0.2.1 ttl=120",
+				`TXT _owner.host1.example.com "heritage=zonepop,zonepop/owner=lab" ttl=1`,
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			api, server := newFakeAPI(t, tc.existing...)
+			p := newTestProvider(t, server, tc.config)
+
+			err := p.UpdateEndpoints(context.Background(), tc.endpoints)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, api.summary())
+
+			api.calls = nil
+			err = p.UpdateEndpoints(context.Background(), tc.endpoints)
+			require.NoError(t, err)
+			assert.Empty(t, api.calls, "second sync should not change anything")
+		})
+	}
+}
+
+func TestUpdateEndpoints_ReusesRecords(t *testing.T) {
+	t.Parallel()
+
+	api, server := newFakeAPI(t,
+		dnsRecord{Type: "TXT", Name: "_zonepop.host1.example.com", Content: testOwnership, TTL: 1},
+		dnsRecord{Type: "A", Name: "host1.example.com", Content: "192.0.2.1", TTL: 300},
+	)
+	p := newTestProvider(t, server, CloudflareProviderConfig{})
+
+	err := p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+		{Hostname: "host1", IPv4s: []string{"192.0.2.2"}, RecordTTL: 300},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PATCH /zones/" + testZoneID + "/dns_records/record-2"}, api.calls)
+	assert.Equal(t, []string{
+		"A host1.example.com 192.0.2.2 ttl=300",
+		"TXT _zonepop.host1.example.com " + testOwnership + " ttl=1",
+	}, api.summary())
+}
+
+func TestUpdateEndpoints_Errors(t *testing.T) {
+	t.Parallel()
+
+	t.Run("API error", func(t *testing.T) {
+		t.Parallel()
+
+		api, server := newFakeAPI(t)
+		api.fail = true
+		p := newTestProvider(t, server, CloudflareProviderConfig{})
+
+		err := p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+			{Hostname: "host1", IPv4s: []string{"192.0.2.1"}},
+		})
+		assert.ErrorContains(t, err, "DNS Validation Error (1004)")
+	})
+
+	t.Run("unknown zone", func(t *testing.T) {
+		t.Parallel()
+
+		_, server := newFakeAPI(t)
+		p := newTestProvider(t, server, CloudflareProviderConfig{ZoneName: "example.org"})
+
+		err := p.UpdateEndpoints(context.Background(), nil)
+		assert.ErrorContains(t, err, `zone "example.org" not found`)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		t.Parallel()
+
+		_, server := newFakeAPI(t)
+		p := newTestProvider(t, server, CloudflareProviderConfig{})
+		p.config.APIToken = "wrong"
+
+		err := p.UpdateEndpoints(context.Background(), nil)
+		assert.ErrorContains(t, err, "failed with status 403")
+	})
+}
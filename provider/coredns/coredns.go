@@ -0,0 +1,270 @@
+package coredns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/config/configtypes"
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/configfile"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/recordset"
+	"github.com/sapslaj/zonepop/pkg/utils"
+	"github.com/sapslaj/zonepop/provider"
+)
+
+const (
+	// File of the hosts plugin
+	FormatHosts = "hosts"
+	// Zone file of the file plugin
+	FormatZone = "zone"
+)
+
+type CoreDNSProviderConfig struct {
+	// "hosts" (default) or "zone"
+	Format string
+	// Appended to hostnames, also the origin of the zone file
+	RecordSuffix string
+	// TTL of records of endpoints without one in the zone file (default 300)
+	DefaultTTL int64
+	// Primary name server and mailbox in the SOA record of the zone file
+	// (default "ns.<suffix>." and "hostmaster.<suffix>.")
+	Nameserver  string
+	Hostmaster  string
+	File        string
+	Permissions string
+	// Write the file and reload CoreDNS on a remote host
+	SSH configfile.SSHConfig
+	// How CoreDNS is reloaded after the file changed, not needed with the
+	// reload option of the hosts and file plugins
+	Reload configfile.ReloadConfig
+	// How hostnames are normalized into valid DNS names
+	Normalization dnsname.Policy
+}
+
+type coreDNSProvider struct {
+	config              CoreDNSProviderConfig
+	file                configfile.Config
+	fileState           configfile.State
+	forwardLookupFilter configtypes.EndpointFilterFunc
+	logger              *zap.Logger
+	normalizer          *dnsname.Normalizer
+	// Origin of the zone file
+	origin  string
+	connect configfile.ConnectFunc
+	now     func() time.Time
+}
+
+func NewCoreDNSProvider(
+	name string,
+	providerConfig CoreDNSProviderConfig,
+	forwardLookupFilter configtypes.EndpointFilterFunc,
+) (provider.Provider, error) {
+	if providerConfig.Format == "" {
+		providerConfig.Format = FormatHosts
+	}
+	zone := strings.Trim(providerConfig.RecordSuffix, ".")
+	switch providerConfig.Format {
+	case FormatHosts:
+	case FormatZone:
+		if zone == "" {
+			return nil, errors.New("record_suffix is required for the zone format")
+		}
+		if providerConfig.Nameserver == "" {
+			providerConfig.Nameserver = "ns." + zone + "."
+		}
+		if providerConfig.Hostmaster == "" {
+			providerConfig.Hostmaster = "hostmaster." + zone + "."
+		}
+	default:
+		return nil, fmt.Errorf("invalid format %q, must be %q or %q", providerConfig.Format, FormatHosts, FormatZone)
+	}
+	file, err := configfile.Config{
+		File:        providerConfig.File,
+		Permissions: providerConfig.Permissions,
+		SSH:         providerConfig.SSH,
+		Reload:      providerConfig.Reload,
+	}.WithDefaults()
+	if err != nil {
+		return nil, err
+	}
+	normalizer, err := dnsname.NewNormalizer(name, providerConfig.Normalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure hostname normalization: %w", err)
+	}
+	return &coreDNSProvider{
+		config:              providerConfig,
+		file:                file,
+		forwardLookupFilter: forwardLookupFilter,
+		logger:              log.MustNewLogger().Named("coredns_provider"),
+		normalizer:          normalizer,
+		origin:              zone + ".",
+		connect:             configfile.Connect,
+		now:                 time.Now,
+	}, nil
+}
+
+func (p *coreDNSProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) error {
+	forwardEndpoints := utils.Filter(p.forwardLookupFilter, endpoints)
+	txtValue := recordset.QuotedTXT
+	if p.config.Format == FormatHosts {
+		txtValue = nil
+	}
+	desired, _ := recordset.Forward(forwardEndpoints, recordset.SyncConfig{
+		RecordSuffix: p.config.RecordSuffix,
+		Normalizer:   p.normalizer,
+		Logger:       p.logger,
+		DefaultTTL:   p.config.DefaultTTL,
+		TXTValue:     txtValue,
+	})
+
+	host, err := p.connect(ctx, p.file.SSH)
+	if err != nil {
+		p.logger.Sugar().Errorw("could not connect to host", "host", p.file.SSH.Host, "err", err)
+		return err
+	}
+	defer func() {
+		err := host.Close()
+		if err != nil {
+			p.logger.Sugar().Errorw("error disconnecting from host", "host", p.file.SSH.Host, "err", err)
+		}
+	}()
+
+	var render func(current string) (string, error)
+	if p.config.Format == FormatZone {
+		records := p.zoneRecords(desired)
+		render = func(current string) (string, error) {
+			return p.zoneFile(records, current), nil
+		}
+	} else {
+		content := p.hostsFile(desired)
+		render = func(string) (string, error) {
+			return content, nil
+		}
+	}
+	_, err = configfile.Update(ctx, host, p.file, &p.fileState, p.logger, render)
+	if err != nil {
+		p.logger.Sugar().Errorw("failed to update CoreDNS file", "err", err)
+	}
+	return err
+}
+
+// hostsFile returns a file of the hosts plugin with the addresses of the
+// desired record sets. CNAMEs become additional names of their target, other
+// record types aren't supported.
+func (p *coreDNSProvider) hostsFile(desired recordset.Desired) string {
+	names := map[string][]string{}
+	for _, s := range desired {
+		if s.Type == endpoint.RecordTypeCNAME {
+			target := recordset.Canonical(s.Values[0])
+			_, hasA := desired[recordset.Key{Name: target, Type: recordset.TypeA}]
+			_, hasAAAA := desired[recordset.Key{Name: target, Type: recordset.TypeAAAA}]
+			if !hasA && !hasAAAA {
+				p.logger.Sugar().Warnf("skipping CNAME %q, the hosts format only supports targets with addresses", s.Name)
+				continue
+			}
+			names[target] = append(names[target], strings.TrimSuffix(s.Name, "."))
+		} else if s.Type != recordset.TypeA && s.Type != recordset.TypeAAAA {
+			p.logger.Sugar().Debugf("skipping %s record %q, not supported by the hosts format", s.Type, s.Name)
+		}
+	}
+
+	lines := make([]string, 0)
+	for _, s := range desired {
+		if s.Type != recordset.TypeA && s.Type != recordset.TypeAAAA {
+			continue
+		}
+		aliases := names[s.Key().Name]
+		sort.Strings(aliases)
+		for _, addr := range s.Values {
+			lines = append(lines, strings.Join(append([]string{addr, strings.TrimSuffix(s.Name, ".")}, aliases...), "\t"))
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return hostsLineKey(lines[i]) < hostsLineKey(lines[j])
+	})
+
+	var b strings.Builder
+	b.WriteString("# Generated by ZonePop\n")
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+// hostsLineKey sorts lines by name, IPv4 addresses first.
+func hostsLineKey(line string) string {
+	addr, names, _ := strings.Cut(line, "\t")
+	kind := "4"
+	if strings.Contains(addr, ":") {
+		kind = "6"
+	}
+	return names + "\t" + kind + addr
+}
+
+var serialPattern = regexp.MustCompile(`(?m)^\S+\s+\d+\s+IN\s+SOA\s+\S+\s+\S+\s+(\d+)\s`)
+
+// zoneRecords returns the lines of the desired record sets in the zone of
+// the record suffix.
+func (p *coreDNSProvider) zoneRecords(desired recordset.Desired) []string {
+	zones := []recordset.Zone{{Name: p.origin}}
+	lines := make([]string, 0)
+	for _, s := range desired {
+		if recordset.ZoneFor(zones, s.Name) == nil {
+			p.logger.Sugar().Warnf("skipping %s record %q outside of zone %q", s.Type, s.Name, zones[0].Name)
+			continue
+		}
+		for _, value := range s.Values {
+			lines = append(lines, fmt.Sprintf("%s\t%d\tIN\t%s\t%s", s.Name, s.TTL, s.Type, value))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// zoneFile returns a zone file of the file plugin with the records. The
+// serial of the current zone file is kept if the records are the same,
+// otherwise it's increased to the current Unix time.
+func (p *coreDNSProvider) zoneFile(records []string, current string) string {
+	var currentSerial uint32
+	if match := serialPattern.FindStringSubmatch(current); match != nil {
+		serial, err := strconv.ParseUint(match[1], 10, 32)
+		if err == nil {
+			currentSerial = uint32(serial)
+		}
+	}
+	if content := p.renderZoneFile(records, currentSerial); content == current {
+		return content
+	}
+	serial := uint32(p.now().Unix())
+	if serial <= currentSerial {
+		serial = currentSerial + 1
+	}
+	return p.renderZoneFile(records, serial)
+}
+
+func (p *coreDNSProvider) renderZoneFile(records []string, serial uint32) string {
+	ttl := p.config.DefaultTTL
+	if ttl <= 0 {
+		ttl = recordset.DefaultTTL
+	}
+
+	var b strings.Builder
+	b.WriteString("; Generated by ZonePop\n")
+	fmt.Fprintf(&b, "$ORIGIN %s\n", p.origin)
+	fmt.Fprintf(&b, "%s\t%d\tIN\tSOA\t%s %s %d 7200 3600 1209600 %d\n", p.origin, ttl, p.config.Nameserver, p.config.Hostmaster, serial, ttl)
+	fmt.Fprintf(&b, "%s\t%d\tIN\tNS\t%s\n", p.origin, ttl, p.config.Nameserver)
+	for _, record := range records {
+		b.WriteString(record + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,121 @@
+package coredns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/configfile"
+	"github.com/sapslaj/zonepop/pkg/configfile/configfiletest"
+)
+
+func newTestProvider(t *testing.T, host *configfiletest.Host, config CoreDNSProviderConfig) *coreDNSProvider {
+	config.RecordSuffix = ".lan"
+	config.File = "/etc/coredns/lan"
+	p, err := NewCoreDNSProvider("test", config, func(e *endpoint.Endpoint) bool { return true })
+	require.NoError(t, err)
+	cp := p.(*coreDNSProvider)
+	cp.connect = func(ctx context.Context, c configfile.SSHConfig) (configfile.Host, error) {
+		return host, nil
+	}
+	cp.now = func() time.Time {
+		return time.Unix(1700000000, 0)
+	}
+	return cp
+}
+
+func testEndpoints() []*endpoint.Endpoint {
+	return []*endpoint.Endpoint{
+		{Hostname: "host1", IPv4s: []string{"192.0.2.1"}, IPv6s: []string{"2001:db8::1"}, Aliases: []string{"nas", "files"}},
+		{Hostname: "host2", IPv4s: []string{"192.0.2.2"}, RecordTTL: 60, Records: []endpoint.Record{
+			{Type: endpoint.RecordTypeTXT, Name: "host2", Value: "v=spf1 -all"},
+			{Type: endpoint.RecordTypeCNAME, Name: "external", Value: "example.com."},
+		}},
+	}
+}
+
+func TestUpdateEndpoints_Hosts(t *testing.T) {
+	t.Parallel()
+
+	host := configfiletest.NewHost()
+	p := newTestProvider(t, host, CoreDNSProviderConfig{})
+	err := p.UpdateEndpoints(context.Background(), testEndpoints())
+	require.NoError(t, err)
+	assert.Equal(t, "# Generated by ZonePop\n"+
+		"192.0.2.1\thost1.lan\tfiles.lan\tnas.lan\n"+
+		"2001:db8::1\thost1.lan\tfiles.lan\tnas.lan\n"+
+		"192.0.2.2\thost2.lan\n", host.File("/etc/coredns/lan"))
+	assert.Empty(t, host.Commands())
+}
+
+func TestUpdateEndpoints_Zone(t *testing.T) {
+	t.Parallel()
+
+	host := configfiletest.NewHost()
+	p := newTestProvider(t, host, CoreDNSProviderConfig{
+		Format: FormatZone,
+		Reload: configfile.ReloadConfig{Command: "systemctl reload coredns"},
+	})
+	err := p.UpdateEndpoints(context.Background(), testEndpoints())
+	require.NoError(t, err)
+	want := "; Generated by ZonePop\n" +
+		"$ORIGIN lan.\n" +
+		"lan.\t300\tIN\tSOA\tns.lan. hostmaster.lan. 1700000000 7200 3600 1209600 300\n" +
+		"lan.\t300\tIN\tNS\tns.lan.\n" +
+		"external.lan.\t60\tIN\tCNAME\texample.com.\n" +
+		"files.lan.\t300\tIN\tCNAME\thost1.lan.\n" +
+		"host1.lan.\t300\tIN\tA\t192.0.2.1\n" +
+		"host1.lan.\t300\tIN\tAAAA\t2001:db8::1\n" +
+		"host2.lan.\t60\tIN\tA\t192.0.2.2\n" +
+		"host2.lan.\t60\tIN\tTXT\t\"v=spf1 -all\"\n" +
+		"nas.lan.\t300\tIN\tCNAME\thost1.lan.\n"
+	assert.Equal(t, want, host.File("/etc/coredns/lan"))
+	assert.Equal(t, []string{"systemctl reload coredns"}, host.Commands())
+
+	err = p.UpdateEndpoints(context.Background(), testEndpoints())
+	require.NoError(t, err)
+	assert.Equal(t, want, host.File("/etc/coredns/lan"), "serial should be kept without changes")
+	assert.Len(t, host.Commands(), 1, "should not reload without changes")
+
+	err = p.UpdateEndpoints(context.Background(), testEndpoints()[:1])
+	require.NoError(t, err)
+	assert.Contains(t, host.File("/etc/coredns/lan"), "SOA\tns.lan. hostmaster.lan. 1700000001 ", "serial should be increased")
+	assert.NotContains(t, host.File("/etc/coredns/lan"), "host2.lan.")
+	assert.Len(t, host.Commands(), 2)
+}
+
+func TestNewCoreDNSProvider_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config  CoreDNSProviderConfig
+		wantErr string
+	}{
+		"invalid format": {
+			config:  CoreDNSProviderConfig{Format: "json", File: "/etc/coredns/lan"},
+			wantErr: `invalid format "json", must be "hosts" or "zone"`,
+		},
+		"zone without record suffix": {
+			config:  CoreDNSProviderConfig{Format: FormatZone, File: "/etc/coredns/lan"},
+			wantErr: "record_suffix is required for the zone format",
+		},
+		"missing file": {
+			config:  CoreDNSProviderConfig{},
+			wantErr: "file is required",
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewCoreDNSProvider("test", tc.config, nil)
+			assert.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
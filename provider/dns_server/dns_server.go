@@ -0,0 +1,540 @@
+package dnsserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/config/configtypes"
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/rdns"
+	"github.com/sapslaj/zonepop/pkg/recordset"
+	"github.com/sapslaj/zonepop/pkg/utils"
+	"github.com/sapslaj/zonepop/provider"
+)
+
+const (
+	DefaultListenAddress = ":53"
+	DefaultNegativeTTL   = 60
+	// Timeout of forwarded queries and NOTIFY messages
+	exchangeTimeout = 5 * time.Second
+	// Records per message of zone transfers
+	transferChunkSize = 100
+)
+
+type DNSServerProviderConfig struct {
+	// Address the server listens on for UDP and TCP (default ":53")
+	ListenAddress string
+	// Forward lookup zone served, also appended to hostnames
+	RecordSuffix string
+	// Reverse lookup zones served, e.g. "2.0.192.in-addr.arpa"
+	ReverseZones []string
+	// TTL of records of endpoints without one, also of the SOA and NS
+	// records (default 300)
+	DefaultTTL int64
+	// Name servers in the NS records of the zones (default "ns.<suffix>")
+	Nameservers []string
+	// Mailbox in the SOA records of the zones (default "hostmaster.<suffix>")
+	Hostmaster string
+	// TTL of negative answers (default 60)
+	NegativeTTL int64
+	// Addresses of secondary servers, which may transfer the zones with AXFR
+	// and are sent NOTIFY messages when they change (port defaults to 53)
+	Secondaries []string
+	// Upstream servers queries of names outside the zones are forwarded to,
+	// as host or host:port. Without any, those queries are refused.
+	Forwarders []string
+	// Networks of clients whose queries may be forwarded, in CIDR notation
+	// (default private, loopback and link-local networks)
+	AllowedNetworks []string
+	// How hostnames are normalized into valid DNS names
+	Normalization dnsname.Policy
+}
+
+// defaultAllowedNetworks are the networks queries may be forwarded for
+// without allowed_networks, so the server isn't an open resolver.
+var defaultAllowedNetworks = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+type dnsServerProvider struct {
+	config              DNSServerProviderConfig
+	forwardLookupFilter configtypes.EndpointFilterFunc
+	reverseLookupFilter configtypes.EndpointFilterFunc
+	logger              *zap.Logger
+	normalizer          *dnsname.Normalizer
+	zones               recordset.Zones
+	soaParams           soaParams
+	secondaries         []netip.AddrPort
+	allowedNetworks     []netip.Prefix
+	now                 func() time.Time
+
+	mu sync.RWMutex
+	// served zones by origin
+	served map[string]*zone
+
+	listenMu sync.Mutex
+	servers  []*dns.Server
+	// address the server listens on, with the port if it was 0
+	addr string
+}
+
+func NewDNSServerProvider(
+	name string,
+	providerConfig DNSServerProviderConfig,
+	forwardLookupFilter configtypes.EndpointFilterFunc,
+	reverseLookupFilter configtypes.EndpointFilterFunc,
+) (provider.Provider, error) {
+	suffix := strings.Trim(providerConfig.RecordSuffix, ".")
+	if suffix == "" {
+		return nil, errors.New("record_suffix is required")
+	}
+	if providerConfig.ListenAddress == "" {
+		providerConfig.ListenAddress = DefaultListenAddress
+	}
+	if providerConfig.DefaultTTL <= 0 {
+		providerConfig.DefaultTTL = recordset.DefaultTTL
+	}
+	if providerConfig.NegativeTTL <= 0 {
+		providerConfig.NegativeTTL = DefaultNegativeTTL
+	}
+	if len(providerConfig.Nameservers) == 0 {
+		providerConfig.Nameservers = []string{"ns." + suffix}
+	}
+	if providerConfig.Hostmaster == "" {
+		providerConfig.Hostmaster = "hostmaster." + suffix
+	}
+
+	zones := recordset.Zones{}
+	zones.Add(recordset.Zone{ID: suffix, Name: suffix})
+	for _, name := range providerConfig.ReverseZones {
+		if !strings.HasSuffix(recordset.Canonical(name), ".arpa.") {
+			return nil, fmt.Errorf("invalid reverse zone %q", name)
+		}
+		if !zones.Add(recordset.Zone{ID: name, Name: name}) {
+			return nil, fmt.Errorf("duplicate zone %q", name)
+		}
+	}
+
+	secondaries := make([]netip.AddrPort, 0, len(providerConfig.Secondaries))
+	for _, secondary := range providerConfig.Secondaries {
+		addrPort, err := netip.ParseAddrPort(secondary)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(secondary)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid secondary %q, must be an IP address with optional port", secondary)
+			}
+			addrPort = netip.AddrPortFrom(addr, 53)
+		}
+		secondaries = append(secondaries, netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()))
+	}
+	for i, forwarder := range providerConfig.Forwarders {
+		_, _, err := net.SplitHostPort(forwarder)
+		if err != nil {
+			providerConfig.Forwarders[i] = net.JoinHostPort(forwarder, "53")
+		}
+	}
+
+	if len(providerConfig.AllowedNetworks) == 0 {
+		providerConfig.AllowedNetworks = defaultAllowedNetworks
+	}
+	allowedNetworks := make([]netip.Prefix, 0, len(providerConfig.AllowedNetworks))
+	for _, network := range providerConfig.AllowedNetworks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed network %q, must be in CIDR notation", network)
+		}
+		allowedNetworks = append(allowedNetworks, prefix.Masked())
+	}
+
+	normalizer, err := dnsname.NewNormalizer(name, providerConfig.Normalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure hostname normalization: %w", err)
+	}
+	return &dnsServerProvider{
+		config:              providerConfig,
+		forwardLookupFilter: forwardLookupFilter,
+		reverseLookupFilter: reverseLookupFilter,
+		logger:              log.MustNewLogger().Named("dns_server_provider"),
+		normalizer:          normalizer,
+		zones:               zones,
+		soaParams: soaParams{
+			nameservers: nameservers(providerConfig.Nameservers),
+			hostmaster:  recordset.Canonical(providerConfig.Hostmaster),
+			ttl:         uint32(providerConfig.DefaultTTL),
+			negativeTTL: uint32(providerConfig.NegativeTTL),
+		},
+		secondaries:     secondaries,
+		allowedNetworks: allowedNetworks,
+		now:             time.Now,
+		served:          map[string]*zone{},
+	}, nil
+}
+
+func nameservers(names []string) []string {
+	canonical := make([]string, 0, len(names))
+	for _, name := range names {
+		canonical = append(canonical, recordset.Canonical(name))
+	}
+	return canonical
+}
+
+// UpdateEndpoints replaces the records served with those of the endpoints,
+// starts the server the first time and notifies the secondaries of changed
+// zones.
+func (p *dnsServerProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) error {
+	records, err := p.records(utils.Filter(p.forwardLookupFilter, endpoints), utils.Filter(p.reverseLookupFilter, endpoints))
+	if err != nil {
+		p.logger.Sugar().Errorw("could not generate records", "err", err)
+		return err
+	}
+
+	changed := make([]*zone, 0)
+	p.mu.Lock()
+	for _, zones := range [][]recordset.Zone{p.zones.Forward, p.zones.IPv4Reverse, p.zones.IPv6Reverse} {
+		for _, zone := range zones {
+			z, zoneChanged := newZone(zone.Name, records[zone.Name], p.soaParams, p.served[zone.Name], uint32(p.now().Unix()))
+			if zoneChanged {
+				p.logger.Sugar().Infof("serving %d records in zone %s with serial %d", len(z.records), z.origin, z.soa.Serial)
+				p.served[zone.Name] = z
+				changed = append(changed, z)
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	err = p.listen()
+	if err != nil {
+		p.logger.Sugar().Errorw("could not start DNS server", "address", p.config.ListenAddress, "err", err)
+		return err
+	}
+	for _, z := range changed {
+		err = multierr.Append(err, p.notify(ctx, z))
+	}
+	if err != nil {
+		p.logger.Sugar().Errorw("failed to notify secondaries", "err", err)
+	}
+	return err
+}
+
+// records returns the records of the endpoints by the zone they are served
+// in.
+func (p *dnsServerProvider) records(forwardEndpoints []*endpoint.Endpoint, reverseEndpoints []*endpoint.Endpoint) (map[string][]dns.RR, error) {
+	syncConfig := recordset.SyncConfig{
+		RecordSuffix: p.config.RecordSuffix,
+		Normalizer:   p.normalizer,
+		Logger:       p.logger,
+		DefaultTTL:   p.config.DefaultTTL,
+		TXTValue:     recordset.QuotedTXT,
+	}
+	forward, _ := recordset.Forward(forwardEndpoints, syncConfig)
+	ptrs, err := recordset.PTRs(reverseEndpoints, syncConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	records := map[string][]dns.RR{}
+	add := func(zones []recordset.Zone, desired recordset.Desired) {
+		for _, s := range desired {
+			zone := recordset.ZoneFor(zones, s.Name)
+			if zone == nil {
+				p.logger.Sugar().Debugf("skipping %s record %q outside of the zones served", s.Type, s.Name)
+				continue
+			}
+			for _, value := range s.Values {
+				rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", s.Name, s.TTL, s.Type, value))
+				if err != nil {
+					p.logger.Sugar().Warnw("skipping invalid record", "name", s.Name, "type", s.Type, "value", value, "err", err)
+					continue
+				}
+				records[zone.Name] = append(records[zone.Name], rr)
+			}
+		}
+	}
+	add(p.zones.Forward, forward)
+	add(p.zones.IPv4Reverse, ptrs[rdns.AddressKindIPv4])
+	add(p.zones.IPv6Reverse, ptrs[rdns.AddressKindIPv6])
+	return records, nil
+}
+
+// listen starts the UDP and TCP servers if they aren't running yet.
+func (p *dnsServerProvider) listen() error {
+	p.listenMu.Lock()
+	defer p.listenMu.Unlock()
+	if p.servers != nil {
+		return nil
+	}
+
+	packetConn, err := net.ListenPacket("udp", p.config.ListenAddress)
+	if err != nil {
+		return err
+	}
+	// the TCP server listens on the same port, in case it was 0
+	addr := packetConn.LocalAddr().String()
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		packetConn.Close()
+		return err
+	}
+	p.addr = addr
+	p.servers = []*dns.Server{
+		{PacketConn: packetConn, Handler: p},
+		{Listener: listener, Handler: p},
+	}
+	for _, server := range p.servers {
+		server := server
+		go func() {
+			err := server.ActivateAndServe()
+			if err != nil {
+				p.logger.Sugar().Errorw("DNS server stopped", "address", addr, "err", err)
+			}
+		}()
+	}
+	p.logger.Sugar().Infof("DNS server listening on %s", addr)
+	return nil
+}
+
+// shutdown stops the servers started by listen.
+func (p *dnsServerProvider) shutdown() {
+	p.listenMu.Lock()
+	defer p.listenMu.Unlock()
+	for _, server := range p.servers {
+		server.Shutdown()
+	}
+	p.servers = nil
+}
+
+// notify sends NOTIFY messages for the zone to the secondaries, which then
+// transfer the changed zone.
+func (p *dnsServerProvider) notify(ctx context.Context, z *zone) error {
+	var errs error
+	client := &dns.Client{Timeout: exchangeTimeout}
+	for _, secondary := range p.secondaries {
+		m := new(dns.Msg)
+		m.SetNotify(z.origin)
+		m.Answer = []dns.RR{z.soa}
+		p.logger.Sugar().Infof("notifying %s of zone %s", secondary, z.origin)
+		resp, _, err := client.ExchangeContext(ctx, m, secondary.String())
+		if err == nil && resp.Rcode != dns.RcodeSuccess {
+			err = fmt.Errorf("response code %s", dns.RcodeToString[resp.Rcode])
+		}
+		if err != nil {
+			MetricNotifies.WithLabelValues(zoneLabel(z), "error").Inc()
+			errs = multierr.Append(errs, fmt.Errorf("could not notify %s of zone %s: %w", secondary, z.origin, err))
+			continue
+		}
+		MetricNotifies.WithLabelValues(zoneLabel(z), "success").Inc()
+	}
+	return errs
+}
+
+// zoneFor returns the most specific served zone name is in, or nil.
+func (p *dnsServerProvider) zoneFor(name string) *zone {
+	name = strings.ToLower(name)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var found *zone
+	for origin, z := range p.served {
+		if dns.IsSubDomain(origin, name) && (found == nil || len(origin) > len(found.origin)) {
+			found = z
+		}
+	}
+	return found
+}
+
+func zoneLabel(z *zone) string {
+	if z == nil {
+		return ""
+	}
+	return strings.TrimSuffix(z.origin, ".")
+}
+
+// ServeDNS implements [dns.Handler]. Queries of names in the zones are
+// answered authoritatively, zone transfers are allowed for secondaries over
+// TCP and other queries of clients in the allowed networks are forwarded
+// upstream, others are refused.
+func (p *dnsServerProvider) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
+	_, tcp := w.RemoteAddr().(*net.TCPAddr)
+	remote := remoteAddr(w)
+	recursion := len(p.config.Forwarders) > 0 && p.isAllowed(remote)
+
+	var z *zone
+	qtype := ""
+	m := new(dns.Msg)
+	switch {
+	case r.Opcode != dns.OpcodeQuery:
+		m.SetRcode(r, dns.RcodeNotImplemented)
+	case len(r.Question) != 1:
+		m.SetRcodeFormatError(r)
+	default:
+		q := r.Question[0]
+		qtype = queryTypeLabel(q.Qtype)
+		z = p.zoneFor(q.Name)
+		switch {
+		case z == nil && recursion:
+			m = p.forward(r, tcp)
+		case z == nil || q.Qclass != dns.ClassINET:
+			m.SetRcode(r, dns.RcodeRefused)
+		case q.Qtype == dns.TypeIXFR && !tcp:
+			// the zone doesn't fit, so the client retries over TCP (RFC 1995)
+			m.SetReply(r)
+			m.Authoritative = true
+			m.Answer = []dns.RR{z.soa}
+		case q.Qtype == dns.TypeAXFR && !tcp:
+			m.SetRcode(r, dns.RcodeRefused)
+		case q.Qtype == dns.TypeAXFR || q.Qtype == dns.TypeIXFR:
+			// IXFR is answered with the full zone (RFC 1995)
+			if p.transfer(w, r, z, remote) {
+				p.observe(z, qtype, dns.RcodeSuccess, start)
+				return
+			}
+			m.SetRcode(r, dns.RcodeRefused)
+		default:
+			m.SetReply(r)
+			m.Authoritative = true
+			z.answer(m, q)
+		}
+	}
+
+	if m.IsEdns0() == nil {
+		if opt := r.IsEdns0(); opt != nil {
+			m.SetEdns0(dns.DefaultMsgSize, false)
+		}
+	}
+	m.RecursionAvailable = recursion
+	if !tcp {
+		size := dns.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+			size = int(opt.UDPSize())
+		}
+		m.Truncate(size)
+	}
+	err := w.WriteMsg(m)
+	if err != nil {
+		p.logger.Sugar().Debugw("could not write response", "remote", w.RemoteAddr(), "err", err)
+	}
+	p.observe(z, qtype, m.Rcode, start)
+}
+
+// metricQueryTypes are the query types counted by name in the queries
+// metric. Clients choose the query type, so others are counted as OTHER to
+// keep the number of series bounded.
+var metricQueryTypes = map[uint16]bool{
+	dns.TypeA:     true,
+	dns.TypeAAAA:  true,
+	dns.TypePTR:   true,
+	dns.TypeSOA:   true,
+	dns.TypeNS:    true,
+	dns.TypeCNAME: true,
+	dns.TypeTXT:   true,
+	dns.TypeMX:    true,
+	dns.TypeSRV:   true,
+	dns.TypeANY:   true,
+	dns.TypeAXFR:  true,
+	dns.TypeIXFR:  true,
+}
+
+func queryTypeLabel(qtype uint16) string {
+	if metricQueryTypes[qtype] {
+		return dns.TypeToString[qtype]
+	}
+	return "OTHER"
+}
+
+func (p *dnsServerProvider) observe(z *zone, qtype string, rcode int, start time.Time) {
+	MetricQueries.WithLabelValues(zoneLabel(z), qtype, dns.RcodeToString[rcode]).Inc()
+	MetricQueryDurationSeconds.WithLabelValues(zoneLabel(z)).Observe(time.Since(start).Seconds())
+}
+
+// forward forwards the query to the first upstream server that answers.
+func (p *dnsServerProvider) forward(r *dns.Msg, tcp bool) *dns.Msg {
+	client := &dns.Client{Timeout: exchangeTimeout}
+	if tcp {
+		client.Net = "tcp"
+	}
+	var errs error
+	for _, forwarder := range p.config.Forwarders {
+		resp, _, err := client.Exchange(r, forwarder)
+		if err == nil {
+			return resp
+		}
+		errs = multierr.Append(errs, fmt.Errorf("%s: %w", forwarder, err))
+	}
+	p.logger.Sugar().Warnw("could not forward query", "question", r.Question[0].String(), "err", errs)
+	m := new(dns.Msg)
+	return m.SetRcode(r, dns.RcodeServerFailure)
+}
+
+// transfer sends the zone to a secondary over TCP. It reports false if the
+// transfer isn't allowed.
+func (p *dnsServerProvider) transfer(w dns.ResponseWriter, r *dns.Msg, z *zone, remote netip.Addr) bool {
+	if !p.isSecondary(remote) {
+		p.logger.Sugar().Warnw("refusing zone transfer", "zone", z.origin, "remote", w.RemoteAddr())
+		return false
+	}
+	p.logger.Sugar().Infof("transferring zone %s to %s", z.origin, w.RemoteAddr())
+	records := z.transfer()
+	ch := make(chan *dns.Envelope, len(records)/transferChunkSize+1)
+	for len(records) > 0 {
+		n := min(transferChunkSize, len(records))
+		ch <- &dns.Envelope{RR: records[:n]}
+		records = records[n:]
+	}
+	close(ch)
+	err := new(dns.Transfer).Out(w, r, ch)
+	if err != nil {
+		p.logger.Sugar().Errorw("zone transfer failed", "zone", z.origin, "remote", w.RemoteAddr(), "err", err)
+	}
+	return true
+}
+
+func (p *dnsServerProvider) isSecondary(addr netip.Addr) bool {
+	for _, secondary := range p.secondaries {
+		if secondary.Addr() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *dnsServerProvider) isAllowed(addr netip.Addr) bool {
+	for _, network := range p.allowedNetworks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr returns the address of the client, or the zero address if it
+// isn't known.
+func remoteAddr(w dns.ResponseWriter) netip.Addr {
+	var ip net.IP
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr.Unmap()
+}
@@ -0,0 +1,387 @@
+package dnsserver
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+// newTestUDPServer starts an in-process DNS server on the loopback interface
+// and returns its address.
+func newTestUDPServer(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &dns.Server{PacketConn: packetConn, Handler: handler}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return packetConn.LocalAddr().String()
+}
+
+// fakeSecondary records the zones of the NOTIFY messages it receives.
+type fakeSecondary struct {
+	mu       sync.Mutex
+	notifies []string
+}
+
+func (s *fakeSecondary) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := new(dns.Msg)
+	if r.Opcode != dns.OpcodeNotify {
+		w.WriteMsg(m.SetRcode(r, dns.RcodeRefused))
+		return
+	}
+	s.notifies = append(s.notifies, r.Question[0].Name)
+	w.WriteMsg(m.SetReply(r))
+}
+
+func (s *fakeSecondary) takeNotifies() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notifies := s.notifies
+	s.notifies = nil
+	sort.Strings(notifies)
+	return notifies
+}
+
+func newTestProvider(t *testing.T, config DNSServerProviderConfig) *dnsServerProvider {
+	config.ListenAddress = "127.0.0.1:0"
+	config.RecordSuffix = ".lan"
+	config.ReverseZones = []string{"2.0.192.in-addr.arpa"}
+	p, err := NewDNSServerProvider(
+		"test",
+		config,
+		func(e *endpoint.Endpoint) bool { return true },
+		func(e *endpoint.Endpoint) bool { return true },
+	)
+	require.NoError(t, err)
+	dp := p.(*dnsServerProvider)
+	dp.now = func() time.Time {
+		return time.Unix(1700000000, 0)
+	}
+	t.Cleanup(dp.shutdown)
+	return dp
+}
+
+func testEndpoints() []*endpoint.Endpoint {
+	return []*endpoint.Endpoint{
+		{Hostname: "host1", IPv4s: []string{"192.0.2.1"}, IPv6s: []string{"2001:db8::1"}, Aliases: []string{"nas"}},
+		{Hostname: "host2", IPv4s: []string{"192.0.2.2"}, RecordTTL: 60, Records: []endpoint.Record{
+			{Type: endpoint.RecordTypeTXT, Name: "host2", Value: "v=spf1 -all"},
+			{Type: endpoint.RecordTypeSRV, Name: "_sip._tcp.host2", Value: "10 20 5060 host2"},
+		}},
+	}
+}
+
+func query(t *testing.T, addr string, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	resp, _, err := new(dns.Client).Exchange(m, addr)
+	require.NoError(t, err)
+	return resp
+}
+
+func rrStrings(rrs []dns.RR) []string {
+	strs := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		strs = append(strs, strings.ReplaceAll(rr.String(), "\t", " "))
+	}
+	return strs
+}
+
+func TestServeDNS(t *testing.T) {
+	t.Parallel()
+
+	upstream := newTestUDPServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.RecursionAvailable = true
+		rr, _ := dns.NewRR(r.Question[0].Name + " 30 IN A 198.51.100.1")
+		m.Answer = append(m.Answer, rr)
+		w.WriteMsg(m)
+	})
+	p := newTestProvider(t, DNSServerProviderConfig{Forwarders: []string{upstream}})
+	require.NoError(t, p.UpdateEndpoints(context.Background(), testEndpoints()))
+
+	soa := "lan. 60 IN SOA ns.lan. hostmaster.lan. 1700000000 3600 600 604800 60"
+	tests := map[string]struct {
+		name          string
+		qtype         uint16
+		wantRcode     int
+		wantAuthority bool
+		wantAnswer    []string
+		wantNs        []string
+	}{
+		"address": {
+			name:          "host1.lan.",
+			qtype:         dns.TypeA,
+			wantRcode:     dns.RcodeSuccess,
+			wantAuthority: true,
+			wantAnswer:    []string{"host1.lan. 300 IN A 192.0.2.1"},
+		},
+		"case of the question": {
+			name:          "HOST1.lan.",
+			qtype:         dns.TypeAAAA,
+			wantRcode:     dns.RcodeSuccess,
+			wantAuthority: true,
+			wantAnswer:    []string{"HOST1.lan. 300 IN AAAA 2001:db8::1"},
+		},
+		"alias": {
+			name:          "nas.lan.",
+			qtype:         dns.TypeA,
+			wantRcode:     dns.RcodeSuccess,
+			wantAuthority: true,
+			wantAnswer:    []string{"nas.lan. 300 IN CNAME host1.lan.", "host1.lan. 300 IN A 192.0.2.1"},
+		},
+		"TXT record": {
+			name:          "host2.lan.",
+			qtype:         dns.TypeTXT,
+			wantRcode:     dns.RcodeSuccess,
+			wantAuthority: true,
+			wantAnswer:    []string{`host2.lan. 60 IN TXT "v=spf1 -all"`},
+		},
+		"SRV record": {
+			name:          "_sip._tcp.host2.lan.",
+			qtype:         dns.TypeSRV,
+			wantRcode:     dns.RcodeSuccess,
+			wantAuthority: true,
+			wantAnswer:    []string{"_sip._tcp.host2.lan. 60 IN SRV 10 20 5060 host2.lan."},
+		},
+		"PTR record": {
+			name:          "1.2.0.192.in-addr.arpa.",
+			qtype:         dns.TypePTR,
+			wantRcode:     dns.RcodeSuccess,
+			wantAuthority: true,
+			wantAnswer:    []string{"1.2.0.192.in-addr.arpa. 300 IN PTR host1.lan."},
+		},
+		"SOA": {
+			name:          "lan.",
+			qtype:         dns.TypeSOA,
+			wantRcode:     dns.RcodeSuccess,
+			wantAuthority: true,
+			wantAnswer:    []string{"lan. 300 IN SOA ns.lan. hostmaster.lan. 1700000000 3600 600 604800 60"},
+		},
+		"NS": {
+			name:          "lan.",
+			qtype:         dns.TypeNS,
+			wantRcode:     dns.RcodeSuccess,
+			wantAuthority: true,
+			wantAnswer:    []string{"lan. 300 IN NS ns.lan."},
+		},
+		"missing name": {
+			name:          "missing.lan.",
+			qtype:         dns.TypeA,
+			wantRcode:     dns.RcodeNameError,
+			wantAuthority: true,
+			wantNs:        []string{soa},
+		},
+		"missing type": {
+			name:          "host1.lan.",
+			qtype:         dns.TypeMX,
+			wantRcode:     dns.RcodeSuccess,
+			wantAuthority: true,
+			wantNs:        []string{soa},
+		},
+		"empty non-terminal": {
+			name:          "_tcp.host2.lan.",
+			qtype:         dns.TypeSRV,
+			wantRcode:     dns.RcodeSuccess,
+			wantAuthority: true,
+			wantNs:        []string{soa},
+		},
+		"missing PTR record": {
+			name:          "9.2.0.192.in-addr.arpa.",
+			qtype:         dns.TypePTR,
+			wantRcode:     dns.RcodeNameError,
+			wantAuthority: true,
+			wantNs:        []string{"2.0.192.in-addr.arpa. 60 IN SOA ns.lan. hostmaster.lan. 1700000000 3600 600 604800 60"},
+		},
+		"IXFR over UDP": {
+			name:          "lan.",
+			qtype:         dns.TypeIXFR,
+			wantRcode:     dns.RcodeSuccess,
+			wantAuthority: true,
+			wantAnswer:    []string{"lan. 300 IN SOA ns.lan. hostmaster.lan. 1700000000 3600 600 604800 60"},
+		},
+		"forwarded": {
+			name:       "example.com.",
+			qtype:      dns.TypeA,
+			wantRcode:  dns.RcodeSuccess,
+			wantAnswer: []string{"example.com. 30 IN A 198.51.100.1"},
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			resp := query(t, p.addr, tc.name, tc.qtype)
+			assert.Equal(t, dns.RcodeToString[tc.wantRcode], dns.RcodeToString[resp.Rcode])
+			assert.Equal(t, tc.wantAuthority, resp.Authoritative)
+			assert.True(t, resp.RecursionAvailable)
+			assert.Equal(t, tc.wantAnswer, nilIfEmpty(rrStrings(resp.Answer)))
+			assert.Equal(t, tc.wantNs, nilIfEmpty(rrStrings(resp.Ns)))
+		})
+	}
+}
+
+func nilIfEmpty(s []string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+func TestServeDNS_Refused(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProvider(t, DNSServerProviderConfig{})
+	require.NoError(t, p.UpdateEndpoints(context.Background(), testEndpoints()))
+
+	refused := testutil.ToFloat64(MetricQueries.WithLabelValues("", "AAAA", "REFUSED"))
+	resp := query(t, p.addr, "example.com.", dns.TypeAAAA)
+	assert.Equal(t, dns.RcodeRefused, resp.Rcode)
+	assert.False(t, resp.RecursionAvailable)
+	assert.Equal(t, refused+1, testutil.ToFloat64(MetricQueries.WithLabelValues("", "AAAA", "REFUSED")))
+
+	m := new(dns.Msg)
+	m.SetAxfr("lan.")
+	resp, _, err := (&dns.Client{Net: "tcp"}).Exchange(m, p.addr)
+	require.NoError(t, err)
+	assert.Equal(t, dns.RcodeRefused, resp.Rcode, "transfers should only be allowed for secondaries")
+
+	resp = query(t, p.addr, "lan.", dns.TypeAXFR)
+	assert.Equal(t, dns.RcodeRefused, resp.Rcode, "transfers should only be allowed over TCP")
+}
+
+func TestServeDNS_QueryTypeMetric(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProvider(t, DNSServerProviderConfig{})
+	require.NoError(t, p.UpdateEndpoints(context.Background(), testEndpoints()))
+
+	other := testutil.ToFloat64(MetricQueries.WithLabelValues("lan", "OTHER", "NOERROR"))
+	query(t, p.addr, "host1.lan.", 12345)
+	query(t, p.addr, "host1.lan.", dns.TypeHINFO)
+	assert.Equal(t, other+2, testutil.ToFloat64(MetricQueries.WithLabelValues("lan", "OTHER", "NOERROR")))
+	assert.Zero(t, testutil.ToFloat64(MetricQueries.WithLabelValues("lan", "TYPE12345", "NOERROR")))
+}
+
+func TestServeDNS_ForwardingNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	forwarded := 0
+	upstream := newTestUDPServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		forwarded++
+		m := new(dns.Msg)
+		w.WriteMsg(m.SetReply(r))
+	})
+	p := newTestProvider(t, DNSServerProviderConfig{
+		Forwarders:      []string{upstream},
+		AllowedNetworks: []string{"192.0.2.0/24"},
+	})
+	require.NoError(t, p.UpdateEndpoints(context.Background(), testEndpoints()))
+
+	resp := query(t, p.addr, "example.com.", dns.TypeA)
+	assert.Equal(t, dns.RcodeRefused, resp.Rcode)
+	assert.False(t, resp.RecursionAvailable)
+	assert.Zero(t, forwarded, "queries of clients outside the allowed networks should not be forwarded")
+
+	resp = query(t, p.addr, "host1.lan.", dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode, "the zones should be served to all clients")
+}
+
+func TestUpdateEndpoints_Secondaries(t *testing.T) {
+	t.Parallel()
+
+	secondary := &fakeSecondary{}
+	secondaryAddr := newTestUDPServer(t, secondary.ServeDNS)
+	p := newTestProvider(t, DNSServerProviderConfig{Secondaries: []string{secondaryAddr}})
+
+	notifies := testutil.ToFloat64(MetricNotifies.WithLabelValues("2.0.192.in-addr.arpa", "success"))
+	require.NoError(t, p.UpdateEndpoints(context.Background(), testEndpoints()))
+	assert.Equal(t, []string{"2.0.192.in-addr.arpa.", "lan."}, secondary.takeNotifies())
+	assert.Equal(t, notifies+1, testutil.ToFloat64(MetricNotifies.WithLabelValues("2.0.192.in-addr.arpa", "success")))
+
+	m := new(dns.Msg)
+	m.SetAxfr("lan.")
+	envelopes, err := new(dns.Transfer).In(m, p.addr)
+	require.NoError(t, err)
+	records := make([]dns.RR, 0)
+	for envelope := range envelopes {
+		require.NoError(t, envelope.Error)
+		records = append(records, envelope.RR...)
+	}
+	assert.Equal(t, []string{
+		"lan. 300 IN SOA ns.lan. hostmaster.lan. 1700000000 3600 600 604800 60",
+		"_sip._tcp.host2.lan. 60 IN SRV 10 20 5060 host2.lan.",
+		"host1.lan. 300 IN A 192.0.2.1",
+		"host1.lan. 300 IN AAAA 2001:db8::1",
+		"host2.lan. 60 IN A 192.0.2.2",
+		`host2.lan. 60 IN TXT "v=spf1 -all"`,
+		"lan. 300 IN NS ns.lan.",
+		"nas.lan. 300 IN CNAME host1.lan.",
+		"lan. 300 IN SOA ns.lan. hostmaster.lan. 1700000000 3600 600 604800 60",
+	}, rrStrings(records))
+
+	require.NoError(t, p.UpdateEndpoints(context.Background(), testEndpoints()))
+	assert.Empty(t, secondary.takeNotifies(), "unchanged zones should not be notified")
+
+	require.NoError(t, p.UpdateEndpoints(context.Background(), testEndpoints()[:1]))
+	assert.Equal(t, []string{"2.0.192.in-addr.arpa.", "lan."}, secondary.takeNotifies())
+	resp := query(t, p.addr, "lan.", dns.TypeSOA)
+	assert.Equal(t, uint32(1700000001), resp.Answer[0].(*dns.SOA).Serial, "serial should be increased")
+}
+
+func TestNewDNSServerProvider_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config  DNSServerProviderConfig
+		wantErr string
+	}{
+		"missing record suffix": {
+			config:  DNSServerProviderConfig{},
+			wantErr: "record_suffix is required",
+		},
+		"invalid reverse zone": {
+			config:  DNSServerProviderConfig{RecordSuffix: "lan", ReverseZones: []string{"example.com"}},
+			wantErr: `invalid reverse zone "example.com"`,
+		},
+		"invalid allowed network": {
+			config:  DNSServerProviderConfig{RecordSuffix: "lan", AllowedNetworks: []string{"192.168.1.1"}},
+			wantErr: `invalid allowed network "192.168.1.1", must be in CIDR notation`,
+		},
+		"invalid secondary": {
+			config:  DNSServerProviderConfig{RecordSuffix: "lan", Secondaries: []string{"ns2.lan"}},
+			wantErr: `invalid secondary "ns2.lan", must be an IP address with optional port`,
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewDNSServerProvider("test", tc.config, nil, nil)
+			assert.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
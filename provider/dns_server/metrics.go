@@ -0,0 +1,37 @@
+package dnsserver
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sapslaj/zonepop/pkg/metrics"
+)
+
+const MetricSubsystem = "dns_server"
+
+var (
+	MetricQueries = metrics.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: MetricSubsystem,
+			Name:      "queries",
+			Help:      "Number of answered queries by zone (empty for forwarded and refused queries of other names), query type (OTHER for uncommon ones) and response code",
+		},
+		[]string{"zone", "type", "rcode"},
+	)
+	MetricQueryDurationSeconds = metrics.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: MetricSubsystem,
+			Name:      "query_duration_seconds",
+			Help:      "Time it took to answer queries by zone (empty for forwarded and refused queries of other names)",
+			Buckets:   []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+		},
+		[]string{"zone"},
+	)
+	MetricNotifies = metrics.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: MetricSubsystem,
+			Name:      "notifies",
+			Help:      "Number of NOTIFY messages sent to secondaries by zone and result",
+		},
+		[]string{"zone", "result"},
+	)
+)
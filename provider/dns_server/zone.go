@@ -0,0 +1,183 @@
+package dnsserver
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain is the number of CNAMEs followed within a zone when
+// answering a query.
+const maxCNAMEChain = 8
+
+// zone is the immutable content of a zone served by the server.
+type zone struct {
+	origin string
+	soa    *dns.SOA
+	// records other than the SOA, sorted, as they are transferred
+	records []dns.RR
+	// all records by lower case name and type
+	names map[string]map[uint16][]dns.RR
+}
+
+// soaParams are the fields of the synthesized SOA and NS records that don't
+// change with the records of the zone.
+type soaParams struct {
+	nameservers []string
+	hostmaster  string
+	ttl         uint32
+	negativeTTL uint32
+}
+
+// newZone returns a zone with records and synthesized SOA and NS records.
+// If the records are the same as those of the previous zone, the previous
+// zone is returned with changed false, otherwise the serial is increased to
+// now (in Unix time) or at least by one.
+func newZone(origin string, records []dns.RR, params soaParams, previous *zone, now uint32) (z *zone, changed bool) {
+	for _, nameserver := range params.nameservers {
+		records = append(records, &dns.NS{
+			Hdr: dns.RR_Header{Name: origin, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: params.ttl},
+			Ns:  nameserver,
+		})
+	}
+	sorted := make([]dns.RR, 0, len(records))
+	seen := map[string]bool{}
+	for _, rr := range records {
+		s := rr.String()
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		sorted = append(sorted, rr)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].String() < sorted[j].String()
+	})
+
+	var serial uint32
+	if previous != nil {
+		if sameRecords(previous.records, sorted) {
+			return previous, false
+		}
+		serial = previous.soa.Serial + 1
+	}
+	if now > serial {
+		serial = now
+	}
+
+	z = &zone{
+		origin: origin,
+		soa: &dns.SOA{
+			Hdr:     dns.RR_Header{Name: origin, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: params.ttl},
+			Ns:      params.nameservers[0],
+			Mbox:    params.hostmaster,
+			Serial:  serial,
+			Refresh: 3600,
+			Retry:   600,
+			Expire:  604800,
+			Minttl:  params.negativeTTL,
+		},
+		records: sorted,
+		names:   map[string]map[uint16][]dns.RR{},
+	}
+	for _, rr := range append([]dns.RR{z.soa}, sorted...) {
+		name := strings.ToLower(rr.Header().Name)
+		if z.names[name] == nil {
+			z.names[name] = map[uint16][]dns.RR{}
+		}
+		z.names[name][rr.Header().Rrtype] = append(z.names[name][rr.Header().Rrtype], rr)
+	}
+	return z, true
+}
+
+func sameRecords(a []dns.RR, b []dns.RR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].String() != b[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
+// transfer returns the records of a zone transfer: all records, starting and
+// ending with the SOA.
+func (z *zone) transfer() []dns.RR {
+	records := make([]dns.RR, 0, len(z.records)+2)
+	records = append(records, z.soa)
+	records = append(records, z.records...)
+	return append(records, z.soa)
+}
+
+// answer fills in the answer of m to a question about a name in the zone.
+// Names that don't exist get NXDOMAIN and names without records of the type
+// an empty answer, both with the SOA in the authority section for negative
+// caching (RFC 2308).
+func (z *zone) answer(m *dns.Msg, q dns.Question) {
+	name := q.Name
+	for i := 0; i <= maxCNAMEChain; i++ {
+		rrsets, ok := z.names[strings.ToLower(name)]
+		if !ok {
+			if !z.hasDescendants(name) {
+				m.Rcode = dns.RcodeNameError
+			}
+			m.Ns = append(m.Ns, z.negativeSOA())
+			return
+		}
+
+		var answer []dns.RR
+		switch q.Qtype {
+		case dns.TypeANY:
+			for _, rrset := range rrsets {
+				answer = append(answer, rrset...)
+			}
+		default:
+			answer = rrsets[q.Qtype]
+		}
+		if len(answer) == 0 && q.Qtype != dns.TypeCNAME {
+			answer = rrsets[dns.TypeCNAME]
+		}
+		if len(answer) == 0 {
+			m.Ns = append(m.Ns, z.negativeSOA())
+			return
+		}
+		for _, rr := range answer {
+			// the name of the question is used as is, in case the resolver
+			// relies on its case (draft-vixie-dnsext-dns0x20)
+			rr = dns.Copy(rr)
+			rr.Header().Name = name
+			m.Answer = append(m.Answer, rr)
+		}
+
+		cname, ok := answer[0].(*dns.CNAME)
+		if !ok || q.Qtype == dns.TypeCNAME || !dns.IsSubDomain(z.origin, strings.ToLower(cname.Target)) {
+			return
+		}
+		name = cname.Target
+	}
+}
+
+// hasDescendants reports whether there are records below name, which makes
+// it an empty non-terminal name that exists (RFC 8020).
+func (z *zone) hasDescendants(name string) bool {
+	suffix := "." + strings.ToLower(name)
+	for existing := range z.names {
+		if strings.HasSuffix(existing, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// negativeSOA returns the SOA for negative answers, with the TTL of negative
+// answers.
+func (z *zone) negativeSOA() dns.RR {
+	soa := dns.Copy(z.soa).(*dns.SOA)
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+	return soa
+}
@@ -0,0 +1,168 @@
+package dnsmasq
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/config/configtypes"
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/configfile"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/recordset"
+	"github.com/sapslaj/zonepop/pkg/utils"
+	"github.com/sapslaj/zonepop/provider"
+)
+
+type DnsmasqProviderConfig struct {
+	RecordSuffix string
+	// TTL of records of endpoints without one (default 300)
+	DefaultTTL int64
+	// Config file, e.g. in /etc/dnsmasq.d
+	File        string
+	Permissions string
+	// Write the file and reload dnsmasq on a remote host
+	SSH configfile.SSHConfig
+	// How dnsmasq is reloaded after the file changed
+	Reload configfile.ReloadConfig
+	// How hostnames are normalized into valid DNS names
+	Normalization dnsname.Policy
+}
+
+type dnsmasqProvider struct {
+	config              DnsmasqProviderConfig
+	file                configfile.Config
+	fileState           configfile.State
+	forwardLookupFilter configtypes.EndpointFilterFunc
+	logger              *zap.Logger
+	normalizer          *dnsname.Normalizer
+	connect             configfile.ConnectFunc
+}
+
+func NewDnsmasqProvider(
+	name string,
+	providerConfig DnsmasqProviderConfig,
+	forwardLookupFilter configtypes.EndpointFilterFunc,
+) (provider.Provider, error) {
+	file, err := configfile.Config{
+		File:        providerConfig.File,
+		Permissions: providerConfig.Permissions,
+		SSH:         providerConfig.SSH,
+		Reload:      providerConfig.Reload,
+	}.WithDefaults()
+	if err != nil {
+		return nil, err
+	}
+	normalizer, err := dnsname.NewNormalizer(name, providerConfig.Normalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure hostname normalization: %w", err)
+	}
+	return &dnsmasqProvider{
+		config:              providerConfig,
+		file:                file,
+		forwardLookupFilter: forwardLookupFilter,
+		logger:              log.MustNewLogger().Named("dnsmasq_provider"),
+		normalizer:          normalizer,
+		connect:             configfile.Connect,
+	}, nil
+}
+
+func (p *dnsmasqProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) error {
+	forwardEndpoints := utils.Filter(p.forwardLookupFilter, endpoints)
+	content := p.configFile(forwardEndpoints)
+
+	host, err := p.connect(ctx, p.file.SSH)
+	if err != nil {
+		p.logger.Sugar().Errorw("could not connect to host", "host", p.file.SSH.Host, "err", err)
+		return err
+	}
+	defer func() {
+		err := host.Close()
+		if err != nil {
+			p.logger.Sugar().Errorw("error disconnecting from host", "host", p.file.SSH.Host, "err", err)
+		}
+	}()
+
+	_, err = configfile.Update(ctx, host, p.file, &p.fileState, p.logger, func(string) (string, error) {
+		return content, nil
+	})
+	if err != nil {
+		p.logger.Sugar().Errorw("failed to update dnsmasq config", "err", err)
+	}
+	return err
+}
+
+// configFile returns a dnsmasq config file with the records of the
+// endpoints. Addresses are host-record options, which also answer reverse
+// lookups.
+func (p *dnsmasqProvider) configFile(endpoints []*endpoint.Endpoint) string {
+	desired, _ := recordset.Forward(endpoints, recordset.SyncConfig{
+		RecordSuffix: p.config.RecordSuffix,
+		Normalizer:   p.normalizer,
+		Logger:       p.logger,
+		DefaultTTL:   p.config.DefaultTTL,
+	})
+	keys := make([]recordset.Key, 0, len(desired))
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Name != keys[j].Name {
+			return keys[i].Name < keys[j].Name
+		}
+		return keys[i].Type < keys[j].Type
+	})
+
+	var b strings.Builder
+	b.WriteString("# Generated by ZonePop\n")
+	for _, key := range keys {
+		s := desired[key]
+		name := strings.TrimSuffix(s.Name, ".")
+		for _, value := range s.Values {
+			option, err := configOption(name, s.Type, s.TTL, value)
+			if err != nil {
+				p.logger.Sugar().Warnw("skipping record", "name", name, "type", s.Type, "value", value, "err", err)
+				continue
+			}
+			b.WriteString(option + "\n")
+		}
+	}
+	return b.String()
+}
+
+// configOption returns the dnsmasq option for a record in the format of
+// [recordset.Set] values.
+func configOption(name string, recordType string, ttl int64, value string) (string, error) {
+	switch recordType {
+	case recordset.TypeA, recordset.TypeAAAA:
+		return fmt.Sprintf("host-record=%s,%s,%d", name, value, ttl), nil
+	case endpoint.RecordTypeCNAME:
+		return fmt.Sprintf("cname=%s,%s,%d", name, strings.TrimSuffix(value, "."), ttl), nil
+	case recordset.TypeTXT:
+		return fmt.Sprintf("txt-record=%s,%s", name, quote(value)), nil
+	case endpoint.RecordTypeMX:
+		fields := strings.Fields(value)
+		if len(fields) != 2 {
+			return "", fmt.Errorf("invalid MX record %q", value)
+		}
+		return fmt.Sprintf("mx-host=%s,%s,%s", name, strings.TrimSuffix(fields[1], "."), fields[0]), nil
+	case endpoint.RecordTypeSRV:
+		fields := strings.Fields(value)
+		if len(fields) != 4 {
+			return "", fmt.Errorf("invalid SRV record %q", value)
+		}
+		return fmt.Sprintf("srv-host=%s,%s,%s,%s,%s", name, strings.TrimSuffix(fields[3], "."), fields[2], fields[0], fields[1]), nil
+	}
+	return "", fmt.Errorf("unsupported record type %s", recordType)
+}
+
+// quote returns text as a quoted string of dnsmasq.
+func quote(text string) string {
+	text = strings.ReplaceAll(text, `\`, `\\`)
+	text = strings.ReplaceAll(text, `"`, `\"`)
+	return `"` + text + `"`
+}
@@ -0,0 +1,89 @@
+package dnsmasq
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/configfile"
+	"github.com/sapslaj/zonepop/pkg/configfile/configfiletest"
+)
+
+func TestUpdateEndpoints(t *testing.T) {
+	t.Parallel()
+
+	host := configfiletest.NewHost()
+	p, err := NewDnsmasqProvider("test", DnsmasqProviderConfig{
+		RecordSuffix: ".lan",
+		File:         "/etc/dnsmasq.d/zonepop.conf",
+		Reload:       configfile.ReloadConfig{Signal: "HUP", PIDFile: "/run/dnsmasq.pid"},
+	}, func(e *endpoint.Endpoint) bool { return e.Hostname != "filtered" })
+	require.NoError(t, err)
+	p.(*dnsmasqProvider).connect = func(ctx context.Context, c configfile.SSHConfig) (configfile.Host, error) {
+		return host, nil
+	}
+
+	endpoints := []*endpoint.Endpoint{
+		{Hostname: "host1", IPv4s: []string{"192.0.2.1"}, IPv6s: []string{"2001:db8::1"}, Aliases: []string{"nas"}, RecordTTL: 60},
+		{Hostname: "host2", IPv4s: []string{"192.0.2.2"}, Records: []endpoint.Record{
+			{Type: endpoint.RecordTypeTXT, Name: "host2", Value: `v=spf1 "quoted" -all`},
+			{Type: endpoint.RecordTypeMX, Name: "host2", Value: "10 host1"},
+			{Type: endpoint.RecordTypeSRV, Name: "_sip._tcp.host2", Value: "10 20 5060 host2"},
+		}},
+		{Hostname: "filtered", IPv4s: []string{"192.0.2.3"}},
+	}
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	assert.Equal(t, `# Generated by ZonePop
+srv-host=_sip._tcp.host2.lan,host2.lan,5060,10,20
+host-record=host1.lan,192.0.2.1,60
+host-record=host1.lan,2001:db8::1,60
+host-record=host2.lan,192.0.2.2,300
+mx-host=host2.lan,host1.lan,10
+txt-record=host2.lan,"v=spf1 \"quoted\" -all"
+cname=nas.lan,host1.lan,60
+`, host.File("/etc/dnsmasq.d/zonepop.conf"))
+	assert.Equal(t, []string{`kill -s HUP "$(cat '/run/dnsmasq.pid')"`}, host.Commands())
+
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	assert.Len(t, host.Commands(), 1, "should not reload without changes")
+}
+
+func TestUpdateEndpoints_RetriesFailedReload(t *testing.T) {
+	t.Parallel()
+
+	host := configfiletest.NewHost()
+	host.FailRuns(errors.New("no such process"))
+	p, err := NewDnsmasqProvider("test", DnsmasqProviderConfig{
+		File:   "/etc/dnsmasq.d/zonepop.conf",
+		Reload: configfile.ReloadConfig{Command: "systemctl reload dnsmasq"},
+	}, func(e *endpoint.Endpoint) bool { return true })
+	require.NoError(t, err)
+	p.(*dnsmasqProvider).connect = func(ctx context.Context, c configfile.SSHConfig) (configfile.Host, error) {
+		return host, nil
+	}
+
+	endpoints := []*endpoint.Endpoint{
+		{Hostname: "host1", IPv4s: []string{"192.0.2.1"}},
+	}
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	assert.ErrorContains(t, err, "could not reload: no such process")
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	assert.Len(t, host.Commands(), 2, "should retry the failed reload")
+	err = p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+	assert.Len(t, host.Commands(), 2, "should not reload again after the retry")
+}
+
+func TestNewDnsmasqProvider_Invalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewDnsmasqProvider("test", DnsmasqProviderConfig{}, nil)
+	assert.ErrorContains(t, err, "file is required")
+}
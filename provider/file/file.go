@@ -15,6 +15,7 @@ import (
 
 	"github.com/sapslaj/zonepop/config/configtypes"
 	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
 	"github.com/sapslaj/zonepop/pkg/gluamapper"
 	"github.com/sapslaj/zonepop/pkg/log"
 	"github.com/sapslaj/zonepop/pkg/rdns"
@@ -41,11 +42,14 @@ type FileProviderConfigFile struct {
 type FileProviderConfig struct {
 	SSH   FileProviderConfigSSH
 	Files []FileProviderConfigFile
+	// How hostnames of PTR records are normalized into valid DNS names
+	Normalization dnsname.Policy
 }
 
 type FileProvider struct {
 	Config              FileProviderConfig
 	Logger              *zap.Logger
+	Normalizer          *dnsname.Normalizer
 	ForwardLookupFilter configtypes.EndpointFilterFunc
 	ReverseLookupFilter configtypes.EndpointFilterFunc
 	State               *lua.LState
@@ -57,15 +61,21 @@ type TemplateResult struct {
 }
 
 func NewFileProvider(
+	name string,
 	state *lua.LState,
 	providerConfig FileProviderConfig,
 	forwardLookupFilter configtypes.EndpointFilterFunc,
 	reverseLookupFilter configtypes.EndpointFilterFunc,
 ) (provider.Provider, error) {
+	normalizer, err := dnsname.NewNormalizer(name, providerConfig.Normalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure hostname normalization: %w", err)
+	}
 	p := &FileProvider{
 		State:               state,
 		Config:              providerConfig,
 		Logger:              log.MustNewLogger().Named("file_provider"),
+		Normalizer:          normalizer,
 		ForwardLookupFilter: forwardLookupFilter,
 		ReverseLookupFilter: reverseLookupFilter,
 	}
@@ -99,6 +109,7 @@ func (p *FileProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoin
 		ptrs, err := rdns.PTRsForEndpoints(reverseEndpoints, rdns.Config{
 			Zone:         rdnsZone,
 			RecordSuffix: fileConfig.RecordSuffix,
+			Normalizer:   p.Normalizer,
 			Logger:       p.Logger,
 		})
 		if err != nil {
@@ -106,6 +117,8 @@ func (p *FileProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoin
 			return fmt.Errorf("error generating rDNS records: %w", err)
 		}
 
+		records := p.Normalizer.Records(forwardEndpoints, fileConfig.RecordSuffix, p.Logger)
+
 		var result string
 
 		if fileConfig.Generate != nil {
@@ -116,6 +129,7 @@ func (p *FileProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoin
 					fileConfig.Generate,
 					gluamapper.FromGoValue(co, forwardEndpoints),
 					gluamapper.FromGoValue(co, ptrs),
+					gluamapper.FromGoValue(co, records),
 				)
 
 				if err != nil {
@@ -152,11 +166,13 @@ func (p *FileProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoin
 				FileConfig FileProviderConfigFile
 				Endpoints  []*endpoint.Endpoint
 				PTRRecords []rdns.PTRRecord
+				Records    []endpoint.Record
 			}{
 				Config:     p.Config,
 				FileConfig: fileConfig,
 				Endpoints:  forwardEndpoints,
 				PTRRecords: ptrs,
+				Records:    records,
 			})
 			if err != nil {
 				logger.Error("failed to render template", zap.Error(err))
@@ -21,7 +21,7 @@ func TestFileProvider(t *testing.T) {
 	defer L.Close()
 
 	err := L.DoString(`
-		return function (endpoints, ptr_records)
+		return function (endpoints, ptr_records, records)
 			local result = ""
 			for _, endpoint in pairs(endpoints) do
 				for _, ipv4 in pairs(endpoint.ipv4s or {}) do
@@ -34,6 +34,9 @@ func TestFileProvider(t *testing.T) {
 			for _, ptr_record in pairs(ptr_records) do
 				result = result .. ptr_record.domain_name .. " PTR " .. ptr_record.full_hostname .. "\n"
 			end
+			for _, record in pairs(records) do
+				result = result .. record.name .. " " .. record.type .. " " .. record.value .. "\n"
+			end
 			return result
 		end
 	`)
@@ -51,17 +54,23 @@ func TestFileProvider(t *testing.T) {
 {{ end -}}
 {{ range $ptr := .PTRRecords -}}
 {{ $ptr.DomainName }} PTR {{ $ptr.FullHostname }}
+{{ end -}}
+{{ range $record := .Records -}}
+{{ $record.Name }} {{ $record.Type }} {{ $record.Value }}
 {{ end -}}`
 
 	expect := `test-host A 192.0.2.1
 test-host AAAA 2001:db8::1
 1.2.0.192.in-addr.arpa. PTR test-host
 1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. PTR test-host
+nas CNAME test-host
+test-host MX 10 mail
 `
 
 	tmpdir := t.TempDir()
 
 	p, err := NewFileProvider(
+		"test",
 		L,
 		FileProviderConfig{
 			Files: []FileProviderConfigFile{
@@ -86,6 +95,10 @@ test-host AAAA 2001:db8::1
 			IPv4s:     []string{"192.0.2.1"},
 			IPv6s:     []string{"2001:db8::1"},
 			RecordTTL: 60,
+			Aliases:   []string{"nas"},
+			Records: []endpoint.Record{
+				{Type: endpoint.RecordTypeMX, Value: "10 mail"},
+			},
 		},
 	})
 	require.NoError(t, err)
@@ -0,0 +1,350 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/config/configtypes"
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/accesstoken"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/recordset"
+	"github.com/sapslaj/zonepop/pkg/utils"
+	"github.com/sapslaj/zonepop/provider"
+)
+
+const DefaultEndpointURL = "https://dns.googleapis.com/dns/v1"
+
+type CloudDNSProviderConfig struct {
+	// Project of the managed zones (default is the project of the service
+	// account key)
+	Project      string
+	RecordSuffix string
+	// Names of the managed zones, not their DNS names
+	ForwardZone     string
+	Ipv4ReverseZone string
+	Ipv6ReverseZone string
+	// Additional zones, records are routed to the most specific zone
+	ForwardZones     []string
+	Ipv4ReverseZones []string
+	Ipv6ReverseZones []string
+	// Use all managed zones of the project in addition to the configured ones
+	DiscoverZones        bool
+	CleanForwardZone     bool
+	CleanIPv4ReverseZone bool
+	CleanIPv6ReverseZone bool
+	// TTL of records of endpoints without one (default 300)
+	DefaultTTL int64
+	// Service account key, either the JSON itself or a file containing it
+	// (default $GOOGLE_APPLICATION_CREDENTIALS). Without one, the metadata
+	// server provides the credentials, e.g. with GKE workload identity.
+	CredentialsJSON string
+	CredentialsFile string
+	// Cloud DNS API endpoint, e.g. of a local stand-in
+	EndpointURL string
+	// How hostnames are normalized into valid DNS names
+	Normalization dnsname.Policy
+}
+
+type cloudDNSProvider struct {
+	config              CloudDNSProviderConfig
+	forwardLookupFilter configtypes.EndpointFilterFunc
+	reverseLookupFilter configtypes.EndpointFilterFunc
+	logger              *zap.Logger
+	normalizer          *dnsname.Normalizer
+	httpClient          *http.Client
+	tokenSource         *accesstoken.Source
+	zoneNames           map[string]string
+	written             recordset.Written
+}
+
+type managedZone struct {
+	Name    string `json:"name"`
+	DNSName string `json:"dnsName"`
+}
+
+type resourceRecordSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int64    `json:"ttl"`
+	Rrdatas []string `json:"rrdatas"`
+}
+
+type change struct {
+	Additions []resourceRecordSet `json:"additions,omitempty"`
+	Deletions []resourceRecordSet `json:"deletions,omitempty"`
+	ID        string              `json:"id,omitempty"`
+	Status    string              `json:"status,omitempty"`
+}
+
+type apiError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func NewCloudDNSProvider(
+	name string,
+	providerConfig CloudDNSProviderConfig,
+	forwardLookupFilter configtypes.EndpointFilterFunc,
+	reverseLookupFilter configtypes.EndpointFilterFunc,
+) (provider.Provider, error) {
+	if providerConfig.EndpointURL == "" {
+		providerConfig.EndpointURL = DefaultEndpointURL
+	}
+	providerConfig.EndpointURL = strings.TrimSuffix(providerConfig.EndpointURL, "/")
+	httpClient := http.DefaultClient
+	tokenSource, keyProject, err := newTokenSource(providerConfig, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure GCP credentials: %w", err)
+	}
+	if providerConfig.Project == "" {
+		providerConfig.Project = keyProject
+	}
+	if providerConfig.Project == "" {
+		return nil, errors.New("project is required")
+	}
+	normalizer, err := dnsname.NewNormalizer(name, providerConfig.Normalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure hostname normalization: %w", err)
+	}
+	return &cloudDNSProvider{
+		config:              providerConfig,
+		forwardLookupFilter: forwardLookupFilter,
+		reverseLookupFilter: reverseLookupFilter,
+		logger:              log.MustNewLogger().Named("gcp_cloud_dns_provider"),
+		normalizer:          normalizer,
+		httpClient:          httpClient,
+		tokenSource:         tokenSource,
+		written:             recordset.Written{},
+	}, nil
+}
+
+func (p *cloudDNSProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) error {
+	zones, err := p.zones(ctx)
+	if err != nil {
+		p.logger.Sugar().Errorw("could not get Cloud DNS zones", "err", err)
+		return err
+	}
+	if p.config.RecordSuffix == "" && len(zones.Forward) > 0 {
+		p.config.RecordSuffix = "." + strings.TrimSuffix(zones.Forward[0].Name, ".")
+	}
+	err = recordset.Sync(
+		ctx,
+		p,
+		zones,
+		utils.Filter(p.forwardLookupFilter, endpoints),
+		utils.Filter(p.reverseLookupFilter, endpoints),
+		recordset.SyncConfig{
+			RecordSuffix:         p.config.RecordSuffix,
+			Normalizer:           p.normalizer,
+			Logger:               p.logger,
+			DefaultTTL:           p.config.DefaultTTL,
+			TXTValue:             recordset.QuotedTXT,
+			CleanForwardZone:     p.config.CleanForwardZone,
+			CleanIPv4ReverseZone: p.config.CleanIPv4ReverseZone,
+			CleanIPv6ReverseZone: p.config.CleanIPv6ReverseZone,
+			Written:              p.written,
+		},
+	)
+	if err != nil {
+		p.logger.Sugar().Errorw("failed to update Cloud DNS zones", "err", err)
+	}
+	return err
+}
+
+// zones returns the configured zones followed by the discovered ones.
+func (p *cloudDNSProvider) zones(ctx context.Context) (recordset.Zones, error) {
+	zones := recordset.Zones{}
+	configured := slices.Concat(
+		optionalZone(p.config.ForwardZone), p.config.ForwardZones,
+		optionalZone(p.config.Ipv4ReverseZone), p.config.Ipv4ReverseZones,
+		optionalZone(p.config.Ipv6ReverseZone), p.config.Ipv6ReverseZones,
+	)
+	for _, name := range configured {
+		dnsName, err := p.zoneDNSName(ctx, name)
+		if err != nil {
+			return zones, err
+		}
+		if !zones.Add(recordset.Zone{ID: name, Name: dnsName}) {
+			p.logger.Sugar().Warnf("ignoring zone %s, another zone is already named %s", name, dnsName)
+		}
+	}
+	if !p.config.DiscoverZones {
+		return zones, nil
+	}
+	discovered, err := p.listManagedZones(ctx)
+	if err != nil {
+		return zones, fmt.Errorf("could not discover zones: %w", err)
+	}
+	for _, z := range discovered {
+		if slices.Contains(configured, z.Name) {
+			continue
+		}
+		if !zones.Add(recordset.Zone{ID: z.Name, Name: z.DNSName}) {
+			p.logger.Sugar().Warnf("ignoring zone %s, another zone is already named %s", z.Name, z.DNSName)
+		}
+	}
+	return zones, nil
+}
+
+func optionalZone(name string) []string {
+	if name == "" {
+		return nil
+	}
+	return []string{name}
+}
+
+// zoneDNSName returns the DNS name of a managed zone, looking it up only once.
+func (p *cloudDNSProvider) zoneDNSName(ctx context.Context, name string) (string, error) {
+	if p.zoneNames == nil {
+		p.zoneNames = map[string]string{}
+	}
+	if dnsName, ok := p.zoneNames[name]; ok {
+		return dnsName, nil
+	}
+	var z managedZone
+	err := p.do(ctx, http.MethodGet, "/managedZones/"+url.PathEscape(name), nil, nil, &z)
+	if err != nil {
+		return "", fmt.Errorf("could not get managed zone %s: %w", name, err)
+	}
+	p.zoneNames[name] = recordset.Canonical(z.DNSName)
+	return p.zoneNames[name], nil
+}
+
+func (p *cloudDNSProvider) listManagedZones(ctx context.Context) ([]managedZone, error) {
+	zones := make([]managedZone, 0)
+	query := url.Values{}
+	for {
+		var res struct {
+			ManagedZones  []managedZone `json:"managedZones"`
+			NextPageToken string        `json:"nextPageToken"`
+		}
+		err := p.do(ctx, http.MethodGet, "/managedZones", query, nil, &res)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, res.ManagedZones...)
+		if res.NextPageToken == "" {
+			return zones, nil
+		}
+		query.Set("pageToken", res.NextPageToken)
+	}
+}
+
+// RecordSets implements [recordset.Client].
+func (p *cloudDNSProvider) RecordSets(ctx context.Context, zone recordset.Zone) ([]recordset.Set, error) {
+	sets := make([]recordset.Set, 0)
+	query := url.Values{}
+	for {
+		var res struct {
+			Rrsets        []resourceRecordSet `json:"rrsets"`
+			NextPageToken string              `json:"nextPageToken"`
+		}
+		err := p.do(ctx, http.MethodGet, "/managedZones/"+url.PathEscape(zone.ID)+"/rrsets", query, nil, &res)
+		if err != nil {
+			return nil, err
+		}
+		for _, rrs := range res.Rrsets {
+			sets = append(sets, recordset.Set{
+				Name:   rrs.Name,
+				Type:   rrs.Type,
+				TTL:    rrs.TTL,
+				Values: rrs.Rrdatas,
+			})
+		}
+		if res.NextPageToken == "" {
+			return sets, nil
+		}
+		query.Set("pageToken", res.NextPageToken)
+	}
+}
+
+// Apply implements [recordset.Client]. All changes of a zone are submitted as
+// one atomic change, with updates as deletion of the old and addition of the
+// new record set.
+func (p *cloudDNSProvider) Apply(ctx context.Context, zone recordset.Zone, changes recordset.Changes) error {
+	c := change{}
+	for _, s := range changes.Delete {
+		c.Deletions = append(c.Deletions, toResourceRecordSet(s))
+	}
+	for _, s := range changes.Create {
+		c.Additions = append(c.Additions, toResourceRecordSet(s))
+	}
+	for _, u := range changes.Update {
+		c.Deletions = append(c.Deletions, toResourceRecordSet(u.Old))
+		c.Additions = append(c.Additions, toResourceRecordSet(u.New))
+	}
+	var res change
+	err := p.do(ctx, http.MethodPost, "/managedZones/"+url.PathEscape(zone.ID)+"/changes", nil, c, &res)
+	if err != nil {
+		return err
+	}
+	p.logger.Sugar().Debugf("submitted change %s to zone %s with status %s", res.ID, zone.Name, res.Status)
+	return nil
+}
+
+func toResourceRecordSet(s recordset.Set) resourceRecordSet {
+	return resourceRecordSet{
+		Name:    s.Name,
+		Type:    s.Type,
+		TTL:     s.TTL,
+		Rrdatas: s.Values,
+	}
+}
+
+// do sends a request for a resource of the project to the API and decodes the
+// response into result.
+func (p *cloudDNSProvider) do(ctx context.Context, method string, path string, query url.Values, body any, result any) error {
+	token, err := p.tokenSource.Token(ctx)
+	if err != nil {
+		return err
+	}
+	u := p.config.EndpointURL + "/projects/" + url.PathEscape(p.config.Project) + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send %s %s request: %w", method, path, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		var apiErr apiError
+		_ = json.NewDecoder(res.Body).Decode(&apiErr)
+		return fmt.Errorf("%s %s failed with status %d: %s", method, path, res.StatusCode, apiErr.Error.Message)
+	}
+	err = json.NewDecoder(res.Body).Decode(result)
+	if err != nil {
+		return fmt.Errorf("could not decode %s %s response: %w", method, path, err)
+	}
+	return nil
+}
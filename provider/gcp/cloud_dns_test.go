@@ -0,0 +1,399 @@
+package gcp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+)
+
+func testPrivateKey(t *testing.T) *rsa.PrivateKey {
+	testKeyOnce.Do(func() {
+		var err error
+		testKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+	})
+	return testKey
+}
+
+// testCredentialsJSON returns a service account key with privateKey for the
+// token endpoint of server.
+func testCredentialsJSON(t *testing.T, server *httptest.Server, privateKey *rsa.PrivateKey) string {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	key, err := json.Marshal(serviceAccountKey{
+		Type:         "service_account",
+		ProjectID:    "test-project",
+		PrivateKeyID: "key-1",
+		PrivateKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		ClientEmail:  "zonepop@test-project.iam.gserviceaccount.com",
+		TokenURI:     server.URL + "/token",
+	})
+	require.NoError(t, err)
+	return string(key)
+}
+
+type fakeZone struct {
+	dnsName string
+	rrsets  []resourceRecordSet
+}
+
+// fakeCloudDNS is an in-memory stand-in for the OAuth token endpoint and the
+// managed zone endpoints of the Cloud DNS API.
+type fakeCloudDNS struct {
+	t       *testing.T
+	mu      sync.Mutex
+	zones   map[string]*fakeZone
+	changes []string
+	tokens  int
+}
+
+func newFakeCloudDNS(t *testing.T, zones map[string]*fakeZone) (*fakeCloudDNS, *httptest.Server) {
+	api := &fakeCloudDNS{t: t, zones: zones}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	return api, server
+}
+
+func (api *fakeCloudDNS) respond(w http.ResponseWriter, status int, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	require.NoError(api.t, json.NewEncoder(w).Encode(result))
+}
+
+func (api *fakeCloudDNS) error(w http.ResponseWriter, status int, message string) {
+	res := apiError{}
+	res.Error.Code = status
+	res.Error.Message = message
+	api.respond(w, status, res)
+}
+
+// page returns the items of the page of the request, two items per page.
+func page[T any](r *http.Request, items []T) ([]T, string) {
+	start, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+	end := min(start+2, len(items))
+	next := ""
+	if end < len(items) {
+		next = strconv.Itoa(end)
+	}
+	return items[start:end], next
+}
+
+func (api *fakeCloudDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		api.serveToken(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		api.error(w, http.StatusUnauthorized, "Request had invalid authentication credentials.")
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/dns/v1/projects/test-project/managedZones")
+	if !ok {
+		api.error(w, http.StatusNotFound, "The requested project was not found.")
+		return
+	}
+	if path == "" {
+		names := make([]string, 0, len(api.zones))
+		for name := range api.zones {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		zones := make([]managedZone, 0, len(names))
+		for _, name := range names {
+			zones = append(zones, managedZone{Name: name, DNSName: api.zones[name].dnsName})
+		}
+		zones, next := page(r, zones)
+		api.respond(w, http.StatusOK, map[string]any{"managedZones": zones, "nextPageToken": next})
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	z, ok := api.zones[parts[0]]
+	if !ok {
+		api.error(w, http.StatusNotFound, fmt.Sprintf("The 'parameters.managedZone' resource named '%s' does not exist.", parts[0]))
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		api.respond(w, http.StatusOK, managedZone{Name: parts[0], DNSName: z.dnsName})
+	case len(parts) == 2 && parts[1] == "rrsets" && r.Method == http.MethodGet:
+		rrsets, next := page(r, z.rrsets)
+		api.respond(w, http.StatusOK, map[string]any{"rrsets": rrsets, "nextPageToken": next})
+	case len(parts) == 2 && parts[1] == "changes" && r.Method == http.MethodPost:
+		var c change
+		require.NoError(api.t, json.NewDecoder(r.Body).Decode(&c))
+		rrsets := z.rrsets
+		for _, deletion := range c.Deletions {
+			i := indexOf(rrsets, deletion.Name, deletion.Type)
+			if i < 0 || !reflect.DeepEqual(rrsets[i], deletion) {
+				api.error(w, http.StatusPreconditionFailed, "conditionNotMet")
+				return
+			}
+			rrsets = append(rrsets[:i:i], rrsets[i+1:]...)
+			api.changes = append(api.changes, fmt.Sprintf("%s: delete %s %s", parts[0], deletion.Type, deletion.Name))
+		}
+		for _, addition := range c.Additions {
+			if indexOf(rrsets, addition.Name, addition.Type) >= 0 {
+				api.error(w, http.StatusConflict, "alreadyExists")
+				return
+			}
+			rrsets = append(rrsets, addition)
+			api.changes = append(api.changes, fmt.Sprintf("%s: add %s %s %s ttl=%d", parts[0], addition.Type, addition.Name, strings.Join(addition.Rrdatas, ","), addition.TTL))
+		}
+		z.rrsets = rrsets
+		api.respond(w, http.StatusOK, change{ID: "1", Status: "pending", Additions: c.Additions, Deletions: c.Deletions})
+	default:
+		api.error(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+func indexOf(rrsets []resourceRecordSet, name string, rrType string) int {
+	for i, rrs := range rrsets {
+		if rrs.Name == name && rrs.Type == rrType {
+			return i
+		}
+	}
+	return -1
+}
+
+// serveToken checks the signed JWT of a service account token request.
+func (api *fakeCloudDNS) serveToken(w http.ResponseWriter, r *http.Request) {
+	api.tokens++
+	require.NoError(api.t, r.ParseForm())
+	assert.Equal(api.t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.PostForm.Get("grant_type"))
+	parts := strings.Split(r.PostForm.Get("assertion"), ".")
+	require.Len(api.t, parts, 3)
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(api.t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(&testPrivateKey(api.t).PublicKey, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		api.respond(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "Invalid JWT Signature."})
+		return
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(api.t, err)
+	var claims map[string]any
+	require.NoError(api.t, json.Unmarshal(claimsJSON, &claims))
+	assert.Equal(api.t, "zonepop@test-project.iam.gserviceaccount.com", claims["iss"])
+	assert.Equal(api.t, cloudDNSScope, claims["scope"])
+	api.respond(w, http.StatusOK, map[string]any{"access_token": "test-token", "expires_in": 3599, "token_type": "Bearer"})
+}
+
+func newTestProvider(t *testing.T, server *httptest.Server, config CloudDNSProviderConfig) *cloudDNSProvider {
+	config.EndpointURL = server.URL + "/dns/v1"
+	if config.CredentialsJSON == "" {
+		config.CredentialsJSON = testCredentialsJSON(t, server, testPrivateKey(t))
+	}
+	p, err := NewCloudDNSProvider(
+		"test",
+		config,
+		func(e *endpoint.Endpoint) bool { return true },
+		func(e *endpoint.Endpoint) bool { return true },
+	)
+	require.NoError(t, err)
+	return p.(*cloudDNSProvider)
+}
+
+func testZones() map[string]*fakeZone {
+	return map[string]*fakeZone{
+		"example-com": {
+			dnsName: "example.com.",
+			rrsets: []resourceRecordSet{
+				{Name: "example.com.", Type: "NS", TTL: 21600, Rrdatas: []string{"ns-cloud-a1.googledomains.com."}},
+				{Name: "host1.example.com.", Type: "A", TTL: 300, Rrdatas: []string{"192.0.2.9"}},
+				{Name: "host1.example.com.", Type: "AAAA", TTL: 300, Rrdatas: []string{"2001:db8::1"}},
+				{Name: "manual.example.com.", Type: "A", TTL: 300, Rrdatas: []string{"192.0.2.10"}},
+			},
+		},
+		"reverse-ipv4": {
+			dnsName: "2.0.192.in-addr.arpa.",
+			rrsets: []resourceRecordSet{
+				{Name: "10.2.0.192.in-addr.arpa.", Type: "PTR", TTL: 300, Rrdatas: []string{"manual.example.com."}},
+			},
+		},
+		"reverse-ipv6": {
+			dnsName: "8.b.d.0.1.0.0.2.ip6.arpa.",
+		},
+	}
+}
+
+func TestUpdateEndpoints(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config      CloudDNSProviderConfig
+		endpoints   []*endpoint.Endpoint
+		wantChanges []string
+	}{
+		"configured zones": {
+			config: CloudDNSProviderConfig{
+				ForwardZone:     "example-com",
+				Ipv4ReverseZone: "reverse-ipv4",
+			},
+			endpoints: []*endpoint.Endpoint{
+				{Hostname: "host1", IPv4s: []string{"192.0.2.1"}},
+				{Hostname: "host2", IPv4s: []string{"192.0.2.2"}, IPv6s: []string{"2001:db8::2"}, Records: []endpoint.Record{
+					{Type: endpoint.RecordTypeTXT, Name: "host2", Value: `v=spf1 -all`},
+				}},
+			},
+			wantChanges: []string{
+				"example-com: delete AAAA host1.example.com.",
+				"example-com: delete A host1.example.com.",
+				"example-com: add A host2.example.com. 192.0.2.2 ttl=300",
+				"example-com: add AAAA host2.example.com. 2001:db8::2 ttl=300",
+				`example-com: add TXT host2.example.com. "v=spf1 -all" ttl=300`,
+				"example-com: add A host1.example.com. 192.0.2.1 ttl=300",
+				"reverse-ipv4: add PTR 1.2.0.192.in-addr.arpa. host1.example.com. ttl=300",
+				"reverse-ipv4: add PTR 2.2.0.192.in-addr.arpa. host2.example.com. ttl=300",
+			},
+		},
+		"discovered zones with cleanup": {
+			config: CloudDNSProviderConfig{
+				DiscoverZones:        true,
+				CleanForwardZone:     true,
+				CleanIPv4ReverseZone: true,
+				DefaultTTL:           60,
+			},
+			endpoints: []*endpoint.Endpoint{
+				{Hostname: "host1", IPv4s: []string{"192.0.2.9"}, IPv6s: []string{"2001:db8::1"}, RecordTTL: 300},
+			},
+			wantChanges: []string{
+				"example-com: delete A manual.example.com.",
+				"reverse-ipv4: delete PTR 10.2.0.192.in-addr.arpa.",
+				"reverse-ipv4: add PTR 9.2.0.192.in-addr.arpa. host1.example.com. ttl=300",
+				"reverse-ipv6: add PTR 1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. host1.example.com. ttl=300",
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			api, server := newFakeCloudDNS(t, testZones())
+			p := newTestProvider(t, server, tc.config)
+
+			err := p.UpdateEndpoints(context.Background(), tc.endpoints)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantChanges, api.changes)
+			assert.Equal(t, 1, api.tokens)
+
+			api.changes = nil
+			err = p.UpdateEndpoints(context.Background(), tc.endpoints)
+			require.NoError(t, err)
+			assert.Empty(t, api.changes, "second sync should not change anything")
+			assert.Equal(t, 1, api.tokens, "access token should be cached")
+		})
+	}
+}
+
+func TestUpdateEndpoints_MetadataServer(t *testing.T) {
+	api, server := newFakeCloudDNS(t, testZones())
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+		assert.Equal(t, "/computeMetadata/v1/instance/service-accounts/default/token", r.URL.Path)
+		assert.Equal(t, cloudDNSScope, r.URL.Query().Get("scopes"))
+		_, _ = w.Write([]byte(`{"access_token":"test-token","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	defer metadata.Close()
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(metadata.URL, "http://"))
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+
+	p, err := NewCloudDNSProvider("test", CloudDNSProviderConfig{
+		Project:     "test-project",
+		ForwardZone: "example-com",
+		EndpointURL: server.URL + "/dns/v1",
+	}, func(e *endpoint.Endpoint) bool { return true }, func(e *endpoint.Endpoint) bool { return true })
+	require.NoError(t, err)
+
+	err = p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+		{Hostname: "host3", IPv4s: []string{"192.0.2.3"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example-com: add A host3.example.com. 192.0.2.3 ttl=300"}, api.changes)
+}
+
+func TestUpdateEndpoints_Errors(t *testing.T) {
+	t.Parallel()
+
+	t.Run("unknown zone", func(t *testing.T) {
+		t.Parallel()
+
+		_, server := newFakeCloudDNS(t, testZones())
+		p := newTestProvider(t, server, CloudDNSProviderConfig{ForwardZone: "example-org"})
+		err := p.UpdateEndpoints(context.Background(), nil)
+		assert.ErrorContains(t, err, "could not get managed zone example-org: GET /managedZones/example-org failed with status 404")
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		t.Parallel()
+
+		_, server := newFakeCloudDNS(t, testZones())
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		p := newTestProvider(t, server, CloudDNSProviderConfig{
+			ForwardZone:     "example-com",
+			CredentialsJSON: testCredentialsJSON(t, server, otherKey),
+		})
+		err = p.UpdateEndpoints(context.Background(), nil)
+		assert.ErrorContains(t, err, "invalid_grant: Invalid JWT Signature.")
+	})
+}
+
+func TestNewCloudDNSProvider_Invalid(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+
+	tests := map[string]struct {
+		config CloudDNSProviderConfig
+		errMsg string
+	}{
+		"missing project": {
+			config: CloudDNSProviderConfig{},
+			errMsg: "project is required",
+		},
+		"unsupported credentials": {
+			config: CloudDNSProviderConfig{CredentialsJSON: `{"type":"authorized_user"}`},
+			errMsg: `unsupported credentials type "authorized_user"`,
+		},
+		"missing credentials file": {
+			config: CloudDNSProviderConfig{CredentialsFile: "/nonexistent/key.json"},
+			errMsg: "could not read credentials file",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewCloudDNSProvider("test", tc.config, nil, nil)
+			assert.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package gcp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sapslaj/zonepop/pkg/accesstoken"
+)
+
+const cloudDNSScope = "https://www.googleapis.com/auth/ndev.clouddns.readwrite"
+
+const defaultTokenURI = "https://oauth2.googleapis.com/token"
+
+// The metadata server provides the tokens of the service account of GCE
+// instances, and of the Kubernetes service account with GKE workload
+// identity.
+const defaultMetadataHost = "metadata.google.internal"
+
+type serviceAccountKey struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// newTokenSource returns the access token source for the credentials set in
+// the provider config: a service account key from credentials_json,
+// credentials_file or $GOOGLE_APPLICATION_CREDENTIALS, or else the metadata
+// server. It also returns the project of the service account key, if any.
+func newTokenSource(c CloudDNSProviderConfig, client *http.Client) (*accesstoken.Source, string, error) {
+	keyJSON := []byte(c.CredentialsJSON)
+	if len(keyJSON) == 0 {
+		credentialsFile := c.CredentialsFile
+		if credentialsFile == "" {
+			credentialsFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		}
+		if credentialsFile != "" {
+			var err error
+			keyJSON, err = os.ReadFile(credentialsFile)
+			if err != nil {
+				return nil, "", fmt.Errorf("could not read credentials file: %w", err)
+			}
+		}
+	}
+	if len(keyJSON) == 0 {
+		return &accesstoken.Source{
+			Fetch: func(ctx context.Context) (accesstoken.Token, error) {
+				return metadataToken(ctx, client)
+			},
+		}, "", nil
+	}
+
+	var key serviceAccountKey
+	err := json.Unmarshal(keyJSON, &key)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not decode credentials: %w", err)
+	}
+	if key.Type != "service_account" {
+		return nil, "", fmt.Errorf("unsupported credentials type %q, only service account keys are supported", key.Type)
+	}
+	privateKey, err := parsePrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, "", err
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = defaultTokenURI
+	}
+	return &accesstoken.Source{
+		Fetch: func(ctx context.Context) (accesstoken.Token, error) {
+			return key.token(ctx, client, privateKey)
+		},
+	}, key.ProjectID, nil
+}
+
+func parsePrivateKey(s string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("could not decode private key of service account key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse private key of service account key: %w", err)
+		}
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key of service account key is not an RSA key")
+	}
+	return privateKey, nil
+}
+
+// token exchanges a JWT signed with the service account key for an access
+// token.
+func (k serviceAccountKey) token(ctx context.Context, client *http.Client, privateKey *rsa.PrivateKey) (accesstoken.Token, error) {
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"kid": k.PrivateKeyID,
+	})
+	if err != nil {
+		return accesstoken.Token{}, err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iss":   k.ClientEmail,
+		"scope": cloudDNSScope,
+		"aud":   k.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return accesstoken.Token{}, err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return accesstoken.Token{}, fmt.Errorf("could not sign access token request: %w", err)
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return accesstoken.Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return accesstoken.Request(client, req)
+}
+
+// metadataToken gets an access token for the service account of the instance
+// or Kubernetes pod from the metadata server.
+func metadataToken(ctx context.Context, client *http.Client) (accesstoken.Token, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = defaultMetadataHost
+	}
+	u := "http://" + host + "/computeMetadata/v1/instance/service-accounts/default/token?" + url.Values{
+		"scopes": {cloudDNSScope},
+	}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return accesstoken.Token{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return accesstoken.Request(client, req)
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/sapslaj/zonepop/config/configtypes"
 	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
 	"github.com/sapslaj/zonepop/pkg/log"
 	"github.com/sapslaj/zonepop/pkg/sshconnection"
 	"github.com/sapslaj/zonepop/pkg/utils"
@@ -30,24 +31,33 @@ type HostsFileProviderConfig struct {
 	File         string
 	Permissions  string
 	SSH          HostsFileProviderConfigSSH
+	// How hostnames are normalized into valid DNS names
+	Normalization dnsname.Policy
 }
 
 type hostsFileProvider struct {
 	config              HostsFileProviderConfig
 	logger              *zap.Logger
+	normalizer          *dnsname.Normalizer
 	forwardLookupFilter configtypes.EndpointFilterFunc
 }
 
 func NewHostsFileProvider(
+	name string,
 	providerConfig HostsFileProviderConfig,
 	forwardLookupFilter configtypes.EndpointFilterFunc,
 ) (provider.Provider, error) {
 	if providerConfig.Permissions == "" {
 		providerConfig.Permissions = "0644"
 	}
+	normalizer, err := dnsname.NewNormalizer(name, providerConfig.Normalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure hostname normalization: %w", err)
+	}
 	p := &hostsFileProvider{
 		config:              providerConfig,
 		logger:              log.MustNewLogger().Named("hosts_file_provider"),
+		normalizer:          normalizer,
 		forwardLookupFilter: forwardLookupFilter,
 	}
 	return p, nil
@@ -77,7 +87,11 @@ func (p *hostsFileProvider) endpointsToFile(endpoints []*endpoint.Endpoint) stri
 	var s strings.Builder
 	s.WriteString("# Generated by ZonePop\n")
 	for _, endpoint := range endpoints {
-		hostname := endpoint.Hostname + p.config.RecordSuffix
+		hostname, err := p.normalizer.Normalize(endpoint.Hostname, p.config.RecordSuffix)
+		if err != nil {
+			p.logger.Sugar().Warnw("skipping endpoint with invalid hostname", "hostname", endpoint.Hostname, "err", err)
+			continue
+		}
 		for _, ipv4 := range endpoint.IPv4s {
 			s.WriteString(fmt.Sprintf("%s\t%s\n", ipv4, hostname))
 		}
@@ -24,6 +24,7 @@ func TestHostsFileProvider(t *testing.T) {
 `
 
 	p, err := NewHostsFileProvider(
+		"test",
 		HostsFileProviderConfig{
 			File: path.Join(tmpdir, "hosts"),
 		},
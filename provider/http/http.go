@@ -3,6 +3,7 @@ package http
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"sync"
 
@@ -10,22 +11,30 @@ import (
 
 	"github.com/sapslaj/zonepop/config/configtypes"
 	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
 	"github.com/sapslaj/zonepop/pkg/log"
 	"github.com/sapslaj/zonepop/pkg/rdns"
 	"github.com/sapslaj/zonepop/pkg/utils"
 )
 
-type HTTPProviderConfig struct{}
+type HTTPProviderConfig struct {
+	// Appended to hostnames of PTR records, aliases and additional records
+	RecordSuffix string
+	// How hostnames of PTR records are normalized into valid DNS names
+	Normalization dnsname.Policy
+}
 
 type CurrentEndpointData struct {
 	Forward     []byte
 	ReverseIPv4 []byte
 	ReverseIPv6 []byte
+	Records     []byte
 }
 
 type HTTPProvider struct {
 	Config              HTTPProviderConfig
 	Logger              *zap.Logger
+	Normalizer          *dnsname.Normalizer
 	ForwardLookupFilter configtypes.EndpointFilterFunc
 	ReverseLookupFilter configtypes.EndpointFilterFunc
 	Mutex               sync.RWMutex
@@ -33,19 +42,26 @@ type HTTPProvider struct {
 }
 
 func NewHTTPProvider(
+	name string,
 	providerConfig HTTPProviderConfig,
 	forwardLookupFilter configtypes.EndpointFilterFunc,
 	reverseLookupFilter configtypes.EndpointFilterFunc,
 ) (*HTTPProvider, error) {
+	normalizer, err := dnsname.NewNormalizer(name, providerConfig.Normalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure hostname normalization: %w", err)
+	}
 	p := &HTTPProvider{
 		Config:              providerConfig,
 		ForwardLookupFilter: forwardLookupFilter,
 		ReverseLookupFilter: reverseLookupFilter,
 		Logger:              log.MustNewLogger().Named("http_provider"),
+		Normalizer:          normalizer,
 		CurrentEndpointData: CurrentEndpointData{
 			Forward:     []byte("[]"),
 			ReverseIPv4: []byte("[]"),
 			ReverseIPv6: []byte("[]"),
+			Records:     []byte("[]"),
 		},
 	}
 	http.HandleFunc("/endpoints/forward", p.MakeHandleFunc(func(h *HTTPProvider) []byte {
@@ -63,6 +79,11 @@ func NewHTTPProvider(
 		defer h.Mutex.RUnlock()
 		return h.CurrentEndpointData.ReverseIPv6
 	}))
+	http.HandleFunc("/endpoints/records", p.MakeHandleFunc(func(h *HTTPProvider) []byte {
+		h.Mutex.RLock()
+		defer h.Mutex.RUnlock()
+		return h.CurrentEndpointData.Records
+	}))
 	return p, nil
 }
 
@@ -74,10 +95,19 @@ func (p *HTTPProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoin
 		return err
 	}
 
+	records := p.Normalizer.Records(forwardEndpoints, p.Config.RecordSuffix, p.Logger)
+	recordsData, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
 	reverseEndpoints := utils.Filter(p.ReverseLookupFilter, endpoints)
 
 	reverseIPv4PTRs, err := rdns.PTRsForEndpoints(reverseEndpoints, rdns.Config{
-		Zone: "in-addr.arpa.",
+		Zone:         "in-addr.arpa.",
+		RecordSuffix: p.Config.RecordSuffix,
+		Normalizer:   p.Normalizer,
+		Logger:       p.Logger,
 	})
 	if err != nil {
 		return err
@@ -88,7 +118,10 @@ func (p *HTTPProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoin
 	}
 
 	reverseIPv6PTRs, err := rdns.PTRsForEndpoints(reverseEndpoints, rdns.Config{
-		Zone: "ip6.arpa.",
+		Zone:         "ip6.arpa.",
+		RecordSuffix: p.Config.RecordSuffix,
+		Normalizer:   p.Normalizer,
+		Logger:       p.Logger,
 	})
 	if err != nil {
 		return err
@@ -102,6 +135,7 @@ func (p *HTTPProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoin
 	p.CurrentEndpointData.Forward = forwardData
 	p.CurrentEndpointData.ReverseIPv4 = reverseIPv4Data
 	p.CurrentEndpointData.ReverseIPv6 = reverseIPv6Data
+	p.CurrentEndpointData.Records = recordsData
 	p.Mutex.Unlock()
 
 	return nil
@@ -20,6 +20,7 @@ func TestHTTPProvider(t *testing.T) {
 	t.Parallel()
 
 	p, err := NewHTTPProvider(
+		"test",
 		HTTPProviderConfig{},
 		configtypes.DefaultEndpointFilterFunc,
 		configtypes.DefaultEndpointFilterFunc,
@@ -46,6 +47,7 @@ func TestHTTPProvider(t *testing.T) {
 			IPv4s:     []string{"192.0.2.1"},
 			IPv6s:     []string{"2001:db8::1"},
 			RecordTTL: 60,
+			Aliases:   []string{"alias"},
 		},
 	})
 
@@ -65,6 +67,7 @@ func TestHTTPProvider(t *testing.T) {
 			IPv4s:     []string{"192.0.2.1"},
 			IPv6s:     []string{"2001:db8::1"},
 			RecordTTL: 60,
+			Aliases:   []string{"alias"},
 		},
 	}, populatedResultForward)
 
@@ -85,6 +88,7 @@ func TestHTTPProvider(t *testing.T) {
 				IPv4s:     []string{"192.0.2.1"},
 				IPv6s:     []string{"2001:db8::1"},
 				RecordTTL: 60,
+				Aliases:   []string{"alias"},
 			},
 			Hostname:              "test-host",
 			AutoGeneratedHostname: false,
@@ -113,6 +117,7 @@ func TestHTTPProvider(t *testing.T) {
 				IPv4s:     []string{"192.0.2.1"},
 				IPv6s:     []string{"2001:db8::1"},
 				RecordTTL: 60,
+				Aliases:   []string{"alias"},
 			},
 			Hostname:              "test-host",
 			AutoGeneratedHostname: false,
@@ -123,4 +128,22 @@ func TestHTTPProvider(t *testing.T) {
 			RFC1035DomainName:     "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2",
 		},
 	}, populatedResultReverse)
+
+	res, err = http.Get(server.URL + "/endpoints/records")
+	require.NoError(t, err)
+	bodyData, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	var populatedResultRecords []endpoint.Record
+	err = json.Unmarshal(bodyData, &populatedResultRecords)
+	require.NoError(t, err)
+
+	assert.Equal(t, []endpoint.Record{
+		{
+			Type:  endpoint.RecordTypeCNAME,
+			Name:  "alias",
+			Value: "test-host",
+			TTL:   60,
+		},
+	}, populatedResultRecords)
 }
@@ -0,0 +1,129 @@
+package pihole
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Settings of the dns section of the config that hold the local DNS entries.
+const (
+	hostsElement = "hosts"
+	cnameElement = "cnameRecords"
+)
+
+type apiError struct {
+	Error struct {
+		Key     string `json:"key"`
+		Message string `json:"message"`
+		Hint    any    `json:"hint"`
+	} `json:"error"`
+}
+
+type authResponse struct {
+	Session struct {
+		Valid bool   `json:"valid"`
+		SID   string `json:"sid"`
+	} `json:"session"`
+}
+
+type configResponse struct {
+	Config struct {
+		DNS map[string][]string `json:"dns"`
+	} `json:"config"`
+}
+
+// login starts a session and returns its ID, which is empty if no password
+// is configured.
+func (p *piholeProvider) login(ctx context.Context) (string, error) {
+	if p.config.Password == "" {
+		return "", nil
+	}
+	var res authResponse
+	err := p.do(ctx, http.MethodPost, "/api/auth", "", map[string]string{"password": p.config.Password}, &res)
+	if err != nil {
+		return "", fmt.Errorf("could not log in: %w", err)
+	}
+	if !res.Session.Valid {
+		return "", fmt.Errorf("could not log in: session is not valid")
+	}
+	return res.Session.SID, nil
+}
+
+// logout ends the session, Pi-hole only allows a limited number of them.
+func (p *piholeProvider) logout(ctx context.Context, sid string) {
+	if sid == "" {
+		return
+	}
+	err := p.do(ctx, http.MethodDelete, "/api/auth", sid, nil, nil)
+	if err != nil {
+		p.logger.Sugar().Warnw("could not log out", "err", err)
+	}
+}
+
+// getConfigArray returns the items of an array setting of the dns section.
+func (p *piholeProvider) getConfigArray(ctx context.Context, sid string, element string) ([]string, error) {
+	var res configResponse
+	err := p.do(ctx, http.MethodGet, "/api/config/dns/"+element, sid, nil, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Config.DNS[element], nil
+}
+
+func (p *piholeProvider) addConfigItem(ctx context.Context, sid string, element string, item string) error {
+	return p.do(ctx, http.MethodPut, "/api/config/dns/"+element+"/"+url.PathEscape(item), sid, nil, nil)
+}
+
+func (p *piholeProvider) deleteConfigItem(ctx context.Context, sid string, element string, item string) error {
+	return p.do(ctx, http.MethodDelete, "/api/config/dns/"+element+"/"+url.PathEscape(item), sid, nil, nil)
+}
+
+// do sends a request to the API and decodes the response into result if it's
+// not nil.
+func (p *piholeProvider) do(ctx context.Context, method string, path string, sid string, body any, result any) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.config.URL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	if sid != "" {
+		req.Header.Set("X-FTL-SID", sid)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send %s %s request: %w", method, path, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		var apiErr apiError
+		_ = json.NewDecoder(res.Body).Decode(&apiErr)
+		message := apiErr.Error.Message
+		if apiErr.Error.Hint != nil {
+			message += fmt.Sprintf(" (%v)", apiErr.Error.Hint)
+		}
+		return fmt.Errorf("%s %s failed with status %d: %s", method, path, res.StatusCode, message)
+	}
+	if result == nil {
+		return nil
+	}
+	err = json.NewDecoder(res.Body).Decode(result)
+	if err != nil {
+		return fmt.Errorf("could not decode %s %s response: %w", method, path, err)
+	}
+	return nil
+}
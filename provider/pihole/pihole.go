@@ -0,0 +1,174 @@
+package pihole
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/config/configtypes"
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
+	"github.com/sapslaj/zonepop/pkg/localdns"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/utils"
+	"github.com/sapslaj/zonepop/provider"
+)
+
+type PiholeProviderConfig struct {
+	// Base URL of the web interface, e.g. http://pi.hole
+	URL string
+	// App password or web interface password, empty if there is none
+	Password     string
+	RecordSuffix string
+	// Remove entries that aren't desired of all names ending with the record
+	// suffix, not just of the hostnames of the endpoints
+	Clean bool
+	// How hostnames are normalized into valid DNS names
+	Normalization dnsname.Policy
+}
+
+type piholeProvider struct {
+	config              PiholeProviderConfig
+	forwardLookupFilter configtypes.EndpointFilterFunc
+	logger              *zap.Logger
+	normalizer          *dnsname.Normalizer
+	httpClient          *http.Client
+}
+
+// configItem is the item of the hosts or cnameRecords setting an entry comes
+// from.
+type configItem struct {
+	element string
+	item    string
+	// the item holds entries of other names too
+	shared bool
+}
+
+func NewPiholeProvider(
+	name string,
+	providerConfig PiholeProviderConfig,
+	forwardLookupFilter configtypes.EndpointFilterFunc,
+) (provider.Provider, error) {
+	if providerConfig.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	if providerConfig.Clean && strings.Trim(providerConfig.RecordSuffix, ".") == "" {
+		return nil, errors.New("clean requires a record_suffix")
+	}
+	providerConfig.URL = strings.TrimSuffix(providerConfig.URL, "/")
+	normalizer, err := dnsname.NewNormalizer(name, providerConfig.Normalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure hostname normalization: %w", err)
+	}
+	return &piholeProvider{
+		config:              providerConfig,
+		forwardLookupFilter: forwardLookupFilter,
+		logger:              log.MustNewLogger().Named("pihole_provider"),
+		normalizer:          normalizer,
+		httpClient:          http.DefaultClient,
+	}, nil
+}
+
+func (p *piholeProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) error {
+	sid, err := p.login(ctx)
+	if err != nil {
+		p.logger.Sugar().Errorw("could not log in to Pi-hole", "err", err)
+		return err
+	}
+	defer p.logout(context.WithoutCancel(ctx), sid)
+
+	existing := make([]localdns.Entry, 0)
+	items := map[localdns.Entry]configItem{}
+	for _, element := range []string{hostsElement, cnameElement} {
+		elementItems, err := p.getConfigArray(ctx, sid, element)
+		if err != nil {
+			p.logger.Sugar().Errorw("could not get local DNS entries", "setting", element, "err", err)
+			return fmt.Errorf("could not get local DNS entries: %w", err)
+		}
+		for _, item := range elementItems {
+			entries := parseItem(element, item)
+			for _, e := range entries {
+				existing = append(existing, e)
+				items[e] = configItem{element: element, item: item, shared: len(entries) > 1}
+			}
+		}
+	}
+
+	desired, managed := localdns.Desired(utils.Filter(p.forwardLookupFilter, endpoints), localdns.Config{
+		RecordSuffix: p.config.RecordSuffix,
+		Normalizer:   p.normalizer,
+		Logger:       p.logger,
+		Clean:        p.config.Clean,
+	})
+	if p.config.Clean {
+		p.logger.Info("cleanup: cleaning local DNS entries")
+	}
+	remove, add := localdns.Plan(desired, existing, managed)
+	if len(remove) == 0 && len(add) == 0 {
+		p.logger.Debug("no changes for local DNS entries")
+		return nil
+	}
+	localdns.LogChanges(p.logger, remove, add)
+
+	var errs error
+	for _, e := range remove {
+		item := items[e]
+		if item.shared {
+			p.logger.Sugar().Warnf("not removing entry %q, %q holds entries of other names too", e.Name, item.item)
+			continue
+		}
+		err := p.deleteConfigItem(ctx, sid, item.element, item.item)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("could not remove entry %q: %w", item.item, err))
+		}
+	}
+	for _, e := range add {
+		element, item := hostsElement, e.Value+" "+e.Name
+		if e.IsCNAME() {
+			element, item = cnameElement, e.Name+","+e.Value
+		}
+		err := p.addConfigItem(ctx, sid, element, item)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("could not add entry %q: %w", item, err))
+		}
+	}
+	if errs != nil {
+		p.logger.Sugar().Errorw("failed to update some local DNS entries", "err", errs)
+	}
+	return errs
+}
+
+// parseItem returns the entries of an item of the hosts setting ("address
+// name..." like in /etc/hosts) or the cnameRecords setting
+// ("name...,target[,ttl]" like the cname option of dnsmasq).
+func parseItem(element string, item string) []localdns.Entry {
+	entries := make([]localdns.Entry, 0)
+	if element == hostsElement {
+		fields := strings.Fields(item)
+		if len(fields) < 2 {
+			return entries
+		}
+		for _, name := range fields[1:] {
+			entries = append(entries, localdns.Entry{Name: localdns.Canonical(name), Value: fields[0]})
+		}
+		return entries
+	}
+	fields := strings.Split(item, ",")
+	if _, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) < 2 {
+		return entries
+	}
+	target := localdns.Canonical(strings.TrimSpace(fields[len(fields)-1]))
+	for _, name := range fields[:len(fields)-1] {
+		entries = append(entries, localdns.Entry{Name: localdns.Canonical(strings.TrimSpace(name)), Value: target})
+	}
+	return entries
+}
@@ -0,0 +1,254 @@
+package pihole
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+// fakePihole is an in-memory stand-in for the authentication and config
+// endpoints of the Pi-hole v6 API.
+type fakePihole struct {
+	t        *testing.T
+	mu       sync.Mutex
+	settings map[string][]string
+	changes  []string
+	sessions int
+	// config changes are rejected
+	readOnly bool
+}
+
+func newFakePihole(t *testing.T, hosts []string, cnameRecords []string) (*fakePihole, *httptest.Server) {
+	api := &fakePihole{t: t, settings: map[string][]string{
+		hostsElement: hosts,
+		cnameElement: cnameRecords,
+	}}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	return api, server
+}
+
+func (api *fakePihole) respond(w http.ResponseWriter, status int, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	require.NoError(api.t, json.NewEncoder(w).Encode(result))
+}
+
+func (api *fakePihole) error(w http.ResponseWriter, status int, key string, message string, hint any) {
+	res := apiError{}
+	res.Error.Key = key
+	res.Error.Message = message
+	res.Error.Hint = hint
+	api.respond(w, status, res)
+}
+
+func (api *fakePihole) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if r.URL.Path == "/api/auth" {
+		api.serveAuth(w, r)
+		return
+	}
+	if r.Header.Get("X-FTL-SID") != "test-sid" {
+		api.error(w, http.StatusUnauthorized, "unauthorized", "Unauthorized", nil)
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.EscapedPath(), "/api/config/dns/")
+	if !ok {
+		api.error(w, http.StatusNotFound, "not_found", "Not found", nil)
+		return
+	}
+	element, item, _ := strings.Cut(path, "/")
+	item, err := url.PathUnescape(item)
+	require.NoError(api.t, err)
+	items, ok := api.settings[element]
+	if !ok {
+		api.error(w, http.StatusBadRequest, "bad_request", "Config item is invalid", element)
+		return
+	}
+	if item != "" && api.readOnly {
+		api.error(w, http.StatusForbidden, "forbidden", "Config items set via environment variables cannot be changed via the API", nil)
+		return
+	}
+	switch {
+	case item == "" && r.Method == http.MethodGet:
+		api.respond(w, http.StatusOK, map[string]any{"config": map[string]any{"dns": map[string]any{element: items}}, "took": 0.001})
+	case item != "" && r.Method == http.MethodPut:
+		if slices.Contains(items, item) {
+			api.error(w, http.StatusBadRequest, "bad_request", "Item already present", "Uniqueness of items is enforced")
+			return
+		}
+		api.settings[element] = append(items, item)
+		api.changes = append(api.changes, fmt.Sprintf("add %s %s", element, item))
+		api.respond(w, http.StatusCreated, map[string]any{"took": 0.001})
+	case item != "" && r.Method == http.MethodDelete:
+		i := slices.Index(items, item)
+		if i < 0 {
+			api.error(w, http.StatusNotFound, "not_found", "Item not found", nil)
+			return
+		}
+		api.settings[element] = slices.Delete(items, i, i+1)
+		api.changes = append(api.changes, fmt.Sprintf("delete %s %s", element, item))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		api.error(w, http.StatusNotFound, "not_found", "Not found", nil)
+	}
+}
+
+func (api *fakePihole) serveAuth(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var req map[string]string
+		require.NoError(api.t, json.NewDecoder(r.Body).Decode(&req))
+		if req["password"] != "test-password" {
+			api.error(w, http.StatusUnauthorized, "unauthorized", "Unauthorized", nil)
+			return
+		}
+		api.sessions++
+		api.respond(w, http.StatusOK, map[string]any{"session": map[string]any{
+			"valid":    true,
+			"totp":     false,
+			"sid":      "test-sid",
+			"csrf":     "test-csrf",
+			"validity": 1800,
+		}})
+	case http.MethodDelete:
+		assert.Equal(api.t, "test-sid", r.Header.Get("X-FTL-SID"))
+		api.sessions--
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		api.error(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", nil)
+	}
+}
+
+func newTestProvider(t *testing.T, server *httptest.Server, config PiholeProviderConfig) *piholeProvider {
+	config.URL = server.URL + "/"
+	if config.Password == "" {
+		config.Password = "test-password"
+	}
+	p, err := NewPiholeProvider("test", config, func(e *endpoint.Endpoint) bool { return true })
+	require.NoError(t, err)
+	return p.(*piholeProvider)
+}
+
+func TestUpdateEndpoints(t *testing.T) {
+	t.Parallel()
+
+	hosts := []string{
+		"192.0.2.9 host1.lan",
+		"2001:db8::1 host1.lan",
+		"192.0.2.10 manual.lan",
+		"192.0.2.3 host3.lan router.lan",
+		"192.0.2.20 nas.example.com",
+	}
+	cnameRecords := []string{
+		"nas.lan,host1.lan",
+		"old.lan,host1.lan,300",
+	}
+
+	tests := map[string]struct {
+		config      PiholeProviderConfig
+		wantChanges []string
+	}{
+		"hostnames of endpoints": {
+			wantChanges: []string{
+				"delete hosts 192.0.2.9 host1.lan",
+				"add hosts 192.0.2.1 host1.lan",
+				"add hosts 192.0.2.2 host2.lan",
+				"add hosts 2001:db8::2 host2.lan",
+				"add cnameRecords www.lan,host2.lan",
+			},
+		},
+		"clean": {
+			config: PiholeProviderConfig{Clean: true},
+			wantChanges: []string{
+				"delete hosts 192.0.2.9 host1.lan",
+				"delete hosts 192.0.2.10 manual.lan",
+				"delete cnameRecords old.lan,host1.lan,300",
+				"add hosts 192.0.2.1 host1.lan",
+				"add hosts 192.0.2.2 host2.lan",
+				"add hosts 2001:db8::2 host2.lan",
+				"add cnameRecords www.lan,host2.lan",
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			api, server := newFakePihole(t, slices.Clone(hosts), slices.Clone(cnameRecords))
+			tc.config.RecordSuffix = ".lan"
+			p := newTestProvider(t, server, tc.config)
+			endpoints := []*endpoint.Endpoint{
+				{Hostname: "host1", IPv4s: []string{"192.0.2.1"}, IPv6s: []string{"2001:db8::1"}, Aliases: []string{"nas"}},
+				{Hostname: "host2", IPv4s: []string{"192.0.2.2"}, IPv6s: []string{"2001:db8::2"}, Aliases: []string{"www"}},
+				// the entry of host3.lan shares its item with router.lan, so
+				// it's left alone
+				{Hostname: "host3"},
+			}
+
+			err := p.UpdateEndpoints(context.Background(), endpoints)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantChanges, api.changes)
+			assert.Equal(t, 0, api.sessions, "session should be ended")
+
+			api.changes = nil
+			err = p.UpdateEndpoints(context.Background(), endpoints)
+			require.NoError(t, err)
+			assert.Empty(t, api.changes, "second sync should not change anything")
+		})
+	}
+}
+
+func TestUpdateEndpoints_Errors(t *testing.T) {
+	t.Parallel()
+
+	t.Run("wrong password", func(t *testing.T) {
+		t.Parallel()
+
+		api, server := newFakePihole(t, nil, nil)
+		p := newTestProvider(t, server, PiholeProviderConfig{Password: "wrong-password"})
+		err := p.UpdateEndpoints(context.Background(), nil)
+		assert.ErrorContains(t, err, "could not log in: POST /api/auth failed with status 401: Unauthorized")
+		assert.Equal(t, 0, api.sessions)
+	})
+
+	t.Run("read-only config", func(t *testing.T) {
+		t.Parallel()
+
+		api, server := newFakePihole(t, []string{"192.0.2.9 host1.lan"}, nil)
+		api.readOnly = true
+		p := newTestProvider(t, server, PiholeProviderConfig{RecordSuffix: ".lan"})
+		err := p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+			{Hostname: "host1", IPv4s: []string{"192.0.2.1"}},
+		})
+		assert.ErrorContains(t, err, `could not remove entry "192.0.2.9 host1.lan": DELETE /api/config/dns/hosts/192.0.2.9%20host1.lan failed with status 403`)
+		assert.ErrorContains(t, err, `could not add entry "192.0.2.1 host1.lan"`)
+		assert.Equal(t, 0, api.sessions)
+	})
+}
+
+func TestNewPiholeProvider_Invalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewPiholeProvider("test", PiholeProviderConfig{}, nil)
+	assert.ErrorContains(t, err, "url is required")
+
+	_, err = NewPiholeProvider("test", PiholeProviderConfig{URL: "http://localhost", Clean: true}, nil)
+	assert.ErrorContains(t, err, "clean requires a record_suffix")
+}
@@ -0,0 +1,145 @@
+package powerdns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	changeTypeReplace = "REPLACE"
+	changeTypeDelete  = "DELETE"
+)
+
+var errZoneNotFound = errors.New("zone not found")
+
+type zone struct {
+	ID     string  `json:"id,omitempty"`
+	Name   string  `json:"name"`
+	Kind   string  `json:"kind,omitempty"`
+	RRsets []rrset `json:"rrsets,omitempty"`
+}
+
+type createZoneRequest struct {
+	Name        string   `json:"name"`
+	Kind        string   `json:"kind"`
+	Nameservers []string `json:"nameservers"`
+}
+
+type patchZoneRequest struct {
+	RRsets []rrset `json:"rrsets"`
+}
+
+type rrset struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	TTL        int64    `json:"ttl,omitempty"`
+	ChangeType string   `json:"changetype,omitempty"`
+	Records    []record `json:"records"`
+}
+
+type record struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// zoneID returns the ID the API uses for a zone name.
+func zoneID(name string) string {
+	return strings.ReplaceAll(name, "/", "=2F")
+}
+
+func (p *powerDNSProvider) zonesPath() string {
+	return "/api/v1/servers/" + url.PathEscape(p.config.ServerID) + "/zones"
+}
+
+func (p *powerDNSProvider) zonePath(name string) string {
+	return p.zonesPath() + "/" + url.PathEscape(zoneID(name))
+}
+
+// do sends a request to the API and decodes the response into result if it's
+// not nil.
+func (p *powerDNSProvider) do(ctx context.Context, method string, path string, body any, result any) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.config.URL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("X-API-Key", p.config.APIKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send %s %s request: %w", method, path, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		var apiErr apiError
+		_ = json.NewDecoder(res.Body).Decode(&apiErr)
+		if res.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%s %s: %w", method, path, errZoneNotFound)
+		}
+		return fmt.Errorf("%s %s failed with status %d: %s", method, path, res.StatusCode, apiErr.Error)
+	}
+	if result != nil {
+		err = json.NewDecoder(res.Body).Decode(result)
+		if err != nil {
+			return fmt.Errorf("could not decode %s %s response: %w", method, path, err)
+		}
+	}
+	return nil
+}
+
+// listZones returns the zones of the server without their records.
+func (p *powerDNSProvider) listZones(ctx context.Context) ([]zone, error) {
+	var zones []zone
+	err := p.do(ctx, http.MethodGet, p.zonesPath(), nil, &zones)
+	return zones, err
+}
+
+// getZone returns a zone with its records.
+func (p *powerDNSProvider) getZone(ctx context.Context, name string) (zone, error) {
+	var z zone
+	err := p.do(ctx, http.MethodGet, p.zonePath(name), nil, &z)
+	return z, err
+}
+
+func (p *powerDNSProvider) createZone(ctx context.Context, name string) error {
+	nameservers := p.config.ReverseZoneNameservers
+	if nameservers == nil {
+		nameservers = []string{}
+	}
+	return p.do(ctx, http.MethodPost, p.zonesPath(), createZoneRequest{
+		Name:        name,
+		Kind:        p.config.ReverseZoneKind,
+		Nameservers: nameservers,
+	}, nil)
+}
+
+func (p *powerDNSProvider) patchRRsets(ctx context.Context, name string, rrsets []rrset) error {
+	return p.do(ctx, http.MethodPatch, p.zonePath(name), patchZoneRequest{RRsets: rrsets}, nil)
+}
+
+// notify sends a DNS NOTIFY for the zone to its secondaries.
+func (p *powerDNSProvider) notify(ctx context.Context, name string) error {
+	return p.do(ctx, http.MethodPut, p.zonePath(name)+"/notify", nil, nil)
+}
@@ -0,0 +1,329 @@
+package powerdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/config/configtypes"
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/rdns"
+	"github.com/sapslaj/zonepop/pkg/recordset"
+	"github.com/sapslaj/zonepop/pkg/utils"
+	"github.com/sapslaj/zonepop/provider"
+)
+
+const (
+	DefaultServerID   = "localhost"
+	DefaultTTL        = recordset.DefaultTTL
+	DefaultZoneKind   = "Native"
+	defaultIPv4Prefix = 24
+	defaultIPv6Prefix = 64
+)
+
+type PowerDNSProviderConfig struct {
+	// Base URL of the API, e.g. http://localhost:8081
+	URL string
+	// Value of the api-key setting of the server
+	APIKey string
+	// ID of the server in the API (default "localhost")
+	ServerID string
+	// Zone for A, AAAA and additional records, e.g. "example.com"
+	ForwardZone string
+	// Appended to hostnames (default is the forward zone name)
+	RecordSuffix string
+	// Zones for PTR records, records go to the most specific zone they fit in
+	Ipv4ReverseZones []string
+	Ipv6ReverseZones []string
+	// Create missing reverse zones, including ones for PTR records that don't
+	// fit in any configured zone
+	CreateReverseZones bool
+	// Prefix length of created reverse zones, a multiple of 8 for IPv4
+	// (default 24) and 4 for IPv6 (default 64)
+	Ipv4ReverseZonePrefixLength int
+	Ipv6ReverseZonePrefixLength int
+	// Kind of created reverse zones (default "Native")
+	ReverseZoneKind string
+	// Nameservers of created reverse zones
+	ReverseZoneNameservers []string
+	// Send a DNS NOTIFY to the secondaries of changed zones
+	Notify bool
+	// Remove A and AAAA records that aren't desired from the forward zone
+	CleanForwardZone bool
+	// Remove PTR records that aren't desired from the reverse zones
+	CleanReverseZones bool
+	// TTL of records of endpoints without one (default 300)
+	DefaultTTL int64
+	// How hostnames are normalized into valid DNS names
+	Normalization dnsname.Policy
+}
+
+type powerDNSProvider struct {
+	config              PowerDNSProviderConfig
+	forwardLookupFilter configtypes.EndpointFilterFunc
+	reverseLookupFilter configtypes.EndpointFilterFunc
+	logger              *zap.Logger
+	normalizer          *dnsname.Normalizer
+	httpClient          *http.Client
+	written             recordset.Written
+}
+
+func NewPowerDNSProvider(
+	name string,
+	providerConfig PowerDNSProviderConfig,
+	forwardLookupFilter configtypes.EndpointFilterFunc,
+	reverseLookupFilter configtypes.EndpointFilterFunc,
+) (provider.Provider, error) {
+	if providerConfig.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	if providerConfig.APIKey == "" {
+		return nil, errors.New("api_key is required")
+	}
+	providerConfig.URL = strings.TrimSuffix(providerConfig.URL, "/")
+	if providerConfig.ServerID == "" {
+		providerConfig.ServerID = DefaultServerID
+	}
+	if providerConfig.ReverseZoneKind == "" {
+		providerConfig.ReverseZoneKind = DefaultZoneKind
+	}
+	if providerConfig.DefaultTTL <= 0 {
+		providerConfig.DefaultTTL = DefaultTTL
+	}
+	if providerConfig.Ipv4ReverseZonePrefixLength == 0 {
+		providerConfig.Ipv4ReverseZonePrefixLength = defaultIPv4Prefix
+	}
+	if providerConfig.Ipv6ReverseZonePrefixLength == 0 {
+		providerConfig.Ipv6ReverseZonePrefixLength = defaultIPv6Prefix
+	}
+	if l := providerConfig.Ipv4ReverseZonePrefixLength; l < 8 || l > 24 || l%8 != 0 {
+		return nil, fmt.Errorf("ipv4_reverse_zone_prefix_length has to be 8, 16 or 24, not %d", l)
+	}
+	if l := providerConfig.Ipv6ReverseZonePrefixLength; l < 4 || l > 124 || l%4 != 0 {
+		return nil, fmt.Errorf("ipv6_reverse_zone_prefix_length has to be a multiple of 4 up to 124, not %d", l)
+	}
+	if providerConfig.ForwardZone != "" {
+		providerConfig.ForwardZone = recordset.Canonical(providerConfig.ForwardZone)
+		if providerConfig.RecordSuffix == "" {
+			providerConfig.RecordSuffix = "." + strings.TrimSuffix(providerConfig.ForwardZone, ".")
+		}
+	}
+	providerConfig.Ipv4ReverseZones = utils.Map(recordset.Canonical, providerConfig.Ipv4ReverseZones)
+	providerConfig.Ipv6ReverseZones = utils.Map(recordset.Canonical, providerConfig.Ipv6ReverseZones)
+	normalizer, err := dnsname.NewNormalizer(name, providerConfig.Normalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure hostname normalization: %w", err)
+	}
+	return &powerDNSProvider{
+		config:              providerConfig,
+		forwardLookupFilter: forwardLookupFilter,
+		reverseLookupFilter: reverseLookupFilter,
+		logger:              log.MustNewLogger().Named("powerdns_provider"),
+		normalizer:          normalizer,
+		httpClient:          http.DefaultClient,
+		written:             recordset.Written{},
+	}, nil
+}
+
+func (p *powerDNSProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) error {
+	existing, err := p.listZones(ctx)
+	if err != nil {
+		p.logger.Sugar().Errorw("could not list zones", "err", err)
+		return fmt.Errorf("could not list zones: %w", err)
+	}
+	existingZones := map[string]bool{}
+	for _, z := range existing {
+		existingZones[recordset.Canonical(z.Name)] = true
+	}
+
+	var errs error
+	zones := recordset.Zones{}
+	if p.config.ForwardZone != "" {
+		if existingZones[p.config.ForwardZone] {
+			zones.Add(recordset.Zone{ID: p.config.ForwardZone, Name: p.config.ForwardZone})
+		} else {
+			errs = multierr.Append(errs, fmt.Errorf("forward lookup zone %q does not exist", p.config.ForwardZone))
+		}
+	}
+	for _, z := range slices.Concat(p.config.Ipv4ReverseZones, p.config.Ipv6ReverseZones) {
+		if !existingZones[z] {
+			if !p.config.CreateReverseZones {
+				errs = multierr.Append(errs, fmt.Errorf("reverse lookup zone %q does not exist", z))
+				continue
+			}
+			err := p.ensureZone(ctx, existingZones, z)
+			if err != nil {
+				errs = multierr.Append(errs, err)
+				continue
+			}
+		}
+		zones.Add(recordset.Zone{ID: z, Name: z})
+	}
+	reverseEndpoints := utils.Filter(p.reverseLookupFilter, endpoints)
+	if p.config.CreateReverseZones {
+		errs = multierr.Append(errs, p.createMissingReverseZones(ctx, existingZones, &zones, reverseEndpoints))
+	}
+
+	err = recordset.Sync(ctx, p, zones, utils.Filter(p.forwardLookupFilter, endpoints), reverseEndpoints, recordset.SyncConfig{
+		RecordSuffix:         p.config.RecordSuffix,
+		Normalizer:           p.normalizer,
+		Logger:               p.logger,
+		DefaultTTL:           p.config.DefaultTTL,
+		TXTValue:             recordset.QuotedTXT,
+		Written:              p.written,
+		CleanForwardZone:     p.config.CleanForwardZone,
+		CleanIPv4ReverseZone: p.config.CleanReverseZones,
+		CleanIPv6ReverseZone: p.config.CleanReverseZones,
+	})
+	errs = multierr.Append(errs, err)
+	if errs != nil {
+		p.logger.Sugar().Errorw("failed to update zones", "err", errs)
+	}
+	return errs
+}
+
+// createMissingReverseZones creates reverse lookup zones for PTR records of
+// the endpoints that don't fit in any of the zones and adds them to zones.
+func (p *powerDNSProvider) createMissingReverseZones(
+	ctx context.Context,
+	existingZones map[string]bool,
+	zones *recordset.Zones,
+	endpoints []*endpoint.Endpoint,
+) error {
+	ptrs, err := rdns.PTRsForEndpoints(endpoints, rdns.Config{
+		RecordSuffix: p.config.RecordSuffix,
+		Normalizer:   p.normalizer,
+		Logger:       p.logger,
+	})
+	if err != nil {
+		return fmt.Errorf("could not generate PTR records: %w", err)
+	}
+	var errs error
+	for _, ptr := range ptrs {
+		if recordset.ZoneFor(slices.Concat(zones.IPv4Reverse, zones.IPv6Reverse), ptr.DomainName) != nil {
+			continue
+		}
+		z, err := p.reverseZoneName(ptr.Address)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		err = p.ensureZone(ctx, existingZones, z)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		zones.Add(recordset.Zone{ID: z, Name: z})
+	}
+	return errs
+}
+
+// RecordSets implements [recordset.Client]. Disabled records are left out so
+// that record sets with disabled records are replaced.
+func (p *powerDNSProvider) RecordSets(ctx context.Context, zone recordset.Zone) ([]recordset.Set, error) {
+	z, err := p.getZone(ctx, zone.ID)
+	if err != nil {
+		return nil, err
+	}
+	sets := make([]recordset.Set, 0, len(z.RRsets))
+	for _, rs := range z.RRsets {
+		values := make([]string, 0, len(rs.Records))
+		for _, r := range rs.Records {
+			if !r.Disabled {
+				values = append(values, r.Content)
+			}
+		}
+		sets = append(sets, recordset.Set{
+			Name:   rs.Name,
+			Type:   rs.Type,
+			TTL:    rs.TTL,
+			Values: values,
+		})
+	}
+	return sets, nil
+}
+
+// Apply implements [recordset.Client]. It replaces created and updated RRsets
+// and notifies the secondaries of the zone if configured.
+func (p *powerDNSProvider) Apply(ctx context.Context, zone recordset.Zone, changes recordset.Changes) error {
+	rrsets := make([]rrset, 0, len(changes.Delete)+len(changes.Create)+len(changes.Update))
+	for _, s := range changes.Delete {
+		rrsets = append(rrsets, rrset{
+			Name:       s.Name,
+			Type:       s.Type,
+			ChangeType: changeTypeDelete,
+			Records:    []record{},
+		})
+	}
+	replace := func(s recordset.Set) {
+		records := make([]record, 0, len(s.Values))
+		for _, value := range s.Values {
+			records = append(records, record{Content: value})
+		}
+		rrsets = append(rrsets, rrset{
+			Name:       s.Name,
+			Type:       s.Type,
+			TTL:        s.TTL,
+			ChangeType: changeTypeReplace,
+			Records:    records,
+		})
+	}
+	for _, s := range changes.Create {
+		replace(s)
+	}
+	for _, u := range changes.Update {
+		replace(u.New)
+	}
+	err := p.patchRRsets(ctx, zone.ID, rrsets)
+	if err != nil {
+		return err
+	}
+	if p.config.Notify {
+		err = p.notify(ctx, zone.ID)
+		if err != nil {
+			return fmt.Errorf("could not notify secondaries: %w", err)
+		}
+	}
+	return nil
+}
+
+// ensureZone creates a reverse lookup zone unless it exists.
+func (p *powerDNSProvider) ensureZone(ctx context.Context, existingZones map[string]bool, name string) error {
+	if existingZones[name] {
+		return nil
+	}
+	p.logger.Sugar().Infof("creating reverse lookup zone %q", name)
+	err := p.createZone(ctx, name)
+	if err != nil {
+		return fmt.Errorf("could not create reverse lookup zone %q: %w", name, err)
+	}
+	existingZones[name] = true
+	return nil
+}
+
+// reverseZoneName returns the name of the reverse lookup zone with the
+// configured prefix length that addr is in.
+func (p *powerDNSProvider) reverseZoneName(addr string) (string, error) {
+	kind, err := rdns.DetermineAddressKind(addr)
+	if err != nil {
+		return "", err
+	}
+	ptr, err := rdns.ReverseAddr(addr)
+	if err != nil {
+		return "", err
+	}
+	labels := strings.Split(ptr, ".")
+	drop := (32 - p.config.Ipv4ReverseZonePrefixLength) / 8
+	if kind == rdns.AddressKindIPv6 {
+		drop = (128 - p.config.Ipv6ReverseZonePrefixLength) / 4
+	}
+	return strings.Join(labels[drop:], "."), nil
+}
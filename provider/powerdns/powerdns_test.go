@@ -0,0 +1,521 @@
+package powerdns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+// fakeAPI is an in-memory stand-in for the zones endpoints of the PowerDNS
+// Authoritative Server API.
+type fakeAPI struct {
+	t     *testing.T
+	mu    sync.Mutex
+	zones map[string]*zone
+	calls []string
+}
+
+func newFakeAPI(t *testing.T, zoneNames ...string) (*fakeAPI, *httptest.Server) {
+	api := &fakeAPI{t: t, zones: map[string]*zone{}}
+	for _, name := range zoneNames {
+		api.zones[name] = &zone{
+			ID:   zoneID(name),
+			Name: name,
+			Kind: "Native",
+			RRsets: []rrset{
+				{Name: name, Type: "SOA", TTL: 3600, Records: []record{{Content: "ns1.example.com. hostmaster.example.com. 1 10800 3600 604800 3600"}}},
+				{Name: name, Type: "NS", TTL: 3600, Records: []record{{Content: "ns1.example.com."}}},
+			},
+		}
+	}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	return api, server
+}
+
+func (api *fakeAPI) respond(w http.ResponseWriter, status int, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if result != nil {
+		require.NoError(api.t, json.NewEncoder(w).Encode(result))
+	}
+}
+
+func (api *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if r.Header.Get("X-API-Key") != "test-key" {
+		api.respond(w, http.StatusUnauthorized, apiError{Error: "Unauthorized"})
+		return
+	}
+	const zonesPath = "/api/v1/servers/localhost/zones"
+	path := r.URL.Path
+	if r.Method != http.MethodGet {
+		api.calls = append(api.calls, r.Method+" "+path)
+	}
+	if path == zonesPath {
+		switch r.Method {
+		case http.MethodGet:
+			zones := make([]zone, 0, len(api.zones))
+			for _, z := range api.zones {
+				zones = append(zones, zone{ID: z.ID, Name: z.Name, Kind: z.Kind})
+			}
+			api.respond(w, http.StatusOK, zones)
+		case http.MethodPost:
+			var req createZoneRequest
+			require.NoError(api.t, json.NewDecoder(r.Body).Decode(&req))
+			if _, ok := api.zones[req.Name]; ok {
+				api.respond(w, http.StatusConflict, apiError{Error: "Domain already exists"})
+				return
+			}
+			z := &zone{ID: zoneID(req.Name), Name: req.Name, Kind: req.Kind, RRsets: []rrset{}}
+			for _, ns := range req.Nameservers {
+				z.RRsets = append(z.RRsets, rrset{Name: req.Name, Type: "NS", TTL: 3600, Records: []record{{Content: ns}}})
+			}
+			api.zones[req.Name] = z
+			api.respond(w, http.StatusCreated, z)
+		}
+		return
+	}
+
+	id, notify := strings.CutSuffix(strings.TrimPrefix(path, zonesPath+"/"), "/notify")
+	z, ok := api.zones[strings.ReplaceAll(id, "=2F", "/")]
+	if !ok {
+		api.respond(w, http.StatusNotFound, apiError{Error: "Could not find domain '" + id + "'"})
+		return
+	}
+	switch {
+	case notify && r.Method == http.MethodPut:
+		api.respond(w, http.StatusOK, map[string]string{"result": "Notification queued"})
+	case r.Method == http.MethodGet:
+		api.respond(w, http.StatusOK, z)
+	case r.Method == http.MethodPatch:
+		var req patchZoneRequest
+		require.NoError(api.t, json.NewDecoder(r.Body).Decode(&req))
+		for _, change := range req.RRsets {
+			rrsets := make([]rrset, 0, len(z.RRsets))
+			for _, rs := range z.RRsets {
+				if rs.Name != change.Name || rs.Type != change.Type {
+					rrsets = append(rrsets, rs)
+				}
+			}
+			if change.ChangeType == changeTypeReplace {
+				change.ChangeType = ""
+				rrsets = append(rrsets, change)
+			}
+			z.RRsets = rrsets
+		}
+		api.respond(w, http.StatusNoContent, nil)
+	default:
+		api.respond(w, http.StatusMethodNotAllowed, apiError{Error: "Method not allowed"})
+	}
+}
+
+func (api *fakeAPI) addRRset(zoneName string, rs rrset) {
+	api.zones[zoneName].RRsets = append(api.zones[zoneName].RRsets, rs)
+}
+
+// summary returns the A, AAAA, PTR and additional records of the zones in a
+// comparable form.
+func (api *fakeAPI) summary() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	summary := make([]string, 0)
+	for _, z := range api.zones {
+		for _, rs := range z.RRsets {
+			if rs.Type == "SOA" || rs.Type == "NS" {
+				continue
+			}
+			contents := make([]string, 0, len(rs.Records))
+			for _, r := range rs.Records {
+				contents = append(contents, r.Content)
+			}
+			summary = append(summary, fmt.Sprintf("%s %s %s ttl=%d", rs.Type, rs.Name, strings.Join(contents, ","), rs.TTL))
+		}
+	}
+	sort.Strings(summary)
+	return summary
+}
+
+func newTestProvider(t *testing.T, server *httptest.Server, config PowerDNSProviderConfig) *powerDNSProvider {
+	config.URL = server.URL
+	config.APIKey = "test-key"
+	p, err := NewPowerDNSProvider(
+		"test",
+		config,
+		func(e *endpoint.Endpoint) bool { return true },
+		func(e *endpoint.Endpoint) bool { return true },
+	)
+	require.NoError(t, err)
+	pp := p.(*powerDNSProvider)
+	pp.httpClient = server.Client()
+	return pp
+}
+
+func TestNewPowerDNSProvider_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config PowerDNSProviderConfig
+		errMsg string
+	}{
+		"missing url": {
+			config: PowerDNSProviderConfig{APIKey: "test-key"},
+			errMsg: "url is required",
+		},
+		"missing api key": {
+			config: PowerDNSProviderConfig{URL: "http://localhost:8081"},
+			errMsg: "api_key is required",
+		},
+		"invalid IPv4 prefix length": {
+			config: PowerDNSProviderConfig{URL: "http://localhost:8081", APIKey: "test-key", Ipv4ReverseZonePrefixLength: 20},
+			errMsg: "has to be 8, 16 or 24",
+		},
+		"invalid IPv6 prefix length": {
+			config: PowerDNSProviderConfig{URL: "http://localhost:8081", APIKey: "test-key", Ipv6ReverseZonePrefixLength: 62},
+			errMsg: "has to be a multiple of 4",
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewPowerDNSProvider("test", tc.config, nil, nil)
+			assert.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
+
+func TestReverseZoneName(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		addr       string
+		ipv4Prefix int
+		ipv6Prefix int
+		want       string
+	}{
+		"IPv4 /24": {
+			addr: "192.0.2.1",
+			want: "2.0.192.in-addr.arpa.",
+		},
+		"IPv4 /16": {
+			addr:       "192.0.2.1",
+			ipv4Prefix: 16,
+			want:       "0.192.in-addr.arpa.",
+		},
+		"IPv6 /64": {
+			addr: "2001:db8::1",
+			want: "0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+		},
+		"IPv6 /48": {
+			addr:       "2001:db8::1",
+			ipv6Prefix: 48,
+			want:       "0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := NewPowerDNSProvider("test", PowerDNSProviderConfig{
+				URL:                         "http://localhost:8081",
+				APIKey:                      "test-key",
+				Ipv4ReverseZonePrefixLength: tc.ipv4Prefix,
+				Ipv6ReverseZonePrefixLength: tc.ipv6Prefix,
+			}, nil, nil)
+			require.NoError(t, err)
+			got, err := p.(*powerDNSProvider).reverseZoneName(tc.addr)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestUpdateEndpoints(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		zones     []string
+		existing  map[string][]rrset
+		config    PowerDNSProviderConfig
+		endpoints []*endpoint.Endpoint
+		want      []string
+		wantCalls []string
+	}{
+		"creates forward and reverse records": {
+			zones: []string{"example.com.", "2.0.192.in-addr.arpa."},
+			config: PowerDNSProviderConfig{
+				ForwardZone:      "example.com",
+				Ipv4ReverseZones: []string{"2.0.192.in-addr.arpa"},
+			},
+			endpoints: []*endpoint.Endpoint{
+				{
+					Hostname:  "host1",
+					IPv4s:     []string{"192.0.2.1"},
+					IPv6s:     []string{"2001:db8::1"},
+					RecordTTL: 60,
+					Aliases:   []string{"www"},
+				},
+				{Hostname: "host2", IPv4s: []string{"192.0.2.2"}},
+			},
+			want: []string{
+				"A host1.example.com. 192.0.2.1 ttl=60",
+				"A host2.example.com. 192.0.2.2 ttl=300",
+				"AAAA host1.example.com. 2001:db8::1 ttl=60",
+				"CNAME www.example.com. host1.example.com. ttl=60",
+				"PTR 1.2.0.192.in-addr.arpa. host1.example.com. ttl=60",
+				"PTR 2.2.0.192.in-addr.arpa. host2.example.com. ttl=300",
+			},
+			wantCalls: []string{
+				"PATCH /api/v1/servers/localhost/zones/example.com.",
+				"PATCH /api/v1/servers/localhost/zones/2.0.192.in-addr.arpa.",
+			},
+		},
+		"updates and removes records of managed names": {
+			zones: []string{"example.com.", "2.0.192.in-addr.arpa."},
+			existing: map[string][]rrset{
+				"example.com.": {
+					{Name: "host1.example.com.", Type: "A", TTL: 300, Records: []record{{Content: "192.0.2.9"}}},
+					{Name: "host1.example.com.", Type: "AAAA", TTL: 300, Records: []record{{Content: "2001:db8::1"}}},
+					{Name: "manual.example.com.", Type: "A", TTL: 300, Records: []record{{Content: "192.0.2.10"}}},
+				},
+				"2.0.192.in-addr.arpa.": {
+					{Name: "9.2.0.192.in-addr.arpa.", Type: "PTR", TTL: 300, Records: []record{{Content: "host1.example.com."}}},
+				},
+			},
+			config: PowerDNSProviderConfig{
+				ForwardZone:      "example.com",
+				Ipv4ReverseZones: []string{"2.0.192.in-addr.arpa"},
+			},
+			endpoints: []*endpoint.Endpoint{
+				{Hostname: "host1", IPv4s: []string{"192.0.2.1"}},
+			},
+			want: []string{
+				"A host1.example.com. 192.0.2.1 ttl=300",
+				"A manual.example.com. 192.0.2.10 ttl=300",
+				"PTR 1.2.0.192.in-addr.arpa. host1.example.com. ttl=300",
+				"PTR 9.2.0.192.in-addr.arpa. host1.example.com. ttl=300",
+			},
+			wantCalls: []string{
+				"PATCH /api/v1/servers/localhost/zones/example.com.",
+				"PATCH /api/v1/servers/localhost/zones/2.0.192.in-addr.arpa.",
+			},
+		},
+		"cleans zones": {
+			zones: []string{"example.com.", "2.0.192.in-addr.arpa."},
+			existing: map[string][]rrset{
+				"example.com.": {
+					{Name: "manual.example.com.", Type: "A", TTL: 300, Records: []record{{Content: "192.0.2.10"}}},
+					{Name: "manual.example.com.", Type: "TXT", TTL: 300, Records: []record{{Content: `"keep"`}}},
+				},
+				"2.0.192.in-addr.arpa.": {
+					{Name: "9.2.0.192.in-addr.arpa.", Type: "PTR", TTL: 300, Records: []record{{Content: "host1.example.com."}}},
+				},
+			},
+			config: PowerDNSProviderConfig{
+				ForwardZone:       "example.com",
+				Ipv4ReverseZones:  []string{"2.0.192.in-addr.arpa"},
+				CleanForwardZone:  true,
+				CleanReverseZones: true,
+			},
+			endpoints: []*endpoint.Endpoint{
+				{Hostname: "host1", IPv4s: []string{"192.0.2.1"}},
+			},
+			want: []string{
+				"A host1.example.com. 192.0.2.1 ttl=300",
+				"PTR 1.2.0.192.in-addr.arpa. host1.example.com. ttl=300",
+				`TXT manual.example.com. "keep" ttl=300`,
+			},
+			wantCalls: []string{
+				"PATCH /api/v1/servers/localhost/zones/example.com.",
+				"PATCH /api/v1/servers/localhost/zones/2.0.192.in-addr.arpa.",
+			},
+		},
+		"creates missing reverse zones": {
+			zones: []string{"example.com."},
+			config: PowerDNSProviderConfig{
+				ForwardZone:            "example.com",
+				Ipv6ReverseZones:       []string{"8.b.d.0.1.0.0.2.ip6.arpa."},
+				CreateReverseZones:     true,
+				ReverseZoneNameservers: []string{"ns1.example.com."},
+			},
+			endpoints: []*endpoint.Endpoint{
+				{Hostname: "host1", IPv4s: []string{"192.0.2.1"}, IPv6s: []string{"2001:db8::1"}},
+			},
+			want: []string{
+				"A host1.example.com. 192.0.2.1 ttl=300",
+				"AAAA host1.example.com. 2001:db8::1 ttl=300",
+				"PTR 1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. host1.example.com. ttl=300",
+				"PTR 1.2.0.192.in-addr.arpa. host1.example.com. ttl=300",
+			},
+			wantCalls: []string{
+				"POST /api/v1/servers/localhost/zones",
+				"POST /api/v1/servers/localhost/zones",
+				"PATCH /api/v1/servers/localhost/zones/example.com.",
+				"PATCH /api/v1/servers/localhost/zones/2.0.192.in-addr.arpa.",
+				"PATCH /api/v1/servers/localhost/zones/8.b.d.0.1.0.0.2.ip6.arpa.",
+			},
+		},
+		"notifies secondaries of changed zones": {
+			zones: []string{"example.com.", "2.0.192.in-addr.arpa."},
+			config: PowerDNSProviderConfig{
+				ForwardZone:      "example.com",
+				Ipv4ReverseZones: []string{"2.0.192.in-addr.arpa"},
+				Notify:           true,
+			},
+			endpoints: []*endpoint.Endpoint{
+				{Hostname: "host1", IPv4s: []string{"192.0.2.1"}},
+			},
+			want: []string{
+				"A host1.example.com. 192.0.2.1 ttl=300",
+				"PTR 1.2.0.192.in-addr.arpa. host1.example.com. ttl=300",
+			},
+			wantCalls: []string{
+				"PATCH /api/v1/servers/localhost/zones/example.com.",
+				"PUT /api/v1/servers/localhost/zones/example.com./notify",
+				"PATCH /api/v1/servers/localhost/zones/2.0.192.in-addr.arpa.",
+				"PUT /api/v1/servers/localhost/zones/2.0.192.in-addr.arpa./notify",
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			api, server := newFakeAPI(t, tc.zones...)
+			for zoneName, rrsets := range tc.existing {
+				for _, rs := range rrsets {
+					api.addRRset(zoneName, rs)
+				}
+			}
+			p := newTestProvider(t, server, tc.config)
+
+			err := p.UpdateEndpoints(context.Background(), tc.endpoints)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, api.summary())
+			assert.Equal(t, tc.wantCalls, api.calls)
+
+			api.calls = nil
+			err = p.UpdateEndpoints(context.Background(), tc.endpoints)
+			require.NoError(t, err)
+			assert.Empty(t, api.calls, "second sync should not change anything")
+		})
+	}
+}
+
+func TestUpdateEndpoints_RecordConflicts(t *testing.T) {
+	t.Parallel()
+
+	api, server := newFakeAPI(t, "example.com.")
+	p := newTestProvider(t, server, PowerDNSProviderConfig{ForwardZone: "example.com"})
+
+	err := p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+		{Hostname: "nas", IPv4s: []string{"192.0.2.1"}},
+		{
+			Hostname: "synology-ds920",
+			IPv4s:    []string{"192.0.2.2"},
+			Aliases:  []string{"nas", "files"},
+			Records: []endpoint.Record{
+				{Type: endpoint.RecordTypeTXT, Name: "files", Value: "conflicts with the CNAME"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"A nas.example.com. 192.0.2.1 ttl=300",
+		"A synology-ds920.example.com. 192.0.2.2 ttl=300",
+		"CNAME files.example.com. synology-ds920.example.com. ttl=300",
+	}, api.summary())
+}
+
+func TestUpdateEndpoints_RemovesWrittenRecords(t *testing.T) {
+	t.Parallel()
+
+	api, server := newFakeAPI(t, "example.com.")
+	api.addRRset("example.com.", rrset{Name: "manual.example.com.", Type: "TXT", TTL: 300, Records: []record{{Content: `"keep"`}}})
+	p := newTestProvider(t, server, PowerDNSProviderConfig{ForwardZone: "example.com"})
+
+	endpoints := []*endpoint.Endpoint{
+		{
+			Hostname: "host1",
+			IPv4s:    []string{"192.0.2.1"},
+			Aliases:  []string{"www"},
+			Records: []endpoint.Record{
+				{Type: endpoint.RecordTypeTXT, Value: "hi"},
+			},
+		},
+		{Hostname: "gone", IPv4s: []string{"192.0.2.2"}},
+	}
+	err := p.UpdateEndpoints(context.Background(), endpoints)
+	require.NoError(t, err)
+
+	endpoints[0].Aliases = nil
+	endpoints[0].Records = nil
+	err = p.UpdateEndpoints(context.Background(), endpoints[:1])
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"A host1.example.com. 192.0.2.1 ttl=300",
+		`TXT manual.example.com. "keep" ttl=300`,
+	}, api.summary())
+}
+
+func TestUpdateEndpoints_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config PowerDNSProviderConfig
+		apiKey string
+		errMsg string
+	}{
+		"missing forward zone": {
+			config: PowerDNSProviderConfig{ForwardZone: "example.org"},
+			errMsg: `forward lookup zone "example.org." does not exist`,
+		},
+		"missing reverse zone": {
+			config: PowerDNSProviderConfig{Ipv4ReverseZones: []string{"2.0.192.in-addr.arpa"}},
+			errMsg: `reverse lookup zone "2.0.192.in-addr.arpa." does not exist`,
+		},
+		"invalid api key": {
+			config: PowerDNSProviderConfig{ForwardZone: "example.com"},
+			apiKey: "wrong",
+			errMsg: "failed with status 401: Unauthorized",
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, server := newFakeAPI(t, "example.com.")
+			p := newTestProvider(t, server, tc.config)
+			if tc.apiKey != "" {
+				p.config.APIKey = tc.apiKey
+			}
+
+			err := p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+				{Hostname: "host1", IPv4s: []string{"192.0.2.1"}},
+			})
+			assert.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package unbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/sapslaj/zonepop/pkg/configfile"
+	"github.com/sapslaj/zonepop/pkg/recordset"
+)
+
+// control runs unbound-control with args and input as its standard input,
+// and reports the errors it prints, which don't always make it fail. The
+// command is the unbound-control command line, e.g. with sudo.
+func control(ctx context.Context, host configfile.Host, command string, args []string, input string) (string, error) {
+	quoted := make([]string, 0, len(args)+1)
+	quoted = append(quoted, command)
+	for _, arg := range args {
+		quoted = append(quoted, configfile.Quote(arg))
+	}
+	output, err := host.RunWithInput(ctx, strings.Join(quoted, " "), input)
+	if err != nil {
+		return output, fmt.Errorf("unbound-control %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(output))
+	}
+	var errs []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "error") {
+			errs = append(errs, line)
+		}
+	}
+	if len(errs) > 0 {
+		return output, fmt.Errorf("unbound-control %s failed: %w", strings.Join(args, " "), errors.New(strings.Join(errs, "; ")))
+	}
+	return output, nil
+}
+
+// parseLocalData parses the output of unbound-control list_local_data, one
+// resource record per line in zone file format, into record sets.
+func parseLocalData(output string) map[recordset.Key]*recordset.Set {
+	sets := map[recordset.Key]*recordset.Set{}
+	for _, line := range strings.Split(output, "\n") {
+		// the record data may contain TXT strings with whitespace, so only the
+		// fields before it are split
+		fields := make([]string, 0, 5)
+		rest := line
+		for len(fields) < 4 {
+			var field string
+			field, rest = cutField(rest)
+			fields = append(fields, field)
+		}
+		fields = append(fields, strings.TrimSpace(rest))
+		if fields[4] == "" || fields[2] != "IN" {
+			continue
+		}
+		ttl, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		key := recordset.Key{Name: recordset.Canonical(fields[0]), Type: fields[3]}
+		s, ok := sets[key]
+		if !ok {
+			s = &recordset.Set{Name: key.Name, Type: key.Type, TTL: ttl}
+			sets[key] = s
+		}
+		s.Values = append(s.Values, fields[4])
+	}
+	return sets
+}
+
+// cutField returns the first whitespace separated field of s and the rest
+// after it.
+func cutField(s string) (string, string) {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
@@ -0,0 +1,299 @@
+package unbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/config/configtypes"
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/configfile"
+	"github.com/sapslaj/zonepop/pkg/dnsname"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/rdns"
+	"github.com/sapslaj/zonepop/pkg/recordset"
+	"github.com/sapslaj/zonepop/pkg/utils"
+	"github.com/sapslaj/zonepop/provider"
+)
+
+const DefaultCommand = "unbound-control"
+
+type UnboundProviderConfig struct {
+	// Appended to hostnames, also the domain cleaned with CleanForwardZone
+	RecordSuffix string
+	// unbound-control command line (default "unbound-control"), e.g. with sudo
+	// or the -c option
+	Command string
+	// TTL of records of endpoints without one (default 300)
+	DefaultTTL int64
+	// Remove A and AAAA local data of names in the record suffix that isn't
+	// desired
+	CleanForwardZone bool
+	// Remove PTR local data that isn't desired
+	CleanIPv4ReverseZone bool
+	CleanIPv6ReverseZone bool
+	// Config file written with the local-data and local-data-ptr entries, to
+	// be included in unbound.conf so they survive restarts
+	IncludeFile        string
+	IncludePermissions string
+	// Run unbound-control and write the include file on a remote host
+	SSH configfile.SSHConfig
+	// How hostnames are normalized into valid DNS names
+	Normalization dnsname.Policy
+}
+
+type unboundProvider struct {
+	config              UnboundProviderConfig
+	forwardLookupFilter configtypes.EndpointFilterFunc
+	reverseLookupFilter configtypes.EndpointFilterFunc
+	logger              *zap.Logger
+	normalizer          *dnsname.Normalizer
+	connect             configfile.ConnectFunc
+	written             recordset.Written
+}
+
+func NewUnboundProvider(
+	name string,
+	providerConfig UnboundProviderConfig,
+	forwardLookupFilter configtypes.EndpointFilterFunc,
+	reverseLookupFilter configtypes.EndpointFilterFunc,
+) (provider.Provider, error) {
+	if strings.Trim(providerConfig.RecordSuffix, ".") == "" {
+		return nil, errors.New("record_suffix is required")
+	}
+	if providerConfig.Command == "" {
+		providerConfig.Command = DefaultCommand
+	}
+	if providerConfig.IncludePermissions == "" {
+		providerConfig.IncludePermissions = configfile.DefaultPermissions
+	}
+	if _, err := strconv.ParseUint(providerConfig.IncludePermissions, 8, 32); err != nil {
+		return nil, fmt.Errorf("invalid include_permissions %q: %w", providerConfig.IncludePermissions, err)
+	}
+	normalizer, err := dnsname.NewNormalizer(name, providerConfig.Normalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure hostname normalization: %w", err)
+	}
+	return &unboundProvider{
+		config:              providerConfig,
+		forwardLookupFilter: forwardLookupFilter,
+		reverseLookupFilter: reverseLookupFilter,
+		logger:              log.MustNewLogger().Named("unbound_provider"),
+		normalizer:          normalizer,
+		connect:             configfile.Connect,
+		written:             recordset.Written{},
+	}, nil
+}
+
+func (p *unboundProvider) UpdateEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) error {
+	host, err := p.connect(ctx, p.config.SSH)
+	if err != nil {
+		p.logger.Sugar().Errorw("could not connect to host", "host", p.config.SSH.Host, "err", err)
+		return err
+	}
+	defer func() {
+		err := host.Close()
+		if err != nil {
+			p.logger.Sugar().Errorw("error disconnecting from host", "host", p.config.SSH.Host, "err", err)
+		}
+	}()
+
+	output, err := control(ctx, host, p.config.Command, []string{"list_local_data"}, "")
+	if err != nil {
+		p.logger.Sugar().Errorw("could not list local data", "err", err)
+		return err
+	}
+	client := &localData{host: host, command: p.config.Command, sets: parseLocalData(output)}
+
+	suffix := strings.Trim(p.config.RecordSuffix, ".")
+	zones := recordset.Zones{}
+	for _, name := range []string{suffix, "in-addr.arpa", "ip6.arpa"} {
+		zones.Add(recordset.Zone{ID: name, Name: name})
+	}
+	forwardEndpoints := utils.Filter(p.forwardLookupFilter, endpoints)
+	reverseEndpoints := utils.Filter(p.reverseLookupFilter, endpoints)
+	syncConfig := recordset.SyncConfig{
+		RecordSuffix:         p.config.RecordSuffix,
+		Normalizer:           p.normalizer,
+		Logger:               p.logger,
+		DefaultTTL:           p.config.DefaultTTL,
+		TXTValue:             recordset.QuotedTXT,
+		CleanForwardZone:     p.config.CleanForwardZone,
+		CleanIPv4ReverseZone: p.config.CleanIPv4ReverseZone,
+		CleanIPv6ReverseZone: p.config.CleanIPv6ReverseZone,
+		Written:              p.written,
+	}
+	err = recordset.Sync(ctx, client, zones, forwardEndpoints, reverseEndpoints, syncConfig)
+
+	if p.config.IncludeFile != "" {
+		p.logger.Sugar().Infof("writing include file %s", p.config.IncludeFile)
+		include, includeErr := includeFile(zones, forwardEndpoints, reverseEndpoints, syncConfig)
+		if includeErr == nil {
+			includeErr = host.WriteFile(ctx, p.config.IncludeFile, include, p.config.IncludePermissions)
+		}
+		if includeErr != nil {
+			err = multierr.Append(err, fmt.Errorf("could not write include file %s: %w", p.config.IncludeFile, includeErr))
+		}
+	}
+	if err != nil {
+		p.logger.Sugar().Errorw("failed to update Unbound local data", "err", err)
+	}
+	return err
+}
+
+// localData implements [recordset.Client] for the local data of Unbound,
+// which is listed once and kept up to date with the applied changes.
+type localData struct {
+	host    configfile.Host
+	command string
+	sets    map[recordset.Key]*recordset.Set
+}
+
+// builtin reports whether s is local data Unbound has by default, which is
+// never changed.
+func builtin(s *recordset.Set) bool {
+	return s.Name == "localhost." || (s.Type == recordset.TypePTR && len(s.Values) == 1 && recordset.Value(s.Type, s.Values[0]) == "localhost")
+}
+
+func (c *localData) RecordSets(ctx context.Context, zone recordset.Zone) ([]recordset.Set, error) {
+	sets := make([]recordset.Set, 0)
+	for key, s := range c.sets {
+		if key.Name != zone.Name && !strings.HasSuffix(key.Name, "."+zone.Name) || builtin(s) {
+			continue
+		}
+		sets = append(sets, *s)
+	}
+	return sets, nil
+}
+
+// Apply implements [recordset.Client]. Unbound can only remove all local
+// data of a name, so names with deleted or updated record sets are removed
+// and their remaining record sets added again.
+func (c *localData) Apply(ctx context.Context, zone recordset.Zone, changes recordset.Changes) error {
+	removed := map[string]bool{}
+	deleted := map[recordset.Key]bool{}
+	for _, s := range changes.Delete {
+		removed[s.Key().Name] = true
+		deleted[s.Key()] = true
+	}
+	for _, u := range changes.Update {
+		removed[u.New.Key().Name] = true
+	}
+	added := map[recordset.Key]recordset.Set{}
+	for key, s := range c.sets {
+		if removed[key.Name] && !deleted[key] {
+			added[key] = *s
+		}
+	}
+	for _, u := range changes.Update {
+		added[u.New.Key()] = u.New
+	}
+	for _, s := range changes.Create {
+		added[s.Key()] = s
+	}
+
+	if len(removed) > 0 {
+		names := make([]string, 0, len(removed))
+		for name := range removed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		_, err := control(ctx, c.host, c.command, []string{"local_datas_remove"}, strings.Join(names, "\n")+"\n")
+		if err != nil {
+			return err
+		}
+		for key := range c.sets {
+			if removed[key.Name] {
+				delete(c.sets, key)
+			}
+		}
+	}
+
+	keys := make([]recordset.Key, 0, len(added))
+	for key := range added {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Name != keys[j].Name {
+			return keys[i].Name < keys[j].Name
+		}
+		return keys[i].Type < keys[j].Type
+	})
+	var rrs strings.Builder
+	for _, key := range keys {
+		s := added[key]
+		for _, value := range s.Values {
+			fmt.Fprintf(&rrs, "%s %d IN %s %s\n", s.Name, s.TTL, s.Type, value)
+		}
+	}
+	if rrs.Len() == 0 {
+		return nil
+	}
+	_, err := control(ctx, c.host, c.command, []string{"local_datas"}, rrs.String())
+	if err != nil {
+		return err
+	}
+	for key, s := range added {
+		c.sets[key] = &s
+	}
+	return nil
+}
+
+// includeFile returns an Unbound config file with the desired local data of
+// the endpoints, PTR records as local-data-ptr entries.
+func includeFile(zones recordset.Zones, forwardEndpoints []*endpoint.Endpoint, reverseEndpoints []*endpoint.Endpoint, c recordset.SyncConfig) (string, error) {
+	// invalid hostnames and records were logged by the sync already
+	c.Logger = zap.NewNop()
+	forward, _ := recordset.Forward(forwardEndpoints, c)
+	localData := make([]string, 0)
+	for _, s := range forward {
+		if recordset.ZoneFor(zones.Forward, s.Name) == nil {
+			continue
+		}
+		for _, value := range s.Values {
+			localData = append(localData, configOption("local-data", fmt.Sprintf("%s %d IN %s %s", s.Name, s.TTL, s.Type, value)))
+		}
+	}
+	sort.Strings(localData)
+
+	ptrs, err := recordset.PTRs(reverseEndpoints, c)
+	if err != nil {
+		return "", err
+	}
+	localDataPTR := make([]string, 0)
+	for _, desired := range ptrs {
+		for _, s := range desired {
+			addr, err := rdns.AddrFromReverse(s.Name)
+			if err != nil {
+				return "", err
+			}
+			for _, value := range s.Values {
+				localDataPTR = append(localDataPTR, configOption("local-data-ptr", fmt.Sprintf("%s %d %s", addr, s.TTL, value)))
+			}
+		}
+	}
+	sort.Strings(localDataPTR)
+
+	var b strings.Builder
+	b.WriteString("# Generated by ZonePop\n")
+	b.WriteString("server:\n")
+	for _, option := range append(localData, localDataPTR...) {
+		b.WriteString("\t" + option + "\n")
+	}
+	return b.String(), nil
+}
+
+// configOption returns an option of unbound.conf with a quoted value. Values
+// with double quotes, like TXT records, are quoted with single quotes.
+func configOption(name string, value string) string {
+	if strings.Contains(value, `"`) {
+		return name + ": '" + value + "'"
+	}
+	return name + `: "` + value + `"`
+}
@@ -0,0 +1,264 @@
+package unbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/configfile"
+	"github.com/sapslaj/zonepop/pkg/recordset"
+)
+
+// fakeUnbound is an in-memory stand-in for the local data commands of
+// unbound-control and the file system of the host.
+type fakeUnbound struct {
+	mu sync.Mutex
+	// resource records in the format of list_local_data
+	localData []string
+	commands  []string
+	files     map[string]string
+	// output of local_datas, like for invalid records
+	localDatasOutput string
+}
+
+func newFakeUnbound(localData ...string) *fakeUnbound {
+	return &fakeUnbound{localData: localData, files: map[string]string{}}
+}
+
+var _ configfile.Host = (*fakeUnbound)(nil)
+
+func (u *fakeUnbound) ReadFile(ctx context.Context, path string) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.files[path], nil
+}
+
+func (u *fakeUnbound) Run(ctx context.Context, command string) (string, error) {
+	return u.RunWithInput(ctx, command, "")
+}
+
+func (u *fakeUnbound) RunWithInput(ctx context.Context, command string, input string) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	lines := strings.Split(strings.TrimSuffix(input, "\n"), "\n")
+	switch strings.TrimPrefix(command, DefaultCommand+" ") {
+	case "'list_local_data'":
+		return strings.Join(u.localData, "\n") + "\n", nil
+	case "'local_datas_remove'":
+		u.commands = append(u.commands, "remove "+strings.Join(lines, ","))
+		u.localData = slices.DeleteFunc(u.localData, func(rr string) bool {
+			name, _ := cutField(rr)
+			return slices.Contains(lines, name)
+		})
+		return "ok\n", nil
+	case "'local_datas'":
+		if u.localDatasOutput != "" {
+			return u.localDatasOutput, nil
+		}
+		for _, line := range lines {
+			u.commands = append(u.commands, "add "+line)
+			fields := strings.Fields(line)
+			u.localData = append(u.localData, strings.Join(fields[:4], "\t")+"\t"+strings.Join(fields[4:], " "))
+		}
+		return fmt.Sprintf("added %d datas\n", len(lines)), nil
+	default:
+		return "error unknown command\n", errors.New("exit status 1")
+	}
+}
+
+func (u *fakeUnbound) WriteFile(ctx context.Context, path string, content string, permissions string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.files[path] = permissions + "\n" + content
+	return nil
+}
+
+func (u *fakeUnbound) Close() error {
+	return nil
+}
+
+func newTestProvider(t *testing.T, u *fakeUnbound, config UnboundProviderConfig) *unboundProvider {
+	config.RecordSuffix = ".lan"
+	p, err := NewUnboundProvider(
+		"test",
+		config,
+		func(e *endpoint.Endpoint) bool { return true },
+		func(e *endpoint.Endpoint) bool { return true },
+	)
+	require.NoError(t, err)
+	up := p.(*unboundProvider)
+	up.connect = func(ctx context.Context, c configfile.SSHConfig) (configfile.Host, error) {
+		return u, nil
+	}
+	return up
+}
+
+func testLocalData() []string {
+	return []string{
+		"localhost.\t10800\tIN\tNS\tlocalhost.",
+		"localhost.\t10800\tIN\tA\t127.0.0.1",
+		"1.0.0.127.in-addr.arpa.\t10800\tIN\tPTR\tlocalhost.",
+		"host1.lan.\t300\tIN\tA\t192.0.2.9",
+		"host1.lan.\t300\tIN\tTXT\t\"manual  text\"",
+		"host1.lan.\t300\tIN\tAAAA\t2001:db8::1",
+		"manual.lan.\t3600\tIN\tA\t192.0.2.10",
+		"10.2.0.192.in-addr.arpa.\t3600\tIN\tPTR\tmanual.lan.",
+	}
+}
+
+func TestUpdateEndpoints(t *testing.T) {
+	t.Parallel()
+
+	endpoints := []*endpoint.Endpoint{
+		{Hostname: "host1", IPv4s: []string{"192.0.2.1"}, IPv6s: []string{"2001:db8::1"}},
+		{Hostname: "host2", IPv4s: []string{"192.0.2.2"}, Aliases: []string{"www"}, Records: []endpoint.Record{
+			{Type: endpoint.RecordTypeTXT, Name: "host2", Value: "v=spf1 -all"},
+		}},
+	}
+
+	tests := map[string]struct {
+		config       UnboundProviderConfig
+		wantCommands []string
+	}{
+		"hostnames of endpoints": {
+			wantCommands: []string{
+				"remove host1.lan.",
+				"add host1.lan. 300 IN A 192.0.2.1",
+				"add host1.lan. 300 IN AAAA 2001:db8::1",
+				`add host1.lan. 300 IN TXT "manual  text"`,
+				"add host2.lan. 300 IN A 192.0.2.2",
+				`add host2.lan. 300 IN TXT "v=spf1 -all"`,
+				"add www.lan. 300 IN CNAME host2.lan.",
+				"add 1.2.0.192.in-addr.arpa. 300 IN PTR host1.lan.",
+				"add 2.2.0.192.in-addr.arpa. 300 IN PTR host2.lan.",
+				"add 1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. 300 IN PTR host1.lan.",
+			},
+		},
+		"clean": {
+			config: UnboundProviderConfig{
+				CleanForwardZone:     true,
+				CleanIPv4ReverseZone: true,
+				CleanIPv6ReverseZone: true,
+			},
+			wantCommands: []string{
+				"remove host1.lan.,manual.lan.",
+				"add host1.lan. 300 IN A 192.0.2.1",
+				"add host1.lan. 300 IN AAAA 2001:db8::1",
+				`add host1.lan. 300 IN TXT "manual  text"`,
+				"add host2.lan. 300 IN A 192.0.2.2",
+				`add host2.lan. 300 IN TXT "v=spf1 -all"`,
+				"add www.lan. 300 IN CNAME host2.lan.",
+				"remove 10.2.0.192.in-addr.arpa.",
+				"add 1.2.0.192.in-addr.arpa. 300 IN PTR host1.lan.",
+				"add 2.2.0.192.in-addr.arpa. 300 IN PTR host2.lan.",
+				"add 1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. 300 IN PTR host1.lan.",
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			u := newFakeUnbound(testLocalData()...)
+			p := newTestProvider(t, u, tc.config)
+
+			err := p.UpdateEndpoints(context.Background(), endpoints)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantCommands, u.commands)
+			assert.Contains(t, u.localData, "localhost.\t10800\tIN\tA\t127.0.0.1")
+			assert.Contains(t, u.localData, "1.0.0.127.in-addr.arpa.\t10800\tIN\tPTR\tlocalhost.")
+
+			u.commands = nil
+			err = p.UpdateEndpoints(context.Background(), endpoints)
+			require.NoError(t, err)
+			assert.Empty(t, u.commands, "second sync should not change anything")
+		})
+	}
+}
+
+func TestUpdateEndpoints_IncludeFile(t *testing.T) {
+	t.Parallel()
+
+	u := newFakeUnbound()
+	p := newTestProvider(t, u, UnboundProviderConfig{
+		IncludeFile: "/etc/unbound/zonepop.conf",
+		DefaultTTL:  60,
+	})
+	err := p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+		{Hostname: "host1", IPv4s: []string{"192.0.2.1"}, IPv6s: []string{"2001:db8::1"}, RecordTTL: 300},
+		{Hostname: "host2", IPv4s: []string{"192.0.2.2"}, Records: []endpoint.Record{
+			{Type: endpoint.RecordTypeTXT, Name: "host2", Value: "v=spf1 -all"},
+		}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `0644
+# Generated by ZonePop
+server:
+	local-data: "host1.lan. 300 IN A 192.0.2.1"
+	local-data: "host1.lan. 300 IN AAAA 2001:db8::1"
+	local-data: "host2.lan. 60 IN A 192.0.2.2"
+	local-data: 'host2.lan. 60 IN TXT "v=spf1 -all"'
+	local-data-ptr: "192.0.2.1 300 host1.lan."
+	local-data-ptr: "192.0.2.2 60 host2.lan."
+	local-data-ptr: "2001:db8::1 300 host1.lan."
+`, u.files["/etc/unbound/zonepop.conf"])
+}
+
+func TestUpdateEndpoints_Errors(t *testing.T) {
+	t.Parallel()
+
+	u := newFakeUnbound()
+	u.localDatasOutput = "error for input line: host1.lan. 300 IN A 192.0.2.1\nadded 0 datas\n"
+	p := newTestProvider(t, u, UnboundProviderConfig{})
+	err := p.UpdateEndpoints(context.Background(), []*endpoint.Endpoint{
+		{Hostname: "host1", IPv4s: []string{"192.0.2.1"}},
+	})
+	assert.ErrorContains(t, err, "unbound-control local_datas failed: error for input line: host1.lan. 300 IN A 192.0.2.1")
+}
+
+func TestParseLocalData(t *testing.T) {
+	t.Parallel()
+
+	sets := parseLocalData(strings.Join([]string{
+		"Host1.lan.\t300\tIN\tA\t192.0.2.1",
+		"host1.lan.\t300\tIN\tA\t192.0.2.2",
+		"host1.lan. 300 IN TXT \"v=spf1  -all\" \"second\"",
+		"",
+		"invalid",
+	}, "\n"))
+	assert.Equal(t, map[recordset.Key]*recordset.Set{
+		{Name: "host1.lan.", Type: "A"}: {
+			Name:   "host1.lan.",
+			Type:   "A",
+			TTL:    300,
+			Values: []string{"192.0.2.1", "192.0.2.2"},
+		},
+		{Name: "host1.lan.", Type: "TXT"}: {
+			Name:   "host1.lan.",
+			Type:   "TXT",
+			TTL:    300,
+			Values: []string{`"v=spf1  -all" "second"`},
+		},
+	}, sets)
+}
+
+func TestNewUnboundProvider_Invalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewUnboundProvider("test", UnboundProviderConfig{RecordSuffix: "."}, nil, nil)
+	assert.ErrorContains(t, err, "record_suffix is required")
+
+	_, err = NewUnboundProvider("test", UnboundProviderConfig{RecordSuffix: "lan", IncludePermissions: "rw-r--r--"}, nil, nil)
+	assert.ErrorContains(t, err, "invalid include_permissions")
+}
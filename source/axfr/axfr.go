@@ -0,0 +1,243 @@
+package axfr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/source"
+)
+
+type AXFRSourceConfig struct {
+	// Zone to import, e.g. "example.com"
+	Zone string
+	// Server to transfer the zone from as host or host:port (port defaults
+	// to 53)
+	Server string
+	// Path to an RFC 1035 zone file to read instead of transferring the zone
+	ZoneFile string
+	// TSIG key name, algorithm and base64 encoded secret for signing the
+	// transfer. The algorithm defaults to hmac-sha256.
+	TSIGKeyName   string
+	TSIGAlgorithm string
+	TSIGSecret    string
+	// Keep the zone name on hostnames instead of making them relative to the
+	// zone, e.g. "host.example.com" instead of "host"
+	FQDN bool
+	// Also create an endpoint for records at the zone apex. Requires FQDN
+	// since the relative name of the apex is empty.
+	IncludeApex    bool
+	TimeoutSeconds int
+	// TTL for resulting records. Defaults to the lowest TTL of the imported
+	// records for each name.
+	RecordTTL int64
+}
+
+type axfrSource struct {
+	config AXFRSourceConfig
+	logger *zap.Logger
+	zone   string
+}
+
+func NewAXFRSource(sourceConfig AXFRSourceConfig) (source.Source, error) {
+	if sourceConfig.Zone == "" {
+		return nil, fmt.Errorf("zone is required")
+	}
+	if (sourceConfig.Server == "") == (sourceConfig.ZoneFile == "") {
+		return nil, fmt.Errorf("exactly one of server or zone_file is required")
+	}
+	if sourceConfig.IncludeApex && !sourceConfig.FQDN {
+		return nil, fmt.Errorf("include_apex requires fqdn")
+	}
+	if sourceConfig.Server != "" {
+		_, _, err := net.SplitHostPort(sourceConfig.Server)
+		if err != nil {
+			sourceConfig.Server = net.JoinHostPort(sourceConfig.Server, "53")
+		}
+	}
+	if sourceConfig.TSIGKeyName != "" {
+		if sourceConfig.TSIGSecret == "" {
+			return nil, fmt.Errorf("tsig_secret is required when tsig_key_name is set")
+		}
+		if sourceConfig.TSIGAlgorithm == "" {
+			sourceConfig.TSIGAlgorithm = dns.HmacSHA256
+		}
+		sourceConfig.TSIGKeyName = dns.Fqdn(strings.ToLower(sourceConfig.TSIGKeyName))
+		sourceConfig.TSIGAlgorithm = dns.Fqdn(strings.ToLower(sourceConfig.TSIGAlgorithm))
+	}
+	if sourceConfig.TimeoutSeconds == 0 {
+		sourceConfig.TimeoutSeconds = 30
+	}
+	logger := log.MustNewLogger().Named("axfr_source").With(
+		zap.String("zone", sourceConfig.Zone),
+	)
+	if sourceConfig.Server != "" {
+		logger = logger.With(zap.String("server", sourceConfig.Server))
+	} else {
+		logger = logger.With(zap.String("zone_file", sourceConfig.ZoneFile))
+	}
+	return &axfrSource{
+		config: sourceConfig,
+		logger: logger,
+		zone:   dns.CanonicalName(sourceConfig.Zone),
+	}, nil
+}
+
+func (s *axfrSource) Endpoints(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	var records []dns.RR
+	var err error
+	if s.config.ZoneFile != "" {
+		records, err = s.readZoneFile()
+	} else {
+		records, err = s.transfer(ctx)
+	}
+	if err != nil {
+		newErr := fmt.Errorf("could not get records for zone %s: %w", s.zone, err)
+		s.logger.Error(newErr.Error())
+		return nil, newErr
+	}
+	return s.recordsToEndpoints(records), nil
+}
+
+// transfer requests a full zone transfer from the configured server.
+func (s *axfrSource) transfer(ctx context.Context) ([]dns.RR, error) {
+	s.logger.Info("Transferring zone")
+	timeout := time.Duration(s.config.TimeoutSeconds) * time.Second
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	msg := new(dns.Msg)
+	msg.SetAxfr(s.zone)
+	t := &dns.Transfer{
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	}
+	if s.config.TSIGKeyName != "" {
+		t.TsigSecret = map[string]string{s.config.TSIGKeyName: s.config.TSIGSecret}
+		msg.SetTsig(s.config.TSIGKeyName, s.config.TSIGAlgorithm, 300, time.Now().Unix())
+	}
+	envelopes, err := t.In(msg, s.config.Server)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]dns.RR, 0)
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return nil, envelope.Error
+		}
+		records = append(records, envelope.RR...)
+	}
+	return records, nil
+}
+
+// readZoneFile parses the configured zone file. $INCLUDE directives are
+// allowed since the file is as trusted as the config itself.
+func (s *axfrSource) readZoneFile() ([]dns.RR, error) {
+	s.logger.Info("Reading zone file")
+	f, err := os.Open(s.config.ZoneFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseZone(f, s.zone, s.config.ZoneFile)
+}
+
+func parseZone(r io.Reader, origin string, filename string) ([]dns.RR, error) {
+	zp := dns.NewZoneParser(r, origin, filename)
+	zp.SetIncludeAllowed(true)
+	records := make([]dns.RR, 0)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		records = append(records, rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// hostname returns the endpoint hostname for an owner name in the zone, or
+// an empty string if the name shouldn't get an endpoint.
+func (s *axfrSource) hostname(name string) string {
+	name = dns.CanonicalName(name)
+	if !dns.IsSubDomain(s.zone, name) {
+		return ""
+	}
+	if name == s.zone {
+		if !s.config.IncludeApex {
+			return ""
+		}
+	} else if strings.HasPrefix(name, "*.") {
+		// wildcards can't be represented as a single host
+		return ""
+	}
+	if s.config.FQDN || name == s.zone {
+		return strings.TrimSuffix(name, ".")
+	}
+	return strings.TrimSuffix(name, "."+s.zone)
+}
+
+// recordsToEndpoints groups the A and AAAA records by owner name into
+// endpoints in the order the names first appear.
+func (s *axfrSource) recordsToEndpoints(records []dns.RR) []*endpoint.Endpoint {
+	endpoints := make([]*endpoint.Endpoint, 0)
+	byName := map[string]*endpoint.Endpoint{}
+	for _, rr := range records {
+		var ip string
+		switch record := rr.(type) {
+		case *dns.A:
+			ip = record.A.String()
+		case *dns.AAAA:
+			ip = record.AAAA.String()
+		default:
+			continue
+		}
+		header := rr.Header()
+		hostname := s.hostname(header.Name)
+		if hostname == "" {
+			s.logger.Debug("skipping record", zap.String("name", header.Name))
+			continue
+		}
+		e, ok := byName[hostname]
+		if !ok {
+			e = &endpoint.Endpoint{
+				Hostname:  hostname,
+				IPv4s:     []string{},
+				IPv6s:     []string{},
+				RecordTTL: int64(header.Ttl),
+				SourceProperties: map[string]any{
+					"axfr_zone": strings.TrimSuffix(s.zone, "."),
+					"axfr_name": strings.TrimSuffix(dns.CanonicalName(header.Name), "."),
+				},
+			}
+			byName[hostname] = e
+			endpoints = append(endpoints, e)
+		}
+		if int64(header.Ttl) < e.RecordTTL {
+			e.RecordTTL = int64(header.Ttl)
+		}
+		if header.Rrtype == dns.TypeA {
+			if !slices.Contains(e.IPv4s, ip) {
+				e.IPv4s = append(e.IPv4s, ip)
+			}
+		} else if !slices.Contains(e.IPv6s, ip) {
+			e.IPv6s = append(e.IPv6s, ip)
+		}
+	}
+	if s.config.RecordTTL != 0 {
+		for _, e := range endpoints {
+			e.RecordTTL = s.config.RecordTTL
+		}
+	}
+	return endpoints
+}
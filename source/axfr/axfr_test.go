@@ -0,0 +1,239 @@
+package axfr
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+const testZone = `$ORIGIN example.com.
+$TTL 300
+@         IN SOA   ns1 hostmaster 1 3600 600 86400 300
+@         IN NS    ns1
+@         IN A     192.0.2.1
+ns1       IN A     192.0.2.2
+router    IN A     192.0.2.3
+router    IN AAAA  2001:db8::3
+router 60 IN A     192.0.2.4
+router    IN A     192.0.2.3
+www       IN CNAME router
+*         IN A     192.0.2.99
+other.org. IN A    198.51.100.1
+printer   IN TXT   "ignored"
+printer   IN AAAA  2001:db8::10
+`
+
+const testTSIGKey = "transfer-key."
+
+// base64 of "zonepop-test-secret"
+const testTSIGSecret = "em9uZXBvcC10ZXN0LXNlY3JldA=="
+
+// newTestServer starts an in-process DNS server that answers AXFR requests
+// for example.com with the records in testZone and returns its address.
+func newTestServer(t *testing.T, tsigSecret map[string]string) string {
+	t.Helper()
+	records, err := parseZone(strings.NewReader(testZone), "example.com.", "test")
+	require.NoError(t, err)
+
+	mux := dns.NewServeMux()
+	mux.HandleFunc("example.com.", func(w dns.ResponseWriter, r *dns.Msg) {
+		if tsigSecret != nil && (r.IsTsig() == nil || w.TsigStatus() != nil) {
+			m := new(dns.Msg)
+			m.SetRcode(r, dns.RcodeRefused)
+			w.WriteMsg(m)
+			return
+		}
+		if r.Question[0].Qtype != dns.TypeAXFR {
+			m := new(dns.Msg)
+			m.SetRcode(r, dns.RcodeNotImplemented)
+			w.WriteMsg(m)
+			return
+		}
+		// the transfer starts and ends with the SOA record
+		soa := records[0]
+		ch := make(chan *dns.Envelope)
+		tr := new(dns.Transfer)
+		tr.TsigSecret = tsigSecret
+		go func() {
+			ch <- &dns.Envelope{RR: []dns.RR{soa}}
+			ch <- &dns.Envelope{RR: records[1:]}
+			ch <- &dns.Envelope{RR: []dns.RR{soa}}
+			close(ch)
+		}()
+		tr.Out(w, r, ch)
+		w.Hijack()
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &dns.Server{
+		Listener:   listener,
+		Handler:    mux,
+		TsigSecret: tsigSecret,
+	}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return listener.Addr().String()
+}
+
+var expectedEndpoints = []*endpoint.Endpoint{
+	{
+		Hostname:  "ns1",
+		IPv4s:     []string{"192.0.2.2"},
+		IPv6s:     []string{},
+		RecordTTL: 300,
+		SourceProperties: map[string]any{
+			"axfr_zone": "example.com",
+			"axfr_name": "ns1.example.com",
+		},
+	},
+	{
+		Hostname:  "router",
+		IPv4s:     []string{"192.0.2.3", "192.0.2.4"},
+		IPv6s:     []string{"2001:db8::3"},
+		RecordTTL: 60,
+		SourceProperties: map[string]any{
+			"axfr_zone": "example.com",
+			"axfr_name": "router.example.com",
+		},
+	},
+	{
+		Hostname:  "printer",
+		IPv4s:     []string{},
+		IPv6s:     []string{"2001:db8::10"},
+		RecordTTL: 300,
+		SourceProperties: map[string]any{
+			"axfr_zone": "example.com",
+			"axfr_name": "printer.example.com",
+		},
+	},
+}
+
+func TestEndpoints_Transfer(t *testing.T) {
+	addr := newTestServer(t, nil)
+	s, err := NewAXFRSource(AXFRSourceConfig{
+		Zone:   "example.com",
+		Server: addr,
+	})
+	require.NoError(t, err)
+
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	if diff := cmp.Diff(expectedEndpoints, endpoints); diff != "" {
+		t.Errorf("Endpoints() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEndpoints_TransferTSIG(t *testing.T) {
+	addr := newTestServer(t, map[string]string{testTSIGKey: testTSIGSecret})
+
+	s, err := NewAXFRSource(AXFRSourceConfig{
+		Zone:        "example.com",
+		Server:      addr,
+		TSIGKeyName: "transfer-key",
+		TSIGSecret:  testTSIGSecret,
+	})
+	require.NoError(t, err)
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, endpoints, len(expectedEndpoints))
+
+	// without the key the server refuses the transfer
+	s, err = NewAXFRSource(AXFRSourceConfig{
+		Zone:   "example.com",
+		Server: addr,
+	})
+	require.NoError(t, err)
+	_, err = s.Endpoints(context.Background())
+	assert.Error(t, err)
+
+	// and with the wrong secret the response doesn't verify
+	s, err = NewAXFRSource(AXFRSourceConfig{
+		Zone:        "example.com",
+		Server:      addr,
+		TSIGKeyName: "transfer-key",
+		TSIGSecret:  "d3Jvbmctc2VjcmV0",
+	})
+	require.NoError(t, err)
+	_, err = s.Endpoints(context.Background())
+	assert.Error(t, err)
+}
+
+func TestEndpoints_ZoneFile(t *testing.T) {
+	zoneFile := filepath.Join(t.TempDir(), "example.com.zone")
+	require.NoError(t, os.WriteFile(zoneFile, []byte(testZone), 0o644))
+
+	s, err := NewAXFRSource(AXFRSourceConfig{
+		Zone:     "example.com",
+		ZoneFile: zoneFile,
+	})
+	require.NoError(t, err)
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	if diff := cmp.Diff(expectedEndpoints, endpoints); diff != "" {
+		t.Errorf("Endpoints() mismatch (-want +got):\n%s", diff)
+	}
+
+	require.NoError(t, os.WriteFile(zoneFile, []byte("router IN A not-an-ip\n"), 0o644))
+	_, err = s.Endpoints(context.Background())
+	assert.Error(t, err)
+}
+
+func TestEndpoints_FQDN(t *testing.T) {
+	zoneFile := filepath.Join(t.TempDir(), "example.com.zone")
+	require.NoError(t, os.WriteFile(zoneFile, []byte(testZone), 0o644))
+
+	s, err := NewAXFRSource(AXFRSourceConfig{
+		Zone:        "Example.com.",
+		ZoneFile:    zoneFile,
+		FQDN:        true,
+		IncludeApex: true,
+		RecordTTL:   120,
+	})
+	require.NoError(t, err)
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	hostnames := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		hostnames = append(hostnames, e.Hostname)
+		assert.Equal(t, int64(120), e.RecordTTL)
+	}
+	assert.Equal(t, []string{"example.com", "ns1.example.com", "router.example.com", "printer.example.com"}, hostnames)
+}
+
+func TestNewAXFRSource_Validation(t *testing.T) {
+	tests := map[string]AXFRSourceConfig{
+		"no zone":           {Server: "192.0.2.53"},
+		"no server or file": {Zone: "example.com"},
+		"server and file":   {Zone: "example.com", Server: "192.0.2.53", ZoneFile: "example.com.zone"},
+		"apex without fqdn": {Zone: "example.com", Server: "192.0.2.53", IncludeApex: true},
+		"key without secret": {
+			Zone:        "example.com",
+			Server:      "192.0.2.53",
+			TSIGKeyName: "transfer-key",
+		},
+	}
+	for name, config := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewAXFRSource(config)
+			assert.Error(t, err)
+		})
+	}
+
+	s, err := NewAXFRSource(AXFRSourceConfig{Zone: "example.com", Server: "192.0.2.53"})
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.53:53", s.(*axfrSource).config.Server)
+}
@@ -0,0 +1,420 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/rdns"
+	"github.com/sapslaj/zonepop/source"
+)
+
+const DefaultConsulAddress = "http://127.0.0.1:8500"
+
+type ConsulSourceConfig struct {
+	// Address of the Consul HTTP API. Defaults to http://127.0.0.1:8500
+	Address    string
+	Token      string
+	Datacenter string
+	// Create endpoints for catalog nodes. If neither CollectNodes nor
+	// CollectServices is set, both are collected.
+	CollectNodes bool
+	// Create endpoints for catalog service instances. If neither CollectNodes
+	// nor CollectServices is set, both are collected.
+	CollectServices bool
+	// Only include these services (default is all services)
+	Services []string
+	// Only include service instances that have all of these tags
+	ServiceTags []string
+	// Only include nodes (and service instances on nodes) that have all of
+	// these node meta key/value pairs
+	NodeMeta map[string]string
+	// Use blocking queries to trigger a sync as soon as the catalog changes
+	Watch bool
+	// Maximum duration of a single blocking query in seconds (default 300)
+	WatchWaitSeconds int
+	RecordTTL        int64
+}
+
+type catalogNode struct {
+	ID              string            `json:"ID"`
+	Node            string            `json:"Node"`
+	Address         string            `json:"Address"`
+	Datacenter      string            `json:"Datacenter"`
+	TaggedAddresses map[string]string `json:"TaggedAddresses"`
+	Meta            map[string]string `json:"Meta"`
+}
+
+type catalogService struct {
+	ID             string            `json:"ID"`
+	Node           string            `json:"Node"`
+	Address        string            `json:"Address"`
+	Datacenter     string            `json:"Datacenter"`
+	NodeMeta       map[string]string `json:"NodeMeta"`
+	ServiceID      string            `json:"ServiceID"`
+	ServiceName    string            `json:"ServiceName"`
+	ServiceAddress string            `json:"ServiceAddress"`
+	ServiceTags    []string          `json:"ServiceTags"`
+	ServicePort    int               `json:"ServicePort"`
+}
+
+type consulSource struct {
+	config     ConsulSourceConfig
+	logger     *zap.Logger
+	httpClient *http.Client
+	retryDelay time.Duration
+}
+
+func NewConsulSource(sourceConfig ConsulSourceConfig) (source.Source, error) {
+	if sourceConfig.Address == "" {
+		sourceConfig.Address = DefaultConsulAddress
+	}
+	if !strings.Contains(sourceConfig.Address, "://") {
+		sourceConfig.Address = "http://" + sourceConfig.Address
+	}
+	sourceConfig.Address = strings.TrimSuffix(sourceConfig.Address, "/")
+	if !sourceConfig.CollectNodes && !sourceConfig.CollectServices {
+		sourceConfig.CollectNodes = true
+		sourceConfig.CollectServices = true
+	}
+	if sourceConfig.WatchWaitSeconds == 0 {
+		sourceConfig.WatchWaitSeconds = 300
+	}
+	return &consulSource{
+		config:     sourceConfig,
+		httpClient: http.DefaultClient,
+		retryDelay: 5 * time.Second,
+		logger: log.MustNewLogger().Named("consul_source").With(
+			zap.String("address", sourceConfig.Address),
+			zap.String("datacenter", sourceConfig.Datacenter),
+		),
+	}, nil
+}
+
+func (s *consulSource) Endpoints(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	endpoints := make([]*endpoint.Endpoint, 0)
+
+	if s.config.CollectNodes {
+		nodes, err := s.getNodes(ctx)
+		if err != nil {
+			newErr := fmt.Errorf("could not get catalog nodes: %w", err)
+			s.logger.Error(newErr.Error())
+			return nil, newErr
+		}
+		for _, node := range nodes {
+			endpoints = append(endpoints, s.nodeToEndpoint(node))
+		}
+	}
+
+	if s.config.CollectServices {
+		services, err := s.getServices(ctx)
+		if err != nil {
+			newErr := fmt.Errorf("could not get catalog services: %w", err)
+			s.logger.Error(newErr.Error())
+			return nil, newErr
+		}
+		endpoints = append(endpoints, s.servicesToEndpoints(services)...)
+	}
+
+	return endpoints, nil
+}
+
+// AddEventHandler starts watching the catalog with blocking queries and calls
+// handler whenever the catalog index changes.
+func (s *consulSource) AddEventHandler(ctx context.Context, handler func()) {
+	if !s.config.Watch {
+		return
+	}
+	if s.config.CollectNodes {
+		go s.watch(ctx, "/v1/catalog/nodes", handler)
+	}
+	if s.config.CollectServices {
+		go s.watch(ctx, "/v1/catalog/services", handler)
+	}
+}
+
+func (s *consulSource) watch(ctx context.Context, path string, handler func()) {
+	logger := s.logger.With(zap.String("path", path))
+	logger.Info("starting catalog watch")
+	var index uint64
+	for {
+		if ctx.Err() != nil {
+			logger.Info("stopping catalog watch")
+			return
+		}
+		newIndex, err := s.blockingQuery(ctx, path, index)
+		if err != nil {
+			if ctx.Err() != nil {
+				logger.Info("stopping catalog watch")
+				return
+			}
+			logger.Error("blocking query failed", zap.Error(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(s.retryDelay):
+			}
+			continue
+		}
+		// Per the Consul docs the index must be reset if it goes backwards and
+		// must never be 0.
+		if newIndex < index || newIndex == 0 {
+			index = 0
+			continue
+		}
+		if index != 0 && newIndex != index {
+			logger.Debug("catalog changed", zap.Uint64("index", newIndex))
+			handler()
+		}
+		index = newIndex
+	}
+}
+
+func (s *consulSource) blockingQuery(ctx context.Context, path string, index uint64) (uint64, error) {
+	query := s.baseQuery()
+	if index != 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", strconv.Itoa(s.config.WatchWaitSeconds)+"s")
+	}
+	res, err := s.do(ctx, path, query)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	return consulIndex(res)
+}
+
+func consulIndex(res *http.Response) (uint64, error) {
+	header := res.Header.Get("X-Consul-Index")
+	if header == "" {
+		return 0, fmt.Errorf("missing X-Consul-Index header")
+	}
+	index, err := strconv.ParseUint(header, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid X-Consul-Index header %q: %w", header, err)
+	}
+	return index, nil
+}
+
+func (s *consulSource) baseQuery() url.Values {
+	query := url.Values{}
+	if s.config.Datacenter != "" {
+		query.Set("dc", s.config.Datacenter)
+	}
+	for key, value := range s.config.NodeMeta {
+		query.Add("node-meta", key+":"+value)
+	}
+	return query
+}
+
+func (s *consulSource) do(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := s.config.Address + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.config.Token != "" {
+		req.Header.Set("X-Consul-Token", s.config.Token)
+	}
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected status code %d from %s: %s", res.StatusCode, path, strings.TrimSpace(string(body)))
+	}
+	return res, nil
+}
+
+func (s *consulSource) get(ctx context.Context, path string, query url.Values, v any) error {
+	res, err := s.do(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (s *consulSource) getNodes(ctx context.Context) ([]*catalogNode, error) {
+	s.logger.Info("Getting catalog nodes")
+	var nodes []*catalogNode
+	err := s.get(ctx, "/v1/catalog/nodes", s.baseQuery(), &nodes)
+	return nodes, err
+}
+
+func (s *consulSource) getServices(ctx context.Context) ([]*catalogService, error) {
+	s.logger.Info("Getting catalog services")
+	var serviceTags map[string][]string
+	err := s.get(ctx, "/v1/catalog/services", s.baseQuery(), &serviceTags)
+	if err != nil {
+		return nil, err
+	}
+	serviceNames := make([]string, 0, len(serviceTags))
+	for name, tags := range serviceTags {
+		if len(s.config.Services) > 0 && !slices.Contains(s.config.Services, name) {
+			continue
+		}
+		if !hasAllTags(tags, s.config.ServiceTags) {
+			continue
+		}
+		serviceNames = append(serviceNames, name)
+	}
+	slices.Sort(serviceNames)
+
+	result := make([]*catalogService, 0)
+	for _, name := range serviceNames {
+		var instances []*catalogService
+		err := s.get(ctx, "/v1/catalog/service/"+url.PathEscape(name), s.baseQuery(), &instances)
+		if err != nil {
+			return nil, err
+		}
+		for _, instance := range instances {
+			// the services list is the union of all instance tags, so each
+			// instance has to be checked individually as well.
+			if !hasAllTags(instance.ServiceTags, s.config.ServiceTags) {
+				continue
+			}
+			result = append(result, instance)
+		}
+	}
+	return result, nil
+}
+
+func hasAllTags(tags []string, wanted []string) bool {
+	for _, tag := range wanted {
+		if !slices.Contains(tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *consulSource) addressesToIPs(addresses ...string) ([]string, []string) {
+	ipv4s := make([]string, 0)
+	ipv6s := make([]string, 0)
+	for _, addr := range addresses {
+		if addr == "" {
+			continue
+		}
+		kind, err := rdns.DetermineAddressKind(addr)
+		if err != nil {
+			// addresses can be hostnames in Consul, which we can't do anything
+			// with.
+			s.logger.Sugar().Debugf("ignoring non-IP address %q", addr)
+			continue
+		}
+		if kind == rdns.AddressKindIPv4 {
+			if !slices.Contains(ipv4s, addr) {
+				ipv4s = append(ipv4s, addr)
+			}
+		} else {
+			if !slices.Contains(ipv6s, addr) {
+				ipv6s = append(ipv6s, addr)
+			}
+		}
+	}
+	return ipv4s, ipv6s
+}
+
+func (s *consulSource) nodeToEndpoint(node *catalogNode) *endpoint.Endpoint {
+	ipv4s, ipv6s := s.addressesToIPs(
+		node.Address,
+		node.TaggedAddresses["lan_ipv4"],
+		node.TaggedAddresses["lan_ipv6"],
+	)
+	meta := node.Meta
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	return &endpoint.Endpoint{
+		Hostname:  node.Node,
+		IPv4s:     ipv4s,
+		IPv6s:     ipv6s,
+		RecordTTL: s.config.RecordTTL,
+		SourceProperties: map[string]any{
+			"consul_kind":       "node",
+			"consul_node":       node.Node,
+			"consul_datacenter": node.Datacenter,
+			"consul_node_meta":  meta,
+		},
+	}
+}
+
+// servicesToEndpoints creates one endpoint per service with the addresses of
+// all of its instances, so a service with multiple instances gets a single
+// hostname with multiple addresses.
+func (s *consulSource) servicesToEndpoints(services []*catalogService) []*endpoint.Endpoint {
+	endpoints := make([]*endpoint.Endpoint, 0)
+	byName := map[string]*endpoint.Endpoint{}
+	for _, service := range services {
+		address := service.ServiceAddress
+		if address == "" {
+			address = service.Address
+		}
+		ipv4s, ipv6s := s.addressesToIPs(address)
+		meta := service.NodeMeta
+		if meta == nil {
+			meta = map[string]string{}
+		}
+		tags := service.ServiceTags
+		if tags == nil {
+			tags = []string{}
+		}
+		instance := map[string]any{
+			"node":         service.Node,
+			"node_meta":    meta,
+			"service_id":   service.ServiceID,
+			"service_port": service.ServicePort,
+			"service_tags": tags,
+		}
+
+		e, ok := byName[service.ServiceName]
+		if !ok {
+			e = &endpoint.Endpoint{
+				Hostname:  service.ServiceName,
+				IPv4s:     []string{},
+				IPv6s:     []string{},
+				RecordTTL: s.config.RecordTTL,
+				SourceProperties: map[string]any{
+					"consul_kind":         "service",
+					"consul_service":      service.ServiceName,
+					"consul_datacenter":   service.Datacenter,
+					"consul_service_tags": []string{},
+					"consul_instances":    []map[string]any{},
+				},
+			}
+			byName[service.ServiceName] = e
+			endpoints = append(endpoints, e)
+		}
+		e.IPv4s = appendMissing(e.IPv4s, ipv4s...)
+		e.IPv6s = appendMissing(e.IPv6s, ipv6s...)
+		e.SourceProperties["consul_service_tags"] = appendMissing(e.SourceProperties["consul_service_tags"].([]string), tags...)
+		e.SourceProperties["consul_instances"] = append(e.SourceProperties["consul_instances"].([]map[string]any), instance)
+	}
+	return endpoints
+}
+
+func appendMissing(values []string, add ...string) []string {
+	for _, value := range add {
+		if !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,363 @@
+package consul
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+const catalogNodesJSON = `[
+  {
+    "ID": "40e4a748-2192-161a-0510-9bf59fe950b5",
+    "Node": "node-1",
+    "Address": "192.0.2.10",
+    "Datacenter": "dc1",
+    "TaggedAddresses": {
+      "lan": "192.0.2.10",
+      "lan_ipv4": "192.0.2.10",
+      "lan_ipv6": "2001:db8::10",
+      "wan": "198.51.100.10"
+    },
+    "Meta": {"rack": "a1"}
+  },
+  {
+    "ID": "8f246b77-f3e1-ff88-5b48-8ec93abf3e05",
+    "Node": "node-2",
+    "Address": "192.0.2.11",
+    "Datacenter": "dc1",
+    "TaggedAddresses": null,
+    "Meta": null
+  }
+]`
+
+const catalogServicesJSON = `{
+  "consul": [],
+  "web": ["prod", "v2", "canary"],
+  "db": ["prod"]
+}`
+
+const catalogServiceWebJSON = `[
+  {
+    "ID": "40e4a748-2192-161a-0510-9bf59fe950b5",
+    "Node": "node-1",
+    "Address": "192.0.2.10",
+    "Datacenter": "dc1",
+    "NodeMeta": {"rack": "a1"},
+    "ServiceID": "web-1",
+    "ServiceName": "web",
+    "ServiceAddress": "",
+    "ServiceTags": ["prod", "v2"],
+    "ServicePort": 8080
+  },
+  {
+    "ID": "8f246b77-f3e1-ff88-5b48-8ec93abf3e05",
+    "Node": "node-2",
+    "Address": "192.0.2.11",
+    "Datacenter": "dc1",
+    "NodeMeta": null,
+    "ServiceID": "web-2",
+    "ServiceName": "web",
+    "ServiceAddress": "192.0.2.20",
+    "ServiceTags": ["canary"],
+    "ServicePort": 8080
+  }
+]`
+
+const catalogServiceDBJSON = `[
+  {
+    "ID": "40e4a748-2192-161a-0510-9bf59fe950b5",
+    "Node": "node-1",
+    "Address": "192.0.2.10",
+    "Datacenter": "dc1",
+    "NodeMeta": {"rack": "a1"},
+    "ServiceID": "db",
+    "ServiceName": "db",
+    "ServiceAddress": "2001:db8::30",
+    "ServiceTags": ["prod"],
+    "ServicePort": 5432
+  }
+]`
+
+const catalogServiceConsulJSON = `[
+  {
+    "ID": "40e4a748-2192-161a-0510-9bf59fe950b5",
+    "Node": "node-1",
+    "Address": "192.0.2.10",
+    "Datacenter": "dc1",
+    "NodeMeta": {"rack": "a1"},
+    "ServiceID": "consul",
+    "ServiceName": "consul",
+    "ServiceAddress": "",
+    "ServiceTags": [],
+    "ServicePort": 8300
+  }
+]`
+
+type testConsulServer struct {
+	*httptest.Server
+	index atomic.Uint64
+}
+
+func newTestConsulServer(t *testing.T) *testConsulServer {
+	t.Helper()
+	s := &testConsulServer{}
+	s.index.Store(10)
+	respond := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Consul-Token") != "secret" {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("ACL not found"))
+				return
+			}
+			if r.URL.Query().Get("dc") != "dc1" {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("No path to datacenter"))
+				return
+			}
+			if index := r.URL.Query().Get("index"); index != "" {
+				// emulate a blocking query that returns once the index has moved
+				deadline := time.Now().Add(5 * time.Second)
+				for strconv.FormatUint(s.index.Load(), 10) == index && time.Now().Before(deadline) {
+					select {
+					case <-r.Context().Done():
+						return
+					case <-time.After(10 * time.Millisecond):
+					}
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Consul-Index", strconv.FormatUint(s.index.Load(), 10))
+			w.Write([]byte(body))
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/catalog/nodes", respond(catalogNodesJSON))
+	mux.HandleFunc("/v1/catalog/services", respond(catalogServicesJSON))
+	mux.HandleFunc("/v1/catalog/service/web", respond(catalogServiceWebJSON))
+	mux.HandleFunc("/v1/catalog/service/db", respond(catalogServiceDBJSON))
+	mux.HandleFunc("/v1/catalog/service/consul", respond(catalogServiceConsulJSON))
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Server.Close)
+	return s
+}
+
+func TestEndpoints(t *testing.T) {
+	server := newTestConsulServer(t)
+
+	node1 := &endpoint.Endpoint{
+		Hostname:  "node-1",
+		IPv4s:     []string{"192.0.2.10"},
+		IPv6s:     []string{"2001:db8::10"},
+		RecordTTL: 60,
+		SourceProperties: map[string]any{
+			"consul_kind":       "node",
+			"consul_node":       "node-1",
+			"consul_datacenter": "dc1",
+			"consul_node_meta":  map[string]string{"rack": "a1"},
+		},
+	}
+	node2 := &endpoint.Endpoint{
+		Hostname:  "node-2",
+		IPv4s:     []string{"192.0.2.11"},
+		IPv6s:     []string{},
+		RecordTTL: 60,
+		SourceProperties: map[string]any{
+			"consul_kind":       "node",
+			"consul_node":       "node-2",
+			"consul_datacenter": "dc1",
+			"consul_node_meta":  map[string]string{},
+		},
+	}
+	consulService := &endpoint.Endpoint{
+		Hostname:  "consul",
+		IPv4s:     []string{"192.0.2.10"},
+		IPv6s:     []string{},
+		RecordTTL: 60,
+		SourceProperties: map[string]any{
+			"consul_kind":         "service",
+			"consul_service":      "consul",
+			"consul_datacenter":   "dc1",
+			"consul_service_tags": []string{},
+			"consul_instances": []map[string]any{
+				{
+					"node":         "node-1",
+					"node_meta":    map[string]string{"rack": "a1"},
+					"service_id":   "consul",
+					"service_port": 8300,
+					"service_tags": []string{},
+				},
+			},
+		},
+	}
+	dbService := &endpoint.Endpoint{
+		Hostname:  "db",
+		IPv4s:     []string{},
+		IPv6s:     []string{"2001:db8::30"},
+		RecordTTL: 60,
+		SourceProperties: map[string]any{
+			"consul_kind":         "service",
+			"consul_service":      "db",
+			"consul_datacenter":   "dc1",
+			"consul_service_tags": []string{"prod"},
+			"consul_instances": []map[string]any{
+				{
+					"node":         "node-1",
+					"node_meta":    map[string]string{"rack": "a1"},
+					"service_id":   "db",
+					"service_port": 5432,
+					"service_tags": []string{"prod"},
+				},
+			},
+		},
+	}
+	web1Instance := map[string]any{
+		"node":         "node-1",
+		"node_meta":    map[string]string{"rack": "a1"},
+		"service_id":   "web-1",
+		"service_port": 8080,
+		"service_tags": []string{"prod", "v2"},
+	}
+	webService := &endpoint.Endpoint{
+		Hostname:  "web",
+		IPv4s:     []string{"192.0.2.10", "192.0.2.20"},
+		IPv6s:     []string{},
+		RecordTTL: 60,
+		SourceProperties: map[string]any{
+			"consul_kind":         "service",
+			"consul_service":      "web",
+			"consul_datacenter":   "dc1",
+			"consul_service_tags": []string{"prod", "v2", "canary"},
+			"consul_instances": []map[string]any{
+				web1Instance,
+				{
+					"node":         "node-2",
+					"node_meta":    map[string]string{},
+					"service_id":   "web-2",
+					"service_port": 8080,
+					"service_tags": []string{"canary"},
+				},
+			},
+		},
+	}
+	// only the instance with the tag is included
+	webProdService := &endpoint.Endpoint{
+		Hostname:  "web",
+		IPv4s:     []string{"192.0.2.10"},
+		IPv6s:     []string{},
+		RecordTTL: 60,
+		SourceProperties: map[string]any{
+			"consul_kind":         "service",
+			"consul_service":      "web",
+			"consul_datacenter":   "dc1",
+			"consul_service_tags": []string{"prod", "v2"},
+			"consul_instances":    []map[string]any{web1Instance},
+		},
+	}
+
+	tests := map[string]struct {
+		config ConsulSourceConfig
+		expect []*endpoint.Endpoint
+	}{
+		"default collects nodes and services": {
+			config: ConsulSourceConfig{},
+			expect: []*endpoint.Endpoint{node1, node2, consulService, dbService, webService},
+		},
+		"only nodes": {
+			config: ConsulSourceConfig{
+				CollectNodes: true,
+			},
+			expect: []*endpoint.Endpoint{node1, node2},
+		},
+		"service name filter": {
+			config: ConsulSourceConfig{
+				CollectServices: true,
+				Services:        []string{"web"},
+			},
+			expect: []*endpoint.Endpoint{webService},
+		},
+		"service tag filter": {
+			config: ConsulSourceConfig{
+				CollectServices: true,
+				ServiceTags:     []string{"prod"},
+			},
+			expect: []*endpoint.Endpoint{dbService, webProdService},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc.config.Address = server.URL
+			tc.config.Token = "secret"
+			tc.config.Datacenter = "dc1"
+			tc.config.RecordTTL = 60
+			s, err := NewConsulSource(tc.config)
+			require.NoError(t, err)
+
+			endpoints, err := s.Endpoints(context.Background())
+			require.NoError(t, err)
+
+			diff := cmp.Diff(tc.expect, endpoints)
+			if diff != "" {
+				t.Fatalf("mismatch:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEndpoints_Forbidden(t *testing.T) {
+	server := newTestConsulServer(t)
+
+	s, err := NewConsulSource(ConsulSourceConfig{
+		Address:    server.URL,
+		Datacenter: "dc1",
+	})
+	require.NoError(t, err)
+
+	_, err = s.Endpoints(context.Background())
+	assert.ErrorContains(t, err, "unexpected status code 403")
+}
+
+func TestAddEventHandler(t *testing.T) {
+	server := newTestConsulServer(t)
+
+	s, err := NewConsulSource(ConsulSourceConfig{
+		Address:         server.URL,
+		Token:           "secret",
+		Datacenter:      "dc1",
+		CollectServices: true,
+		Watch:           true,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan struct{}, 10)
+	s.(*consulSource).AddEventHandler(ctx, func() {
+		events <- struct{}{}
+	})
+
+	select {
+	case <-events:
+		t.Fatal("event handler called before the catalog changed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	server.index.Add(1)
+
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event handler was not called after the catalog changed")
+	}
+}
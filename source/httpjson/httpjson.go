@@ -0,0 +1,482 @@
+package httpjson
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/rdns"
+	"github.com/sapslaj/zonepop/source"
+)
+
+const (
+	PaginationNone   = ""
+	PaginationLink   = "link"
+	PaginationCursor = "cursor"
+	PaginationPage   = "page"
+
+	DefaultMaxPages = 100
+)
+
+type HTTPJSONSourceConfig struct {
+	URL string
+	// HTTP method to use (default GET)
+	Method string
+	// Extra headers to send with every request
+	Headers map[string]string
+	// Sent as "Authorization: Bearer <token>"
+	BearerToken   string
+	BasicUsername string
+	BasicPassword string
+	// Name and value of a header to send the credentials in, e.g. X-API-Key
+	AuthHeaderName  string
+	AuthHeaderValue string
+	TimeoutSeconds  int
+
+	// Pagination style: "" (none), "link" (RFC 8288 Link header with
+	// rel="next"), "cursor" or "page"
+	Pagination string
+	// Path to the next cursor in the response body (cursor pagination)
+	CursorPath string
+	// Query parameter to send the cursor in (default "cursor")
+	CursorParam string
+	// Query parameter to send the page number in (default "page")
+	PageParam string
+	// Number of the first page (default 1)
+	PageStart int
+	// Query parameter to send the page size in, if any
+	PageSizeParam string
+	PageSize      int
+	// Maximum number of pages to fetch (default 100)
+	MaxPages int
+
+	// Path to the list of items in the response body. Defaults to the whole
+	// body, which should then be an array.
+	ItemsPath string
+	// Path to the hostname of an item
+	HostnamePath string
+	// Path to the IPv4 address(es) of an item
+	IPv4Path string
+	// Path to the IPv6 address(es) of an item
+	IPv6Path string
+	// Path to address(es) of an item which are sorted into IPv4 and IPv6 by
+	// their kind. Can be combined with IPv4Path and IPv6Path.
+	AddressesPath string
+	// Path to the record TTL of an item. RecordTTL is used if it's missing.
+	TTLPath string
+	// Map of source property name to path
+	Properties map[string]string
+	// Strip CIDR prefix lengths from addresses, e.g. "192.0.2.1/24"
+	StripPrefixLength bool
+	RecordTTL         int64
+}
+
+type paths struct {
+	items      *Path
+	hostname   *Path
+	ipv4       *Path
+	ipv6       *Path
+	addresses  *Path
+	ttl        *Path
+	cursor     *Path
+	properties map[string]*Path
+}
+
+type cachedResponse struct {
+	url    string
+	etag   string
+	header http.Header
+	body   []byte
+}
+
+type httpJSONSource struct {
+	config     HTTPJSONSourceConfig
+	logger     *zap.Logger
+	httpClient *http.Client
+	paths      paths
+	cacheMutex sync.Mutex
+	// cache holds the last response with an ETag for each page number, so it
+	// stays bounded by max_pages even when cursors change between runs.
+	cache map[int]*cachedResponse
+}
+
+func compileOptionalPath(raw string) (*Path, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	return CompilePath(raw)
+}
+
+func NewHTTPJSONSource(sourceConfig HTTPJSONSourceConfig) (source.Source, error) {
+	if sourceConfig.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	if _, err := url.Parse(sourceConfig.URL); err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if sourceConfig.Method == "" {
+		sourceConfig.Method = http.MethodGet
+	}
+	if sourceConfig.CursorParam == "" {
+		sourceConfig.CursorParam = "cursor"
+	}
+	if sourceConfig.PageParam == "" {
+		sourceConfig.PageParam = "page"
+	}
+	if sourceConfig.PageStart == 0 {
+		sourceConfig.PageStart = 1
+	}
+	if sourceConfig.MaxPages == 0 {
+		sourceConfig.MaxPages = DefaultMaxPages
+	}
+	if sourceConfig.TimeoutSeconds == 0 {
+		sourceConfig.TimeoutSeconds = 30
+	}
+	switch sourceConfig.Pagination {
+	case PaginationNone, PaginationLink, PaginationPage:
+	case PaginationCursor:
+		if sourceConfig.CursorPath == "" {
+			return nil, fmt.Errorf("cursor_path is required for cursor pagination")
+		}
+	default:
+		return nil, fmt.Errorf("unknown pagination %q", sourceConfig.Pagination)
+	}
+	if sourceConfig.IPv4Path == "" && sourceConfig.IPv6Path == "" && sourceConfig.AddressesPath == "" {
+		return nil, fmt.Errorf("at least one of ipv4_path, ipv6_path or addresses_path is required")
+	}
+
+	var p paths
+	var err error
+	p.items, err = CompilePath(sourceConfig.ItemsPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range []struct {
+		dst **Path
+		raw string
+	}{
+		{&p.hostname, sourceConfig.HostnamePath},
+		{&p.ipv4, sourceConfig.IPv4Path},
+		{&p.ipv6, sourceConfig.IPv6Path},
+		{&p.addresses, sourceConfig.AddressesPath},
+		{&p.ttl, sourceConfig.TTLPath},
+		{&p.cursor, sourceConfig.CursorPath},
+	} {
+		*c.dst, err = compileOptionalPath(c.raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.properties = make(map[string]*Path, len(sourceConfig.Properties))
+	for name, raw := range sourceConfig.Properties {
+		p.properties[name], err = CompilePath(raw)
+		if err != nil {
+			return nil, fmt.Errorf("property %s: %w", name, err)
+		}
+	}
+
+	return &httpJSONSource{
+		config: sourceConfig,
+		httpClient: &http.Client{
+			Timeout: time.Duration(sourceConfig.TimeoutSeconds) * time.Second,
+		},
+		paths: p,
+		cache: map[int]*cachedResponse{},
+		logger: log.MustNewLogger().Named("http_json_source").With(
+			zap.String("url", sourceConfig.URL),
+		),
+	}, nil
+}
+
+func (s *httpJSONSource) Endpoints(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	items, err := s.getItems(ctx)
+	if err != nil {
+		newErr := fmt.Errorf("could not get items: %w", err)
+		s.logger.Error(newErr.Error())
+		return nil, newErr
+	}
+	endpoints := make([]*endpoint.Endpoint, 0, len(items))
+	for _, item := range items {
+		e := s.itemToEndpoint(item)
+		if e == nil {
+			continue
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
+
+func (s *httpJSONSource) getItems(ctx context.Context) ([]any, error) {
+	s.logger.Info("Getting items")
+	result := make([]any, 0)
+	next := s.firstPageURL()
+	page := s.config.PageStart
+	pages := 1
+	for ; next != ""; pages++ {
+		if pages > s.config.MaxPages {
+			s.logger.Sugar().Warnf("stopping after reaching max_pages (%d)", s.config.MaxPages)
+			break
+		}
+		s.logger.Sugar().Debugf("Getting page %d", pages)
+		header, body, err := s.get(ctx, pages, next)
+		if err != nil {
+			return nil, err
+		}
+		var doc any
+		err = json.Unmarshal(body, &doc)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode response from %s: %w", next, err)
+		}
+		items := s.paths.items.Get(doc)
+		result = append(result, items...)
+
+		current := next
+		next = ""
+		switch s.config.Pagination {
+		case PaginationLink:
+			link := nextLink(header.Values("Link"))
+			if link != "" {
+				next, err = resolveReference(current, link)
+				if err != nil {
+					return nil, err
+				}
+			}
+		case PaginationCursor:
+			cursor, ok := s.paths.cursor.GetString(doc)
+			if ok && cursor != "" {
+				next = withQuery(current, s.config.CursorParam, cursor)
+			}
+		case PaginationPage:
+			if len(items) > 0 && (s.config.PageSize == 0 || len(items) >= s.config.PageSize) {
+				page++
+				next = withQuery(current, s.config.PageParam, strconv.Itoa(page))
+			}
+		}
+	}
+	// forget the pages past the end of this run
+	s.cacheMutex.Lock()
+	for cachedPage := range s.cache {
+		if cachedPage >= pages {
+			delete(s.cache, cachedPage)
+		}
+	}
+	s.cacheMutex.Unlock()
+	return result, nil
+}
+
+func (s *httpJSONSource) firstPageURL() string {
+	u := s.config.URL
+	if s.config.PageSizeParam != "" && s.config.PageSize > 0 {
+		u = withQuery(u, s.config.PageSizeParam, strconv.Itoa(s.config.PageSize))
+	}
+	if s.config.Pagination == PaginationPage {
+		u = withQuery(u, s.config.PageParam, strconv.Itoa(s.config.PageStart))
+	}
+	return u
+}
+
+func withQuery(rawURL string, key string, value string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func resolveReference(base string, ref string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid next link %q: %w", ref, err)
+	}
+	return baseURL.ResolveReference(refURL).String(), nil
+}
+
+// nextLink returns the target of the rel="next" link from Link header
+// values, e.g. `<https://example.com/items?page=2>; rel="next"`.
+func nextLink(values []string) string {
+	for _, value := range values {
+		for _, link := range strings.Split(value, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+			if !ok {
+				continue
+			}
+			target = strings.TrimSpace(target)
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range strings.Split(params, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if !strings.EqualFold(name, "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return target[1 : len(target)-1]
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// get fetches u as the given page number and returns the response headers and
+// body. Responses with an ETag are cached per page and revalidated with
+// If-None-Match when the same page is requested from the same URL again.
+func (s *httpJSONSource) get(ctx context.Context, page int, u string) (http.Header, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, s.config.Method, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, value := range s.config.Headers {
+		req.Header.Set(name, value)
+	}
+	if s.config.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.BearerToken)
+	}
+	if s.config.BasicUsername != "" || s.config.BasicPassword != "" {
+		req.SetBasicAuth(s.config.BasicUsername, s.config.BasicPassword)
+	}
+	if s.config.AuthHeaderName != "" {
+		req.Header.Set(s.config.AuthHeaderName, s.config.AuthHeaderValue)
+	}
+
+	s.cacheMutex.Lock()
+	cached := s.cache[page]
+	s.cacheMutex.Unlock()
+	if cached != nil && cached.url != u {
+		cached = nil
+	}
+	if cached != nil {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified && cached != nil {
+		s.logger.Sugar().Debugf("%s not modified, using cached response", u)
+		return cached.header, cached.body, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		return nil, nil, fmt.Errorf("unexpected status code %d from %s: %s", res.StatusCode, u, strings.TrimSpace(string(body)))
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.cacheMutex.Lock()
+	if etag := res.Header.Get("ETag"); etag != "" {
+		s.cache[page] = &cachedResponse{
+			url:    u,
+			etag:   etag,
+			header: res.Header.Clone(),
+			body:   bytes.Clone(body),
+		}
+	} else {
+		delete(s.cache, page)
+	}
+	s.cacheMutex.Unlock()
+	return res.Header, body, nil
+}
+
+func (s *httpJSONSource) normalizeAddress(address string) string {
+	address = strings.TrimSpace(address)
+	if s.config.StripPrefixLength {
+		address, _, _ = strings.Cut(address, "/")
+	}
+	return address
+}
+
+func (s *httpJSONSource) itemToEndpoint(item any) *endpoint.Endpoint {
+	e := &endpoint.Endpoint{
+		IPv4s:            []string{},
+		RecordTTL:        s.config.RecordTTL,
+		SourceProperties: map[string]any{},
+	}
+	if s.paths.ipv6 != nil || s.paths.addresses != nil {
+		e.IPv6s = []string{}
+	}
+	if s.paths.hostname != nil {
+		e.Hostname, _ = s.paths.hostname.GetString(item)
+	}
+
+	// an empty expected kind accepts both IPv4 and IPv6 addresses
+	addAddress := func(address string, expect rdns.AddressKind) {
+		address = s.normalizeAddress(address)
+		kind, err := rdns.DetermineAddressKind(address)
+		if err != nil || (expect != "" && kind != expect) {
+			s.logger.Warn(
+				"ignoring invalid address",
+				zap.String("hostname", e.Hostname),
+				zap.String("address", address),
+			)
+			return
+		}
+		if kind == rdns.AddressKindIPv4 {
+			e.IPv4s = append(e.IPv4s, address)
+		} else {
+			e.IPv6s = append(e.IPv6s, address)
+		}
+	}
+	if s.paths.ipv4 != nil {
+		for _, address := range s.paths.ipv4.GetStrings(item) {
+			addAddress(address, rdns.AddressKindIPv4)
+		}
+	}
+	if s.paths.ipv6 != nil {
+		for _, address := range s.paths.ipv6.GetStrings(item) {
+			addAddress(address, rdns.AddressKindIPv6)
+		}
+	}
+	if s.paths.addresses != nil {
+		for _, address := range s.paths.addresses.GetStrings(item) {
+			addAddress(address, "")
+		}
+	}
+	if len(e.IPv4s) == 0 && len(e.IPv6s) == 0 {
+		s.logger.Debug("ignoring item without addresses", zap.String("hostname", e.Hostname))
+		return nil
+	}
+
+	if s.paths.ttl != nil {
+		if raw, ok := s.paths.ttl.GetString(item); ok {
+			ttl, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				s.logger.Warn("ignoring invalid TTL", zap.String("hostname", e.Hostname), zap.String("ttl", raw))
+			} else {
+				e.RecordTTL = ttl
+			}
+		}
+	}
+	for name, path := range s.paths.properties {
+		if value, ok := path.Value(item); ok {
+			e.SourceProperties[name] = value
+		}
+	}
+	return e
+}
@@ -0,0 +1,413 @@
+package httpjson
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+var testHosts = []map[string]any{
+	{
+		"id":        1,
+		"name":      "web01",
+		"addresses": []string{"192.0.2.1/24", "2001:db8::1/64"},
+		"ttl":       120,
+		"site":      "dc1",
+		"roles":     []string{"web"},
+	},
+	{
+		"id":        2,
+		"name":      "db01",
+		"addresses": []string{"192.0.2.2/24"},
+		"site":      "dc2",
+		"roles":     []string{"db", "backup"},
+	},
+	{
+		"id":        3,
+		"name":      "no-address",
+		"addresses": []string{},
+	},
+}
+
+func pageOf(page int, size int) []map[string]any {
+	start := (page - 1) * size
+	if start >= len(testHosts) {
+		return []map[string]any{}
+	}
+	return testHosts[start:min(start+size, len(testHosts))]
+}
+
+func newTestServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, *[]string) {
+	t.Helper()
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RequestURI())
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func baseConfig(url string) HTTPJSONSourceConfig {
+	return HTTPJSONSourceConfig{
+		URL:               url,
+		ItemsPath:         "hosts",
+		HostnamePath:      "name",
+		AddressesPath:     "addresses",
+		TTLPath:           "ttl",
+		StripPrefixLength: true,
+		Properties: map[string]string{
+			"site":  "site",
+			"roles": "roles",
+		},
+		RecordTTL: 60,
+	}
+}
+
+var expectedEndpoints = []*endpoint.Endpoint{
+	{
+		Hostname:  "web01",
+		IPv4s:     []string{"192.0.2.1"},
+		IPv6s:     []string{"2001:db8::1"},
+		RecordTTL: 120,
+		SourceProperties: map[string]any{
+			"site":  "dc1",
+			"roles": []any{"web"},
+		},
+	},
+	{
+		Hostname:  "db01",
+		IPv4s:     []string{"192.0.2.2"},
+		IPv6s:     []string{},
+		RecordTTL: 60,
+		SourceProperties: map[string]any{
+			"site":  "dc2",
+			"roles": []any{"db", "backup"},
+		},
+	},
+}
+
+func TestEndpoints_Pagination(t *testing.T) {
+	tests := map[string]struct {
+		configure      func(config *HTTPJSONSourceConfig)
+		handler        func(w http.ResponseWriter, r *http.Request)
+		expectRequests []string
+	}{
+		"none": {
+			configure: func(config *HTTPJSONSourceConfig) {},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]any{"hosts": testHosts})
+			},
+			expectRequests: []string{"/hosts"},
+		},
+		"link": {
+			configure: func(config *HTTPJSONSourceConfig) {
+				config.Pagination = PaginationLink
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				page, _ := strconv.Atoi(r.URL.Query().Get("p"))
+				if page == 0 {
+					page = 1
+				}
+				if page*2 < len(testHosts) {
+					w.Header().Add("Link", `</hosts?p=1>; rel="first"`)
+					w.Header().Add("Link", fmt.Sprintf(`</hosts?p=%d>; rel="next"`, page+1))
+				}
+				writeJSON(w, map[string]any{"hosts": pageOf(page, 2)})
+			},
+			expectRequests: []string{"/hosts", "/hosts?p=2"},
+		},
+		"cursor": {
+			configure: func(config *HTTPJSONSourceConfig) {
+				config.Pagination = PaginationCursor
+				config.CursorPath = "meta.next_cursor"
+				config.CursorParam = "after"
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				page := 1
+				if r.URL.Query().Get("after") == "abc" {
+					page = 2
+				}
+				res := map[string]any{"hosts": pageOf(page, 2), "meta": map[string]any{"next_cursor": nil}}
+				if page == 1 {
+					res["meta"] = map[string]any{"next_cursor": "abc"}
+				}
+				writeJSON(w, res)
+			},
+			expectRequests: []string{"/hosts", "/hosts?after=abc"},
+		},
+		"page": {
+			configure: func(config *HTTPJSONSourceConfig) {
+				config.Pagination = PaginationPage
+				config.PageSizeParam = "per_page"
+				config.PageSize = 1
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+				writeJSON(w, map[string]any{"hosts": pageOf(page, 1)})
+			},
+			expectRequests: []string{
+				"/hosts?page=1&per_page=1",
+				"/hosts?page=2&per_page=1",
+				"/hosts?page=3&per_page=1",
+				"/hosts?page=4&per_page=1",
+			},
+		},
+		"page without page size": {
+			configure: func(config *HTTPJSONSourceConfig) {
+				config.Pagination = PaginationPage
+				config.PageStart = 0
+				config.PageParam = "pg"
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				page, _ := strconv.Atoi(r.URL.Query().Get("pg"))
+				writeJSON(w, map[string]any{"hosts": pageOf(page, 2)})
+			},
+			expectRequests: []string{"/hosts?pg=1", "/hosts?pg=2", "/hosts?pg=3"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			server, requests := newTestServer(t, tc.handler)
+			config := baseConfig(server.URL + "/hosts")
+			tc.configure(&config)
+			s, err := NewHTTPJSONSource(config)
+			require.NoError(t, err)
+
+			endpoints, err := s.Endpoints(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectRequests, *requests)
+
+			diff := cmp.Diff(expectedEndpoints, endpoints)
+			if diff != "" {
+				t.Fatalf("mismatch:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEndpoints_MaxPages(t *testing.T) {
+	server, requests := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		// always has a next page
+		w.Header().Set("Link", `</hosts>; rel="next"`)
+		writeJSON(w, map[string]any{"hosts": testHosts})
+	})
+	config := baseConfig(server.URL + "/hosts")
+	config.Pagination = PaginationLink
+	config.MaxPages = 3
+	s, err := NewHTTPJSONSource(config)
+	require.NoError(t, err)
+
+	_, err = s.Endpoints(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, *requests, 3)
+}
+
+func TestEndpoints_Auth(t *testing.T) {
+	tests := map[string]struct {
+		configure func(config *HTTPJSONSourceConfig)
+		check     func(r *http.Request) bool
+	}{
+		"bearer": {
+			configure: func(config *HTTPJSONSourceConfig) {
+				config.BearerToken = "token"
+			},
+			check: func(r *http.Request) bool {
+				return r.Header.Get("Authorization") == "Bearer token"
+			},
+		},
+		"basic": {
+			configure: func(config *HTTPJSONSourceConfig) {
+				config.BasicUsername = "user"
+				config.BasicPassword = "pass"
+			},
+			check: func(r *http.Request) bool {
+				username, password, ok := r.BasicAuth()
+				return ok && username == "user" && password == "pass"
+			},
+		},
+		"header": {
+			configure: func(config *HTTPJSONSourceConfig) {
+				config.AuthHeaderName = "X-API-Key"
+				config.AuthHeaderValue = "key"
+				config.Headers = map[string]string{"X-Extra": "extra"}
+			},
+			check: func(r *http.Request) bool {
+				return r.Header.Get("X-API-Key") == "key" && r.Header.Get("X-Extra") == "extra"
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			server, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if !tc.check(r) {
+					w.WriteHeader(http.StatusUnauthorized)
+					w.Write([]byte("nope"))
+					return
+				}
+				writeJSON(w, map[string]any{"hosts": testHosts})
+			})
+			config := baseConfig(server.URL + "/hosts")
+			s, err := NewHTTPJSONSource(config)
+			require.NoError(t, err)
+			_, err = s.Endpoints(context.Background())
+			assert.ErrorContains(t, err, "unexpected status code 401")
+
+			tc.configure(&config)
+			s, err = NewHTTPJSONSource(config)
+			require.NoError(t, err)
+			endpoints, err := s.Endpoints(context.Background())
+			require.NoError(t, err)
+			assert.Len(t, endpoints, 2)
+		})
+	}
+}
+
+func TestEndpoints_ETag(t *testing.T) {
+	hits := 0
+	notModified := 0
+	server, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		writeJSON(w, map[string]any{"hosts": testHosts})
+	})
+	s, err := NewHTTPJSONSource(baseConfig(server.URL + "/hosts"))
+	require.NoError(t, err)
+
+	for range 3 {
+		endpoints, err := s.Endpoints(context.Background())
+		require.NoError(t, err)
+		diff := cmp.Diff(expectedEndpoints, endpoints)
+		if diff != "" {
+			t.Fatalf("mismatch:\n%s", diff)
+		}
+	}
+	assert.Equal(t, 3, hits)
+	assert.Equal(t, 2, notModified)
+}
+
+func TestEndpoints_ETagChangingCursors(t *testing.T) {
+	run := 0
+	server, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.URL.Query().Get("after") == "" {
+			run++
+			writeJSON(w, map[string]any{
+				"hosts": testHosts[:1],
+				"meta":  map[string]any{"next_cursor": fmt.Sprintf("run-%d", run)},
+			})
+			return
+		}
+		writeJSON(w, map[string]any{"hosts": testHosts[1:]})
+	})
+	config := baseConfig(server.URL + "/hosts")
+	config.Pagination = PaginationCursor
+	config.CursorPath = "meta.next_cursor"
+	config.CursorParam = "after"
+	src, err := NewHTTPJSONSource(config)
+	require.NoError(t, err)
+	s := src.(*httpJSONSource)
+
+	for range 5 {
+		_, err := s.Endpoints(context.Background())
+		require.NoError(t, err)
+	}
+	// one entry per page, replaced as the cursors change
+	assert.Len(t, s.cache, 2)
+	assert.Equal(t, server.URL+"/hosts?after=run-5", s.cache[2].url)
+}
+
+func TestEndpoints_SeparateAddressPaths(t *testing.T) {
+	server, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"hostname": "a", "ip": "192.0.2.1", "ipv6": ["2001:db8::1", "192.0.2.99"]},
+			{"hostname": "b", "ip": "not an ip"}
+		]`))
+	})
+	s, err := NewHTTPJSONSource(HTTPJSONSourceConfig{
+		URL:          server.URL,
+		HostnamePath: "hostname",
+		IPv4Path:     "ip",
+		IPv6Path:     "ipv6",
+	})
+	require.NoError(t, err)
+
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	expected := []*endpoint.Endpoint{
+		{
+			Hostname:         "a",
+			IPv4s:            []string{"192.0.2.1"},
+			IPv6s:            []string{"2001:db8::1"},
+			SourceProperties: map[string]any{},
+		},
+	}
+	diff := cmp.Diff(expected, endpoints)
+	if diff != "" {
+		t.Fatalf("mismatch:\n%s", diff)
+	}
+}
+
+func TestNewHTTPJSONSource_Invalid(t *testing.T) {
+	tests := map[string]struct {
+		config    HTTPJSONSourceConfig
+		expectErr string
+	}{
+		"missing url": {
+			config:    HTTPJSONSourceConfig{IPv4Path: "ip"},
+			expectErr: "url is required",
+		},
+		"missing address paths": {
+			config:    HTTPJSONSourceConfig{URL: "http://example.com"},
+			expectErr: "at least one of",
+		},
+		"unknown pagination": {
+			config:    HTTPJSONSourceConfig{URL: "http://example.com", IPv4Path: "ip", Pagination: "offset"},
+			expectErr: "unknown pagination",
+		},
+		"cursor without path": {
+			config:    HTTPJSONSourceConfig{URL: "http://example.com", IPv4Path: "ip", Pagination: "cursor"},
+			expectErr: "cursor_path is required",
+		},
+		"invalid path": {
+			config:    HTTPJSONSourceConfig{URL: "http://example.com", IPv4Path: "ip[x]"},
+			expectErr: "invalid index",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewHTTPJSONSource(tc.config)
+			assert.ErrorContains(t, err, tc.expectErr)
+		})
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	assert.Equal(t, "https://example.com/?page=2", nextLink([]string{
+		`<https://example.com/?page=1>; rel="prev", <https://example.com/?page=2>; rel="next"`,
+	}))
+	assert.Equal(t, "/b", nextLink([]string{`</a>; rel="first"`, `</b>; rel=next`}))
+	assert.Equal(t, "/c", nextLink([]string{`</c>; title="x"; rel="last next"`}))
+	assert.Equal(t, "", nextLink([]string{`</a>; rel="prev"`}))
+	assert.Equal(t, "", nextLink(nil))
+}
@@ -0,0 +1,181 @@
+package httpjson
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type pathSegment struct {
+	key      string
+	index    int
+	indexed  bool
+	wildcard bool
+}
+
+// Path is a compiled JSON path expression. The syntax is a small subset of
+// JSONPath/JMESPath: keys separated by dots, optionally followed by an array
+// index or wildcard, e.g. "data.hosts", "addresses[0]" or
+// "interfaces[*].ip". A leading "$." is allowed and ignored. The empty path
+// refers to the whole document.
+type Path struct {
+	raw      string
+	segments []pathSegment
+}
+
+func CompilePath(raw string) (*Path, error) {
+	p := &Path{raw: raw}
+	expr := strings.TrimPrefix(strings.TrimPrefix(raw, "$"), ".")
+	if expr == "" {
+		return p, nil
+	}
+	for _, part := range strings.Split(expr, ".") {
+		key, rest, hasBracket := strings.Cut(part, "[")
+		if key == "" && !hasBracket {
+			return nil, fmt.Errorf("invalid path %q: empty segment", raw)
+		}
+		if key != "" {
+			p.segments = append(p.segments, pathSegment{key: key})
+		}
+		for hasBracket {
+			var inner string
+			var ok bool
+			inner, rest, ok = strings.Cut(rest, "]")
+			if !ok {
+				return nil, fmt.Errorf("invalid path %q: unclosed bracket", raw)
+			}
+			if inner == "*" {
+				p.segments = append(p.segments, pathSegment{wildcard: true})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid path %q: invalid index %q", raw, inner)
+				}
+				p.segments = append(p.segments, pathSegment{index: index, indexed: true})
+			}
+			if rest == "" {
+				break
+			}
+			if !strings.HasPrefix(rest, "[") {
+				return nil, fmt.Errorf("invalid path %q: unexpected %q", raw, rest)
+			}
+			rest = rest[1:]
+		}
+	}
+	return p, nil
+}
+
+func (p *Path) String() string {
+	return p.raw
+}
+
+// match returns all values matched by the path. Missing keys and out of
+// range indexes produce no values rather than an error. Negative indexes
+// count from the end of the array.
+func (p *Path) match(v any) []any {
+	values := []any{v}
+	for _, segment := range p.segments {
+		next := make([]any, 0, len(values))
+		for _, value := range values {
+			switch {
+			case segment.wildcard:
+				switch t := value.(type) {
+				case []any:
+					next = append(next, t...)
+				case map[string]any:
+					// sort keys so results are stable between runs
+					for _, key := range slices.Sorted(maps.Keys(t)) {
+						next = append(next, t[key])
+					}
+				}
+			case segment.indexed:
+				arr, ok := value.([]any)
+				if !ok {
+					continue
+				}
+				index := segment.index
+				if index < 0 {
+					index += len(arr)
+				}
+				if index >= 0 && index < len(arr) {
+					next = append(next, arr[index])
+				}
+			default:
+				obj, ok := value.(map[string]any)
+				if !ok {
+					continue
+				}
+				if item, ok := obj[segment.key]; ok {
+					next = append(next, item)
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// Value returns the value matched by the path as-is, or a slice of values
+// if the path contains a wildcard that matched more than one value.
+func (p *Path) Value(v any) (any, bool) {
+	values := p.match(v)
+	switch len(values) {
+	case 0:
+		return nil, false
+	case 1:
+		return values[0], true
+	default:
+		return values, true
+	}
+}
+
+// Get returns all values matched by the path, with a matched array being
+// expanded into its items.
+func (p *Path) Get(v any) []any {
+	values := p.match(v)
+	// flatten a trailing array so "addresses" and "addresses[*]" behave the
+	// same
+	result := make([]any, 0, len(values))
+	for _, value := range values {
+		if arr, ok := value.([]any); ok {
+			result = append(result, arr...)
+		} else if value != nil {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// GetString returns the first value matched by the path as a string.
+func (p *Path) GetString(v any) (string, bool) {
+	values := p.Get(v)
+	if len(values) == 0 {
+		return "", false
+	}
+	return stringify(values[0]), true
+}
+
+// GetStrings returns all values matched by the path as strings.
+func (p *Path) GetStrings(v any) []string {
+	values := p.Get(v)
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		result = append(result, stringify(value))
+	}
+	return result
+}
+
+func stringify(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	default:
+		return fmt.Sprint(t)
+	}
+}
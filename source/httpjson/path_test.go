@@ -0,0 +1,113 @@
+package httpjson
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const pathTestDocument = `{
+  "data": {
+    "hosts": [
+      {"name": "a", "addresses": ["192.0.2.1", "192.0.2.2"], "ttl": 60, "up": true},
+      {"name": "b", "interfaces": [{"ip": "2001:db8::1"}, {"ip": "2001:db8::2"}]}
+    ],
+    "labels": {"z": "last", "a": "first"}
+  }
+}`
+
+func TestPath_Get(t *testing.T) {
+	var doc any
+	require.NoError(t, json.Unmarshal([]byte(pathTestDocument), &doc))
+
+	tests := map[string]struct {
+		path   string
+		expect []any
+	}{
+		"root":                {path: "", expect: []any{doc}},
+		"dollar root":         {path: "$", expect: []any{doc}},
+		"nested key":          {path: "$.data.hosts[0].name", expect: []any{"a"}},
+		"trailing array":      {path: "data.hosts[0].addresses", expect: []any{"192.0.2.1", "192.0.2.2"}},
+		"wildcard":            {path: "data.hosts[*].name", expect: []any{"a", "b"}},
+		"nested wildcard":     {path: "data.hosts[1].interfaces[*].ip", expect: []any{"2001:db8::1", "2001:db8::2"}},
+		"negative index":      {path: "data.hosts[-1].name", expect: []any{"b"}},
+		"out of range":        {path: "data.hosts[5].name", expect: []any{}},
+		"missing key":         {path: "data.nope", expect: []any{}},
+		"object wildcard":     {path: "data.labels[*]", expect: []any{"first", "last"}},
+		"index on non-array":  {path: "data.labels[0]", expect: []any{}},
+		"key on non-object":   {path: "data.hosts.name", expect: []any{}},
+		"number":              {path: "data.hosts[0].ttl", expect: []any{float64(60)}},
+		"bool":                {path: "data.hosts[0].up", expect: []any{true}},
+		"wildcard missing in": {path: "data.hosts[*].ttl", expect: []any{float64(60)}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			p, err := CompilePath(tc.path)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expect, p.Get(doc))
+		})
+	}
+}
+
+func TestPath_Value(t *testing.T) {
+	var doc any
+	require.NoError(t, json.Unmarshal([]byte(pathTestDocument), &doc))
+
+	p, err := CompilePath("data.hosts[0].addresses")
+	require.NoError(t, err)
+	value, ok := p.Value(doc)
+	assert.True(t, ok)
+	assert.Equal(t, []any{"192.0.2.1", "192.0.2.2"}, value)
+
+	p, err = CompilePath("data.hosts[*].name")
+	require.NoError(t, err)
+	value, ok = p.Value(doc)
+	assert.True(t, ok)
+	assert.Equal(t, []any{"a", "b"}, value)
+
+	p, err = CompilePath("data.hosts[0].name")
+	require.NoError(t, err)
+	value, ok = p.Value(doc)
+	assert.True(t, ok)
+	assert.Equal(t, "a", value)
+
+	p, err = CompilePath("data.nope")
+	require.NoError(t, err)
+	_, ok = p.Value(doc)
+	assert.False(t, ok)
+}
+
+func TestPath_GetStrings(t *testing.T) {
+	var doc any
+	require.NoError(t, json.Unmarshal([]byte(pathTestDocument), &doc))
+
+	p, err := CompilePath("data.hosts[0][\"ttl\"]")
+	require.Error(t, err)
+	assert.Nil(t, p)
+
+	p, err = CompilePath("data.hosts[0].ttl")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"60"}, p.GetStrings(doc))
+
+	p, err = CompilePath("data.hosts[0].up")
+	require.NoError(t, err)
+	s, ok := p.GetString(doc)
+	assert.True(t, ok)
+	assert.Equal(t, "true", s)
+}
+
+func TestCompilePath_Invalid(t *testing.T) {
+	for _, raw := range []string{
+		"a..b",
+		"a[",
+		"a[x]",
+		"a[0]b",
+	} {
+		t.Run(raw, func(t *testing.T) {
+			_, err := CompilePath(raw)
+			assert.Error(t, err)
+		})
+	}
+}
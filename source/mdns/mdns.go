@@ -0,0 +1,614 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/source"
+)
+
+const (
+	// DNS-SD meta-query for enumerating service types (RFC 6763 section 9)
+	servicesMetaQuery = "_services._dns-sd._udp.local."
+
+	// Top bit of the class field is used as the cache-flush bit in responses
+	// (RFC 6762 section 10.2)
+	cacheFlushBit = 1 << 15
+
+	// Bounds of the delay between retries after failed reads
+	readErrorBackoffMin = 100 * time.Millisecond
+	readErrorBackoffMax = 30 * time.Second
+)
+
+var (
+	mdnsIPv4Group = &net.UDPAddr{IP: net.ParseIP("224.0.0.251"), Port: 5353}
+	mdnsIPv6Group = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: 5353}
+)
+
+type MDNSSourceConfig struct {
+	// Names of the interfaces to listen and browse on. Defaults to all
+	// multicast capable interfaces.
+	Interfaces []string
+	// Also listen on the IPv6 mDNS group (ff02::fb)
+	IPv6 bool
+	// Service types to actively browse for (e.g. "_http._tcp"). If empty, all
+	// service types discovered via the DNS-SD meta-query are browsed.
+	BrowseServices []string
+	// Only passively listen for announcements, never send queries
+	DisableBrowse bool
+	// How often to send browse queries in seconds (default 60)
+	BrowseIntervalSeconds int
+	// How long the first Endpoints call waits for answers after the listener
+	// starts in seconds (default 3). Without it the first sync (e.g. with
+	// -once) would always be empty.
+	StartupWaitSeconds int
+	// Keep link-local addresses (169.254.0.0/16 and fe80::/10)
+	IncludeLinkLocal bool
+	RecordTTL        int64
+}
+
+type cachedValue struct {
+	expiry       time.Time
+	iface        string
+	lastReceived time.Time
+}
+
+type cachedHost struct {
+	addresses map[string]*cachedValue
+	services  map[string]*cachedValue
+}
+
+type mdnsSource struct {
+	config MDNSSourceConfig
+	logger *zap.Logger
+	mutex  sync.Mutex
+	hosts  map[string]*cachedHost
+	// service types discovered via the DNS-SD meta-query
+	serviceTypes map[string]*cachedValue
+	// service instance names seen in PTR records
+	instances map[string]*cachedValue
+	// service instance name -> target hostname
+	targets  map[string]string
+	handlers []func()
+	started  sync.Once
+	startErr error
+	// when the listener started, zero if it never did
+	startedAt time.Time
+	conns     []multicastConn
+	now       func() time.Time
+}
+
+// multicastConn is the common interface between ipv4.PacketConn and
+// ipv6.PacketConn that is needed to send queries.
+type multicastConn interface {
+	SetMulticastInterface(*net.Interface) error
+	WriteTo([]byte, net.Addr) (int, error)
+	group() net.Addr
+}
+
+type ipv4Conn struct{ *ipv4.PacketConn }
+
+func (c ipv4Conn) group() net.Addr { return mdnsIPv4Group }
+func (c ipv4Conn) WriteTo(b []byte, dst net.Addr) (int, error) {
+	return c.PacketConn.WriteTo(b, nil, dst)
+}
+
+type ipv6Conn struct{ *ipv6.PacketConn }
+
+func (c ipv6Conn) group() net.Addr { return mdnsIPv6Group }
+func (c ipv6Conn) WriteTo(b []byte, dst net.Addr) (int, error) {
+	return c.PacketConn.WriteTo(b, nil, dst)
+}
+
+func NewMDNSSource(sourceConfig MDNSSourceConfig) (source.Source, error) {
+	if sourceConfig.BrowseIntervalSeconds == 0 {
+		sourceConfig.BrowseIntervalSeconds = 60
+	}
+	if sourceConfig.StartupWaitSeconds == 0 {
+		sourceConfig.StartupWaitSeconds = 3
+	}
+	services := make([]string, 0, len(sourceConfig.BrowseServices))
+	for _, service := range sourceConfig.BrowseServices {
+		services = append(services, strings.TrimSuffix(strings.TrimSuffix(service, "."), ".local"))
+	}
+	sourceConfig.BrowseServices = services
+	return &mdnsSource{
+		config:       sourceConfig,
+		logger:       log.MustNewLogger().Named("mdns_source"),
+		hosts:        map[string]*cachedHost{},
+		serviceTypes: map[string]*cachedValue{},
+		instances:    map[string]*cachedValue{},
+		targets:      map[string]string{},
+		now:          time.Now,
+	}, nil
+}
+
+func (s *mdnsSource) Endpoints(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	err := s.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = s.waitForStartup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+
+	hostnames := make([]string, 0, len(s.hosts))
+	for hostname := range s.hosts {
+		hostnames = append(hostnames, hostname)
+	}
+	slices.Sort(hostnames)
+
+	endpoints := make([]*endpoint.Endpoint, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		host := s.hosts[hostname]
+		if len(host.addresses) == 0 {
+			continue
+		}
+		ipv4s := make([]string, 0)
+		ipv6s := make([]string, 0)
+		var iface string
+		var lastSeen time.Time
+		for addr, value := range host.addresses {
+			if strings.Contains(addr, ":") {
+				ipv6s = append(ipv6s, addr)
+			} else {
+				ipv4s = append(ipv4s, addr)
+			}
+			if value.expiry.After(lastSeen) {
+				lastSeen = value.expiry
+				iface = value.iface
+			}
+		}
+		slices.Sort(ipv4s)
+		slices.Sort(ipv6s)
+		services := make([]string, 0, len(host.services))
+		for service := range host.services {
+			services = append(services, service)
+		}
+		slices.Sort(services)
+		endpoints = append(endpoints, &endpoint.Endpoint{
+			Hostname:  hostname,
+			IPv4s:     ipv4s,
+			IPv6s:     ipv6s,
+			RecordTTL: s.config.RecordTTL,
+			SourceProperties: map[string]any{
+				"mdns_services":  services,
+				"mdns_interface": iface,
+			},
+		})
+	}
+	return endpoints, nil
+}
+
+// AddEventHandler starts listening (if not already) and registers a handler
+// that is called whenever a new host or address is discovered.
+func (s *mdnsSource) AddEventHandler(ctx context.Context, handler func()) {
+	s.mutex.Lock()
+	s.handlers = append(s.handlers, handler)
+	s.mutex.Unlock()
+	err := s.start(ctx)
+	if err != nil {
+		s.logger.Error("could not start mDNS listener", zap.Error(err))
+	}
+}
+
+func (s *mdnsSource) interfaces() ([]net.Interface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]net.Interface, 0)
+	for _, iface := range all {
+		if len(s.config.Interfaces) > 0 {
+			if slices.Contains(s.config.Interfaces, iface.Name) {
+				result = append(result, iface)
+			}
+			continue
+		}
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		result = append(result, iface)
+	}
+	if len(s.config.Interfaces) > 0 && len(result) != len(s.config.Interfaces) {
+		return nil, fmt.Errorf("could not find all interfaces %v", s.config.Interfaces)
+	}
+	return result, nil
+}
+
+func (s *mdnsSource) start(ctx context.Context) error {
+	s.started.Do(func() {
+		s.startErr = s.listen(ctx)
+		if s.startErr != nil {
+			s.startErr = fmt.Errorf("could not start mDNS listener: %w", s.startErr)
+			s.logger.Error(s.startErr.Error())
+			return
+		}
+		s.startedAt = time.Now()
+	})
+	return s.startErr
+}
+
+// waitForStartup blocks until the listener has been running for
+// StartupWaitSeconds so hosts have had a chance to answer the initial browse
+// query. It returns immediately once that window has passed.
+func (s *mdnsSource) waitForStartup(ctx context.Context) error {
+	if s.startedAt.IsZero() {
+		return nil
+	}
+	wait := time.Until(s.startedAt.Add(time.Duration(s.config.StartupWaitSeconds) * time.Second))
+	if wait <= 0 {
+		return nil
+	}
+	s.logger.Sugar().Debugf("waiting %s for mDNS responses", wait.Round(time.Millisecond))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+func (s *mdnsSource) listen(ctx context.Context) error {
+	ifaces, err := s.interfaces()
+	if err != nil {
+		return err
+	}
+
+	udp4, err := net.ListenMulticastUDP("udp4", nil, mdnsIPv4Group)
+	if err != nil {
+		return err
+	}
+	pc4 := ipv4.NewPacketConn(udp4)
+	for _, iface := range ifaces {
+		err := pc4.JoinGroup(&iface, mdnsIPv4Group)
+		if err != nil {
+			s.logger.Sugar().Debugf("could not join IPv4 mDNS group on %s: %v", iface.Name, err)
+		}
+	}
+	// interface information is best effort; not all platforms support it
+	pc4.SetControlMessage(ipv4.FlagInterface, true)
+	s.conns = append(s.conns, ipv4Conn{pc4})
+	go s.readLoop(ctx, udp4, func(b []byte) (int, int, error) {
+		n, cm, _, err := pc4.ReadFrom(b)
+		ifIndex := 0
+		if cm != nil {
+			ifIndex = cm.IfIndex
+		}
+		return n, ifIndex, err
+	})
+
+	if s.config.IPv6 {
+		udp6, err := net.ListenMulticastUDP("udp6", nil, mdnsIPv6Group)
+		if err != nil {
+			return err
+		}
+		pc6 := ipv6.NewPacketConn(udp6)
+		for _, iface := range ifaces {
+			err := pc6.JoinGroup(&iface, mdnsIPv6Group)
+			if err != nil {
+				s.logger.Sugar().Debugf("could not join IPv6 mDNS group on %s: %v", iface.Name, err)
+			}
+		}
+		pc6.SetControlMessage(ipv6.FlagInterface, true)
+		s.conns = append(s.conns, ipv6Conn{pc6})
+		go s.readLoop(ctx, udp6, func(b []byte) (int, int, error) {
+			n, cm, _, err := pc6.ReadFrom(b)
+			ifIndex := 0
+			if cm != nil {
+				ifIndex = cm.IfIndex
+			}
+			return n, ifIndex, err
+		})
+	}
+
+	if !s.config.DisableBrowse {
+		go s.browseLoop(ctx, ifaces)
+	}
+
+	return nil
+}
+
+func (s *mdnsSource) readLoop(ctx context.Context, conn net.PacketConn, read func([]byte) (int, int, error)) {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	buf := make([]byte, 9000)
+	var backoff time.Duration
+	for {
+		n, ifIndex, err := read(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// back off so a persistent error doesn't spin and flood the logs
+			backoff = min(max(2*backoff, readErrorBackoffMin), readErrorBackoffMax)
+			s.logger.Warn("error reading mDNS packet", zap.Error(err), zap.Duration("retry_in", backoff))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		backoff = 0
+		msg := new(dns.Msg)
+		err = msg.Unpack(buf[:n])
+		if err != nil {
+			s.logger.Debug("could not unpack mDNS packet", zap.Error(err))
+			continue
+		}
+		ifaceName := ""
+		if ifIndex != 0 {
+			if iface, err := net.InterfaceByIndex(ifIndex); err == nil {
+				ifaceName = iface.Name
+			}
+		}
+		s.handleMessage(msg, ifaceName)
+	}
+}
+
+func (s *mdnsSource) browseLoop(ctx context.Context, ifaces []net.Interface) {
+	ticker := time.NewTicker(time.Duration(s.config.BrowseIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		s.browse(ifaces)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *mdnsSource) browse(ifaces []net.Interface) {
+	data, err := s.browseQuery().Pack()
+	if err != nil {
+		s.logger.Error("could not pack browse query", zap.Error(err))
+		return
+	}
+	for _, conn := range s.conns {
+		for _, iface := range ifaces {
+			err := conn.SetMulticastInterface(&iface)
+			if err != nil {
+				s.logger.Sugar().Debugf("could not set multicast interface to %s: %v", iface.Name, err)
+				continue
+			}
+			_, err = conn.WriteTo(data, conn.group())
+			if err != nil {
+				s.logger.Sugar().Debugf("could not send browse query on %s: %v", iface.Name, err)
+			}
+		}
+	}
+}
+
+// browseQuery builds a query for all service types that should be browsed.
+func (s *mdnsSource) browseQuery() *dns.Msg {
+	serviceTypes := s.config.BrowseServices
+	if len(serviceTypes) == 0 {
+		s.mutex.Lock()
+		for serviceType := range s.serviceTypes {
+			serviceTypes = append(serviceTypes, serviceType)
+		}
+		s.mutex.Unlock()
+		slices.Sort(serviceTypes)
+	}
+	msg := new(dns.Msg)
+	msg.Id = 0
+	msg.RecursionDesired = false
+	questions := []dns.Question{}
+	if len(s.config.BrowseServices) == 0 {
+		questions = append(questions, dns.Question{Name: servicesMetaQuery, Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	}
+	for _, service := range serviceTypes {
+		questions = append(questions, dns.Question{Name: service + ".local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	}
+	msg.Question = questions
+	return msg
+}
+
+// hostnameFromName converts an mDNS name like "host.local." to "host".
+// Returns false if the name is not in the .local domain.
+func hostnameFromName(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	hostname, found := strings.CutSuffix(name, ".local")
+	if !found || hostname == "" || strings.Contains(hostname, ".") {
+		return "", false
+	}
+	return hostname, true
+}
+
+// serviceTypeFromName extracts the service type (e.g. "_http._tcp") from a
+// DNS-SD name like "My Printer._http._tcp.local.".
+func serviceTypeFromName(name string) (string, bool) {
+	labels := dns.SplitDomainName(name)
+	for i := len(labels) - 1; i > 0; i-- {
+		if labels[i] == "_tcp" || labels[i] == "_udp" {
+			return labels[i-1] + "." + labels[i], true
+		}
+	}
+	return "", false
+}
+
+func (s *mdnsSource) isLinkLocal(ip net.IP) bool {
+	return !s.config.IncludeLinkLocal && (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast())
+}
+
+// handleMessage updates the cache from the answers in an mDNS message.
+func (s *mdnsSource) handleMessage(msg *dns.Msg, iface string) {
+	if !msg.Response {
+		return
+	}
+	now := s.now()
+	records := append(append([]dns.RR{}, msg.Answer...), msg.Extra...)
+
+	s.mutex.Lock()
+	changed := false
+	for _, rr := range records {
+		header := rr.Header()
+		ttl := time.Duration(header.Ttl) * time.Second
+		cacheFlush := header.Class&cacheFlushBit != 0
+		switch record := rr.(type) {
+		case *dns.A:
+			changed = s.addAddress(header.Name, record.A, ttl, cacheFlush, iface, now) || changed
+		case *dns.AAAA:
+			changed = s.addAddress(header.Name, record.AAAA, ttl, cacheFlush, iface, now) || changed
+		case *dns.PTR:
+			serviceType, ok := serviceTypeFromName(record.Ptr)
+			if !ok {
+				continue
+			}
+			if strings.EqualFold(header.Name, servicesMetaQuery) {
+				// service type enumeration
+				s.setCached(s.serviceTypes, serviceType, ttl, iface, now)
+				continue
+			}
+			s.setCached(s.instances, strings.ToLower(record.Ptr), ttl, iface, now)
+			if target, ok := s.targets[strings.ToLower(record.Ptr)]; ok {
+				s.addService(target, serviceType, ttl, iface, now)
+			}
+		case *dns.SRV:
+			serviceType, ok := serviceTypeFromName(header.Name)
+			if !ok {
+				continue
+			}
+			hostname, ok := hostnameFromName(record.Target)
+			if !ok {
+				continue
+			}
+			instance := strings.ToLower(header.Name)
+			s.setCached(s.instances, instance, ttl, iface, now)
+			if ttl == 0 {
+				delete(s.targets, instance)
+			} else {
+				s.targets[instance] = hostname
+			}
+			s.addService(hostname, serviceType, ttl, iface, now)
+		}
+	}
+	handlers := slices.Clone(s.handlers)
+	s.mutex.Unlock()
+
+	if changed {
+		for _, handler := range handlers {
+			handler()
+		}
+	}
+}
+
+func (s *mdnsSource) setCached(m map[string]*cachedValue, key string, ttl time.Duration, iface string, now time.Time) bool {
+	if ttl == 0 {
+		// goodbye packet (RFC 6762 section 10.1); the spec says to keep the
+		// record for one more second.
+		if value, ok := m[key]; ok {
+			value.expiry = now.Add(time.Second)
+		}
+		return false
+	}
+	value, ok := m[key]
+	if !ok {
+		m[key] = &cachedValue{
+			expiry:       now.Add(ttl),
+			iface:        iface,
+			lastReceived: now,
+		}
+		return true
+	}
+	value.expiry = now.Add(ttl)
+	value.lastReceived = now
+	if iface != "" {
+		value.iface = iface
+	}
+	return false
+}
+
+func (s *mdnsSource) host(hostname string) *cachedHost {
+	host, ok := s.hosts[hostname]
+	if !ok {
+		host = &cachedHost{
+			addresses: map[string]*cachedValue{},
+			services:  map[string]*cachedValue{},
+		}
+		s.hosts[hostname] = host
+	}
+	return host
+}
+
+func (s *mdnsSource) addAddress(name string, ip net.IP, ttl time.Duration, cacheFlush bool, iface string, now time.Time) bool {
+	hostname, ok := hostnameFromName(name)
+	if !ok || ip == nil || s.isLinkLocal(ip) {
+		return false
+	}
+	host := s.host(hostname)
+	addr := ip.String()
+	isIPv4 := ip.To4() != nil
+	if cacheFlush && ttl != 0 {
+		// RFC 6762 section 10.2: records of the same type that were received
+		// more than a second ago should be flushed. Records from the same
+		// packet, or a burst of them, were received just now and are kept.
+		for existing, value := range host.addresses {
+			if existing == addr || strings.Contains(existing, ":") == isIPv4 {
+				continue
+			}
+			if now.Sub(value.lastReceived) > time.Second && value.expiry.After(now.Add(time.Second)) {
+				value.expiry = now.Add(time.Second)
+			}
+		}
+	}
+	return s.setCached(host.addresses, addr, ttl, iface, now)
+}
+
+func (s *mdnsSource) addService(hostname string, serviceType string, ttl time.Duration, iface string, now time.Time) {
+	s.setCached(s.host(hostname).services, serviceType, ttl, iface, now)
+}
+
+// expire removes all entries whose TTL has passed. Must be called with the
+// mutex held.
+func (s *mdnsSource) expire() {
+	now := s.now()
+	for hostname, host := range s.hosts {
+		for addr, value := range host.addresses {
+			if !now.Before(value.expiry) {
+				delete(host.addresses, addr)
+			}
+		}
+		for service, value := range host.services {
+			if !now.Before(value.expiry) {
+				delete(host.services, service)
+			}
+		}
+		if len(host.addresses) == 0 && len(host.services) == 0 {
+			delete(s.hosts, hostname)
+		}
+	}
+	for serviceType, value := range s.serviceTypes {
+		if !now.Before(value.expiry) {
+			delete(s.serviceTypes, serviceType)
+		}
+	}
+	for instance, value := range s.instances {
+		if !now.Before(value.expiry) {
+			delete(s.instances, instance)
+			delete(s.targets, instance)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestMDNSSource(t *testing.T, config MDNSSourceConfig) (*mdnsSource, *fakeClock) {
+	t.Helper()
+	s, err := NewMDNSSource(config)
+	require.NoError(t, err)
+	ms := s.(*mdnsSource)
+	ms.logger = zap.NewNop()
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	ms.now = clock.now
+	// don't actually bind to the mDNS port during tests
+	ms.started.Do(func() {})
+	return ms, clock
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	require.NoError(t, err)
+	return rr
+}
+
+func response(t *testing.T, answers ...string) *dns.Msg {
+	t.Helper()
+	msg := new(dns.Msg)
+	msg.Response = true
+	msg.Authoritative = true
+	for _, answer := range answers {
+		msg.Answer = append(msg.Answer, mustRR(t, answer))
+	}
+	return msg
+}
+
+func TestEndpoints(t *testing.T) {
+	s, _ := newTestMDNSSource(t, MDNSSourceConfig{RecordTTL: 60})
+
+	s.handleMessage(response(t,
+		"printer.local. 120 IN A 192.0.2.10",
+		"printer.local. 120 IN AAAA 2001:db8::10",
+		"printer.local. 120 IN AAAA fe80::10",
+		"_ipp._tcp.local. 4500 IN PTR Office\\ Printer._ipp._tcp.local.",
+		"Office\\ Printer._ipp._tcp.local. 120 IN SRV 0 0 631 printer.local.",
+		"Office\\ Printer._http._tcp.local. 120 IN SRV 0 0 80 printer.local.",
+	), "eth0")
+	s.handleMessage(response(t,
+		"Living-Room-TV.local. 120 IN A 192.0.2.20",
+		"example.com. 120 IN A 198.51.100.1",
+	), "eth1")
+	// queries should be ignored
+	query := new(dns.Msg)
+	query.SetQuestion("ignored.local.", dns.TypeA)
+	query.Answer = append(query.Answer, mustRR(t, "ignored.local. 120 IN A 192.0.2.99"))
+	s.handleMessage(query, "eth0")
+
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+
+	expected := []*endpoint.Endpoint{
+		{
+			Hostname:  "living-room-tv",
+			IPv4s:     []string{"192.0.2.20"},
+			IPv6s:     []string{},
+			RecordTTL: 60,
+			SourceProperties: map[string]any{
+				"mdns_services":  []string{},
+				"mdns_interface": "eth1",
+			},
+		},
+		{
+			Hostname:  "printer",
+			IPv4s:     []string{"192.0.2.10"},
+			IPv6s:     []string{"2001:db8::10"},
+			RecordTTL: 60,
+			SourceProperties: map[string]any{
+				"mdns_services":  []string{"_http._tcp", "_ipp._tcp"},
+				"mdns_interface": "eth0",
+			},
+		},
+	}
+	diff := cmp.Diff(expected, endpoints)
+	if diff != "" {
+		t.Fatalf("mismatch:\n%s", diff)
+	}
+}
+
+func TestEndpoints_TTLExpiry(t *testing.T) {
+	s, clock := newTestMDNSSource(t, MDNSSourceConfig{})
+
+	s.handleMessage(response(t,
+		"short.local. 10 IN A 192.0.2.1",
+		"long.local. 120 IN A 192.0.2.2",
+	), "")
+
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, endpoints, 2)
+
+	clock.advance(11 * time.Second)
+	endpoints, err = s.Endpoints(context.Background())
+	require.NoError(t, err)
+	require.Len(t, endpoints, 1)
+	assert.Equal(t, "long", endpoints[0].Hostname)
+
+	// refreshing an announcement extends its lifetime
+	clock.advance(100 * time.Second)
+	s.handleMessage(response(t, "long.local. 120 IN A 192.0.2.2"), "")
+	clock.advance(100 * time.Second)
+	endpoints, err = s.Endpoints(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, endpoints, 1)
+}
+
+func TestEndpoints_Goodbye(t *testing.T) {
+	s, clock := newTestMDNSSource(t, MDNSSourceConfig{})
+
+	s.handleMessage(response(t, "host.local. 120 IN A 192.0.2.1"), "")
+	s.handleMessage(response(t, "host.local. 0 IN A 192.0.2.1"), "")
+
+	clock.advance(2 * time.Second)
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, endpoints, 0)
+}
+
+func TestEndpoints_CacheFlush(t *testing.T) {
+	s, clock := newTestMDNSSource(t, MDNSSourceConfig{})
+
+	s.handleMessage(response(t, "host.local. 120 IN A 192.0.2.1"), "")
+	clock.advance(5 * time.Second)
+
+	flush := response(t, "host.local. 120 IN A 192.0.2.2")
+	flush.Answer[0].Header().Class |= cacheFlushBit
+	s.handleMessage(flush, "")
+
+	clock.advance(2 * time.Second)
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	require.Len(t, endpoints, 1)
+	assert.Equal(t, []string{"192.0.2.2"}, endpoints[0].IPv4s)
+}
+
+func TestEndpoints_CacheFlushSamePacket(t *testing.T) {
+	s, clock := newTestMDNSSource(t, MDNSSourceConfig{})
+
+	announce := func() {
+		msg := response(t,
+			"host.local. 120 IN A 192.0.2.1",
+			"host.local. 120 IN A 192.0.2.2",
+		)
+		for _, rr := range msg.Answer {
+			rr.Header().Class |= cacheFlushBit
+		}
+		s.handleMessage(msg, "")
+	}
+	announce()
+	clock.advance(60 * time.Second)
+	// re-announcing both records in one packet must not flush either
+	announce()
+
+	clock.advance(2 * time.Second)
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	require.Len(t, endpoints, 1)
+	assert.Equal(t, []string{"192.0.2.1", "192.0.2.2"}, endpoints[0].IPv4s)
+}
+
+func TestEndpoints_IncludeLinkLocal(t *testing.T) {
+	s, _ := newTestMDNSSource(t, MDNSSourceConfig{IncludeLinkLocal: true})
+
+	s.handleMessage(response(t,
+		"host.local. 120 IN A 169.254.1.1",
+		"host.local. 120 IN AAAA fe80::1",
+	), "")
+
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	require.Len(t, endpoints, 1)
+	assert.Equal(t, []string{"169.254.1.1"}, endpoints[0].IPv4s)
+	assert.Equal(t, []string{"fe80::1"}, endpoints[0].IPv6s)
+}
+
+func TestAddEventHandler(t *testing.T) {
+	s, _ := newTestMDNSSource(t, MDNSSourceConfig{})
+
+	calls := 0
+	s.AddEventHandler(context.Background(), func() {
+		calls++
+	})
+
+	s.handleMessage(response(t, "host.local. 120 IN A 192.0.2.1"), "")
+	assert.Equal(t, 1, calls)
+
+	// refreshes of known addresses are not a change
+	s.handleMessage(response(t, "host.local. 120 IN A 192.0.2.1"), "")
+	assert.Equal(t, 1, calls)
+
+	s.handleMessage(response(t, "host.local. 120 IN A 192.0.2.2"), "")
+	assert.Equal(t, 2, calls)
+}
+
+func TestEndpoints_WaitForStartup(t *testing.T) {
+	s, _ := newTestMDNSSource(t, MDNSSourceConfig{StartupWaitSeconds: 60})
+
+	s.startedAt = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := s.Endpoints(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	s.startedAt = time.Now().Add(-time.Minute)
+	s.handleMessage(response(t, "host.local. 120 IN A 192.0.2.1"), "")
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, endpoints, 1)
+}
+
+func TestReadLoop_Backoff(t *testing.T) {
+	s, _ := newTestMDNSSource(t, MDNSSourceConfig{})
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reads := 0
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		s.readLoop(ctx, conn, func([]byte) (int, int, error) {
+			reads++
+			if reads == 3 {
+				cancel()
+			}
+			return 0, 0, errors.New("read failed")
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("readLoop did not return")
+	}
+	assert.Equal(t, 3, reads)
+	// 100ms after the first failure and 200ms after the second
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+}
+
+func TestBrowseQuery(t *testing.T) {
+	t.Run("configured services", func(t *testing.T) {
+		s, _ := newTestMDNSSource(t, MDNSSourceConfig{
+			BrowseServices: []string{"_http._tcp", "_ipp._tcp.local."},
+		})
+		msg := s.browseQuery()
+		assert.Equal(t, []dns.Question{
+			{Name: "_http._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET},
+			{Name: "_ipp._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET},
+		}, msg.Question)
+	})
+
+	t.Run("discovered services", func(t *testing.T) {
+		s, _ := newTestMDNSSource(t, MDNSSourceConfig{})
+		msg := s.browseQuery()
+		assert.Equal(t, []dns.Question{
+			{Name: servicesMetaQuery, Qtype: dns.TypePTR, Qclass: dns.ClassINET},
+		}, msg.Question)
+
+		s.handleMessage(response(t,
+			"_services._dns-sd._udp.local. 4500 IN PTR _airplay._tcp.local.",
+			"_services._dns-sd._udp.local. 4500 IN PTR _googlecast._tcp.local.",
+		), "")
+		msg = s.browseQuery()
+		assert.Equal(t, []dns.Question{
+			{Name: servicesMetaQuery, Qtype: dns.TypePTR, Qclass: dns.ClassINET},
+			{Name: "_airplay._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET},
+			{Name: "_googlecast._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET},
+		}, msg.Question)
+	})
+}
+
+func TestNewMDNSSource_DoesNotModifyBrowseServices(t *testing.T) {
+	services := []string{"_http._tcp", "_ipp._tcp.local."}
+	s, err := NewMDNSSource(MDNSSourceConfig{BrowseServices: services})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"_http._tcp", "_ipp._tcp"}, s.(*mdnsSource).config.BrowseServices)
+	assert.Equal(t, []string{"_http._tcp", "_ipp._tcp.local."}, services)
+}
+
+func TestHostnameFromName(t *testing.T) {
+	tests := map[string]struct {
+		name   string
+		expect string
+		ok     bool
+	}{
+		"local":     {name: "host.local.", expect: "host", ok: true},
+		"uppercase": {name: "Host.LOCAL.", expect: "host", ok: true},
+		"no dot":    {name: "host.local", expect: "host", ok: true},
+		"not local": {name: "host.example.com.", ok: false},
+		"nested":    {name: "a.b.local.", ok: false},
+		"bare":      {name: "local.", ok: false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, ok := hostnameFromName(tc.name)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.expect, got)
+		})
+	}
+}
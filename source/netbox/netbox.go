@@ -0,0 +1,251 @@
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/rdns"
+	"github.com/sapslaj/zonepop/source"
+)
+
+const DefaultPageSize = 1000
+
+type NetBoxSourceConfig struct {
+	// Base URL of NetBox, e.g. https://netbox.example.com
+	URL   string
+	Token string
+	// Only include addresses within these prefixes
+	Prefixes []string
+	// Only include addresses in these VRFs (by route distinguisher)
+	VRFs []string
+	// Only include addresses in these VRFs (by ID)
+	VRFIDs []int
+	// Only include addresses that have all of these tags (by slug)
+	Tags []string
+	// Only include addresses with one of these statuses (e.g. "active")
+	Statuses []string
+	// Domain to strip from the end of dns_name, e.g. ".example.com"
+	StripDomain string
+	// Fall back to the assigned device or virtual machine name when dns_name
+	// is empty
+	UseAssignedObjectName bool
+	// Number of results to request per page (default 1000)
+	PageSize  int
+	RecordTTL int64
+}
+
+type nestedObject struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+	RD   string `json:"rd"`
+}
+
+type IPAddress struct {
+	ID      int    `json:"id"`
+	Address string `json:"address"`
+	Status  struct {
+		Value string `json:"value"`
+	} `json:"status"`
+	DNSName            string         `json:"dns_name"`
+	VRF                *nestedObject  `json:"vrf"`
+	Tenant             *nestedObject  `json:"tenant"`
+	Tags               []nestedObject `json:"tags"`
+	AssignedObjectType string         `json:"assigned_object_type"`
+	AssignedObject     *struct {
+		ID             int           `json:"id"`
+		Name           string        `json:"name"`
+		Device         *nestedObject `json:"device"`
+		VirtualMachine *nestedObject `json:"virtual_machine"`
+	} `json:"assigned_object"`
+}
+
+type ipAddressList struct {
+	Count   int          `json:"count"`
+	Next    *string      `json:"next"`
+	Results []*IPAddress `json:"results"`
+}
+
+type netboxSource struct {
+	config     NetBoxSourceConfig
+	logger     *zap.Logger
+	httpClient *http.Client
+}
+
+func NewNetBoxSource(sourceConfig NetBoxSourceConfig) (source.Source, error) {
+	if sourceConfig.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	sourceConfig.URL = strings.TrimSuffix(sourceConfig.URL, "/")
+	if sourceConfig.PageSize == 0 {
+		sourceConfig.PageSize = DefaultPageSize
+	}
+	return &netboxSource{
+		config:     sourceConfig,
+		httpClient: http.DefaultClient,
+		logger: log.MustNewLogger().Named("netbox_source").With(
+			zap.String("url", sourceConfig.URL),
+		),
+	}, nil
+}
+
+func (s *netboxSource) Endpoints(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	addresses, err := s.getIPAddresses(ctx)
+	if err != nil {
+		newErr := fmt.Errorf("could not get IP addresses: %w", err)
+		s.logger.Error(newErr.Error())
+		return nil, newErr
+	}
+	endpoints := make([]*endpoint.Endpoint, 0, len(addresses))
+	for _, address := range addresses {
+		e := s.ipAddressToEndpoint(address)
+		if e == nil {
+			continue
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
+
+func (s *netboxSource) query() url.Values {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(s.config.PageSize))
+	query.Set("ordering", "id")
+	for _, prefix := range s.config.Prefixes {
+		query.Add("parent", prefix)
+	}
+	for _, vrf := range s.config.VRFs {
+		query.Add("vrf", vrf)
+	}
+	for _, vrfID := range s.config.VRFIDs {
+		query.Add("vrf_id", strconv.Itoa(vrfID))
+	}
+	for _, tag := range s.config.Tags {
+		query.Add("tag", tag)
+	}
+	for _, status := range s.config.Statuses {
+		query.Add("status", status)
+	}
+	return query
+}
+
+func (s *netboxSource) getIPAddresses(ctx context.Context) ([]*IPAddress, error) {
+	s.logger.Info("Getting IP addresses")
+	result := make([]*IPAddress, 0)
+	next := s.config.URL + "/api/ipam/ip-addresses/?" + s.query().Encode()
+	for page := 1; next != ""; page++ {
+		s.logger.Sugar().Debugf("Getting page %d of IP addresses", page)
+		var list ipAddressList
+		err := s.get(ctx, next, &list)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, list.Results...)
+		next = ""
+		if list.Next != nil {
+			next = *list.Next
+		}
+	}
+	return result, nil
+}
+
+func (s *netboxSource) get(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.config.Token)
+	}
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("unexpected status code %d from %s: %s", res.StatusCode, u, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (s *netboxSource) ipAddressToEndpoint(address *IPAddress) *endpoint.Endpoint {
+	logger := s.logger.With(zap.Int("netbox_id", address.ID), zap.String("address", address.Address))
+
+	ip, _, _ := strings.Cut(address.Address, "/")
+	kind, err := rdns.DetermineAddressKind(ip)
+	if err != nil {
+		logger.Warn("ignoring invalid address", zap.Error(err))
+		return nil
+	}
+
+	device := ""
+	virtualMachine := ""
+	iface := ""
+	if address.AssignedObject != nil {
+		iface = address.AssignedObject.Name
+		if address.AssignedObject.Device != nil {
+			device = address.AssignedObject.Device.Name
+		}
+		if address.AssignedObject.VirtualMachine != nil {
+			virtualMachine = address.AssignedObject.VirtualMachine.Name
+		}
+	}
+
+	hostname := strings.TrimSuffix(address.DNSName, ".")
+	if s.config.StripDomain != "" {
+		hostname = strings.TrimSuffix(hostname, "."+strings.Trim(s.config.StripDomain, "."))
+	}
+	if hostname == "" && s.config.UseAssignedObjectName {
+		hostname = device
+		if hostname == "" {
+			hostname = virtualMachine
+		}
+	}
+
+	tags := make([]string, 0, len(address.Tags))
+	for _, tag := range address.Tags {
+		tags = append(tags, tag.Slug)
+	}
+	vrf := ""
+	if address.VRF != nil {
+		vrf = address.VRF.Name
+	}
+	tenant := ""
+	if address.Tenant != nil {
+		tenant = address.Tenant.Slug
+	}
+
+	e := &endpoint.Endpoint{
+		Hostname:  hostname,
+		IPv4s:     []string{},
+		RecordTTL: s.config.RecordTTL,
+		SourceProperties: map[string]any{
+			"netbox_id":              address.ID,
+			"netbox_status":          address.Status.Value,
+			"netbox_vrf":             vrf,
+			"netbox_tenant":          tenant,
+			"netbox_tags":            tags,
+			"netbox_device":          device,
+			"netbox_virtual_machine": virtualMachine,
+			"netbox_interface":       iface,
+		},
+	}
+	if kind == rdns.AddressKindIPv4 {
+		e.IPv4s = []string{ip}
+	} else {
+		e.IPv6s = []string{ip}
+	}
+	return e
+}
@@ -0,0 +1,251 @@
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+const ipAddressesJSON = `[
+  {
+    "id": 1,
+    "address": "192.0.2.1/24",
+    "status": {"value": "active", "label": "Active"},
+    "dns_name": "router.example.com",
+    "vrf": null,
+    "tenant": {"id": 1, "name": "Infra", "slug": "infra"},
+    "tags": [{"id": 1, "name": "DNS", "slug": "dns"}],
+    "assigned_object_type": "dcim.interface",
+    "assigned_object": {
+      "id": 10,
+      "name": "eth0",
+      "device": {"id": 5, "name": "rtr01"}
+    }
+  },
+  {
+    "id": 2,
+    "address": "2001:db8::1/64",
+    "status": {"value": "active", "label": "Active"},
+    "dns_name": "router.example.com",
+    "vrf": null,
+    "tenant": {"id": 1, "name": "Infra", "slug": "infra"},
+    "tags": [],
+    "assigned_object_type": "dcim.interface",
+    "assigned_object": {
+      "id": 10,
+      "name": "eth0",
+      "device": {"id": 5, "name": "rtr01"}
+    }
+  },
+  {
+    "id": 3,
+    "address": "10.0.0.5/24",
+    "status": {"value": "reserved", "label": "Reserved"},
+    "dns_name": "",
+    "vrf": {"id": 2, "name": "mgmt", "rd": "65000:2"},
+    "tenant": null,
+    "tags": [],
+    "assigned_object_type": "virtualization.vminterface",
+    "assigned_object": {
+      "id": 11,
+      "name": "ens3",
+      "virtual_machine": {"id": 7, "name": "vm01"}
+    }
+  }
+]`
+
+func newTestNetBoxServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	var all []json.RawMessage
+	require.NoError(t, json.Unmarshal([]byte(ipAddressesJSON), &all))
+	queries := []string{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/ipam/ip-addresses/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"detail":"Invalid token"}`))
+			return
+		}
+		queries = append(queries, r.URL.RawQuery)
+		query := r.URL.Query()
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		offset, _ := strconv.Atoi(query.Get("offset"))
+		end := min(offset+limit, len(all))
+		res := map[string]any{
+			"count":    len(all),
+			"next":     nil,
+			"previous": nil,
+			"results":  all[offset:end],
+		}
+		if end < len(all) {
+			query.Set("offset", strconv.Itoa(end))
+			res["next"] = server.URL + r.URL.Path + "?" + query.Encode()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(server.Close)
+	return server, &queries
+}
+
+func TestEndpoints(t *testing.T) {
+	routerV4 := &endpoint.Endpoint{
+		Hostname:  "router",
+		IPv4s:     []string{"192.0.2.1"},
+		RecordTTL: 60,
+		SourceProperties: map[string]any{
+			"netbox_id":              1,
+			"netbox_status":          "active",
+			"netbox_vrf":             "",
+			"netbox_tenant":          "infra",
+			"netbox_tags":            []string{"dns"},
+			"netbox_device":          "rtr01",
+			"netbox_virtual_machine": "",
+			"netbox_interface":       "eth0",
+		},
+	}
+	routerV6 := &endpoint.Endpoint{
+		Hostname:  "router",
+		IPv4s:     []string{},
+		IPv6s:     []string{"2001:db8::1"},
+		RecordTTL: 60,
+		SourceProperties: map[string]any{
+			"netbox_id":              2,
+			"netbox_status":          "active",
+			"netbox_vrf":             "",
+			"netbox_tenant":          "infra",
+			"netbox_tags":            []string{},
+			"netbox_device":          "rtr01",
+			"netbox_virtual_machine": "",
+			"netbox_interface":       "eth0",
+		},
+	}
+	vm := &endpoint.Endpoint{
+		Hostname:  "",
+		IPv4s:     []string{"10.0.0.5"},
+		RecordTTL: 60,
+		SourceProperties: map[string]any{
+			"netbox_id":              3,
+			"netbox_status":          "reserved",
+			"netbox_vrf":             "mgmt",
+			"netbox_tenant":          "",
+			"netbox_tags":            []string{},
+			"netbox_device":          "",
+			"netbox_virtual_machine": "vm01",
+			"netbox_interface":       "ens3",
+		},
+	}
+	vmWithName := *vm
+	vmWithName.Hostname = "vm01"
+	routerV4FQDN := *routerV4
+	routerV4FQDN.Hostname = "router.example.com"
+	routerV6FQDN := *routerV6
+	routerV6FQDN.Hostname = "router.example.com"
+
+	tests := map[string]struct {
+		config        NetBoxSourceConfig
+		expect        []*endpoint.Endpoint
+		expectQueries int
+	}{
+		"single page": {
+			config: NetBoxSourceConfig{
+				StripDomain: ".example.com",
+			},
+			expect:        []*endpoint.Endpoint{routerV4, routerV6, vm},
+			expectQueries: 1,
+		},
+		"paginated": {
+			config: NetBoxSourceConfig{
+				StripDomain: "example.com.",
+				PageSize:    2,
+			},
+			expect:        []*endpoint.Endpoint{routerV4, routerV6, vm},
+			expectQueries: 2,
+		},
+		"assigned object name fallback": {
+			config: NetBoxSourceConfig{
+				StripDomain:           ".example.com",
+				UseAssignedObjectName: true,
+				PageSize:              1,
+			},
+			expect:        []*endpoint.Endpoint{routerV4, routerV6, &vmWithName},
+			expectQueries: 3,
+		},
+		"no strip domain": {
+			config: NetBoxSourceConfig{
+				PageSize: 1,
+			},
+			expect:        []*endpoint.Endpoint{&routerV4FQDN, &routerV6FQDN, vm},
+			expectQueries: 3,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			server, queries := newTestNetBoxServer(t)
+			tc.config.URL = server.URL + "/"
+			tc.config.Token = "secret"
+			tc.config.RecordTTL = 60
+			s, err := NewNetBoxSource(tc.config)
+			require.NoError(t, err)
+
+			endpoints, err := s.Endpoints(context.Background())
+			require.NoError(t, err)
+			assert.Len(t, *queries, tc.expectQueries)
+
+			diff := cmp.Diff(tc.expect, endpoints)
+			if diff != "" {
+				t.Fatalf("mismatch:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEndpoints_Filters(t *testing.T) {
+	server, queries := newTestNetBoxServer(t)
+	s, err := NewNetBoxSource(NetBoxSourceConfig{
+		URL:      server.URL,
+		Token:    "secret",
+		Prefixes: []string{"192.0.2.0/24", "2001:db8::/64"},
+		VRFs:     []string{"65000:2"},
+		VRFIDs:   []int{2},
+		Tags:     []string{"dns"},
+		Statuses: []string{"active"},
+	})
+	require.NoError(t, err)
+
+	_, err = s.Endpoints(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, *queries, 1)
+	assert.Equal(
+		t,
+		"limit=1000&ordering=id&parent=192.0.2.0%2F24&parent=2001%3Adb8%3A%3A%2F64&status=active&tag=dns&vrf=65000%3A2&vrf_id=2",
+		(*queries)[0],
+	)
+}
+
+func TestEndpoints_Forbidden(t *testing.T) {
+	server, _ := newTestNetBoxServer(t)
+	s, err := NewNetBoxSource(NetBoxSourceConfig{
+		URL:   server.URL,
+		Token: "wrong",
+	})
+	require.NoError(t, err)
+
+	_, err = s.Endpoints(context.Background())
+	assert.ErrorContains(t, err, "unexpected status code 403")
+}
@@ -0,0 +1,327 @@
+package prometheussd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/source"
+)
+
+const addressLabel = "__address__"
+
+type PrometheusSDSourceConfig struct {
+	// URL of a Prometheus HTTP service discovery endpoint
+	URL string
+	// Extra headers to send to the HTTP SD endpoint, e.g. Authorization
+	Headers        map[string]string
+	TimeoutSeconds int
+	// Paths of file_sd files in JSON or YAML format. Glob patterns are
+	// supported.
+	Files []string
+	// Label to take the hostname from (default "__address__"). Ports are
+	// removed, and targets whose hostname is an IP address are skipped.
+	HostnameLabel string
+	// Function called with the labels of each target (including
+	// __address__) that returns the hostname, or nil to skip the target.
+	// Overrides HostnameLabel.
+	Hostname *lua.LFunction
+	// Domain to strip from the end of hostnames, e.g. ".example.com"
+	StripDomain string
+	// Look up the addresses of targets that use a hostname instead of an IP
+	// address. Otherwise those targets are skipped.
+	Resolve   bool
+	RecordTTL int64
+}
+
+// TargetGroup is the format used by both file_sd files and HTTP SD
+// responses.
+type TargetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+type prometheusSDSource struct {
+	config     PrometheusSDSourceConfig
+	state      *lua.LState
+	logger     *zap.Logger
+	httpClient *http.Client
+	resolver   *net.Resolver
+}
+
+func NewPrometheusSDSource(state *lua.LState, sourceConfig PrometheusSDSourceConfig) (source.Source, error) {
+	if sourceConfig.URL == "" && len(sourceConfig.Files) == 0 {
+		return nil, fmt.Errorf("url or files is required")
+	}
+	if sourceConfig.HostnameLabel == "" {
+		sourceConfig.HostnameLabel = addressLabel
+	}
+	if sourceConfig.TimeoutSeconds == 0 {
+		sourceConfig.TimeoutSeconds = 30
+	}
+	return &prometheusSDSource{
+		config: sourceConfig,
+		state:  state,
+		httpClient: &http.Client{
+			Timeout: time.Duration(sourceConfig.TimeoutSeconds) * time.Second,
+		},
+		resolver: net.DefaultResolver,
+		logger:   log.MustNewLogger().Named("prometheus_sd_source"),
+	}, nil
+}
+
+func (s *prometheusSDSource) Endpoints(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	groups := make([]TargetGroup, 0)
+	if s.config.URL != "" {
+		httpGroups, err := s.getHTTPTargetGroups(ctx)
+		if err != nil {
+			newErr := fmt.Errorf("could not get target groups from %s: %w", s.config.URL, err)
+			s.logger.Error(newErr.Error())
+			return nil, newErr
+		}
+		groups = append(groups, httpGroups...)
+	}
+	fileGroups, err := s.readFileTargetGroups()
+	if err != nil {
+		newErr := fmt.Errorf("could not read target groups: %w", err)
+		s.logger.Error(newErr.Error())
+		return nil, newErr
+	}
+	groups = append(groups, fileGroups...)
+
+	endpoints := make([]*endpoint.Endpoint, 0)
+	byHostname := map[string]*endpoint.Endpoint{}
+	for _, group := range groups {
+		for _, target := range group.Targets {
+			labels := make(map[string]string, len(group.Labels)+1)
+			for k, v := range group.Labels {
+				labels[k] = v
+			}
+			labels[addressLabel] = target
+			err := s.addTarget(ctx, labels, &endpoints, byHostname)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return endpoints, nil
+}
+
+// addTarget adds the target with the given labels to the endpoint for its
+// hostname, creating the endpoint if it doesn't exist yet.
+func (s *prometheusSDSource) addTarget(
+	ctx context.Context,
+	labels map[string]string,
+	endpoints *[]*endpoint.Endpoint,
+	byHostname map[string]*endpoint.Endpoint,
+) error {
+	target := labels[addressLabel]
+	logger := s.logger.With(zap.String("target", target))
+
+	hostname, err := s.hostname(labels)
+	if err != nil {
+		logger.Error("error getting hostname", zap.Error(err))
+		return err
+	}
+	if hostname == "" {
+		logger.Debug("skipping target without hostname")
+		return nil
+	}
+
+	addrs, err := s.addresses(ctx, hostPart(target))
+	if err != nil {
+		logger.Warn("skipping target with unresolvable address", zap.Error(err))
+		return nil
+	}
+	if len(addrs) == 0 {
+		logger.Debug("skipping target without an IP address")
+		return nil
+	}
+
+	e, ok := byHostname[hostname]
+	if !ok {
+		e = &endpoint.Endpoint{
+			Hostname:  hostname,
+			IPv4s:     []string{},
+			IPv6s:     []string{},
+			RecordTTL: s.config.RecordTTL,
+			SourceProperties: map[string]any{
+				"prometheus_sd_targets": []string{},
+				"prometheus_sd_labels":  labels,
+			},
+		}
+		byHostname[hostname] = e
+		*endpoints = append(*endpoints, e)
+	}
+	targets := e.SourceProperties["prometheus_sd_targets"].([]string)
+	if !slices.Contains(targets, target) {
+		e.SourceProperties["prometheus_sd_targets"] = append(targets, target)
+	}
+	for _, addr := range addrs {
+		ip := addr.String()
+		if addr.Is4() {
+			if !slices.Contains(e.IPv4s, ip) {
+				e.IPv4s = append(e.IPv4s, ip)
+			}
+		} else if !slices.Contains(e.IPv6s, ip) {
+			e.IPv6s = append(e.IPv6s, ip)
+		}
+	}
+	return nil
+}
+
+// hostname picks the hostname for a target, either with the Lua hook or from
+// HostnameLabel.
+func (s *prometheusSDSource) hostname(labels map[string]string) (string, error) {
+	var hostname string
+	if s.config.Hostname != nil {
+		labelsLt := s.state.NewTable()
+		for k, v := range labels {
+			labelsLt.RawSetString(k, lua.LString(v))
+		}
+		co, _ := s.state.NewThread()
+		for {
+			st, err, values := s.state.Resume(co, s.config.Hostname, labelsLt)
+
+			if st == lua.ResumeError {
+				return "", fmt.Errorf("hostname function lua.ResumeError: %w", err)
+			}
+
+			for _, lv := range values {
+				if r, ok := lv.(lua.LString); ok {
+					hostname = string(r)
+				}
+			}
+
+			if st == lua.ResumeOK {
+				break
+			}
+		}
+	} else {
+		hostname = hostPart(labels[s.config.HostnameLabel])
+		if _, err := netip.ParseAddr(hostname); err == nil {
+			return "", nil
+		}
+	}
+	hostname = strings.TrimSuffix(hostname, ".")
+	if s.config.StripDomain != "" {
+		hostname = strings.TrimSuffix(hostname, "."+strings.Trim(s.config.StripDomain, "."))
+	}
+	return hostname, nil
+}
+
+// addresses returns the IP addresses of the target host, resolving it first
+// if it's a name and Resolve is enabled.
+func (s *prometheusSDSource) addresses(ctx context.Context, host string) ([]netip.Addr, error) {
+	addr, err := netip.ParseAddr(host)
+	if err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+	if !s.config.Resolve || host == "" {
+		return nil, nil
+	}
+	addrs, err := s.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs, nil
+}
+
+// hostPart removes the port from a host:port target, handling bracketed IPv6
+// addresses and targets without a port.
+func hostPart(target string) string {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return strings.Trim(target, "[]")
+	}
+	return host
+}
+
+func (s *prometheusSDSource) getHTTPTargetGroups(ctx context.Context) ([]TargetGroup, error) {
+	s.logger.Info("Getting target groups", zap.String("url", s.config.URL))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range s.config.Headers {
+		req.Header.Set(k, v)
+	}
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("unexpected status code %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var groups []TargetGroup
+	err = json.NewDecoder(res.Body).Decode(&groups)
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (s *prometheusSDSource) readFileTargetGroups() ([]TargetGroup, error) {
+	filenames := make([]string, 0)
+	for _, pattern := range s.config.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			s.logger.Warn("no files match pattern", zap.String("pattern", pattern))
+		}
+		sort.Strings(matches)
+		filenames = append(filenames, matches...)
+	}
+	groups := make([]TargetGroup, 0)
+	for _, filename := range filenames {
+		s.logger.Debug("Reading target groups", zap.String("filename", filename))
+		fileGroups, err := readTargetGroupFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		groups = append(groups, fileGroups...)
+	}
+	return groups, nil
+}
+
+func readTargetGroupFile(filename string) ([]TargetGroup, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var groups []TargetGroup
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &groups)
+	default:
+		err = json.Unmarshal(data, &groups)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
@@ -0,0 +1,190 @@
+package prometheussd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+const fileSDJSON = `[
+  {
+    "targets": ["node1.example.com:9100", "node1.example.com:9256", "192.0.2.20:9100"],
+    "labels": {"job": "node", "__meta_datacenter": "dc1"}
+  },
+  {
+    "targets": ["[2001:db8::30]:9100", "node3.example.com:9100"],
+    "labels": {"job": "node", "__meta_hostname": "node3"}
+  }
+]`
+
+const fileSDYAML = `- targets:
+    - 192.0.2.40:9100
+  labels:
+    job: blackbox
+    __meta_hostname: probe
+`
+
+func writeFile(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+	filename := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0o644))
+	return filename
+}
+
+func newTestSource(t *testing.T, state *lua.LState, config PrometheusSDSourceConfig) *prometheusSDSource {
+	t.Helper()
+	s, err := NewPrometheusSDSource(state, config)
+	require.NoError(t, err)
+	return s.(*prometheusSDSource)
+}
+
+func hostnames(endpoints []*endpoint.Endpoint) []string {
+	result := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		result = append(result, e.Hostname)
+	}
+	return result
+}
+
+func TestEndpoints_HostnameLabel(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "a.json", fileSDJSON)
+	writeFile(t, dir, "b.yml", fileSDYAML)
+
+	s := newTestSource(t, nil, PrometheusSDSourceConfig{
+		Files:         []string{filepath.Join(dir, "*")},
+		HostnameLabel: "__meta_hostname",
+		RecordTTL:     60,
+	})
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+
+	// node3 has a name target and Resolve is off, so only the IPv6 target
+	// makes it in
+	expected := []*endpoint.Endpoint{
+		{
+			Hostname:  "node3",
+			IPv4s:     []string{},
+			IPv6s:     []string{"2001:db8::30"},
+			RecordTTL: 60,
+			SourceProperties: map[string]any{
+				"prometheus_sd_targets": []string{"[2001:db8::30]:9100"},
+				"prometheus_sd_labels": map[string]string{
+					"__address__":     "[2001:db8::30]:9100",
+					"__meta_hostname": "node3",
+					"job":             "node",
+				},
+			},
+		},
+		{
+			Hostname:  "probe",
+			IPv4s:     []string{"192.0.2.40"},
+			IPv6s:     []string{},
+			RecordTTL: 60,
+			SourceProperties: map[string]any{
+				"prometheus_sd_targets": []string{"192.0.2.40:9100"},
+				"prometheus_sd_labels": map[string]string{
+					"__address__":     "192.0.2.40:9100",
+					"__meta_hostname": "probe",
+					"job":             "blackbox",
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(expected, endpoints); diff != "" {
+		t.Errorf("Endpoints() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEndpoints_Resolve(t *testing.T) {
+	dir := t.TempDir()
+	filename := writeFile(t, dir, "targets.json", `[
+  {"targets": ["localhost:9100", "localhost:9256", "192.0.2.20:9100"]}
+]`)
+
+	s := newTestSource(t, nil, PrometheusSDSourceConfig{
+		Files:   []string{filename},
+		Resolve: true,
+	})
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	// 192.0.2.20 has no name to use as the hostname
+	require.Len(t, endpoints, 1)
+	assert.Equal(t, "localhost", endpoints[0].Hostname)
+	assert.Contains(t, append(endpoints[0].IPv4s, endpoints[0].IPv6s...), "127.0.0.1")
+	assert.Equal(t, []string{"localhost:9100", "localhost:9256"}, endpoints[0].SourceProperties["prometheus_sd_targets"])
+}
+
+func TestEndpoints_HostnameFunction(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(fileSDJSON))
+	}))
+	defer server.Close()
+
+	state := lua.NewState()
+	defer state.Close()
+	err := state.DoString(`
+		return function(labels)
+			if labels.__meta_datacenter == nil then
+				return nil
+			end
+			local host = string.match(labels.__address__, "^([^:]+):")
+			return string.gsub(host, "%.", "-") .. "." .. labels.__meta_datacenter .. ".example.com"
+		end
+	`)
+	require.NoError(t, err)
+
+	s := newTestSource(t, state, PrometheusSDSourceConfig{
+		URL:         server.URL,
+		Headers:     map[string]string{"Authorization": "Bearer secret"},
+		Hostname:    state.Get(-1).(*lua.LFunction),
+		StripDomain: "example.com",
+	})
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	// node1.example.com isn't resolved, so only the IP target is left
+	assert.Equal(t, []string{"192-0-2-20.dc1"}, hostnames(endpoints))
+	assert.Equal(t, []string{"192.0.2.20"}, endpoints[0].IPv4s)
+}
+
+func TestEndpoints_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	s := newTestSource(t, nil, PrometheusSDSourceConfig{URL: server.URL})
+	_, err := s.Endpoints(context.Background())
+	assert.ErrorContains(t, err, "403")
+
+	filename := writeFile(t, t.TempDir(), "targets.json", `{"targets": []}`)
+	s = newTestSource(t, nil, PrometheusSDSourceConfig{Files: []string{filename}})
+	_, err = s.Endpoints(context.Background())
+	assert.Error(t, err)
+
+	state := lua.NewState()
+	defer state.Close()
+	require.NoError(t, state.DoString(`return function(labels) error("boom") end`))
+	filename = writeFile(t, t.TempDir(), "targets.json", fileSDJSON)
+	s = newTestSource(t, state, PrometheusSDSourceConfig{
+		Files:    []string{filename},
+		Hostname: state.Get(-1).(*lua.LFunction),
+	})
+	_, err = s.Endpoints(context.Background())
+	assert.ErrorContains(t, err, "boom")
+
+	_, err = NewPrometheusSDSource(nil, PrometheusSDSourceConfig{})
+	assert.Error(t, err)
+}
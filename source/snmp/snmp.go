@@ -0,0 +1,547 @@
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/source"
+)
+
+const (
+	// IP-MIB::ipNetToPhysicalPhysAddress
+	oidIPNetToPhysicalPhysAddress = ".1.3.6.1.2.1.4.35.1.4"
+	// IP-MIB::ipNetToPhysicalType
+	oidIPNetToPhysicalType = ".1.3.6.1.2.1.4.35.1.6"
+	// IP-MIB::ipNetToMediaPhysAddress (deprecated, IPv4 only)
+	oidIPNetToMediaPhysAddress = ".1.3.6.1.2.1.4.22.1.2"
+	// IP-MIB::ipNetToMediaType (deprecated, IPv4 only)
+	oidIPNetToMediaType = ".1.3.6.1.2.1.4.22.1.4"
+	// IF-MIB::ifName
+	oidIfName = ".1.3.6.1.2.1.31.1.1.1.1"
+
+	// ipNetToPhysicalType and ipNetToMediaType value for invalidated entries
+	netToPhysicalTypeInvalid = 2
+
+	// InetAddressType values
+	inetAddressTypeIPv4 = 1
+	inetAddressTypeIPv6 = 2
+)
+
+type SNMPSourceConfig struct {
+	// Hostname or address of the SNMP agent, optionally with a port
+	Host string
+	// SNMP version, either "2c" (default) or "3"
+	Version string
+	// Community string for SNMPv2c (default "public")
+	Community string
+	// SNMPv3 user name
+	Username string
+	// SNMPv3 authentication protocol: MD5, SHA, SHA224, SHA256, SHA384 or
+	// SHA512
+	AuthProtocol   string
+	AuthPassphrase string
+	// SNMPv3 privacy protocol: DES, AES, AES192, AES256, AES192C or AES256C
+	PrivProtocol   string
+	PrivPassphrase string
+	// SNMPv3 context name
+	ContextName    string
+	TimeoutSeconds int
+	Retries        int
+	// Static map of MAC address to hostname
+	Hostnames map[string]string
+	// Names of other sources to get hostnames from by matching the
+	// hardware_address source property
+	NameSources          []string
+	CollectIPv6Addresses bool
+	// Keep link-local addresses (169.254.0.0/16 and fe80::/10)
+	IncludeLinkLocal bool
+	// Don't create endpoints for MAC addresses without a known hostname
+	SkipUnnamed bool
+	RecordTTL   int64
+}
+
+// Walker is the subset of [github.com/gosnmp/gosnmp.GoSNMP] used for walking
+// tables.
+type Walker interface {
+	Connect() error
+	BulkWalkAll(rootOid string) ([]gosnmp.SnmpPDU, error)
+}
+
+// Neighbor is a single IP to MAC address mapping from the agent's neighbor
+// table.
+type Neighbor struct {
+	IfIndex         int
+	Address         netip.Addr
+	HardwareAddress string
+}
+
+type snmpSource struct {
+	config      SNMPSourceConfig
+	logger      *zap.Logger
+	hostnames   map[string]string
+	nameSources []source.NamedSource
+	newWalker   func() (Walker, func() error, error)
+}
+
+func NewSNMPSource(sourceConfig SNMPSourceConfig) (source.Source, error) {
+	if sourceConfig.Host == "" {
+		return nil, fmt.Errorf("host is required")
+	}
+	if sourceConfig.Version == "" {
+		sourceConfig.Version = "2c"
+	}
+	if sourceConfig.Community == "" {
+		sourceConfig.Community = "public"
+	}
+	if sourceConfig.TimeoutSeconds == 0 {
+		sourceConfig.TimeoutSeconds = 5
+	}
+	hostnames := map[string]string{}
+	for mac, hostname := range sourceConfig.Hostnames {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAC address %q in hostnames: %w", mac, err)
+		}
+		hostnames[hw.String()] = hostname
+	}
+	s := &snmpSource{
+		config:    sourceConfig,
+		hostnames: hostnames,
+		logger: log.MustNewLogger().Named("snmp_source").With(
+			zap.String("host", sourceConfig.Host),
+		),
+	}
+	// validate the client configuration early
+	_, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	s.newWalker = func() (Walker, func() error, error) {
+		client, err := s.client()
+		if err != nil {
+			return nil, nil, err
+		}
+		return client, func() error { return client.Conn.Close() }, nil
+	}
+	return s, nil
+}
+
+func (s *snmpSource) client() (*gosnmp.GoSNMP, error) {
+	host := s.config.Host
+	port := uint16(161)
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		pn, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in host %q: %w", host, err)
+		}
+		host = h
+		port = uint16(pn)
+	}
+	client := &gosnmp.GoSNMP{
+		Target:             host,
+		Port:               port,
+		Transport:          "udp",
+		Community:          s.config.Community,
+		Timeout:            time.Duration(s.config.TimeoutSeconds) * time.Second,
+		Retries:            s.config.Retries,
+		ExponentialTimeout: true,
+		MaxOids:            gosnmp.MaxOids,
+		ContextName:        s.config.ContextName,
+	}
+	switch s.config.Version {
+	case "2c", "2", "v2c":
+		client.Version = gosnmp.Version2c
+	case "3", "v3":
+		client.Version = gosnmp.Version3
+		client.SecurityModel = gosnmp.UserSecurityModel
+		params := &gosnmp.UsmSecurityParameters{
+			UserName:                 s.config.Username,
+			AuthenticationPassphrase: s.config.AuthPassphrase,
+			PrivacyPassphrase:        s.config.PrivPassphrase,
+		}
+		client.MsgFlags = gosnmp.NoAuthNoPriv
+		if s.config.AuthProtocol != "" {
+			authProtocol, ok := authProtocols[strings.ToUpper(s.config.AuthProtocol)]
+			if !ok {
+				return nil, fmt.Errorf("unknown auth protocol %q", s.config.AuthProtocol)
+			}
+			params.AuthenticationProtocol = authProtocol
+			client.MsgFlags = gosnmp.AuthNoPriv
+		}
+		if s.config.PrivProtocol != "" {
+			if s.config.AuthProtocol == "" {
+				return nil, fmt.Errorf("priv protocol requires an auth protocol")
+			}
+			privProtocol, ok := privProtocols[strings.ToUpper(s.config.PrivProtocol)]
+			if !ok {
+				return nil, fmt.Errorf("unknown priv protocol %q", s.config.PrivProtocol)
+			}
+			params.PrivacyProtocol = privProtocol
+			client.MsgFlags = gosnmp.AuthPriv
+		}
+		client.SecurityParameters = params
+	default:
+		return nil, fmt.Errorf("unsupported SNMP version %q", s.config.Version)
+	}
+	return client, nil
+}
+
+var authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"MD5":    gosnmp.MD5,
+	"SHA":    gosnmp.SHA,
+	"SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256,
+	"SHA384": gosnmp.SHA384,
+	"SHA512": gosnmp.SHA512,
+}
+
+var privProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"DES":     gosnmp.DES,
+	"AES":     gosnmp.AES,
+	"AES192":  gosnmp.AES192,
+	"AES256":  gosnmp.AES256,
+	"AES192C": gosnmp.AES192C,
+	"AES256C": gosnmp.AES256C,
+}
+
+func (s *snmpSource) SourceDependencies() []string {
+	return s.config.NameSources
+}
+
+func (s *snmpSource) SetSourceDependencies(sources []source.NamedSource) {
+	s.nameSources = sources
+}
+
+func (s *snmpSource) Endpoints(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	return s.EndpointsWithDependencies(ctx, nil)
+}
+
+// EndpointsWithDependencies gets hostnames from the given endpoints of the
+// name sources. Name sources missing from dependencies are queried.
+func (s *snmpSource) EndpointsWithDependencies(ctx context.Context, dependencies map[string][]*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+	walker, disconnect, err := s.newWalker()
+	if err != nil {
+		return nil, err
+	}
+	err = walker.Connect()
+	if err != nil {
+		newErr := fmt.Errorf("could not connect to host %s: %w", s.config.Host, err)
+		s.logger.Error(newErr.Error())
+		return nil, newErr
+	}
+	defer func() {
+		err := disconnect()
+		if err != nil {
+			s.logger.Sugar().Errorf("error disconnecting from host %s: %v", s.config.Host, err)
+		}
+	}()
+
+	neighbors, err := s.getNeighbors(walker)
+	if err != nil {
+		newErr := fmt.Errorf("could not get neighbors: %w", err)
+		s.logger.Error(newErr.Error())
+		return nil, newErr
+	}
+	ifNames := s.getIfNames(walker)
+
+	hostnames, err := s.resolveHostnames(ctx, dependencies)
+	if err != nil {
+		newErr := fmt.Errorf("could not get hostnames from name sources: %w", err)
+		s.logger.Error(newErr.Error())
+		return nil, newErr
+	}
+
+	return s.neighborsToEndpoints(neighbors, ifNames, hostnames), nil
+}
+
+// resolveHostnames builds a MAC address to hostname map from the static
+// hostnames and name sources. Static hostnames take priority.
+func (s *snmpSource) resolveHostnames(ctx context.Context, dependencies map[string][]*endpoint.Endpoint) (map[string]string, error) {
+	hostnames := map[string]string{}
+	for _, ns := range s.nameSources {
+		endpoints, ok := dependencies[ns.Name]
+		if !ok {
+			var err error
+			endpoints, err = ns.Source.Endpoints(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("source %s: %w", ns.Name, err)
+			}
+		}
+		for _, e := range endpoints {
+			if e.Hostname == "" || e.SourceProperties == nil {
+				continue
+			}
+			rawMAC, ok := e.SourceProperties["hardware_address"].(string)
+			if !ok {
+				continue
+			}
+			hw, err := net.ParseMAC(rawMAC)
+			if err != nil {
+				continue
+			}
+			if _, exists := hostnames[hw.String()]; !exists {
+				hostnames[hw.String()] = e.Hostname
+			}
+		}
+	}
+	for mac, hostname := range s.hostnames {
+		hostnames[mac] = hostname
+	}
+	return hostnames, nil
+}
+
+func (s *snmpSource) getNeighbors(walker Walker) ([]*Neighbor, error) {
+	s.logger.Info("Walking ipNetToPhysicalTable")
+	neighbors, err := s.walkNetToPhysical(walker)
+	if err != nil {
+		return nil, err
+	}
+	if len(neighbors) == 0 {
+		s.logger.Info("ipNetToPhysicalTable is empty, falling back to ipNetToMediaTable")
+		return s.walkNetToMedia(walker)
+	}
+	return neighbors, nil
+}
+
+// invalidIndexes walks a type column and returns the indexes of all invalid
+// entries.
+func invalidIndexes(walker Walker, oid string) (map[string]bool, error) {
+	pdus, err := walker.BulkWalkAll(oid)
+	if err != nil {
+		return nil, err
+	}
+	invalid := map[string]bool{}
+	for _, pdu := range pdus {
+		if gosnmp.ToBigInt(pdu.Value).Int64() == netToPhysicalTypeInvalid {
+			invalid[strings.TrimPrefix(pdu.Name, oid+".")] = true
+		}
+	}
+	return invalid, nil
+}
+
+func (s *snmpSource) walkNetToPhysical(walker Walker) ([]*Neighbor, error) {
+	pdus, err := walker.BulkWalkAll(oidIPNetToPhysicalPhysAddress)
+	if err != nil {
+		return nil, err
+	}
+	invalid, err := invalidIndexes(walker, oidIPNetToPhysicalType)
+	if err != nil {
+		return nil, err
+	}
+	neighbors := make([]*Neighbor, 0, len(pdus))
+	for _, pdu := range pdus {
+		index := strings.TrimPrefix(pdu.Name, oidIPNetToPhysicalPhysAddress+".")
+		if invalid[index] {
+			continue
+		}
+		neighbor, err := parseNetToPhysicalIndex(index)
+		if err != nil {
+			s.logger.Sugar().Warnf("could not parse ipNetToPhysicalTable index %q: %v", index, err)
+			continue
+		}
+		neighbor.HardwareAddress = hardwareAddress(pdu.Value)
+		neighbors = append(neighbors, neighbor)
+	}
+	return neighbors, nil
+}
+
+func (s *snmpSource) walkNetToMedia(walker Walker) ([]*Neighbor, error) {
+	pdus, err := walker.BulkWalkAll(oidIPNetToMediaPhysAddress)
+	if err != nil {
+		return nil, err
+	}
+	invalid, err := invalidIndexes(walker, oidIPNetToMediaType)
+	if err != nil {
+		return nil, err
+	}
+	neighbors := make([]*Neighbor, 0, len(pdus))
+	for _, pdu := range pdus {
+		index := strings.TrimPrefix(pdu.Name, oidIPNetToMediaPhysAddress+".")
+		if invalid[index] {
+			continue
+		}
+		neighbor, err := parseNetToMediaIndex(index)
+		if err != nil {
+			s.logger.Sugar().Warnf("could not parse ipNetToMediaTable index %q: %v", index, err)
+			continue
+		}
+		neighbor.HardwareAddress = hardwareAddress(pdu.Value)
+		neighbors = append(neighbors, neighbor)
+	}
+	return neighbors, nil
+}
+
+func (s *snmpSource) getIfNames(walker Walker) map[int]string {
+	ifNames := map[int]string{}
+	pdus, err := walker.BulkWalkAll(oidIfName)
+	if err != nil {
+		// ifName is nice to have, but not required
+		s.logger.Warn("could not walk ifName", zap.Error(err))
+		return ifNames
+	}
+	for _, pdu := range pdus {
+		ifIndex, err := strconv.Atoi(strings.TrimPrefix(pdu.Name, oidIfName+"."))
+		if err != nil {
+			continue
+		}
+		if b, ok := pdu.Value.([]byte); ok {
+			ifNames[ifIndex] = string(b)
+		}
+	}
+	return ifNames
+}
+
+func hardwareAddress(value any) string {
+	b, ok := value.([]byte)
+	if !ok || len(b) == 0 {
+		return ""
+	}
+	allZero := true
+	for _, octet := range b {
+		if octet != 0 {
+			allZero = false
+			break
+		}
+	}
+	if allZero {
+		return ""
+	}
+	return net.HardwareAddr(b).String()
+}
+
+func parseOIDIndex(index string) ([]int, error) {
+	parts := strings.Split(index, ".")
+	result := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = n
+	}
+	return result, nil
+}
+
+func bytesFromOIDIndex(parts []int) ([]byte, error) {
+	b := make([]byte, len(parts))
+	for i, part := range parts {
+		if part < 0 || part > 255 {
+			return nil, fmt.Errorf("invalid octet %d", part)
+		}
+		b[i] = byte(part)
+	}
+	return b, nil
+}
+
+// parseNetToPhysicalIndex parses an ipNetToPhysicalTable index, which is
+// ifIndex.addressType.addressLength.address...
+func parseNetToPhysicalIndex(index string) (*Neighbor, error) {
+	parts, err := parseOIDIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("index too short")
+	}
+	ifIndex, addrType, addrLen := parts[0], parts[1], parts[2]
+	if len(parts) != 3+addrLen {
+		return nil, fmt.Errorf("address length %d does not match index", addrLen)
+	}
+	b, err := bytesFromOIDIndex(parts[3:])
+	if err != nil {
+		return nil, err
+	}
+	var addr netip.Addr
+	switch {
+	case addrType == inetAddressTypeIPv4 && addrLen == 4:
+		addr = netip.AddrFrom4([4]byte(b))
+	case addrType == inetAddressTypeIPv6 && addrLen == 16:
+		addr = netip.AddrFrom16([16]byte(b))
+	default:
+		return nil, fmt.Errorf("unsupported address type %d with length %d", addrType, addrLen)
+	}
+	return &Neighbor{IfIndex: ifIndex, Address: addr}, nil
+}
+
+// parseNetToMediaIndex parses an ipNetToMediaTable index, which is
+// ifIndex.a.b.c.d
+func parseNetToMediaIndex(index string) (*Neighbor, error) {
+	parts, err := parseOIDIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("unexpected index length %d", len(parts))
+	}
+	b, err := bytesFromOIDIndex(parts[1:])
+	if err != nil {
+		return nil, err
+	}
+	return &Neighbor{IfIndex: parts[0], Address: netip.AddrFrom4([4]byte(b))}, nil
+}
+
+func (s *snmpSource) neighborsToEndpoints(neighbors []*Neighbor, ifNames map[int]string, hostnames map[string]string) []*endpoint.Endpoint {
+	endpointsByMAC := map[string]*endpoint.Endpoint{}
+	macs := make([]string, 0)
+	for _, neighbor := range neighbors {
+		if neighbor.HardwareAddress == "" {
+			continue
+		}
+		addr := neighbor.Address
+		if !s.config.IncludeLinkLocal && (addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast()) {
+			continue
+		}
+		if addr.Is6() && !s.config.CollectIPv6Addresses {
+			continue
+		}
+		hostname := hostnames[neighbor.HardwareAddress]
+		if hostname == "" && s.config.SkipUnnamed {
+			continue
+		}
+		e, ok := endpointsByMAC[neighbor.HardwareAddress]
+		if !ok {
+			var ipv6s []string
+			if s.config.CollectIPv6Addresses {
+				ipv6s = make([]string, 0)
+			}
+			e = &endpoint.Endpoint{
+				Hostname:  hostname,
+				IPv4s:     make([]string, 0),
+				IPv6s:     ipv6s,
+				RecordTTL: s.config.RecordTTL,
+				SourceProperties: map[string]any{
+					"hardware_address": neighbor.HardwareAddress,
+					"snmp_host":        s.config.Host,
+					"snmp_interface":   ifNames[neighbor.IfIndex],
+				},
+			}
+			endpointsByMAC[neighbor.HardwareAddress] = e
+			macs = append(macs, neighbor.HardwareAddress)
+		}
+		if addr.Is4() {
+			if !slices.Contains(e.IPv4s, addr.String()) {
+				e.IPv4s = append(e.IPv4s, addr.String())
+			}
+		} else {
+			if !slices.Contains(e.IPv6s, addr.String()) {
+				e.IPv6s = append(e.IPv6s, addr.String())
+			}
+		}
+	}
+	endpoints := make([]*endpoint.Endpoint, 0, len(macs))
+	for _, mac := range macs {
+		endpoints = append(endpoints, endpointsByMAC[mac])
+	}
+	return endpoints
+}
@@ -0,0 +1,475 @@
+package snmp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gosnmp/gosnmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/source"
+)
+
+type fakeWalker struct {
+	pdus []gosnmp.SnmpPDU
+	err  map[string]error
+}
+
+func (w *fakeWalker) Connect() error {
+	return nil
+}
+
+func (w *fakeWalker) BulkWalkAll(rootOid string) ([]gosnmp.SnmpPDU, error) {
+	if err, ok := w.err[rootOid]; ok {
+		return nil, err
+	}
+	result := []gosnmp.SnmpPDU{}
+	for _, pdu := range w.pdus {
+		if strings.HasPrefix(pdu.Name, rootOid+".") {
+			result = append(result, pdu)
+		}
+	}
+	return result, nil
+}
+
+func octets(name string, value []byte) gosnmp.SnmpPDU {
+	return gosnmp.SnmpPDU{Name: name, Type: gosnmp.OctetString, Value: value}
+}
+
+func integer(name string, value int) gosnmp.SnmpPDU {
+	return gosnmp.SnmpPDU{Name: name, Type: gosnmp.Integer, Value: value}
+}
+
+var netToPhysicalPDUs = []gosnmp.SnmpPDU{
+	octets(oidIPNetToPhysicalPhysAddress+".2.1.4.192.0.2.10", []byte{0x00, 0x53, 0x00, 0x00, 0x00, 0x01}),
+	octets(oidIPNetToPhysicalPhysAddress+".2.1.4.192.0.2.11", []byte{0x00, 0x53, 0x00, 0x00, 0x00, 0x02}),
+	octets(oidIPNetToPhysicalPhysAddress+".2.1.4.192.0.2.12", []byte{0x00, 0x53, 0x00, 0x00, 0x00, 0x03}),
+	octets(oidIPNetToPhysicalPhysAddress+".2.1.4.169.254.0.1", []byte{0x00, 0x53, 0x00, 0x00, 0x00, 0x01}),
+	octets(oidIPNetToPhysicalPhysAddress+".2.1.4.192.0.2.13", []byte{0, 0, 0, 0, 0, 0}),
+	octets(oidIPNetToPhysicalPhysAddress+".3.2.16.32.1.13.184.0.0.0.0.0.0.0.0.0.0.0.16", []byte{0x00, 0x53, 0x00, 0x00, 0x00, 0x01}),
+	octets(oidIPNetToPhysicalPhysAddress+".3.2.16.254.128.0.0.0.0.0.0.0.0.0.0.0.0.0.16", []byte{0x00, 0x53, 0x00, 0x00, 0x00, 0x01}),
+	integer(oidIPNetToPhysicalType+".2.1.4.192.0.2.10", 3),
+	integer(oidIPNetToPhysicalType+".2.1.4.192.0.2.11", 4),
+	integer(oidIPNetToPhysicalType+".2.1.4.192.0.2.12", netToPhysicalTypeInvalid),
+	integer(oidIPNetToPhysicalType+".2.1.4.169.254.0.1", 3),
+	integer(oidIPNetToPhysicalType+".2.1.4.192.0.2.13", 3),
+	integer(oidIPNetToPhysicalType+".3.2.16.32.1.13.184.0.0.0.0.0.0.0.0.0.0.0.16", 3),
+	integer(oidIPNetToPhysicalType+".3.2.16.254.128.0.0.0.0.0.0.0.0.0.0.0.0.0.16", 3),
+	octets(oidIfName+".2", []byte("eth0")),
+	octets(oidIfName+".3", []byte("eth1")),
+}
+
+type staticSource struct {
+	endpoints []*endpoint.Endpoint
+}
+
+func (s *staticSource) Endpoints(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	return s.endpoints, nil
+}
+
+func newTestSNMPSource(t *testing.T, config SNMPSourceConfig, walker Walker) *snmpSource {
+	t.Helper()
+	if config.Host == "" {
+		config.Host = "192.0.2.1"
+	}
+	s, err := NewSNMPSource(config)
+	require.NoError(t, err)
+	ss := s.(*snmpSource)
+	ss.logger = zap.NewNop()
+	ss.newWalker = func() (Walker, func() error, error) {
+		return walker, func() error { return nil }, nil
+	}
+	return ss
+}
+
+// agentEngineID is the authoritative engine ID of the test agent
+const agentEngineID = "\x80\x00\x1f\x88\x04zonepop"
+
+// startAgent starts an in-process SNMP agent on a random local UDP port that
+// answers GetBulk requests from pdus, so the real client and PDU decoding are
+// exercised. usm is the SNMPv3 user, or nil for v2c with the given
+// community. It returns the agent's address.
+func startAgent(t *testing.T, pdus []gosnmp.SnmpPDU, community string, usm *gosnmp.UsmSecurityParameters) string {
+	t.Helper()
+	sorted := slices.Clone(pdus)
+	slices.SortFunc(sorted, func(a, b gosnmp.SnmpPDU) int {
+		return compareOIDs(a.Name, b.Name)
+	})
+	decoder := &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+	if usm != nil {
+		decoder = &gosnmp.GoSNMP{
+			Version:            gosnmp.Version3,
+			SecurityModel:      gosnmp.UserSecurityModel,
+			MsgFlags:           gosnmp.AuthPriv,
+			SecurityParameters: usm.Copy(),
+		}
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request, err := decoder.SnmpDecodePacket(slices.Clone(buf[:n]))
+			if err != nil {
+				t.Errorf("agent could not decode request: %v", err)
+				continue
+			}
+			response := agentResponse(t, request, sorted, community, usm)
+			if response == nil {
+				continue
+			}
+			b, err := response.MarshalMsg()
+			if err != nil {
+				t.Errorf("agent could not marshal response: %v", err)
+				continue
+			}
+			conn.WriteTo(b, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func agentResponse(t *testing.T, request *gosnmp.SnmpPacket, pdus []gosnmp.SnmpPDU, community string, usm *gosnmp.UsmSecurityParameters) *gosnmp.SnmpPacket {
+	response := &gosnmp.SnmpPacket{
+		Version:   request.Version,
+		Community: request.Community,
+		MsgID:     request.MsgID,
+		PDUType:   gosnmp.GetResponse,
+		RequestID: request.RequestID,
+	}
+	if usm == nil {
+		if request.Community != community {
+			return nil
+		}
+	} else {
+		requestUSM := request.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+		sp := usm.Copy().(*gosnmp.UsmSecurityParameters)
+		sp.AuthoritativeEngineID = agentEngineID
+		sp.AuthoritativeEngineBoots = 1
+		sp.AuthoritativeEngineTime = 1
+		response.SecurityModel = gosnmp.UserSecurityModel
+		response.SecurityParameters = sp
+		response.ContextEngineID = agentEngineID
+		response.ContextName = request.ContextName
+		response.MsgFlags = request.MsgFlags &^ gosnmp.Reportable
+		if requestUSM.AuthoritativeEngineID == "" {
+			// engine discovery as per RFC 3414 section 4
+			sp.UserName = ""
+			response.MsgFlags = gosnmp.NoAuthNoPriv
+			response.PDUType = gosnmp.Report
+			response.Variables = []gosnmp.SnmpPDU{
+				{Name: ".1.3.6.1.6.3.15.1.1.4.0", Type: gosnmp.Counter32, Value: uint32(1)},
+			}
+			return response
+		}
+		require.NoError(t, sp.InitSecurityKeys())
+		require.NoError(t, sp.InitPacket(response))
+	}
+
+	switch request.PDUType {
+	case gosnmp.GetBulkRequest:
+		start := request.Variables[0].Name
+		for _, pdu := range pdus {
+			if len(response.Variables) == int(request.MaxRepetitions) {
+				break
+			}
+			if compareOIDs(pdu.Name, start) > 0 {
+				response.Variables = append(response.Variables, pdu)
+			}
+		}
+		if len(response.Variables) < int(request.MaxRepetitions) {
+			response.Variables = append(response.Variables, gosnmp.SnmpPDU{Name: start, Type: gosnmp.EndOfMibView})
+		}
+	case gosnmp.GetRequest:
+		for _, v := range request.Variables {
+			response.Variables = append(response.Variables, gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.NoSuchObject})
+		}
+	default:
+		t.Errorf("agent got unexpected PDU type %s", request.PDUType)
+		return nil
+	}
+	return response
+}
+
+func compareOIDs(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "."), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "."), ".")
+	for i := range min(len(as), len(bs)) {
+		an, _ := strconv.Atoi(as[i])
+		bn, _ := strconv.Atoi(bs[i])
+		if an != bn {
+			return an - bn
+		}
+	}
+	return len(as) - len(bs)
+}
+
+func TestEndpoints(t *testing.T) {
+	s := newTestSNMPSource(t, SNMPSourceConfig{
+		Hostnames: map[string]string{
+			"00-53-00-00-00-01": "router",
+		},
+		NameSources:          []string{"dhcp"},
+		CollectIPv6Addresses: true,
+		RecordTTL:            60,
+	}, &fakeWalker{pdus: netToPhysicalPDUs})
+	s.SetSourceDependencies([]source.NamedSource{
+		{
+			Name: "dhcp",
+			Source: &staticSource{endpoints: []*endpoint.Endpoint{
+				{
+					Hostname: "not-router",
+					SourceProperties: map[string]any{
+						"hardware_address": "00:53:00:00:00:01",
+					},
+				},
+				{
+					Hostname: "laptop",
+					SourceProperties: map[string]any{
+						"hardware_address": "00:53:00:00:00:02",
+					},
+				},
+			}},
+		},
+	})
+	assert.Equal(t, []string{"dhcp"}, s.SourceDependencies())
+
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+
+	expected := []*endpoint.Endpoint{
+		{
+			Hostname:  "router",
+			IPv4s:     []string{"192.0.2.10"},
+			IPv6s:     []string{"2001:db8::10"},
+			RecordTTL: 60,
+			SourceProperties: map[string]any{
+				"hardware_address": "00:53:00:00:00:01",
+				"snmp_host":        "192.0.2.1",
+				"snmp_interface":   "eth0",
+			},
+		},
+		{
+			Hostname:  "laptop",
+			IPv4s:     []string{"192.0.2.11"},
+			IPv6s:     []string{},
+			RecordTTL: 60,
+			SourceProperties: map[string]any{
+				"hardware_address": "00:53:00:00:00:02",
+				"snmp_host":        "192.0.2.1",
+				"snmp_interface":   "eth0",
+			},
+		},
+	}
+	diff := cmp.Diff(expected, endpoints)
+	if diff != "" {
+		t.Fatalf("mismatch:\n%s", diff)
+	}
+}
+
+func TestEndpointsWithDependencies(t *testing.T) {
+	s := newTestSNMPSource(t, SNMPSourceConfig{
+		NameSources: []string{"dhcp"},
+		SkipUnnamed: true,
+	}, &fakeWalker{pdus: netToPhysicalPDUs})
+	// the name source must not be queried again
+	s.SetSourceDependencies([]source.NamedSource{{Name: "dhcp", Source: nil}})
+
+	endpoints, err := s.EndpointsWithDependencies(context.Background(), map[string][]*endpoint.Endpoint{
+		"dhcp": {
+			{
+				Hostname: "laptop",
+				SourceProperties: map[string]any{
+					"hardware_address": "00:53:00:00:00:02",
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, endpoints, 1)
+	assert.Equal(t, "laptop", endpoints[0].Hostname)
+}
+
+func TestEndpoints_SkipUnnamed(t *testing.T) {
+	s := newTestSNMPSource(t, SNMPSourceConfig{
+		Hostnames: map[string]string{
+			"00:53:00:00:00:02": "laptop",
+		},
+		SkipUnnamed:      true,
+		IncludeLinkLocal: true,
+	}, &fakeWalker{pdus: netToPhysicalPDUs})
+
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	require.Len(t, endpoints, 1)
+	assert.Equal(t, "laptop", endpoints[0].Hostname)
+	assert.Nil(t, endpoints[0].IPv6s)
+}
+
+func TestEndpoints_IncludeLinkLocal(t *testing.T) {
+	s := newTestSNMPSource(t, SNMPSourceConfig{
+		IncludeLinkLocal:     true,
+		CollectIPv6Addresses: true,
+	}, &fakeWalker{pdus: netToPhysicalPDUs})
+
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	require.Len(t, endpoints, 2)
+	assert.Equal(t, []string{"192.0.2.10", "169.254.0.1"}, endpoints[0].IPv4s)
+	assert.Equal(t, []string{"2001:db8::10", "fe80::10"}, endpoints[0].IPv6s)
+}
+
+func TestEndpoints_NetToMediaFallback(t *testing.T) {
+	s := newTestSNMPSource(t, SNMPSourceConfig{}, &fakeWalker{pdus: []gosnmp.SnmpPDU{
+		octets(oidIPNetToMediaPhysAddress+".2.192.0.2.20", []byte{0x00, 0x53, 0x00, 0x00, 0x00, 0x20}),
+		octets(oidIPNetToMediaPhysAddress+".2.192.0.2.21", []byte{0x00, 0x53, 0x00, 0x00, 0x00, 0x21}),
+		integer(oidIPNetToMediaType+".2.192.0.2.20", 3),
+		integer(oidIPNetToMediaType+".2.192.0.2.21", netToPhysicalTypeInvalid),
+	}})
+
+	endpoints, err := s.Endpoints(context.Background())
+	require.NoError(t, err)
+	require.Len(t, endpoints, 1)
+	assert.Equal(t, []string{"192.0.2.20"}, endpoints[0].IPv4s)
+	assert.Equal(t, "00:53:00:00:00:20", endpoints[0].SourceProperties["hardware_address"])
+	// ifName is missing, which is fine
+	assert.Equal(t, "", endpoints[0].SourceProperties["snmp_interface"])
+}
+
+func TestEndpoints_WalkError(t *testing.T) {
+	s := newTestSNMPSource(t, SNMPSourceConfig{}, &fakeWalker{
+		pdus: netToPhysicalPDUs,
+		err: map[string]error{
+			oidIPNetToPhysicalPhysAddress: errors.New("request timeout"),
+		},
+	})
+
+	_, err := s.Endpoints(context.Background())
+	assert.ErrorContains(t, err, "request timeout")
+}
+
+func TestEndpoints_Agent(t *testing.T) {
+	tests := map[string]struct {
+		config SNMPSourceConfig
+		usm    *gosnmp.UsmSecurityParameters
+	}{
+		"v2c": {
+			config: SNMPSourceConfig{Community: "zonepop"},
+		},
+		"v3 authPriv": {
+			config: SNMPSourceConfig{
+				Version:        "3",
+				Username:       "zonepop",
+				AuthProtocol:   "SHA256",
+				AuthPassphrase: "authpassphrase",
+				PrivProtocol:   "AES",
+				PrivPassphrase: "privpassphrase",
+			},
+			usm: &gosnmp.UsmSecurityParameters{
+				UserName:                 "zonepop",
+				AuthenticationProtocol:   gosnmp.SHA256,
+				AuthenticationPassphrase: "authpassphrase",
+				PrivacyProtocol:          gosnmp.AES,
+				PrivacyPassphrase:        "privpassphrase",
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc.config.Host = startAgent(t, netToPhysicalPDUs, "zonepop", tc.usm)
+			tc.config.TimeoutSeconds = 2
+			s, err := NewSNMPSource(tc.config)
+			require.NoError(t, err)
+			s.(*snmpSource).logger = zap.NewNop()
+
+			endpoints, err := s.Endpoints(context.Background())
+			require.NoError(t, err)
+			require.Len(t, endpoints, 2)
+			assert.Equal(t, []string{"192.0.2.10"}, endpoints[0].IPv4s)
+			assert.Equal(t, "00:53:00:00:00:01", endpoints[0].SourceProperties["hardware_address"])
+			assert.Equal(t, "eth0", endpoints[0].SourceProperties["snmp_interface"])
+			assert.Equal(t, []string{"192.0.2.11"}, endpoints[1].IPv4s)
+		})
+	}
+}
+
+func TestNewSNMPSource(t *testing.T) {
+	tests := map[string]struct {
+		config      SNMPSourceConfig
+		expectErr   string
+		expectPort  uint16
+		expectFlags gosnmp.SnmpV3MsgFlags
+	}{
+		"v2c defaults": {
+			config:     SNMPSourceConfig{Host: "router.example.com"},
+			expectPort: 161,
+		},
+		"custom port": {
+			config:     SNMPSourceConfig{Host: "192.0.2.1:1161"},
+			expectPort: 1161,
+		},
+		"v3 noAuthNoPriv": {
+			config:      SNMPSourceConfig{Host: "192.0.2.1", Version: "3", Username: "zonepop"},
+			expectPort:  161,
+			expectFlags: gosnmp.NoAuthNoPriv,
+		},
+		"v3 authPriv": {
+			config: SNMPSourceConfig{
+				Host:           "192.0.2.1",
+				Version:        "3",
+				Username:       "zonepop",
+				AuthProtocol:   "sha256",
+				AuthPassphrase: "authpassphrase",
+				PrivProtocol:   "aes",
+				PrivPassphrase: "privpassphrase",
+			},
+			expectPort:  161,
+			expectFlags: gosnmp.AuthPriv,
+		},
+		"missing host": {
+			config:    SNMPSourceConfig{},
+			expectErr: "host is required",
+		},
+		"unknown version": {
+			config:    SNMPSourceConfig{Host: "192.0.2.1", Version: "1"},
+			expectErr: "unsupported SNMP version",
+		},
+		"priv without auth": {
+			config:    SNMPSourceConfig{Host: "192.0.2.1", Version: "3", PrivProtocol: "AES"},
+			expectErr: "priv protocol requires an auth protocol",
+		},
+		"invalid hostnames MAC": {
+			config:    SNMPSourceConfig{Host: "192.0.2.1", Hostnames: map[string]string{"nope": "host"}},
+			expectErr: "invalid MAC address",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewSNMPSource(tc.config)
+			if tc.expectErr != "" {
+				assert.ErrorContains(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			client, err := s.(*snmpSource).client()
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectPort, client.Port)
+			assert.Equal(t, tc.expectFlags, client.MsgFlags)
+		})
+	}
+}
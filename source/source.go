@@ -11,8 +11,45 @@ type Source interface {
 	Endpoints(ctx context.Context) ([]*endpoint.Endpoint, error)
 }
 
+// EventSource defines the interface for sources that are able to notify when
+// their endpoints have changed, rather than waiting for the next interval.
+type EventSource interface {
+	Source
+	// AddEventHandler registers a handler to be called whenever the source
+	// detects a change. Implementations must stop notifying once ctx is done.
+	AddEventHandler(ctx context.Context, handler func())
+}
+
+// DependentSource defines the interface for sources that consume the
+// endpoints of other sources, for example to join hostnames to addresses.
+type DependentSource interface {
+	Source
+	// SourceDependencies returns the logical names of the sources this source
+	// depends on.
+	SourceDependencies() []string
+	// SetSourceDependencies is called with the resolved dependencies once all
+	// sources have been configured.
+	SetSourceDependencies(sources []NamedSource)
+	// EndpointsWithDependencies is called by the controller instead of
+	// Endpoints with the endpoints it already got from the dependencies by
+	// source name, so dependencies aren't queried twice per run.
+	EndpointsWithDependencies(ctx context.Context, dependencies map[string][]*endpoint.Endpoint) ([]*endpoint.Endpoint, error)
+}
+
+// Policies for endpoints with invalid addresses
+const (
+	// InvalidAddressesReject removes the invalid addresses from endpoints.
+	InvalidAddressesReject = "reject"
+	// InvalidAddressesQuarantine holds back endpoints with invalid addresses
+	// from the enrichers and providers.
+	InvalidAddressesQuarantine = "quarantine"
+)
+
 // NamedSource is a struct that pairs a Source instance with a logical name.
 type NamedSource struct {
 	Name   string
 	Source Source
+	// What to do with endpoints with invalid addresses (default
+	// InvalidAddressesReject)
+	InvalidAddresses string
 }
@@ -0,0 +1,281 @@
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/sapslaj/zonepop/endpoint"
+	"github.com/sapslaj/zonepop/pkg/log"
+	"github.com/sapslaj/zonepop/pkg/rdns"
+	"github.com/sapslaj/zonepop/source"
+)
+
+const (
+	FlavorTailscale = "tailscale"
+	FlavorHeadscale = "headscale"
+
+	DefaultTailscaleAPIURL = "https://api.tailscale.com"
+)
+
+type TailscaleSourceConfig struct {
+	// Either "tailscale" (default) or "headscale"
+	Flavor string
+	// Base URL of the API. Defaults to https://api.tailscale.com for the
+	// tailscale flavor and is required for headscale.
+	APIURL string
+	// API access token or Headscale API key
+	APIKey string
+	// Tailnet name (tailscale flavor only). Defaults to "-", which is the
+	// tailnet of the API key.
+	Tailnet string
+	// Only include devices with at least one of these tags
+	Tags                 []string
+	CollectIPv6Addresses bool
+	RecordTTL            int64
+}
+
+// Device is the common representation of a Tailscale or Headscale device.
+type Device struct {
+	ID        string
+	Name      string
+	OS        string
+	User      string
+	Tags      []string
+	Addresses []string
+}
+
+type tailscaleSource struct {
+	config     TailscaleSourceConfig
+	logger     *zap.Logger
+	httpClient *http.Client
+}
+
+func NewTailscaleSource(sourceConfig TailscaleSourceConfig) (source.Source, error) {
+	if sourceConfig.Flavor == "" {
+		sourceConfig.Flavor = FlavorTailscale
+	}
+	switch sourceConfig.Flavor {
+	case FlavorTailscale:
+		if sourceConfig.APIURL == "" {
+			sourceConfig.APIURL = DefaultTailscaleAPIURL
+		}
+		if sourceConfig.Tailnet == "" {
+			sourceConfig.Tailnet = "-"
+		}
+	case FlavorHeadscale:
+		if sourceConfig.APIURL == "" {
+			return nil, fmt.Errorf("api_url is required for headscale")
+		}
+	default:
+		return nil, fmt.Errorf("unknown flavor %q", sourceConfig.Flavor)
+	}
+	sourceConfig.APIURL = strings.TrimSuffix(sourceConfig.APIURL, "/")
+	// a new slice so the caller's tags aren't modified
+	tags := make([]string, 0, len(sourceConfig.Tags))
+	for _, tag := range sourceConfig.Tags {
+		if !strings.HasPrefix(tag, "tag:") {
+			tag = "tag:" + tag
+		}
+		tags = append(tags, tag)
+	}
+	sourceConfig.Tags = tags
+	return &tailscaleSource{
+		config:     sourceConfig,
+		httpClient: http.DefaultClient,
+		logger: log.MustNewLogger().Named("tailscale_source").With(
+			zap.String("flavor", sourceConfig.Flavor),
+			zap.String("api_url", sourceConfig.APIURL),
+		),
+	}, nil
+}
+
+func (s *tailscaleSource) Endpoints(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	var devices []*Device
+	var err error
+	if s.config.Flavor == FlavorHeadscale {
+		devices, err = s.getHeadscaleDevices(ctx)
+	} else {
+		devices, err = s.getTailscaleDevices(ctx)
+	}
+	if err != nil {
+		newErr := fmt.Errorf("could not get devices: %w", err)
+		s.logger.Error(newErr.Error())
+		return nil, newErr
+	}
+
+	endpoints := make([]*endpoint.Endpoint, 0, len(devices))
+	for _, device := range devices {
+		if !s.matchesTags(device) {
+			s.logger.Sugar().Debugf("skipping device %q due to tag filter", device.Name)
+			continue
+		}
+		endpoints = append(endpoints, s.deviceToEndpoint(device))
+	}
+	return endpoints, nil
+}
+
+func (s *tailscaleSource) matchesTags(device *Device) bool {
+	if len(s.config.Tags) == 0 {
+		return true
+	}
+	for _, tag := range device.Tags {
+		if slices.Contains(s.config.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *tailscaleSource) deviceToEndpoint(device *Device) *endpoint.Endpoint {
+	ipv4s := make([]string, 0)
+	var ipv6s []string
+	if s.config.CollectIPv6Addresses {
+		ipv6s = make([]string, 0)
+	}
+	for _, addr := range device.Addresses {
+		kind, err := rdns.DetermineAddressKind(addr)
+		if err != nil {
+			s.logger.Sugar().Warnf("ignoring invalid address %q for device %q", addr, device.Name)
+			continue
+		}
+		if kind == rdns.AddressKindIPv4 {
+			ipv4s = append(ipv4s, addr)
+		} else if s.config.CollectIPv6Addresses {
+			ipv6s = append(ipv6s, addr)
+		}
+	}
+	tags := device.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return &endpoint.Endpoint{
+		Hostname:  device.Name,
+		IPv4s:     ipv4s,
+		IPv6s:     ipv6s,
+		RecordTTL: s.config.RecordTTL,
+		SourceProperties: map[string]any{
+			"tailscale_id":   device.ID,
+			"tailscale_user": device.User,
+			"tags":           tags,
+			"os":             device.OS,
+		},
+	}
+}
+
+func (s *tailscaleSource) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.APIURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.APIKey)
+	}
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d from %s: %s", res.StatusCode, path, string(body))
+	}
+	return json.Unmarshal(body, v)
+}
+
+type tailscaleDevicesResponse struct {
+	Devices []struct {
+		ID        string   `json:"id"`
+		NodeID    string   `json:"nodeId"`
+		Name      string   `json:"name"`
+		Hostname  string   `json:"hostname"`
+		OS        string   `json:"os"`
+		User      string   `json:"user"`
+		Tags      []string `json:"tags"`
+		Addresses []string `json:"addresses"`
+	} `json:"devices"`
+}
+
+func (s *tailscaleSource) getTailscaleDevices(ctx context.Context) ([]*Device, error) {
+	s.logger.Info("Getting Tailscale devices")
+	var res tailscaleDevicesResponse
+	err := s.get(ctx, "/api/v2/tailnet/"+url.PathEscape(s.config.Tailnet)+"/devices", &res)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]*Device, 0, len(res.Devices))
+	for _, d := range res.Devices {
+		// Name is the MagicDNS FQDN (e.g. "host.tailnet-1234.ts.net"), only the
+		// first label is useful to us.
+		name, _, _ := strings.Cut(d.Name, ".")
+		if name == "" {
+			name = d.Hostname
+		}
+		devices = append(devices, &Device{
+			ID:        d.NodeID,
+			Name:      name,
+			OS:        d.OS,
+			User:      d.User,
+			Tags:      d.Tags,
+			Addresses: d.Addresses,
+		})
+	}
+	return devices, nil
+}
+
+type headscaleNode struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	GivenName   string   `json:"givenName"`
+	IPAddresses []string `json:"ipAddresses"`
+	ForcedTags  []string `json:"forcedTags"`
+	ValidTags   []string `json:"validTags"`
+	User        struct {
+		Name string `json:"name"`
+	} `json:"user"`
+}
+
+type headscaleNodesResponse struct {
+	Nodes []headscaleNode `json:"nodes"`
+}
+
+func (s *tailscaleSource) getHeadscaleDevices(ctx context.Context) ([]*Device, error) {
+	s.logger.Info("Getting Headscale nodes")
+	var res headscaleNodesResponse
+	err := s.get(ctx, "/api/v1/node", &res)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]*Device, 0, len(res.Nodes))
+	for _, n := range res.Nodes {
+		name := n.GivenName
+		if name == "" {
+			name = n.Name
+		}
+		tags := make([]string, 0, len(n.ForcedTags)+len(n.ValidTags))
+		for _, tag := range append(n.ForcedTags, n.ValidTags...) {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		devices = append(devices, &Device{
+			ID:        n.ID,
+			Name:      name,
+			User:      n.User.Name,
+			Tags:      tags,
+			Addresses: n.IPAddresses,
+		})
+	}
+	return devices, nil
+}
@@ -0,0 +1,241 @@
+package tailscale
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sapslaj/zonepop/endpoint"
+)
+
+const tailscaleDevicesJSON = `{
+  "devices": [
+    {
+      "addresses": ["100.64.0.1", "fd7a:115c:a1e0::1"],
+      "id": "92960230385",
+      "nodeId": "n292kg92CNTRL",
+      "user": "amelie@example.com",
+      "name": "host-1.tail1234.ts.net",
+      "hostname": "host-1",
+      "os": "linux",
+      "tags": ["tag:server"]
+    },
+    {
+      "addresses": ["100.64.0.2", "fd7a:115c:a1e0::2"],
+      "id": "92960230386",
+      "nodeId": "n392kg92CNTRL",
+      "user": "amelie@example.com",
+      "name": "laptop.tail1234.ts.net",
+      "hostname": "Amelies-MacBook-Pro",
+      "os": "macOS"
+    }
+  ]
+}`
+
+const headscaleNodesJSON = `{
+  "nodes": [
+    {
+      "id": "1",
+      "name": "host-1",
+      "givenName": "host-1",
+      "ipAddresses": ["100.64.0.1", "fd7a:115c:a1e0::1"],
+      "forcedTags": ["tag:server"],
+      "validTags": ["tag:server", "tag:prod"],
+      "user": {"name": "amelie"}
+    },
+    {
+      "id": "2",
+      "name": "laptop-abcdef",
+      "givenName": "laptop",
+      "ipAddresses": ["100.64.0.2"],
+      "user": {"name": "amelie"}
+    }
+  ]
+}`
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/tailnet/-/devices", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tskey-api-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(tailscaleDevicesJSON))
+	})
+	mux.HandleFunc("/api/v1/node", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer hs-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(headscaleNodesJSON))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestEndpoints(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := map[string]struct {
+		config TailscaleSourceConfig
+		expect []*endpoint.Endpoint
+	}{
+		"tailscale": {
+			config: TailscaleSourceConfig{
+				APIURL:               server.URL,
+				APIKey:               "tskey-api-test",
+				CollectIPv6Addresses: true,
+				RecordTTL:            60,
+			},
+			expect: []*endpoint.Endpoint{
+				{
+					Hostname:  "host-1",
+					IPv4s:     []string{"100.64.0.1"},
+					IPv6s:     []string{"fd7a:115c:a1e0::1"},
+					RecordTTL: 60,
+					SourceProperties: map[string]any{
+						"tailscale_id":   "n292kg92CNTRL",
+						"tailscale_user": "amelie@example.com",
+						"tags":           []string{"tag:server"},
+						"os":             "linux",
+					},
+				},
+				{
+					Hostname:  "laptop",
+					IPv4s:     []string{"100.64.0.2"},
+					IPv6s:     []string{"fd7a:115c:a1e0::2"},
+					RecordTTL: 60,
+					SourceProperties: map[string]any{
+						"tailscale_id":   "n392kg92CNTRL",
+						"tailscale_user": "amelie@example.com",
+						"tags":           []string{},
+						"os":             "macOS",
+					},
+				},
+			},
+		},
+		"tailscale with tag filter": {
+			config: TailscaleSourceConfig{
+				APIURL: server.URL,
+				APIKey: "tskey-api-test",
+				Tags:   []string{"server"},
+			},
+			expect: []*endpoint.Endpoint{
+				{
+					Hostname: "host-1",
+					IPv4s:    []string{"100.64.0.1"},
+					SourceProperties: map[string]any{
+						"tailscale_id":   "n292kg92CNTRL",
+						"tailscale_user": "amelie@example.com",
+						"tags":           []string{"tag:server"},
+						"os":             "linux",
+					},
+				},
+			},
+		},
+		"headscale": {
+			config: TailscaleSourceConfig{
+				Flavor:               FlavorHeadscale,
+				APIURL:               server.URL + "/",
+				APIKey:               "hs-test",
+				CollectIPv6Addresses: true,
+			},
+			expect: []*endpoint.Endpoint{
+				{
+					Hostname: "host-1",
+					IPv4s:    []string{"100.64.0.1"},
+					IPv6s:    []string{"fd7a:115c:a1e0::1"},
+					SourceProperties: map[string]any{
+						"tailscale_id":   "1",
+						"tailscale_user": "amelie",
+						"tags":           []string{"tag:server", "tag:prod"},
+						"os":             "",
+					},
+				},
+				{
+					Hostname: "laptop",
+					IPv4s:    []string{"100.64.0.2"},
+					IPv6s:    []string{},
+					SourceProperties: map[string]any{
+						"tailscale_id":   "2",
+						"tailscale_user": "amelie",
+						"tags":           []string{},
+						"os":             "",
+					},
+				},
+			},
+		},
+		"headscale with tag filter": {
+			config: TailscaleSourceConfig{
+				Flavor: FlavorHeadscale,
+				APIURL: server.URL,
+				APIKey: "hs-test",
+				Tags:   []string{"tag:prod"},
+			},
+			expect: []*endpoint.Endpoint{
+				{
+					Hostname: "host-1",
+					IPv4s:    []string{"100.64.0.1"},
+					SourceProperties: map[string]any{
+						"tailscale_id":   "1",
+						"tailscale_user": "amelie",
+						"tags":           []string{"tag:server", "tag:prod"},
+						"os":             "",
+					},
+				},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewTailscaleSource(tc.config)
+			require.NoError(t, err)
+
+			endpoints, err := s.Endpoints(context.Background())
+			require.NoError(t, err)
+
+			diff := cmp.Diff(tc.expect, endpoints)
+			if diff != "" {
+				t.Fatalf("mismatch:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEndpoints_Unauthorized(t *testing.T) {
+	server := newTestServer(t)
+
+	s, err := NewTailscaleSource(TailscaleSourceConfig{
+		APIURL: server.URL,
+		APIKey: "wrong",
+	})
+	require.NoError(t, err)
+
+	_, err = s.Endpoints(context.Background())
+	assert.ErrorContains(t, err, "unexpected status code 401")
+}
+
+func TestNewTailscaleSource_HeadscaleRequiresAPIURL(t *testing.T) {
+	_, err := NewTailscaleSource(TailscaleSourceConfig{
+		Flavor: FlavorHeadscale,
+	})
+	assert.Error(t, err)
+}
+
+func TestNewTailscaleSource_DoesNotModifyTags(t *testing.T) {
+	tags := []string{"server", "tag:prod"}
+	s, err := NewTailscaleSource(TailscaleSourceConfig{Tags: tags})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tag:server", "tag:prod"}, s.(*tailscaleSource).config.Tags)
+	assert.Equal(t, []string{"server", "tag:prod"}, tags)
+}